@@ -20,31 +20,60 @@ import (
 	"flag"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 
 	"k8s.io/klog"
+	klogv2 "k8s.io/klog/v2"
 
 	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
 	gce "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-cloud-provider/compute"
 	metadataservice "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-cloud-provider/metadata"
 	driver "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-pd-csi-driver"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/logging"
 	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/metrics"
 	mountmanager "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/mount-manager"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/tracing"
 )
 
 var (
-	cloudConfigFilePath  = flag.String("cloud-config", "", "Path to GCE cloud provider config")
-	endpoint             = flag.String("endpoint", "unix:/tmp/csi.sock", "CSI endpoint")
-	runControllerService = flag.Bool("run-controller-service", true, "If set to false then the CSI driver does not activate its controller service (default: true)")
-	runNodeService       = flag.Bool("run-node-service", true, "If set to false then the CSI driver does not activate its node service (default: true)")
-	httpEndpoint         = flag.String("http-endpoint", "", "The TCP network address where the prometheus metrics endpoint will listen (example: `:8080`). The default is empty string, which means metrics endpoint is disabled.")
-	metricsPath          = flag.String("metrics-path", "/metrics", "The HTTP path where prometheus metrics will be exposed. Default is `/metrics`.")
-	extraVolumeLabelsStr = flag.String("extra-labels", "", "Extra labels to attach to each PD created. It is a comma separated list of key value pairs like '<key1>=<value1>,<key2>=<value2>'. See https://cloud.google.com/compute/docs/labeling-resources for details")
-	version              string
+	cloudConfigFilePath   = flag.String("cloud-config", "", "Path to GCE cloud provider config")
+	endpoint              = flag.String("endpoint", "unix:/tmp/csi.sock", "CSI endpoint")
+	runControllerService  = flag.Bool("run-controller-service", true, "If set to false then the CSI driver does not activate its controller service (default: true)")
+	runNodeService        = flag.Bool("run-node-service", true, "If set to false then the CSI driver does not activate its node service (default: true)")
+	httpEndpoint          = flag.String("http-endpoint", "", "The TCP network address where the prometheus metrics endpoint will listen (example: `:8080`). The default is empty string, which means metrics endpoint is disabled.")
+	metricsPath           = flag.String("metrics-path", "/metrics", "The HTTP path where prometheus metrics will be exposed. Default is `/metrics`.")
+	extraVolumeLabelsStr  = flag.String("extra-labels", "", "Extra labels to attach to each PD created. It is a comma separated list of key value pairs like '<key1>=<value1>,<key2>=<value2>'. Values may use the templates of the labels StorageClass parameter. See https://cloud.google.com/compute/docs/labeling-resources for details")
+	allowedProjectsStr    = flag.String("allowed-projects", "", "Comma separated list of projects, other than the default project, that disks may be created in with the project StorageClass parameter.")
+	zoneSelectionStrategy = flag.String("zone-selection-strategy", common.ZoneSelectionStrategyRandom, "How to pick zones for new disks that are not fully determined by the topology requirement. One of \"random\", \"round-robin\" or \"least-used\". Can be overridden by the zone-selection-strategy StorageClass parameter.")
+
+	restrictSnapshotProjects = flag.Bool("restrict-snapshot-projects", false, "If set, volumes may only be restored from snapshots in the default project, the --allowed-projects and the project of the new disk. Otherwise any snapshot that the driver's service account can read may be restored.")
+
+	computeRateLimits         = gce.DefaultRateLimitConfig()
+	computeReadQPS            = flag.Float64("compute-read-qps", float64(computeRateLimits.ReadQPS), "Maximum QPS of compute API calls that get or list resources. 0 disables the limit.")
+	computeReadBurst          = flag.Int("compute-read-burst", computeRateLimits.ReadBurst, "Maximum burst of compute API calls that get or list resources.")
+	computeMutateQPS          = flag.Float64("compute-mutate-qps", float64(computeRateLimits.MutateQPS), "Maximum QPS of compute API calls that create, modify or delete resources. 0 disables the limit.")
+	computeMutateBurst        = flag.Int("compute-mutate-burst", computeRateLimits.MutateBurst, "Maximum burst of compute API calls that create, modify or delete resources.")
+	computeOperationPollQPS   = flag.Float64("compute-operation-poll-qps", float64(computeRateLimits.OperationPollQPS), "Maximum QPS of compute API calls that poll pending operations. 0 disables the limit.")
+	computeOperationPollBurst = flag.Int("compute-operation-poll-burst", computeRateLimits.OperationPollBurst, "Maximum burst of compute API calls that poll pending operations.")
+	computeMaxRetries         = flag.Int("compute-max-retries", computeRateLimits.MaxRetries, "Number of times a compute API call that was rate limited or failed with a server error is retried.")
+	computeInitialBackoff     = flag.Duration("compute-initial-backoff", computeRateLimits.InitialBackoff, "Delay before the first retry of a compute API call, which doubles for each further retry.")
+	computeMaxBackoff         = flag.Duration("compute-max-backoff", computeRateLimits.MaxBackoff, "Maximum delay between retries of a compute API call.")
+
+	rpcLogFormat       = flag.String("rpc-log-format", rpcLogFormatText, "Format of the logs of CSI RPCs. With \"text\", the request and response of each RPC are logged as text. With \"json\", each RPC is logged as a line of JSON with its method, volume ID, node ID, duration and status code. Other logs are text in both formats.")
+	rpcLogVerbosityStr = flag.String("rpc-log-verbosity", "", "Comma separated list of CSI methods and the log verbosity at which their RPCs are logged, like 'NodeGetVolumeStats=6,NodeGetCapabilities=5'. Other methods are logged at verbosity 4. Failed RPCs are always logged.")
+
+	enableTracing   = flag.Bool("enable-tracing", false, "If set, CSI RPCs, and the GCE API calls and operation waits made for them, are traced.")
+	tracingEndpoint = flag.String("tracing-endpoint", "localhost:4317", "The host:port of the OpenTelemetry collector that traced spans are exported to with OTLP over gRPC. The connection is insecure.")
+
+	version string
 )
 
 const (
 	driverName = "pd.csi.storage.gke.io"
+
+	rpcLogFormatText = "text"
+	rpcLogFormatJSON = "json"
 )
 
 func init() {
@@ -72,10 +101,21 @@ func handle() {
 	}
 	klog.V(2).Infof("Driver vendor version %v", version)
 
-	if *runControllerService && *httpEndpoint != "" && metrics.IsGKEComponentVersionAvailable() {
+	if *httpEndpoint != "" {
 		mm := metrics.NewMetricsManager()
 		mm.InitializeHttpHandler(*httpEndpoint, *metricsPath)
-		mm.EmitGKEComponentVersion()
+		mm.RegisterOperationMetrics()
+		if *runControllerService && metrics.IsGKEComponentVersionAvailable() {
+			mm.EmitGKEComponentVersion()
+		}
+	}
+
+	if *enableTracing {
+		exporter, err := tracing.NewOTLPExporter(context.Background(), *tracingEndpoint)
+		if err != nil {
+			klog.Fatalf("Failed to create tracing exporter: %v", err)
+		}
+		defer tracing.Enable(exporter)()
 	}
 
 	if len(*extraVolumeLabelsStr) > 0 && !*runControllerService {
@@ -86,6 +126,18 @@ func handle() {
 		klog.Fatalf("Bad extra volume labels: %v", err)
 	}
 
+	if err := common.ValidateZoneSelectionStrategy(*zoneSelectionStrategy); err != nil {
+		klog.Fatalf("Bad zone selection strategy: %v", err)
+	}
+
+	var allowedProjects []string
+	if len(*allowedProjectsStr) > 0 {
+		if !*runControllerService {
+			klog.Fatalf("Allowed projects provided but not running controller")
+		}
+		allowedProjects = strings.Split(*allowedProjectsStr, ",")
+	}
+
 	gceDriver := driver.GetGCEDriver()
 
 	//Initialize GCE Driver
@@ -98,11 +150,23 @@ func handle() {
 	//Initialize requirements for the controller service
 	var controllerServer *driver.GCEControllerServer
 	if *runControllerService {
-		cloudProvider, err := gce.CreateCloudProvider(ctx, version, *cloudConfigFilePath)
+		rateLimits := gce.RateLimitConfig{
+			ReadQPS:            float32(*computeReadQPS),
+			ReadBurst:          *computeReadBurst,
+			MutateQPS:          float32(*computeMutateQPS),
+			MutateBurst:        *computeMutateBurst,
+			OperationPollQPS:   float32(*computeOperationPollQPS),
+			OperationPollBurst: *computeOperationPollBurst,
+			MaxRetries:         *computeMaxRetries,
+			InitialBackoff:     *computeInitialBackoff,
+			MaxBackoff:         *computeMaxBackoff,
+		}
+		cloudProvider, err := gce.CreateCloudProvider(ctx, version, *cloudConfigFilePath, rateLimits)
 		if err != nil {
 			klog.Fatalf("Failed to get cloud provider: %v", err)
 		}
-		controllerServer = driver.NewControllerServer(gceDriver, cloudProvider)
+		controllerServer = driver.NewControllerServer(gceDriver, cloudProvider, *zoneSelectionStrategy, allowedProjects)
+		controllerServer.SetRestrictSnapshotProjects(*restrictSnapshotProjects)
 	} else if *cloudConfigFilePath != "" {
 		klog.Warningf("controller service is disabled but cloud config given - it has no effect")
 	}
@@ -128,5 +192,20 @@ func handle() {
 		klog.Fatalf("Failed to initialize GCE CSI Driver: %v", err)
 	}
 
+	methodVerbosity, err := driver.ParseMethodVerbosity(*rpcLogVerbosityStr)
+	if err != nil {
+		klog.Fatalf("Bad RPC log verbosity: %v", err)
+	}
+	rpcLogConfig := driver.RPCLogConfig{MethodVerbosity: methodVerbosity}
+	switch *rpcLogFormat {
+	case rpcLogFormatText:
+	case rpcLogFormatJSON:
+		rpcLogConfig.Structured = true
+		klogv2.SetLogger(logging.NewJSONLogger(os.Stderr))
+	default:
+		klog.Fatalf("Unknown RPC log format %q, must be %q or %q", *rpcLogFormat, rpcLogFormatText, rpcLogFormatJSON)
+	}
+	gceDriver.SetRPCLogConfig(rpcLogConfig)
+
 	gceDriver.Run(*endpoint)
 }
@@ -23,5 +23,21 @@ const (
 	// VolumeAttributes for Partition
 	VolumeAttributePartition = "partition"
 
+	// VolumeAttributes for force-attaching regional disks, which CreateVolume
+	// sets from ParameterKeyForceAttach
+	VolumeAttributeForceAttach = "force-attach"
+
+	// VolumeAttributes for the options of mkfs when a volume is first
+	// formatted, which CreateVolume sets from ParameterKeyMkfsOptions
+	VolumeAttributeMkfsOptions = "mkfs-options"
+
+	// VolumeAttributes for checking filesystems before they are mounted,
+	// which CreateVolume sets from ParameterKeyFsckOnStage
+	VolumeAttributeFsckOnStage = "fsck-on-stage"
+
+	// VolumeAttributes for encrypting volumes at the node, which
+	// CreateVolume sets from ParameterKeyNodeEncryption
+	VolumeAttributeNodeEncryption = "node-encryption"
+
 	UnspecifiedValue = "UNSPECIFIED"
 )
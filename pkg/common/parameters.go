@@ -17,21 +17,75 @@ limitations under the License.
 package common
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 const (
 	// Parameters for StorageClass
-	ParameterKeyType                 = "type"
-	ParameterKeyReplicationType      = "replication-type"
-	ParameterKeyDiskEncryptionKmsKey = "disk-encryption-kms-key"
-	ParameterKeyLabels               = "labels"
+	ParameterKeyType                    = "type"
+	ParameterKeyReplicationType         = "replication-type"
+	ParameterKeyDiskEncryptionKmsKey    = "disk-encryption-kms-key"
+	ParameterKeyLabels                  = "labels"
+	ParameterKeyProvisionedIOPSOnCreate = "provisioned-iops-on-create"
+	ParameterKeySourceImage             = "source-image"
+	ParameterKeyZoneSelectionStrategy   = "zone-selection-strategy"
+	ParameterKeyProject                 = "project"
+	ParameterKeyResourcePolicies        = "resource-policies"
+	ParameterKeyForceAttach             = "force-attach"
+	ParameterKeyMkfsOptions             = "mkfs-options"
+	ParameterKeyFsckOnStage             = "fsck-on-stage"
+	ParameterKeyNodeEncryption          = "node-encryption"
 
 	// Parameters for VolumeSnapshotClass
 	ParameterKeyStorageLocations = "storage-locations"
+	ParameterKeySnapshotType     = "snapshot-type"
+	ParameterKeyImageFamily      = "image-family"
+	ParameterKeyGuestFlush       = "guest-flush"
 
-	replicationTypeNone = "none"
+	// Values for ParameterKeySnapshotType, which are also the collections
+	// in snapshot IDs
+	DiskSnapshotType = "snapshots"
+	DiskImageType    = "images"
+
+	// Key for the secrets of CreateVolume, CreateSnapshot and
+	// ControllerPublishVolume requests, which holds a raw customer-supplied
+	// encryption key
+	SecretKeyDiskEncryptionRawKey = "disk-encryption-raw-key"
+	// Key for the same secrets, which holds a customer-supplied encryption
+	// key wrapped with the RSA public key certificate of GCE
+	SecretKeyDiskEncryptionRSAKey = "disk-encryption-rsa-key"
+
+	// Key for the secrets of NodeStageVolume requests, which holds the
+	// passphrase of volumes that are encrypted at the node with LUKS
+	SecretKeyLUKSPassphrase = "luks-passphrase"
+
+	// Length of a decoded raw customer-supplied encryption key, which is a
+	// 256 bit AES key
+	rawEncryptionKeyBytes = 32
+	// Length of a decoded RSA-wrapped customer-supplied encryption key, which
+	// GCE wraps with a 2048 bit RSA key
+	rsaEncryptionKeyBytes = 256
+
+	replicationTypeNone       = "none"
+	replicationTypeRegionalPD = "regional-pd"
+
+	// Values for ParameterKeyZoneSelectionStrategy
+	ZoneSelectionStrategyRandom     = "random"
+	ZoneSelectionStrategyRoundRobin = "round-robin"
+	ZoneSelectionStrategyLeastUsed  = "least-used"
+
+	// Values for ParameterKeyFsckOnStage
+	FsckOnStageNone   = "none"
+	FsckOnStageCheck  = "check"
+	FsckOnStageRepair = "repair"
+
+	// Values for ParameterKeyNodeEncryption
+	NodeEncryptionNone = "none"
+	NodeEncryptionLUKS = "luks"
 
 	// Keys for PV and PVC parameters as reported by external-provisioner
 	ParameterKeyPVCName      = "csi.storage.k8s.io/pvc/name"
@@ -45,6 +99,13 @@ const (
 	tagKeyCreatedBy                = "storage.gke.io/created-by"
 )
 
+// labelTemplateTags maps label templates to the tags holding their values.
+var labelTemplateTags = map[string]string{
+	LabelTemplatePVCName:      tagKeyCreatedForClaimName,
+	LabelTemplatePVCNamespace: tagKeyCreatedForClaimNamespace,
+	LabelTemplatePVName:       tagKeyCreatedForVolumeName,
+}
+
 // DiskParameters contains normalized and defaulted disk parameters
 type DiskParameters struct {
 	// Values: pd-standard, pd-balanced, pd-ssd, or any other PD disk type. Not validated.
@@ -62,18 +123,150 @@ type DiskParameters struct {
 	// Values: {map[string]string}
 	// Default: ""
 	Labels map[string]string
+	// Values: {int64}
+	// Default: none
+	ProvisionedIOPSOnCreate int64
+	// Values: {string} in the format projects/{project}/global/images/{name}
+	// Default: ""
+	SourceImage string
+	// Values: "", random, round-robin, least-used
+	// Default: "", which uses the driver's zone selection strategy
+	ZoneSelectionStrategy string
+	// Values: {string}
+	// Default: "", which uses the driver's default project
+	Project string
+	// Values: {[]string} of resource policy names in the region of the disk
+	// Default: none
+	ResourcePolicies []string
+	// Values: {bool}, only for regional-pd
+	// Default: false
+	ForceAttach bool
+	// Values: {string} of mkfs options and their values, separated by
+	// spaces, that are allowed by ParseMkfsOptions
+	// Default: ""
+	MkfsOptions string
+	// Values: none, check, repair
+	// Default: "", which is none
+	FsckOnStage string
+	// Values: none, luks
+	// Default: "", which is none
+	NodeEncryption string
+	// Values: {*CustomerEncryptionKey}, read from the request secrets
+	// rather than the parameters
+	// Default: nil
+	DiskEncryptionKey *CustomerEncryptionKey
+	// Values: {*CustomerEncryptionKey}, the key of the source snapshot or
+	// image snapshot when it is protected by a customer-supplied encryption key
+	// Default: nil
+	SourceSnapshotEncryptionKey *CustomerEncryptionKey
 }
 
 // SnapshotParameters contains normalized and defaulted parameters for snapshots
 type SnapshotParameters struct {
 	StorageLocations []string
+	// Values: snapshots, images
+	// Default: snapshots
+	SnapshotType string
+	// Values: {string}, only for images
+	// Default: ""
+	ImageFamily string
+	// Values: {map[string]string}
+	// Default: ""
+	Labels map[string]string
+	// Values: {bool}, only for snapshots of zonal disks
+	// Default: false
+	GuestFlush bool
+	// Values: {*CustomerEncryptionKey}, read from the request secrets
+	// rather than the parameters
+	// Default: nil
+	DiskEncryptionKey *CustomerEncryptionKey
+}
+
+// CustomerEncryptionKey is a customer-supplied encryption key, which is
+// either raw or RSA-wrapped. RSA-wrapped keys can only be passed to the beta
+// compute API.
+type CustomerEncryptionKey struct {
+	// RawKey is a base64 encoded 256 bit AES key
+	RawKey string
+	// RSAEncryptedKey is a base64 encoded 256 bit AES key, wrapped with the
+	// RSA public key certificate of GCE
+	RSAEncryptedKey string
+}
+
+// IsRSAWrapped returns true if the key is RSA-wrapped.
+func (k *CustomerEncryptionKey) IsRSAWrapped() bool {
+	return k != nil && k.RSAEncryptedKey != ""
+}
+
+// Sha256 returns the base64 encoded SHA-256 hash of the key, in the form that
+// GCE reports for resources protected by it. The hash of an RSA-wrapped key
+// is that of the unwrapped key, which only GCE can compute, so it is empty.
+func (k *CustomerEncryptionKey) Sha256() string {
+	if k == nil || k.IsRSAWrapped() {
+		return ""
+	}
+	key, err := base64.StdEncoding.DecodeString(k.RawKey)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(key)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ExtractCustomerEncryptionKey returns the customer-supplied encryption key
+// in the secrets of a request, or nil if there is none.
+func ExtractCustomerEncryptionKey(secrets map[string]string) (*CustomerEncryptionKey, error) {
+	rawKey, hasRawKey := secrets[SecretKeyDiskEncryptionRawKey]
+	rsaKey, hasRSAKey := secrets[SecretKeyDiskEncryptionRSAKey]
+	switch {
+	case hasRawKey && hasRSAKey:
+		return nil, fmt.Errorf("secrets contain both %q and %q, only one may be set", SecretKeyDiskEncryptionRawKey, SecretKeyDiskEncryptionRSAKey)
+	case hasRSAKey:
+		// The key itself must never be part of an error
+		key, err := base64.StdEncoding.DecodeString(rsaKey)
+		if err != nil || len(key) != rsaEncryptionKeyBytes {
+			return nil, fmt.Errorf("secrets contain invalid %q, must be a base64 encoded %d byte RSA-wrapped key", SecretKeyDiskEncryptionRSAKey, rsaEncryptionKeyBytes)
+		}
+		return &CustomerEncryptionKey{RSAEncryptedKey: rsaKey}, nil
+	case !hasRawKey:
+		return nil, nil
+	}
+	// The key itself must never be part of an error
+	key, err := base64.StdEncoding.DecodeString(rawKey)
+	if err != nil || len(key) != rawEncryptionKeyBytes {
+		return nil, fmt.Errorf("secrets contain invalid %q, must be a base64 encoded %d byte key", SecretKeyDiskEncryptionRawKey, rawEncryptionKeyBytes)
+	}
+	return &CustomerEncryptionKey{RawKey: rawKey}, nil
 }
 
 // ExtractAndDefaultParameters will take the relevant parameters from a map and
 // put them into a well defined struct making sure to default unspecified fields.
 // extraVolumeLabels are added as labels; if there are also labels specified in
-// parameters, any matching extraVolumeLabels will be overridden.
+// parameters, any matching extraVolumeLabels will be overridden. Templates in
+// label values are expanded from the PVC and PV parameters.
 func ExtractAndDefaultParameters(parameters map[string]string, driverName string, extraVolumeLabels map[string]string) (DiskParameters, error) {
+	p, err := ExtractAndDefaultParametersWithoutTemplates(parameters, driverName, extraVolumeLabels)
+	if err != nil {
+		return p, err
+	}
+	// The PVC and PV parameters may follow the labels, so templates are
+	// expanded once all parameters are read.
+	templateValues := map[string]string{}
+	for template, tagKey := range labelTemplateTags {
+		if v, ok := p.Tags[tagKey]; ok {
+			templateValues[template] = v
+		}
+	}
+	if err := expandLabelTemplates(p.Labels, templateValues); err != nil {
+		return p, fmt.Errorf("parameters contain invalid labels, PVC and PV label templates require the provisioner's --extra-create-metadata flag: %w", err)
+	}
+	return p, nil
+}
+
+// ExtractAndDefaultParametersWithoutTemplates is ExtractAndDefaultParameters
+// without expanding the templates in label values, which are left as they
+// are. It is for requests such as GetCapacity, which have no PVC or PV.
+func ExtractAndDefaultParametersWithoutTemplates(parameters map[string]string, driverName string, extraVolumeLabels map[string]string) (DiskParameters, error) {
 	p := DiskParameters{
 		DiskType:             "pd-standard",           // Default
 		ReplicationType:      replicationTypeNone,     // Default
@@ -118,19 +311,110 @@ func ExtractAndDefaultParameters(parameters map[string]string, driverName string
 			for labelKey, labelValue := range paramLabels {
 				p.Labels[labelKey] = labelValue
 			}
+		case ParameterKeyProvisionedIOPSOnCreate:
+			iops, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || iops <= 0 {
+				return p, fmt.Errorf("parameters contain invalid provisioned IOPS %q, must be a positive integer", v)
+			}
+			p.ProvisionedIOPSOnCreate = iops
+		case ParameterKeySourceImage:
+			// Image names are case sensitive, so do not change case
+			if _, _, err := ImageIDToProjectName(v); err != nil {
+				return p, fmt.Errorf("parameters contain invalid source image: %w", err)
+			}
+			p.SourceImage = v
+		case ParameterKeyZoneSelectionStrategy:
+			strategy := strings.ToLower(v)
+			if err := ValidateZoneSelectionStrategy(strategy); err != nil {
+				return p, fmt.Errorf("parameters contain invalid zone selection strategy: %w", err)
+			}
+			p.ZoneSelectionStrategy = strategy
+		case ParameterKeyProject:
+			// Project IDs are lower case, but do not hide a mistyped one
+			p.Project = v
+		case ParameterKeyResourcePolicies:
+			policies, err := ConvertResourcePoliciesStringToList(v)
+			if err != nil {
+				return p, fmt.Errorf("parameters contain invalid resource policies: %w", err)
+			}
+			p.ResourcePolicies = policies
+		case ParameterKeyForceAttach:
+			forceAttach, err := strconv.ParseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("parameters contain invalid force attach %q, must be a boolean", v)
+			}
+			p.ForceAttach = forceAttach
+		case ParameterKeyMkfsOptions:
+			// Options are case sensitive, so do not change case
+			if err := validateMkfsOptionsForAnyFsType(v); err != nil {
+				return p, fmt.Errorf("parameters contain invalid mkfs options: %w", err)
+			}
+			p.MkfsOptions = v
+		case ParameterKeyFsckOnStage:
+			policy := strings.ToLower(v)
+			if err := ValidateFsckOnStage(policy); err != nil {
+				return p, fmt.Errorf("parameters contain invalid fsck on stage policy: %w", err)
+			}
+			p.FsckOnStage = policy
+		case ParameterKeyNodeEncryption:
+			encryption := strings.ToLower(v)
+			if err := ValidateNodeEncryption(encryption); err != nil {
+				return p, fmt.Errorf("parameters contain invalid node encryption: %w", err)
+			}
+			p.NodeEncryption = encryption
 		default:
 			return p, fmt.Errorf("parameters contains invalid option %q", k)
 		}
 	}
+	if p.ForceAttach && p.ReplicationType != replicationTypeRegionalPD {
+		return p, fmt.Errorf("parameters contain force attach, which requires replication type %q", replicationTypeRegionalPD)
+	}
 	if len(p.Tags) > 0 {
 		p.Tags[tagKeyCreatedBy] = driverName
 	}
 	return p, nil
 }
 
+// ValidateFsckOnStage returns an error if policy is not a known policy for
+// checking filesystems before they are mounted.
+func ValidateFsckOnStage(policy string) error {
+	switch policy {
+	case FsckOnStageNone, FsckOnStageCheck, FsckOnStageRepair:
+		return nil
+	default:
+		return fmt.Errorf("fsck on stage policy %q is not one of %q, %q or %q", policy,
+			FsckOnStageNone, FsckOnStageCheck, FsckOnStageRepair)
+	}
+}
+
+// ValidateNodeEncryption returns an error if encryption is not a known way of
+// encrypting volumes at the node.
+func ValidateNodeEncryption(encryption string) error {
+	switch encryption {
+	case NodeEncryptionNone, NodeEncryptionLUKS:
+		return nil
+	default:
+		return fmt.Errorf("node encryption %q is not one of %q or %q", encryption, NodeEncryptionNone, NodeEncryptionLUKS)
+	}
+}
+
+// ValidateZoneSelectionStrategy returns an error if strategy is not a known
+// zone selection strategy.
+func ValidateZoneSelectionStrategy(strategy string) error {
+	switch strategy {
+	case ZoneSelectionStrategyRandom, ZoneSelectionStrategyRoundRobin, ZoneSelectionStrategyLeastUsed:
+		return nil
+	default:
+		return fmt.Errorf("zone selection strategy %q is not one of %q, %q or %q", strategy,
+			ZoneSelectionStrategyRandom, ZoneSelectionStrategyRoundRobin, ZoneSelectionStrategyLeastUsed)
+	}
+}
+
 func ExtractAndDefaultSnapshotParameters(parameters map[string]string) (SnapshotParameters, error) {
 	p := SnapshotParameters{
 		StorageLocations: []string{},
+		SnapshotType:     DiskSnapshotType,        // Default
+		Labels:           make(map[string]string), // Default
 	}
 	for k, v := range parameters {
 		switch strings.ToLower(k) {
@@ -140,9 +424,39 @@ func ExtractAndDefaultSnapshotParameters(parameters map[string]string) (Snapshot
 				return p, err
 			}
 			p.StorageLocations = normalizedStorageLocations
+		case ParameterKeySnapshotType:
+			snapshotType := strings.ToLower(v)
+			if snapshotType != DiskSnapshotType && snapshotType != DiskImageType {
+				return p, fmt.Errorf("parameters contain invalid snapshot type %q, must be %q or %q", v, DiskSnapshotType, DiskImageType)
+			}
+			p.SnapshotType = snapshotType
+		case ParameterKeyImageFamily:
+			p.ImageFamily = v
+		case ParameterKeyGuestFlush:
+			guestFlush, err := strconv.ParseBool(v)
+			if err != nil {
+				return p, fmt.Errorf("parameters contain invalid guest flush %q, must be a boolean", v)
+			}
+			p.GuestFlush = guestFlush
+		case ParameterKeyLabels:
+			labels, err := ConvertLabelsStringToMap(v)
+			if err != nil {
+				return p, fmt.Errorf("parameters contain invalid labels parameter: %w", err)
+			}
+			// Snapshots have no PVC or PV to expand templates from.
+			if err := expandLabelTemplates(labels, nil); err != nil {
+				return p, fmt.Errorf("parameters contain invalid labels parameter: %w", err)
+			}
+			p.Labels = labels
 		default:
 			return p, fmt.Errorf("parameters contains invalid option %q", k)
 		}
 	}
+	if p.ImageFamily != "" && p.SnapshotType != DiskImageType {
+		return p, fmt.Errorf("parameters contain image family %q, which requires snapshot type %q", p.ImageFamily, DiskImageType)
+	}
+	if p.GuestFlush && p.SnapshotType != DiskSnapshotType {
+		return p, fmt.Errorf("parameters contain guest flush, which requires snapshot type %q", DiskSnapshotType)
+	}
 	return p, nil
 }
@@ -17,7 +17,9 @@ limitations under the License.
 package common
 
 import (
+	"encoding/base64"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -146,6 +148,216 @@ func TestExtractAndDefaultParameters(t *testing.T) {
 				Labels:               map[string]string{"key1": "value1", "label-1": "value-a", "label-2": "label-value-2"},
 			},
 		},
+		{
+			name:       "provisioned iops",
+			parameters: map[string]string{ParameterKeyType: "pd-extreme", ParameterKeyProvisionedIOPSOnCreate: "10000"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:                "pd-extreme",
+				ReplicationType:         "none",
+				DiskEncryptionKMSKey:    "",
+				Tags:                    map[string]string{},
+				Labels:                  map[string]string{},
+				ProvisionedIOPSOnCreate: 10000,
+			},
+		},
+		{
+			name:       "source image",
+			parameters: map[string]string{ParameterKeySourceImage: "projects/image-project/global/images/Golden-Image"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:             "pd-standard",
+				ReplicationType:      "none",
+				DiskEncryptionKMSKey: "",
+				Tags:                 map[string]string{},
+				Labels:               map[string]string{},
+				SourceImage:          "projects/image-project/global/images/Golden-Image",
+			},
+		},
+		{
+			name:       "invalid source image",
+			parameters: map[string]string{ParameterKeySourceImage: "golden-image"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "invalid provisioned iops",
+			parameters: map[string]string{ParameterKeyProvisionedIOPSOnCreate: "lots"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "non-positive provisioned iops",
+			parameters: map[string]string{ParameterKeyProvisionedIOPSOnCreate: "0"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "zone selection strategy",
+			parameters: map[string]string{ParameterKeyZoneSelectionStrategy: "Least-Used"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:              "pd-standard",
+				ReplicationType:       "none",
+				DiskEncryptionKMSKey:  "",
+				Tags:                  map[string]string{},
+				Labels:                map[string]string{},
+				ZoneSelectionStrategy: ZoneSelectionStrategyLeastUsed,
+			},
+		},
+		{
+			name: "label templates",
+			parameters: map[string]string{
+				ParameterKeyLabels:       "team=${pvc.namespace},claim=claim-${pvc.name},volume=${pv.name}",
+				ParameterKeyPVCName:      "Data.Claim",
+				ParameterKeyPVCNamespace: "finance",
+				ParameterKeyPVName:       "pvc-0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrstuvwxyz",
+			},
+			labels: map[string]string{"owner": "${pvc.namespace}-owner"},
+			expectParams: DiskParameters{
+				DiskType:             "pd-standard",
+				ReplicationType:      "none",
+				DiskEncryptionKMSKey: "",
+				Tags: map[string]string{
+					tagKeyCreatedForClaimName:      "Data.Claim",
+					tagKeyCreatedForClaimNamespace: "finance",
+					tagKeyCreatedForVolumeName:     "pvc-0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrstuvwxyz",
+					tagKeyCreatedBy:                "testDriver",
+				},
+				Labels: map[string]string{
+					"owner":  "finance-owner",
+					"team":   "finance",
+					"claim":  "claim-data-claim",
+					"volume": "pvc-0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklm",
+				},
+			},
+		},
+		{
+			name:       "label templates without PVC parameters",
+			parameters: map[string]string{ParameterKeyLabels: "team=${pvc.namespace}"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "project",
+			parameters: map[string]string{ParameterKeyProject: "data-project"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:             "pd-standard",
+				ReplicationType:      "none",
+				DiskEncryptionKMSKey: "",
+				Tags:                 map[string]string{},
+				Labels:               map[string]string{},
+				Project:              "data-project",
+			},
+		},
+		{
+			name:       "invalid zone selection strategy",
+			parameters: map[string]string{ParameterKeyZoneSelectionStrategy: "most-used"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "resource policies",
+			parameters: map[string]string{ParameterKeyResourcePolicies: "daily-backup, weekly-backup,daily-backup,"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:             "pd-standard",
+				ReplicationType:      "none",
+				DiskEncryptionKMSKey: "",
+				Tags:                 map[string]string{},
+				Labels:               map[string]string{},
+				ResourcePolicies:     []string{"daily-backup", "weekly-backup"},
+			},
+		},
+		{
+			name:       "invalid resource policies",
+			parameters: map[string]string{ParameterKeyResourcePolicies: "projects/test-project/regions/us-central1/resourcePolicies/daily-backup"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "force attach",
+			parameters: map[string]string{ParameterKeyReplicationType: "regional-pd", ParameterKeyForceAttach: "true"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:             "pd-standard",
+				ReplicationType:      "regional-pd",
+				DiskEncryptionKMSKey: "",
+				Tags:                 map[string]string{},
+				Labels:               map[string]string{},
+				ForceAttach:          true,
+			},
+		},
+		{
+			name:       "invalid force attach",
+			parameters: map[string]string{ParameterKeyReplicationType: "regional-pd", ParameterKeyForceAttach: "always"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "force attach for zonal disk",
+			parameters: map[string]string{ParameterKeyForceAttach: "true"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "mkfs options",
+			parameters: map[string]string{ParameterKeyMkfsOptions: "-i 65536 -O ^has_journal"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:             "pd-standard",
+				ReplicationType:      "none",
+				DiskEncryptionKMSKey: "",
+				Tags:                 map[string]string{},
+				Labels:               map[string]string{},
+				MkfsOptions:          "-i 65536 -O ^has_journal",
+			},
+		},
+		{
+			name:       "invalid mkfs options",
+			parameters: map[string]string{ParameterKeyMkfsOptions: "-J device=/dev/sdb"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "fsck on stage",
+			parameters: map[string]string{ParameterKeyFsckOnStage: "Check"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:             "pd-standard",
+				ReplicationType:      "none",
+				DiskEncryptionKMSKey: "",
+				Tags:                 map[string]string{},
+				Labels:               map[string]string{},
+				FsckOnStage:          "check",
+			},
+		},
+		{
+			name:       "invalid fsck on stage",
+			parameters: map[string]string{ParameterKeyFsckOnStage: "always"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
+		{
+			name:       "node encryption",
+			parameters: map[string]string{ParameterKeyNodeEncryption: "LUKS"},
+			labels:     map[string]string{},
+			expectParams: DiskParameters{
+				DiskType:             "pd-standard",
+				ReplicationType:      "none",
+				DiskEncryptionKMSKey: "",
+				Tags:                 map[string]string{},
+				Labels:               map[string]string{},
+				NodeEncryption:       "luks",
+			},
+		},
+		{
+			name:       "invalid node encryption",
+			parameters: map[string]string{ParameterKeyNodeEncryption: "dm-crypt"},
+			labels:     map[string]string{},
+			expectErr:  true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -165,20 +377,40 @@ func TestExtractAndDefaultParameters(t *testing.T) {
 	}
 }
 
-// Currently the only parameter is storage-locations, which is already tested
-// in utils_test/TestSnapshotStorageLocations. Here we just test the case where
-// no parameter is set in the snapshot class.
+func TestExtractAndDefaultParametersWithoutTemplates(t *testing.T) {
+	parameters := map[string]string{ParameterKeyType: "pd-ssd", ParameterKeyLabels: "team=${pvc.namespace}"}
+	p, err := ExtractAndDefaultParametersWithoutTemplates(parameters, "testDriver", map[string]string{"volume": "${pv.name}"})
+	if err != nil {
+		t.Fatalf("ExtractAndDefaultParametersWithoutTemplates(%+v) failed: %v", parameters, err)
+	}
+	expectParams := DiskParameters{
+		DiskType:        "pd-ssd",
+		ReplicationType: "none",
+		Tags:            map[string]string{},
+		Labels:          map[string]string{"team": "${pvc.namespace}", "volume": "${pv.name}"},
+	}
+	if !reflect.DeepEqual(p, expectParams) {
+		t.Errorf("ExtractAndDefaultParametersWithoutTemplates(%+v) = %v; expected params: %v", parameters, p, expectParams)
+	}
+}
+
+// The storage-locations parameter is already tested in
+// utils_test/TestSnapshotStorageLocations. Here we just test the other
+// parameters and the case where no parameter is set in the snapshot class.
 func TestSnapshotParameters(t *testing.T) {
 	tests := []struct {
 		desc                    string
 		parameters              map[string]string
 		expectedSnapshotParames SnapshotParameters
+		expectErr               bool
 	}{
 		{
 			desc:       "valid parameter",
 			parameters: map[string]string{ParameterKeyStorageLocations: "ASIA "},
 			expectedSnapshotParames: SnapshotParameters{
 				StorageLocations: []string{"asia"},
+				SnapshotType:     DiskSnapshotType,
+				Labels:           map[string]string{},
 			},
 		},
 		{
@@ -186,14 +418,71 @@ func TestSnapshotParameters(t *testing.T) {
 			parameters: nil,
 			expectedSnapshotParames: SnapshotParameters{
 				StorageLocations: []string{},
+				SnapshotType:     DiskSnapshotType,
+				Labels:           map[string]string{},
+			},
+		},
+		{
+			desc: "image with family and labels",
+			parameters: map[string]string{
+				ParameterKeySnapshotType: "Images",
+				ParameterKeyImageFamily:  "db-backups",
+				ParameterKeyLabels:       "team=data",
+			},
+			expectedSnapshotParames: SnapshotParameters{
+				StorageLocations: []string{},
+				SnapshotType:     DiskImageType,
+				ImageFamily:      "db-backups",
+				Labels:           map[string]string{"team": "data"},
 			},
 		},
+		{
+			desc:       "invalid snapshot type",
+			parameters: map[string]string{ParameterKeySnapshotType: "backups"},
+			expectErr:  true,
+		},
+		{
+			desc:       "image family for snapshot",
+			parameters: map[string]string{ParameterKeyImageFamily: "db-backups"},
+			expectErr:  true,
+		},
+		{
+			desc:       "label templates",
+			parameters: map[string]string{ParameterKeyLabels: "claim=${pvc.name}"},
+			expectErr:  true,
+		},
+		{
+			desc:       "guest flush",
+			parameters: map[string]string{ParameterKeyGuestFlush: "true"},
+			expectedSnapshotParames: SnapshotParameters{
+				StorageLocations: []string{},
+				SnapshotType:     DiskSnapshotType,
+				Labels:           map[string]string{},
+				GuestFlush:       true,
+			},
+		},
+		{
+			desc:       "invalid guest flush",
+			parameters: map[string]string{ParameterKeyGuestFlush: "yes please"},
+			expectErr:  true,
+		},
+		{
+			desc: "guest flush for image",
+			parameters: map[string]string{
+				ParameterKeySnapshotType: DiskImageType,
+				ParameterKeyGuestFlush:   "true",
+			},
+			expectErr: true,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.desc, func(t *testing.T) {
 			p, err := ExtractAndDefaultSnapshotParameters(tc.parameters)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("ExtractAndDefaultSnapshotParameters(%+v) = %v; expectedErr: %v", tc.parameters, err, tc.expectErr)
+			}
 			if err != nil {
-				t.Errorf("Got error processing snapshot parameters: %v; expect no error", err)
+				return
 			}
 			if !reflect.DeepEqual(p, tc.expectedSnapshotParames) {
 				t.Errorf("Got ExtractAndDefaultSnapshotParameters(%+v) = %+v; expect %+v", tc.parameters, p, tc.expectedSnapshotParames)
@@ -201,3 +490,94 @@ func TestSnapshotParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractCustomerEncryptionKey(t *testing.T) {
+	// "0123456789abcdef0123456789abcdef", a 32 byte key
+	rawKey := "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	// 256 bytes, the length of a key wrapped with a 2048 bit RSA key
+	rsaKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("0123456789abcdef", 16)))
+	tests := []struct {
+		desc        string
+		secrets     map[string]string
+		expectedKey *CustomerEncryptionKey
+		expectErr   bool
+	}{
+		{
+			desc:    "no secrets",
+			secrets: nil,
+		},
+		{
+			desc:    "unrelated secrets",
+			secrets: map[string]string{"foo": "bar"},
+		},
+		{
+			desc:        "raw key",
+			secrets:     map[string]string{SecretKeyDiskEncryptionRawKey: rawKey},
+			expectedKey: &CustomerEncryptionKey{RawKey: rawKey},
+		},
+		{
+			desc:      "raw key not base64",
+			secrets:   map[string]string{SecretKeyDiskEncryptionRawKey: "not base64!"},
+			expectErr: true,
+		},
+		{
+			desc:      "raw key too short",
+			secrets:   map[string]string{SecretKeyDiskEncryptionRawKey: "c2hvcnQ="},
+			expectErr: true,
+		},
+		{
+			desc:      "empty raw key",
+			secrets:   map[string]string{SecretKeyDiskEncryptionRawKey: ""},
+			expectErr: true,
+		},
+		{
+			desc:        "RSA-wrapped key",
+			secrets:     map[string]string{SecretKeyDiskEncryptionRSAKey: rsaKey},
+			expectedKey: &CustomerEncryptionKey{RSAEncryptedKey: rsaKey},
+		},
+		{
+			desc:      "RSA-wrapped key too short",
+			secrets:   map[string]string{SecretKeyDiskEncryptionRSAKey: rawKey},
+			expectErr: true,
+		},
+		{
+			desc:      "raw and RSA-wrapped keys",
+			secrets:   map[string]string{SecretKeyDiskEncryptionRawKey: rawKey, SecretKeyDiskEncryptionRSAKey: rsaKey},
+			expectErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			key, err := ExtractCustomerEncryptionKey(tc.secrets)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("ExtractCustomerEncryptionKey() = %v; expectedErr: %v", err, tc.expectErr)
+			}
+			if err != nil {
+				if strings.Contains(err.Error(), rawKey) || strings.Contains(err.Error(), rsaKey) {
+					t.Errorf("ExtractCustomerEncryptionKey() error %v contains the key", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(key, tc.expectedKey) {
+				t.Errorf("ExtractCustomerEncryptionKey() = %+v; expected %+v", key, tc.expectedKey)
+			}
+		})
+	}
+}
+
+func TestCustomerEncryptionKeySha256(t *testing.T) {
+	// The SHA-256 hash of "0123456789abcdef0123456789abcdef", as GCE reports it
+	key := &CustomerEncryptionKey{RawKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}
+	if got, expected := key.Sha256(), "PrG9Q5lH63YpmOVmzMLgmceREYsvQFecxPfaK1Bht/k="; got != expected {
+		t.Errorf("Sha256() = %q; expected %q", got, expected)
+	}
+	var nilKey *CustomerEncryptionKey
+	if got := nilKey.Sha256(); got != "" {
+		t.Errorf("Sha256() of nil key = %q; expected empty", got)
+	}
+	// Only GCE can unwrap an RSA-wrapped key to hash it
+	rsaKey := &CustomerEncryptionKey{RSAEncryptedKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}
+	if got := rsaKey.Sha256(); got != "" {
+		t.Errorf("Sha256() of RSA-wrapped key = %q; expected empty", got)
+	}
+}
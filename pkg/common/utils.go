@@ -19,6 +19,8 @@ package common
 import (
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
@@ -40,6 +42,24 @@ const (
 	snapshotTotalElements = 5
 	snapshotTopologyKey   = 2
 	snapshotProjectKey    = 1
+	snapshotTypeKey       = 3
+
+	// Group snapshot ID Expected Format
+	// "projects/{projectName}/global/groupSnapshots/{groupName}"
+	groupSnapshotIDFmt           = "projects/%s/global/groupSnapshots/%s"
+	groupSnapshotIDTotalElements = 5
+	groupSnapshotIDProjectValue  = 1
+	groupSnapshotIDGlobalValue   = 2
+	groupSnapshotIDTypeValue     = 3
+	groupSnapshotIDNameValue     = 4
+
+	// Image ID Expected Format
+	// "projects/{projectName}/global/images/{imageName}"
+	imageIDTotalElements = 5
+	imageIDProjectValue  = 1
+	imageIDGlobalValue   = 2
+	imageIDResourceValue = 3
+	imageIDNameValue     = 4
 
 	// Node ID Expected Format
 	// "projects/{projectName}/zones/{zoneName}/disks/{diskName}"
@@ -57,11 +77,50 @@ const (
 	multiRegionalLocationFmt = "^[a-z]+$"
 	// Example: us-east1
 	regionalLocationFmt = "^[a-z]+-[a-z]+[0-9]$"
+
+	// Templates that label values may contain. They are replaced with the
+	// name and namespace of the PVC and the name of the PV being provisioned.
+	LabelTemplatePVCName      = "${pvc.name}"
+	LabelTemplatePVCNamespace = "${pvc.namespace}"
+	LabelTemplatePVName       = "${pv.name}"
+
+	maxLabelValueLength = 63
+
+	// Resource policy names, such as snapshot schedules. Policies are looked
+	// up in the project and region of the disk they are attached to.
+	// Reference: https://cloud.google.com/compute/docs/naming-resources
+	resourcePolicyNameFmt = "^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$"
+
+	// Values of mkfs options, like "65536", "^has_journal,extent" or
+	// "size=4096,sectsize=512". They may not start with a dash, which would
+	// make them options.
+	mkfsOptionValueFmt = "^[a-zA-Z0-9^_.,:=+][a-zA-Z0-9^_.,:=+-]*$"
+
+	// mkfs formats devices as ext4 when no filesystem type is requested.
+	defaultMkfsFsType = "ext4"
 )
 
 var (
 	multiRegionalPattern = regexp.MustCompile(multiRegionalLocationFmt)
 	regionalPattern      = regexp.MustCompile(regionalLocationFmt)
+
+	labelTemplatePattern          = regexp.MustCompile(`\$\{[^}]*\}`)
+	invalidLabelValueCharsPattern = regexp.MustCompile(`[^\p{Ll}0-9_-]`)
+	labelTemplates                = sets.NewString(LabelTemplatePVCName, LabelTemplatePVCNamespace, LabelTemplatePVName)
+
+	resourcePolicyNamePattern = regexp.MustCompile(resourcePolicyNameFmt)
+
+	mkfsOptionValuePattern = regexp.MustCompile(mkfsOptionValueFmt)
+
+	// mkfsOptionsAllowList holds the mkfs options that may be set for each
+	// filesystem type, which all take a value. They tune the layout of the
+	// filesystem, such as its block size, inode ratio, reserved blocks and
+	// features.
+	mkfsOptionsAllowList = map[string]sets.String{
+		"ext3": sets.NewString("-b", "-E", "-i", "-I", "-m", "-N", "-O", "-T"),
+		"ext4": sets.NewString("-b", "-E", "-i", "-I", "-m", "-N", "-O", "-T"),
+		"xfs":  sets.NewString("-b", "-d", "-i", "-l", "-m", "-n", "-s"),
+	}
 )
 
 func BytesToGbRoundDown(bytes int64) int64 {
@@ -114,16 +173,39 @@ func GenerateUnderspecifiedVolumeID(diskName string, isZonal bool) string {
 	return fmt.Sprintf(volIDRegionalFmt, UnspecifiedValue, UnspecifiedValue, diskName)
 }
 
-func SnapshotIDToProjectKey(id string) (string, string, error) {
+// SnapshotIDToProjectKey returns the project, snapshot type and name of a
+// snapshot given an ID of the form projects/{project}/global/{type}/{name},
+// where the type is DiskSnapshotType or DiskImageType.
+func SnapshotIDToProjectKey(id string) (string, string, string, error) {
 	splitId := strings.Split(id, "/")
 	if len(splitId) != snapshotTotalElements {
-		return "", "", fmt.Errorf("failed to get id components. Expected projects/{project}/global/snapshot/{name}. Got: %s", id)
+		return "", "", "", fmt.Errorf("failed to get id components. Expected projects/{project}/global/{snapshots|images}/{name}. Got: %s", id)
 	}
-	if splitId[snapshotTopologyKey] == "global" {
-		return splitId[snapshotProjectKey], splitId[snapshotTotalElements-1], nil
-	} else {
-		return "", "", fmt.Errorf("could not get id components, expected global, got: %v", splitId[snapshotTopologyKey])
+	if splitId[snapshotTopologyKey] != "global" {
+		return "", "", "", fmt.Errorf("could not get id components, expected global, got: %v", splitId[snapshotTopologyKey])
+	}
+	switch splitId[snapshotTypeKey] {
+	case DiskSnapshotType, DiskImageType:
+		return splitId[snapshotProjectKey], splitId[snapshotTypeKey], splitId[snapshotTotalElements-1], nil
+	default:
+		return "", "", "", fmt.Errorf("could not get id components, expected %s or %s, got: %v", DiskSnapshotType, DiskImageType, splitId[snapshotTypeKey])
+	}
+}
+
+// ImageIDToProjectName returns the project and name of an image given an ID of
+// the form projects/{project}/global/images/{name}.
+func ImageIDToProjectName(id string) (string, string, error) {
+	splitId := strings.Split(id, "/")
+	if len(splitId) != imageIDTotalElements {
+		return "", "", fmt.Errorf("failed to get id components. Expected projects/{project}/global/images/{name}. Got: %s", id)
+	}
+	if splitId[0] != "projects" || splitId[imageIDGlobalValue] != "global" || splitId[imageIDResourceValue] != "images" {
+		return "", "", fmt.Errorf("failed to get id components. Expected projects/{project}/global/images/{name}. Got: %s", id)
 	}
+	if splitId[imageIDProjectValue] == "" || splitId[imageIDNameValue] == "" {
+		return "", "", fmt.Errorf("image id %s has an empty project or name", id)
+	}
+	return splitId[imageIDProjectValue], splitId[imageIDNameValue], nil
 }
 
 func NodeIDToZoneAndName(id string) (string, string, error) {
@@ -134,6 +216,83 @@ func NodeIDToZoneAndName(id string) (string, string, error) {
 	return splitId[nodeIDZoneValue], splitId[nodeIDNameValue], nil
 }
 
+// ValidateVolumeAttributes returns an error if the volume context of a volume
+// contains unknown keys or invalid values.
+func ValidateVolumeAttributes(attributes map[string]string) error {
+	for k, v := range attributes {
+		switch k {
+		case VolumeAttributePartition:
+		case VolumeAttributeForceAttach:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("volume context contains invalid %s %q, must be a boolean", k, v)
+			}
+		case VolumeAttributeMkfsOptions:
+			if err := validateMkfsOptionsForAnyFsType(v); err != nil {
+				return fmt.Errorf("volume context contains invalid %s: %w", k, err)
+			}
+		case VolumeAttributeFsckOnStage:
+			if err := ValidateFsckOnStage(v); err != nil {
+				return fmt.Errorf("volume context contains invalid %s: %w", k, err)
+			}
+		case VolumeAttributeNodeEncryption:
+			if err := ValidateNodeEncryption(v); err != nil {
+				return fmt.Errorf("volume context contains invalid %s: %w", k, err)
+			}
+		default:
+			return fmt.Errorf("volume context contains unknown key %q", k)
+		}
+	}
+	return nil
+}
+
+// ParseMkfsOptions splits options, which are mkfs options and their values
+// separated by spaces like "-i 65536 -m 1", into the arguments of mkfs. It
+// returns an error if an option is not allowed for fsType, where an empty
+// fsType is ext4, or if a value is not a plain word. Values cannot contain
+// slashes, so that options cannot name other devices or files.
+func ParseMkfsOptions(options, fsType string) ([]string, error) {
+	if fsType == "" {
+		fsType = defaultMkfsFsType
+	}
+	allowed, ok := mkfsOptionsAllowList[fsType]
+	if !ok {
+		return nil, fmt.Errorf("mkfs options are not supported for filesystem type %q", fsType)
+	}
+	args := strings.Fields(options)
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("mkfs options %q must be pairs of an option and its value", options)
+	}
+	for i := 0; i < len(args); i += 2 {
+		option, value := args[i], args[i+1]
+		if !allowed.Has(option) {
+			return nil, fmt.Errorf("mkfs option %q is not allowed for filesystem type %q, allowed options are %v", option, fsType, allowed.List())
+		}
+		if !mkfsOptionValuePattern.MatchString(value) {
+			return nil, fmt.Errorf("mkfs option %s has invalid value %q", option, value)
+		}
+	}
+	return args, nil
+}
+
+// validateMkfsOptionsForAnyFsType returns an error if options are not valid
+// for any filesystem type, for when the filesystem type is not known yet.
+func validateMkfsOptionsForAnyFsType(options string) error {
+	fsTypes := make([]string, 0, len(mkfsOptionsAllowList))
+	for fsType := range mkfsOptionsAllowList {
+		fsTypes = append(fsTypes, fsType)
+	}
+	sort.Strings(fsTypes)
+	var errs []string
+	for _, fsType := range fsTypes {
+		_, err := ParseMkfsOptions(options, fsType)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return fmt.Errorf("mkfs options %q are not valid for any filesystem type: %s", options, strings.Join(errs, "; "))
+}
+
 func GetRegionFromZones(zones []string) (string, error) {
 	regions := sets.String{}
 	if len(zones) < 1 {
@@ -174,6 +333,8 @@ func CreateZonalVolumeID(project, zone, name string) string {
 
 // ConvertLabelsStringToMap converts the labels from string to map
 // example: "key1=value1,key2=value2" gets converted into {"key1": "value1", "key2": "value2"}
+// Label values may contain the LabelTemplate* templates, which are left in
+// place to be expanded when a disk is provisioned.
 // See https://cloud.google.com/compute/docs/labeling-resources#label_format for details.
 func ConvertLabelsStringToMap(labels string) (map[string]string, error) {
 	const labelsDelimiter = ","
@@ -194,7 +355,14 @@ func ConvertLabelsStringToMap(labels string) (map[string]string, error) {
 
 	regexValue, _ := regexp.Compile(`^[\p{Ll}0-9_-]{0,63}$`)
 	checkLabelValueFn := func(value string) error {
-		if !regexValue.MatchString(value) {
+		for _, template := range labelTemplatePattern.FindAllString(value, -1) {
+			if !labelTemplates.Has(template) {
+				return fmt.Errorf("label value %q contains unknown template %q (supported templates are %s)", value, template, strings.Join(labelTemplates.List(), ", "))
+			}
+		}
+		// Templates expand to valid label values, so only the rest of the
+		// value is checked.
+		if !regexValue.MatchString(labelTemplatePattern.ReplaceAllString(value, "")) {
 			return fmt.Errorf("label value %q is invalid (lowercase letter, digit, _ and - chars are allowed / 0-63 characters", value)
 		}
 
@@ -230,6 +398,75 @@ func ConvertLabelsStringToMap(labels string) (map[string]string, error) {
 	return labelsMap, nil
 }
 
+// expandLabelTemplates replaces the templates in the label values with their
+// values from templateValues, sanitized to fit GCE's label value format. It
+// returns an error if a label uses a template that has no value.
+func expandLabelTemplates(labels map[string]string, templateValues map[string]string) error {
+	for key, value := range labels {
+		missingTemplate := ""
+		expanded := labelTemplatePattern.ReplaceAllStringFunc(value, func(template string) string {
+			templateValue, ok := templateValues[template]
+			if !ok {
+				missingTemplate = template
+			}
+			return sanitizeLabelValue(templateValue)
+		})
+		if missingTemplate != "" {
+			return fmt.Errorf("label %q uses template %s, which has no value", key, missingTemplate)
+		}
+		if runes := []rune(expanded); len(runes) > maxLabelValueLength {
+			expanded = string(runes[:maxLabelValueLength])
+		}
+		labels[key] = expanded
+	}
+	return nil
+}
+
+// sanitizeLabelValue lower cases value and replaces the characters that are
+// not allowed in label values with "-".
+func sanitizeLabelValue(value string) string {
+	return invalidLabelValueCharsPattern.ReplaceAllString(strings.ToLower(value), "-")
+}
+
+// CreateGroupSnapshotID returns the ID of the group snapshot with the given
+// project and name.
+func CreateGroupSnapshotID(project, name string) string {
+	return fmt.Sprintf(groupSnapshotIDFmt, project, name)
+}
+
+// GroupSnapshotIDToProjectName returns the project and name of a group
+// snapshot given an ID of the form
+// projects/{project}/global/groupSnapshots/{name}.
+func GroupSnapshotIDToProjectName(id string) (string, string, error) {
+	splitID := strings.Split(id, "/")
+	if len(splitID) != groupSnapshotIDTotalElements || splitID[0] != "projects" || splitID[groupSnapshotIDGlobalValue] != "global" || splitID[groupSnapshotIDTypeValue] != "groupSnapshots" {
+		return "", "", fmt.Errorf("failed to get id components. Expected projects/{project}/global/groupSnapshots/{name}. Got: %s", id)
+	}
+	if splitID[groupSnapshotIDProjectValue] == "" || splitID[groupSnapshotIDNameValue] == "" {
+		return "", "", fmt.Errorf("failed to get id components, project or name is empty. Got: %s", id)
+	}
+	return splitID[groupSnapshotIDProjectValue], splitID[groupSnapshotIDNameValue], nil
+}
+
+// ConvertResourcePoliciesStringToList converts a comma separated list of
+// resource policy names into a list, dropping empty and duplicate names.
+func ConvertResourcePoliciesStringToList(str string) ([]string, error) {
+	policies := []string{}
+	seen := sets.NewString()
+	for _, policy := range strings.Split(str, ",") {
+		policy = strings.TrimSpace(policy)
+		if policy == "" || seen.Has(policy) {
+			continue
+		}
+		if !resourcePolicyNamePattern.MatchString(policy) {
+			return nil, fmt.Errorf("resource policy name %q is invalid, it must be a lower case resource name in the region of the disk", policy)
+		}
+		seen.Insert(policy)
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
 // ProcessStorageLocations trims and normalizes storage location to lower letters.
 func ProcessStorageLocations(storageLocations string) ([]string, error) {
 	normalizedLoc := strings.ToLower(strings.TrimSpace(storageLocations))
@@ -253,6 +253,139 @@ func TestNodeIDToZoneAndName(t *testing.T) {
 	}
 }
 
+func TestImageIDToProjectName(t *testing.T) {
+	testCases := []struct {
+		name       string
+		imageID    string
+		expProject string
+		expName    string
+		expErr     bool
+	}{
+		{
+			name:       "normal",
+			imageID:    "projects/test-project/global/images/test-image",
+			expProject: "test-project",
+			expName:    "test-image",
+		},
+		{
+			name:    "snapshot id",
+			imageID: "projects/test-project/global/snapshots/test-snapshot",
+			expErr:  true,
+		},
+		{
+			name:    "empty name",
+			imageID: "projects/test-project/global/images/",
+			expErr:  true,
+		},
+		{
+			name:    "malformed",
+			imageID: "wrong",
+			expErr:  true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		project, name, err := ImageIDToProjectName(tc.imageID)
+		if err == nil && tc.expErr {
+			t.Errorf("Expected error but got none")
+		}
+		if err != nil {
+			if !tc.expErr {
+				t.Errorf("Did not expect error but got: %v", err)
+			}
+			continue
+		}
+
+		if !(project == tc.expProject && name == tc.expName) {
+			t.Errorf("got wrong project/name %s/%s, expected %s/%s", project, name, tc.expProject, tc.expName)
+		}
+	}
+}
+
+func TestSnapshotIDToProjectKey(t *testing.T) {
+	testCases := []struct {
+		name            string
+		snapshotID      string
+		expProject      string
+		expSnapshotType string
+		expName         string
+		expErr          bool
+	}{
+		{
+			name:            "snapshot",
+			snapshotID:      "projects/test-project/global/snapshots/test-snapshot",
+			expProject:      "test-project",
+			expSnapshotType: DiskSnapshotType,
+			expName:         "test-snapshot",
+		},
+		{
+			name:            "image",
+			snapshotID:      "projects/test-project/global/images/test-image",
+			expProject:      "test-project",
+			expSnapshotType: DiskImageType,
+			expName:         "test-image",
+		},
+		{
+			name:       "unknown type",
+			snapshotID: "projects/test-project/global/disks/test-disk",
+			expErr:     true,
+		},
+		{
+			name:       "not global",
+			snapshotID: "projects/test-project/zones/snapshots/test-snapshot",
+			expErr:     true,
+		},
+		{
+			name:       "malformed",
+			snapshotID: "wrong",
+			expErr:     true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		project, snapshotType, name, err := SnapshotIDToProjectKey(tc.snapshotID)
+		if err == nil && tc.expErr {
+			t.Errorf("Expected error but got none")
+		}
+		if err != nil {
+			if !tc.expErr {
+				t.Errorf("Did not expect error but got: %v", err)
+			}
+			continue
+		}
+
+		if project != tc.expProject || snapshotType != tc.expSnapshotType || name != tc.expName {
+			t.Errorf("got wrong project/type/name %s/%s/%s, expected %s/%s/%s", project, snapshotType, name, tc.expProject, tc.expSnapshotType, tc.expName)
+		}
+	}
+}
+
+func TestGroupSnapshotID(t *testing.T) {
+	id := CreateGroupSnapshotID("test-project", "group")
+	if id != "projects/test-project/global/groupSnapshots/group" {
+		t.Errorf("Got group snapshot ID %s, expected projects/test-project/global/groupSnapshots/group", id)
+	}
+	project, name, err := GroupSnapshotIDToProjectName(id)
+	if err != nil {
+		t.Fatalf("Did not expect error for group snapshot ID %s but got: %v", id, err)
+	}
+	if project != "test-project" || name != "group" {
+		t.Errorf("Got project %s and name %s from %s, expected test-project and group", project, name, id)
+	}
+
+	for _, invalidID := range []string{
+		"projects/test-project/global/snapshots/group-0",
+		"projects/test-project/global/groupSnapshots",
+		"projects/test-project/global/groupSnapshots/",
+		"projects//global/groupSnapshots/group",
+		"groupSnapshots/group/projects/test-project/global/snapshots/group-0",
+	} {
+		if _, _, err := GroupSnapshotIDToProjectName(invalidID); err == nil {
+			t.Errorf("Expected error for group snapshot ID %s but got none", invalidID)
+		}
+	}
+}
+
 func TestGetRegionFromZones(t *testing.T) {
 	testCases := []struct {
 		name      string
@@ -517,6 +650,21 @@ func TestConvertLabelsStringToMap(t *testing.T) {
 				labels:        "k=my_value-2",
 				expectedError: false,
 			},
+			{
+				name:          "label value can contain templates",
+				labels:        "team=${pvc.namespace},claim=pvc-${pvc.name},volume=${pv.name}",
+				expectedError: false,
+			},
+			{
+				name:          "label value cannot contain unknown templates",
+				labels:        "k=${pod.name}",
+				expectedError: true,
+			},
+			{
+				name:          "label value around templates can only contain lowercase chars, digits, _ and -",
+				labels:        "k=${pvc.name}.Claim",
+				expectedError: true,
+			},
 		}
 
 		for _, tc := range testCases {
@@ -577,3 +725,139 @@ func TestSnapshotStorageLocations(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateVolumeAttributes(t *testing.T) {
+	testCases := []struct {
+		name       string
+		attributes map[string]string
+		expectErr  bool
+	}{
+		{
+			name: "no attributes",
+		},
+		{
+			name:       "known attributes",
+			attributes: map[string]string{VolumeAttributePartition: "1", VolumeAttributeForceAttach: "true"},
+		},
+		{
+			name:       "invalid force attach",
+			attributes: map[string]string{VolumeAttributeForceAttach: "maybe"},
+			expectErr:  true,
+		},
+		{
+			name:       "mkfs options",
+			attributes: map[string]string{VolumeAttributeMkfsOptions: "-b size=4096 -m reflink=1"},
+		},
+		{
+			name:       "invalid mkfs options",
+			attributes: map[string]string{VolumeAttributeMkfsOptions: "-F"},
+			expectErr:  true,
+		},
+		{
+			name:       "fsck on stage",
+			attributes: map[string]string{VolumeAttributeFsckOnStage: "repair"},
+		},
+		{
+			name:       "invalid fsck on stage",
+			attributes: map[string]string{VolumeAttributeFsckOnStage: "always"},
+			expectErr:  true,
+		},
+		{
+			name:       "node encryption",
+			attributes: map[string]string{VolumeAttributeNodeEncryption: "luks"},
+		},
+		{
+			name:       "invalid node encryption",
+			attributes: map[string]string{VolumeAttributeNodeEncryption: "dm-crypt"},
+			expectErr:  true,
+		},
+		{
+			name:       "unknown attribute",
+			attributes: map[string]string{"foo": "bar"},
+			expectErr:  true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateVolumeAttributes(tc.attributes)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Errorf("ValidateVolumeAttributes(%v) = %v; expectErr: %v", tc.attributes, err, tc.expectErr)
+			}
+		})
+	}
+}
+
+func TestParseMkfsOptions(t *testing.T) {
+	testCases := []struct {
+		name         string
+		options      string
+		fsType       string
+		expectedArgs []string
+		expectErr    bool
+	}{
+		{
+			name:         "ext4 options",
+			options:      "-i 65536  -m 1 -O ^has_journal,extent",
+			fsType:       "ext4",
+			expectedArgs: []string{"-i", "65536", "-m", "1", "-O", "^has_journal,extent"},
+		},
+		{
+			name:         "default filesystem type is ext4",
+			options:      "-b 4096",
+			expectedArgs: []string{"-b", "4096"},
+		},
+		{
+			name:         "xfs options",
+			options:      "-b size=4096 -m crc=1,reflink=1",
+			fsType:       "xfs",
+			expectedArgs: []string{"-b", "size=4096", "-m", "crc=1,reflink=1"},
+		},
+		{
+			name:         "no options",
+			options:      "",
+			fsType:       "xfs",
+			expectedArgs: []string{},
+		},
+		{
+			name:      "option of another filesystem type",
+			options:   "-d agcount=4",
+			fsType:    "ext4",
+			expectErr: true,
+		},
+		{
+			name:      "option without value",
+			options:   "-i 65536 -m",
+			fsType:    "ext4",
+			expectErr: true,
+		},
+		{
+			name:      "value that is an option",
+			options:   "-i -F",
+			fsType:    "ext4",
+			expectErr: true,
+		},
+		{
+			name:      "value that is a path",
+			options:   "-l logdev=/dev/sdb",
+			fsType:    "xfs",
+			expectErr: true,
+		},
+		{
+			name:      "unsupported filesystem type",
+			options:   "-b 4096",
+			fsType:    "ntfs",
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := ParseMkfsOptions(tc.options, tc.fsType)
+			if gotErr := err != nil; gotErr != tc.expectErr {
+				t.Fatalf("ParseMkfsOptions(%q, %q) = %v; expectErr: %v", tc.options, tc.fsType, err, tc.expectErr)
+			}
+			if err == nil && !reflect.DeepEqual(args, tc.expectedArgs) {
+				t.Errorf("ParseMkfsOptions(%q, %q) = %v; expected %v", tc.options, tc.fsType, args, tc.expectedArgs)
+			}
+		})
+	}
+}
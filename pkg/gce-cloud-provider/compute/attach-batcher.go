@@ -0,0 +1,256 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcecloudprovider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	computev1 "google.golang.org/api/compute/v1"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
+)
+
+// AttachRequest is a disk to attach to or detach from an instance.
+type AttachRequest struct {
+	Project string
+	VolKey  *meta.Key
+	// DeviceName is the name the disk is attached to the instance as.
+	DeviceName string
+
+	// ReadWrite, DiskType, EncryptionKey and ForceAttach are passed to
+	// AttachDisk, and unused when detaching.
+	ReadWrite     string
+	DiskType      string
+	EncryptionKey *common.CustomerEncryptionKey
+	ForceAttach   bool
+
+	// IsAttached reports whether the disk is attached to the instance, or an
+	// error if it is attached incompatibly with the request. If it is nil,
+	// the disk is attached if the instance has a disk with its device name.
+	IsAttached func(instance *computev1.Instance) (bool, error)
+}
+
+func (r *AttachRequest) isAttached(instance *computev1.Instance) (bool, error) {
+	if r.IsAttached != nil {
+		return r.IsAttached(instance)
+	}
+	for _, disk := range instance.Disks {
+		if disk.DeviceName == r.DeviceName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// IncompatibleAttachmentError is returned for disks that are already attached
+// to the instance incompatibly with the request.
+type IncompatibleAttachmentError struct {
+	Err error
+}
+
+func (e *IncompatibleAttachmentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *IncompatibleAttachmentError) Unwrap() error {
+	return e.Err
+}
+
+// batchTimeout bounds the instance reads of a batch when one of its callers
+// has no deadline.
+const batchTimeout = 5 * time.Minute
+
+// batchedRequest is an attach or detach request waiting for its batch.
+type batchedRequest struct {
+	ctx    context.Context
+	req    AttachRequest
+	detach bool
+	result chan error
+}
+
+// AttachBatcher coalesces the attach and detach calls for the disks of each
+// instance. Calls for an instance that arrive while a batch for it is being
+// processed are grouped into the next batch. A batch reads the instance once
+// to skip disks that are already attached or detached, attaches and detaches
+// the others one after another, as GCE serializes the operations of an
+// instance anyway, and reads the instance once more to confirm the
+// attachments. Batches for different instances run concurrently.
+type AttachBatcher struct {
+	cloud GCECompute
+
+	// mu guards pending, which holds the requests waiting for the next
+	// batch of each instance. An instance has an entry while a goroutine is
+	// processing its batches.
+	mu      sync.Mutex
+	pending map[string][]*batchedRequest
+}
+
+func NewAttachBatcher(cloud GCECompute) *AttachBatcher {
+	return &AttachBatcher{
+		cloud:   cloud,
+		pending: map[string][]*batchedRequest{},
+	}
+}
+
+// Attach attaches the disk to the instance, unless it is already attached.
+// It returns an *IncompatibleAttachmentError if the disk is attached
+// incompatibly with the request.
+func (b *AttachBatcher) Attach(ctx context.Context, instanceZone, instanceName string, req AttachRequest) error {
+	return b.enqueue(ctx, instanceZone, instanceName, req, false /* detach */)
+}
+
+// Detach detaches the disk from the instance, unless it is not attached.
+func (b *AttachBatcher) Detach(ctx context.Context, instanceZone, instanceName string, req AttachRequest) error {
+	return b.enqueue(ctx, instanceZone, instanceName, req, true /* detach */)
+}
+
+// enqueue adds the request to the next batch of the instance, and waits for
+// its result, or until ctx is done.
+func (b *AttachBatcher) enqueue(ctx context.Context, instanceZone, instanceName string, req AttachRequest, detach bool) error {
+	request := &batchedRequest{
+		ctx:    ctx,
+		req:    req,
+		detach: detach,
+		result: make(chan error, 1),
+	}
+	instanceKey := fmt.Sprintf("%s/%s", instanceZone, instanceName)
+	b.mu.Lock()
+	_, running := b.pending[instanceKey]
+	b.pending[instanceKey] = append(b.pending[instanceKey], request)
+	if !running {
+		go b.run(instanceKey, instanceZone, instanceName)
+	}
+	b.mu.Unlock()
+
+	// The result channel is buffered, so the batch does not block on callers
+	// that stopped waiting.
+	select {
+	case err := <-request.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run processes the batches of the instance until no requests are pending.
+func (b *AttachBatcher) run(instanceKey, instanceZone, instanceName string) {
+	for {
+		b.mu.Lock()
+		batch := b.pending[instanceKey]
+		if len(batch) == 0 {
+			delete(b.pending, instanceKey)
+			b.mu.Unlock()
+			return
+		}
+		b.pending[instanceKey] = []*batchedRequest{}
+		b.mu.Unlock()
+
+		b.processBatch(instanceZone, instanceName, batch)
+	}
+}
+
+// processBatch attaches and detaches the disks of a batch, and sends the
+// result of each request.
+func (b *AttachBatcher) processBatch(instanceZone, instanceName string, batch []*batchedRequest) {
+	// Requests whose callers stopped waiting are dropped. The instance is read
+	// with a context of the batch, so that the other requests do not fail
+	// when one caller stops waiting.
+	live := make([]*batchedRequest, 0, len(batch))
+	for _, request := range batch {
+		if err := request.ctx.Err(); err != nil {
+			request.result <- err
+			continue
+		}
+		live = append(live, request)
+	}
+	if len(live) == 0 {
+		return
+	}
+	batch = live
+	ctx, cancel := batchContext(batch)
+	defer cancel()
+	klog.V(5).Infof("Processing %d attach and detach requests for instance %s", len(batch), instanceName)
+
+	instance, err := b.cloud.GetInstanceOrError(ctx, instanceZone, instanceName)
+	if err != nil {
+		for _, request := range batch {
+			request.result <- err
+		}
+		return
+	}
+
+	var attached []*batchedRequest
+	for _, request := range batch {
+		isAttached, err := request.req.isAttached(instance)
+		switch {
+		case err != nil:
+			request.result <- &IncompatibleAttachmentError{Err: err}
+		case request.ctx.Err() != nil:
+			request.result <- request.ctx.Err()
+		case isAttached != request.detach:
+			// Already attached, or already detached.
+			request.result <- nil
+		case request.detach:
+			request.result <- b.cloud.DetachDisk(request.ctx, request.req.Project, request.req.DeviceName, instanceZone, instanceName)
+		default:
+			r := request.req
+			if err := b.cloud.AttachDisk(request.ctx, r.Project, r.VolKey, r.ReadWrite, r.DiskType, instanceZone, instanceName, r.EncryptionKey, r.ForceAttach); err != nil {
+				request.result <- err
+				continue
+			}
+			attached = append(attached, request)
+		}
+	}
+	if len(attached) == 0 {
+		return
+	}
+
+	// Confirm the attachments with one more read of the instance, rather
+	// than polling each disk.
+	instance, err = b.cloud.GetInstanceOrError(ctx, instanceZone, instanceName)
+	for _, request := range attached {
+		if err != nil {
+			request.result <- fmt.Errorf("failed to confirm attach of disk %v: %w", request.req.VolKey, err)
+			continue
+		}
+		if isAttached, _ := request.req.isAttached(instance); !isAttached {
+			request.result <- fmt.Errorf("disk %v is not attached to instance %s after the attach completed", request.req.VolKey, instanceName)
+			continue
+		}
+		request.result <- nil
+	}
+}
+
+// batchContext returns a context for the instance reads of a batch that does
+// not depend on any single caller. Its deadline is the latest deadline of the
+// requests, or batchTimeout if one of them has none.
+func batchContext(batch []*batchedRequest) (context.Context, context.CancelFunc) {
+	var deadline time.Time
+	for _, request := range batch {
+		d, ok := request.ctx.Deadline()
+		if !ok {
+			return context.WithTimeout(context.Background(), batchTimeout)
+		}
+		if d.After(deadline) {
+			deadline = d
+		}
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
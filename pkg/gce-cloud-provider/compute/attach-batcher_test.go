@@ -0,0 +1,354 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcecloudprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	computev1 "google.golang.org/api/compute/v1"
+)
+
+const testInstance = "test-instance"
+
+// countingCloudProvider counts the instance reads of a FakeCloudProvider, and
+// blocks each of them until it receives from release. Reads fail if their
+// context is done once released.
+type countingCloudProvider struct {
+	*FakeCloudProvider
+	release chan struct{}
+
+	mu            sync.Mutex
+	instanceReads int
+}
+
+func (cloud *countingCloudProvider) GetInstanceOrError(ctx context.Context, instanceZone, instanceName string) (*computev1.Instance, error) {
+	cloud.mu.Lock()
+	cloud.instanceReads++
+	cloud.mu.Unlock()
+	<-cloud.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return cloud.FakeCloudProvider.GetInstanceOrError(ctx, instanceZone, instanceName)
+}
+
+func (cloud *countingCloudProvider) reads() int {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+	return cloud.instanceReads
+}
+
+func newTestAttachRequest(diskName string) AttachRequest {
+	return AttachRequest{
+		Project:    testProject,
+		VolKey:     meta.ZonalKey(diskName, testZone),
+		DeviceName: diskName,
+		ReadWrite:  "READ_WRITE",
+		DiskType:   "PERSISTENT",
+	}
+}
+
+func attachedDeviceNames(t *testing.T, fcp *FakeCloudProvider, instanceName string) map[string]bool {
+	instance, err := fcp.GetInstanceOrError(context.Background(), testZone, instanceName)
+	if err != nil {
+		t.Fatalf("Failed to get instance %s: %v", instanceName, err)
+	}
+	names := map[string]bool{}
+	for _, disk := range instance.Disks {
+		names[disk.DeviceName] = true
+	}
+	return names
+}
+
+// waitFor polls until condition is true, and fails the test after a minute.
+func waitFor(t *testing.T, condition func() bool) {
+	for start := time.Now(); !condition(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Minute {
+			t.Fatalf("Timed out waiting for condition")
+		}
+	}
+}
+
+func TestAttachBatcher(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	testCases := []struct {
+		name          string
+		ctx           context.Context
+		instanceName  string
+		attachedDisks []*computev1.AttachedDisk
+		detach        bool
+		isAttached    func(instance *computev1.Instance) (bool, error)
+		expErr        func(err error) bool
+		expAttached   bool
+		expOperations int
+	}{
+		{
+			name:          "attach",
+			expAttached:   true,
+			expOperations: 1,
+		},
+		{
+			name:          "attach already attached",
+			attachedDisks: []*computev1.AttachedDisk{{DeviceName: "disk", Mode: "READ_WRITE"}},
+			expAttached:   true,
+		},
+		{
+			name:          "attach already attached incompatibly",
+			attachedDisks: []*computev1.AttachedDisk{{DeviceName: "disk", Mode: "READ_ONLY"}},
+			isAttached: func(instance *computev1.Instance) (bool, error) {
+				return true, fmt.Errorf("disk mode does not match")
+			},
+			expErr: func(err error) bool {
+				var incompatibleErr *IncompatibleAttachmentError
+				return errors.As(err, &incompatibleErr)
+			},
+			expAttached: true,
+		},
+		{
+			name:         "attach to missing instance",
+			instanceName: "missing-instance",
+			expErr:       IsGCENotFoundError,
+		},
+		{
+			name: "attach after the caller stopped waiting",
+			ctx:  canceledCtx,
+			expErr: func(err error) bool {
+				return errors.Is(err, context.Canceled)
+			},
+		},
+		{
+			name:          "detach",
+			attachedDisks: []*computev1.AttachedDisk{{DeviceName: "disk", Mode: "READ_WRITE"}},
+			detach:        true,
+		},
+		{
+			name:   "detach already detached",
+			detach: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fcp, err := CreateFakeCloudProvider(testProject, testZone, nil)
+			if err != nil {
+				t.Fatalf("Failed to create fake cloud provider: %v", err)
+			}
+			fcp.InsertInstance(&computev1.Instance{Disks: tc.attachedDisks}, testZone, testInstance)
+			batcher := NewAttachBatcher(fcp)
+
+			ctx := tc.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			instanceName := tc.instanceName
+			if instanceName == "" {
+				instanceName = testInstance
+			}
+			req := newTestAttachRequest("disk")
+			req.IsAttached = tc.isAttached
+			if tc.detach {
+				err = batcher.Detach(ctx, testZone, instanceName, req)
+			} else {
+				err = batcher.Attach(ctx, testZone, instanceName, req)
+			}
+			if tc.expErr == nil && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tc.expErr != nil && !tc.expErr(err) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if attached := attachedDeviceNames(t, fcp, testInstance)["disk"]; attached != tc.expAttached {
+				t.Errorf("Got disk attached %v, expected %v", attached, tc.expAttached)
+			}
+			if fcp.issuedOperations != tc.expOperations {
+				t.Errorf("Got %d operations, expected %d", fcp.issuedOperations, tc.expOperations)
+			}
+		})
+	}
+}
+
+func TestAttachBatcherCoalescesRequests(t *testing.T) {
+	const numDisks = 10
+	fcp, err := CreateFakeCloudProvider(testProject, testZone, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	fcp.InsertInstance(&computev1.Instance{}, testZone, testInstance)
+	cloud := &countingCloudProvider{FakeCloudProvider: fcp, release: make(chan struct{})}
+	batcher := NewAttachBatcher(cloud)
+
+	errs := make(chan error, numDisks)
+	attach := func(diskName string) {
+		errs <- batcher.Attach(context.Background(), testZone, testInstance, newTestAttachRequest(diskName))
+	}
+
+	// The first request reads the instance, and the others wait for the
+	// next batch while it is blocked.
+	go attach("disk-0")
+	waitFor(t, func() bool { return cloud.reads() == 1 })
+	for i := 1; i < numDisks; i++ {
+		go attach(fmt.Sprintf("disk-%d", i))
+	}
+	instanceKey := fmt.Sprintf("%s/%s", testZone, testInstance)
+	waitFor(t, func() bool {
+		batcher.mu.Lock()
+		defer batcher.mu.Unlock()
+		return len(batcher.pending[instanceKey]) == numDisks-1
+	})
+	close(cloud.release)
+
+	for i := 0; i < numDisks; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	attached := attachedDeviceNames(t, fcp, testInstance)
+	for i := 0; i < numDisks; i++ {
+		if diskName := fmt.Sprintf("disk-%d", i); !attached[diskName] {
+			t.Errorf("Disk %s is not attached", diskName)
+		}
+	}
+	// Each of the two batches reads the instance before attaching its disks
+	// and once more to confirm them.
+	if reads := cloud.reads(); reads != 4 {
+		t.Errorf("Got %d instance reads, expected 4", reads)
+	}
+}
+
+func TestAttachBatcherCanceledCaller(t *testing.T) {
+	fcp, err := CreateFakeCloudProvider(testProject, testZone, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	fcp.InsertInstance(&computev1.Instance{}, testZone, testInstance)
+	cloud := &countingCloudProvider{FakeCloudProvider: fcp, release: make(chan struct{})}
+	batcher := NewAttachBatcher(cloud)
+	instanceKey := fmt.Sprintf("%s/%s", testZone, testInstance)
+	pending := func(n int) func() bool {
+		return func() bool {
+			batcher.mu.Lock()
+			defer batcher.mu.Unlock()
+			return len(batcher.pending[instanceKey]) == n
+		}
+	}
+
+	// The first batch blocks on its instance read while the canceled caller
+	// and the waiting caller are queued for the second batch, in that order.
+	firstErr := make(chan error, 1)
+	go func() {
+		firstErr <- batcher.Attach(context.Background(), testZone, testInstance, newTestAttachRequest("disk-0"))
+	}()
+	waitFor(t, func() bool { return cloud.reads() == 1 })
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	canceledErr := make(chan error, 1)
+	go func() {
+		canceledErr <- batcher.Attach(canceledCtx, testZone, testInstance, newTestAttachRequest("disk-1"))
+	}()
+	waitFor(t, pending(1))
+	waitingErr := make(chan error, 1)
+	go func() {
+		waitingErr <- batcher.Attach(context.Background(), testZone, testInstance, newTestAttachRequest("disk-2"))
+	}()
+	waitFor(t, pending(2))
+
+	// Let the first batch read and confirm, and block the second batch on its
+	// first read.
+	cloud.release <- struct{}{}
+	cloud.release <- struct{}{}
+	if err := <-firstErr; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	waitFor(t, func() bool { return cloud.reads() == 3 })
+
+	// The canceled caller returns without waiting for its batch.
+	cancel()
+	if err := <-canceledErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Got error %v, expected %v", err, context.Canceled)
+	}
+	close(cloud.release)
+
+	// The batch continues for the waiting caller, and skips the disk of the
+	// canceled one.
+	if err := <-waitingErr; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	attached := attachedDeviceNames(t, fcp, testInstance)
+	if !attached["disk-2"] {
+		t.Errorf("Disk disk-2 is not attached")
+	}
+	if attached["disk-1"] {
+		t.Errorf("Disk disk-1 of the canceled caller is attached")
+	}
+}
+
+func TestAttachBatcherConcurrentInstances(t *testing.T) {
+	const numInstances, numDisks = 5, 10
+	fcp, err := CreateFakeCloudProvider(testProject, testZone, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	for i := 0; i < numInstances; i++ {
+		fcp.InsertInstance(&computev1.Instance{}, testZone, fmt.Sprintf("instance-%d", i))
+	}
+	batcher := NewAttachBatcher(fcp)
+
+	// Each disk is attached and detached concurrently with the disks of the
+	// same and other instances.
+	var wg sync.WaitGroup
+	errs := make(chan error, numInstances*numDisks)
+	for i := 0; i < numInstances; i++ {
+		for j := 0; j < numDisks; j++ {
+			wg.Add(1)
+			go func(instanceName, diskName string) {
+				defer wg.Done()
+				req := newTestAttachRequest(diskName)
+				if err := batcher.Attach(context.Background(), testZone, instanceName, req); err != nil {
+					errs <- fmt.Errorf("failed to attach %s to %s: %v", diskName, instanceName, err)
+					return
+				}
+				if err := batcher.Detach(context.Background(), testZone, instanceName, req); err != nil {
+					errs <- fmt.Errorf("failed to detach %s from %s: %v", diskName, instanceName, err)
+				}
+			}(fmt.Sprintf("instance-%d", i), fmt.Sprintf("disk-%d-%d", i, j))
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for i := 0; i < numInstances; i++ {
+		if attached := attachedDeviceNames(t, fcp, fmt.Sprintf("instance-%d", i)); len(attached) != 0 {
+			t.Errorf("Disks %v are still attached to instance-%d", attached, i)
+		}
+	}
+	if fcp.issuedOperations != numInstances*numDisks {
+		t.Errorf("Got %d attach operations, expected %d", fcp.issuedOperations, numInstances*numDisks)
+	}
+	// The goroutine of each instance exits once its last batch is done.
+	waitFor(t, func() bool {
+		batcher.mu.Lock()
+		defer batcher.mu.Unlock()
+		return len(batcher.pending) == 0
+	})
+}
@@ -18,13 +18,15 @@ import (
 	"strings"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	computealpha "google.golang.org/api/compute/v0.alpha"
 	computebeta "google.golang.org/api/compute/v0.beta"
 	computev1 "google.golang.org/api/compute/v1"
 )
 
 type CloudDisk struct {
-	disk     *computev1.Disk
-	betaDisk *computebeta.Disk
+	disk      *computev1.Disk
+	betaDisk  *computebeta.Disk
+	alphaDisk *computealpha.Disk
 }
 
 type CloudDiskType string
@@ -41,6 +43,12 @@ func CloudDiskFromBeta(disk *computebeta.Disk) *CloudDisk {
 	}
 }
 
+func CloudDiskFromAlpha(disk *computealpha.Disk) *CloudDisk {
+	return &CloudDisk{
+		alphaDisk: disk,
+	}
+}
+
 func (d *CloudDisk) LocationType() meta.KeyType {
 	var zone, region string
 	switch {
@@ -50,6 +58,9 @@ func (d *CloudDisk) LocationType() meta.KeyType {
 	case d.betaDisk != nil:
 		zone = d.betaDisk.Zone
 		region = d.betaDisk.Region
+	case d.alphaDisk != nil:
+		zone = d.alphaDisk.Zone
+		region = d.alphaDisk.Region
 	}
 	switch {
 	case zone != "":
@@ -67,17 +78,34 @@ func (d *CloudDisk) GetUsers() []string {
 		return d.disk.Users
 	case d.betaDisk != nil:
 		return d.betaDisk.Users
+	case d.alphaDisk != nil:
+		return d.alphaDisk.Users
 	default:
 		return nil
 	}
 }
 
+// setUsers sets the instances the disk is attached to, used ONLY for testing
+// purposes.
+func (d *CloudDisk) setUsers(users []string) {
+	switch {
+	case d.disk != nil:
+		d.disk.Users = users
+	case d.betaDisk != nil:
+		d.betaDisk.Users = users
+	case d.alphaDisk != nil:
+		d.alphaDisk.Users = users
+	}
+}
+
 func (d *CloudDisk) GetName() string {
 	switch {
 	case d.disk != nil:
 		return d.disk.Name
 	case d.betaDisk != nil:
 		return d.betaDisk.Name
+	case d.alphaDisk != nil:
+		return d.alphaDisk.Name
 	default:
 		return ""
 	}
@@ -89,6 +117,8 @@ func (d *CloudDisk) GetKind() string {
 		return d.disk.Kind
 	case d.betaDisk != nil:
 		return d.betaDisk.Kind
+	case d.alphaDisk != nil:
+		return d.alphaDisk.Kind
 	default:
 		return ""
 	}
@@ -100,11 +130,25 @@ func (d *CloudDisk) GetStatus() string {
 		return d.disk.Status
 	case d.betaDisk != nil:
 		return d.betaDisk.Status
+	case d.alphaDisk != nil:
+		return d.alphaDisk.Status
 	default:
 		return "Unknown"
 	}
 }
 
+// setStatus sets the status of the disk, used ONLY for testing purposes.
+func (d *CloudDisk) setStatus(status string) {
+	switch {
+	case d.disk != nil:
+		d.disk.Status = status
+	case d.betaDisk != nil:
+		d.betaDisk.Status = status
+	case d.alphaDisk != nil:
+		d.alphaDisk.Status = status
+	}
+}
+
 // GetPDType returns the type of the PD, which is stored as a url like
 // projects/project/zones/zone/diskTypes/pd-standard. The returned type is not
 // validated, it is just passed verbatium from GCP.
@@ -115,6 +159,8 @@ func (d *CloudDisk) GetPDType() string {
 		pdType = d.disk.Type
 	case d.betaDisk != nil:
 		pdType = d.betaDisk.Type
+	case d.alphaDisk != nil:
+		pdType = d.alphaDisk.Type
 	default:
 		return ""
 	}
@@ -128,6 +174,8 @@ func (d *CloudDisk) GetSelfLink() string {
 		return d.disk.SelfLink
 	case d.betaDisk != nil:
 		return d.betaDisk.SelfLink
+	case d.alphaDisk != nil:
+		return d.alphaDisk.SelfLink
 	default:
 		return ""
 	}
@@ -139,6 +187,8 @@ func (d *CloudDisk) GetSizeGb() int64 {
 		return d.disk.SizeGb
 	case d.betaDisk != nil:
 		return d.betaDisk.SizeGb
+	case d.alphaDisk != nil:
+		return d.alphaDisk.SizeGb
 	default:
 		return -1
 	}
@@ -152,6 +202,8 @@ func (d *CloudDisk) setSizeGb(size int64) {
 		d.disk.SizeGb = size
 	case d.betaDisk != nil:
 		d.betaDisk.SizeGb = size
+	case d.alphaDisk != nil:
+		d.alphaDisk.SizeGb = size
 	}
 }
 
@@ -161,17 +213,77 @@ func (d *CloudDisk) GetZone() string {
 		return d.disk.Zone
 	case d.betaDisk != nil:
 		return d.betaDisk.Zone
+	case d.alphaDisk != nil:
+		return d.alphaDisk.Zone
 	default:
 		return ""
 	}
 }
 
+// GetRegion returns the URL of the region of a regional disk.
+func (d *CloudDisk) GetRegion() string {
+	switch {
+	case d.disk != nil:
+		return d.disk.Region
+	case d.betaDisk != nil:
+		return d.betaDisk.Region
+	case d.alphaDisk != nil:
+		return d.alphaDisk.Region
+	default:
+		return ""
+	}
+}
+
+// GetReplicaZones returns the URLs of the zones that a regional disk is
+// replicated in.
+func (d *CloudDisk) GetReplicaZones() []string {
+	switch {
+	case d.disk != nil:
+		return d.disk.ReplicaZones
+	case d.betaDisk != nil:
+		return d.betaDisk.ReplicaZones
+	case d.alphaDisk != nil:
+		return d.alphaDisk.ReplicaZones
+	default:
+		return nil
+	}
+}
+
 func (d *CloudDisk) GetSnapshotId() string {
 	switch {
 	case d.disk != nil:
 		return d.disk.SourceSnapshotId
 	case d.betaDisk != nil:
 		return d.betaDisk.SourceSnapshotId
+	case d.alphaDisk != nil:
+		return d.alphaDisk.SourceSnapshotId
+	default:
+		return ""
+	}
+}
+
+// GetSourceDisk returns the URL of the disk this disk was cloned from.
+func (d *CloudDisk) GetSourceDisk() string {
+	switch {
+	case d.disk != nil:
+		return d.disk.SourceDisk
+	case d.betaDisk != nil:
+		return d.betaDisk.SourceDisk
+	case d.alphaDisk != nil:
+		return d.alphaDisk.SourceDisk
+	default:
+		return ""
+	}
+}
+
+func (d *CloudDisk) GetSourceImage() string {
+	switch {
+	case d.disk != nil:
+		return d.disk.SourceImage
+	case d.betaDisk != nil:
+		return d.betaDisk.SourceImage
+	case d.alphaDisk != nil:
+		return d.alphaDisk.SourceImage
 	default:
 		return ""
 	}
@@ -187,6 +299,30 @@ func (d *CloudDisk) GetKMSKeyName() string {
 		if dek := d.betaDisk.DiskEncryptionKey; dek != nil {
 			return dek.KmsKeyName
 		}
+	case d.alphaDisk != nil:
+		if dek := d.alphaDisk.DiskEncryptionKey; dek != nil {
+			return dek.KmsKeyName
+		}
+	}
+	return ""
+}
+
+// GetEncryptionKeySha256 returns the hash of the customer-supplied encryption
+// key that protects the disk, or "" if it is not protected by one.
+func (d *CloudDisk) GetEncryptionKeySha256() string {
+	switch {
+	case d.disk != nil:
+		if dek := d.disk.DiskEncryptionKey; dek != nil {
+			return dek.Sha256
+		}
+	case d.betaDisk != nil:
+		if dek := d.betaDisk.DiskEncryptionKey; dek != nil {
+			return dek.Sha256
+		}
+	case d.alphaDisk != nil:
+		if dek := d.alphaDisk.DiskEncryptionKey; dek != nil {
+			return dek.Sha256
+		}
 	}
 	return ""
 }
@@ -197,7 +333,48 @@ func (d *CloudDisk) GetMultiWriter() bool {
 		return false
 	case d.betaDisk != nil:
 		return d.betaDisk.MultiWriter
+	case d.alphaDisk != nil:
+		return d.alphaDisk.MultiWriter
 	default:
 		return false
 	}
 }
+
+// GetResourcePolicies returns the URLs of the resource policies attached to
+// the disk.
+func (d *CloudDisk) GetResourcePolicies() []string {
+	switch {
+	case d.disk != nil:
+		return d.disk.ResourcePolicies
+	case d.betaDisk != nil:
+		return d.betaDisk.ResourcePolicies
+	case d.alphaDisk != nil:
+		return d.alphaDisk.ResourcePolicies
+	default:
+		return nil
+	}
+}
+
+// setResourcePolicies sets the resource policies of the disk used ONLY
+// for testing purposes.
+func (d *CloudDisk) setResourcePolicies(policies []string) {
+	switch {
+	case d.disk != nil:
+		d.disk.ResourcePolicies = policies
+	case d.betaDisk != nil:
+		d.betaDisk.ResourcePolicies = policies
+	case d.alphaDisk != nil:
+		d.alphaDisk.ResourcePolicies = policies
+	}
+}
+
+// GetProvisionedIops returns the IOPS provisioned for the disk. It is only
+// reported by the alpha API, so it is 0 for disks fetched with other versions.
+func (d *CloudDisk) GetProvisionedIops() int64 {
+	switch {
+	case d.alphaDisk != nil:
+		return d.alphaDisk.ProvisionedIops
+	default:
+		return 0
+	}
+}
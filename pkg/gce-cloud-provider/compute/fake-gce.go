@@ -16,12 +16,19 @@ package gcecloudprovider
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	computealpha "google.golang.org/api/compute/v0.alpha"
 	computev1 "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/grpc/codes"
@@ -35,9 +42,12 @@ import (
 
 const (
 	DiskSizeGb                = 10
+	DefaultQuotaLimitGb       = 4096
 	Timestamp                 = "2018-09-05T15:17:08.270-07:00"
 	BasePath                  = "https://www.googleapis.com/compute/v1/projects/"
 	snapshotURITemplateGlobal = "%s/global/snapshots/%s" //{gce.projectID}/global/snapshots/{snapshot.Name}"
+	imageIDTemplate           = "projects/%s/global/images/%s"
+	imageURITemplateGlobal    = "%s/global/images/%s" //{gce.projectID}/global/images/{image.Name}"
 )
 
 type FakeCloudProvider struct {
@@ -48,23 +58,44 @@ type FakeCloudProvider struct {
 	pageTokens map[string]sets.String
 	instances  map[string]*computev1.Instance
 	snapshots  map[string]*computev1.Snapshot
+	quotas     map[string][]*computev1.Quota
+	// images are keyed by image ID
+	images map[string]*computev1.Image
+	// zoneStatuses holds the zones set with SetZoneStatus, other zones are
+	// UP.
+	zoneStatuses map[string]string
 
 	// marker to set disk status during InsertDisk operation.
 	mockDiskStatus string
+
+	// When longRunningOperations is set, operations are left pending in
+	// pendingOperations instead of completing, and complete when the same
+	// request is retried.
+	longRunningOperations bool
+	pendingOperations     map[string]func()
+	issuedOperations      int
+
+	// mu serializes the disk and instance methods that AttachBatcher calls
+	// concurrently.
+	mu sync.Mutex
 }
 
 var _ GCECompute = &FakeCloudProvider{}
 
 func CreateFakeCloudProvider(project, zone string, cloudDisks []*CloudDisk) (*FakeCloudProvider, error) {
 	fcp := &FakeCloudProvider{
-		project:    project,
-		zone:       zone,
-		disks:      map[string]*CloudDisk{},
-		instances:  map[string]*computev1.Instance{},
-		snapshots:  map[string]*computev1.Snapshot{},
-		pageTokens: map[string]sets.String{},
+		project:      project,
+		zone:         zone,
+		disks:        map[string]*CloudDisk{},
+		instances:    map[string]*computev1.Instance{},
+		snapshots:    map[string]*computev1.Snapshot{},
+		pageTokens:   map[string]sets.String{},
+		quotas:       map[string][]*computev1.Quota{},
+		images:       map[string]*computev1.Image{},
+		zoneStatuses: map[string]string{},
 		// A newly created disk is marked READY by default.
-		mockDiskStatus: "READY",
+		mockDiskStatus:    "READY",
+		pendingOperations: map[string]func(){},
 	}
 	for _, d := range cloudDisks {
 		fcp.disks[d.GetName()] = d
@@ -115,6 +146,46 @@ func (cloud *FakeCloudProvider) ListZones(ctx context.Context, region string) ([
 	return []string{cloud.zone, "country-region-fakesecondzone"}, nil
 }
 
+func (cloud *FakeCloudProvider) GetZoneStatus(ctx context.Context, zone string) (string, error) {
+	if status, ok := cloud.zoneStatuses[zone]; ok {
+		return status, nil
+	}
+	return "UP", nil
+}
+
+// SetZoneStatus sets the status that GetZoneStatus returns for zone.
+func (cloud *FakeCloudProvider) SetZoneStatus(zone, status string) {
+	cloud.zoneStatuses[zone] = status
+}
+
+// GetRegionQuotas returns the quotas set with UpdateRegionQuota. Regions
+// without any quotas set report DefaultQuotaLimitGb for every disk metric.
+func (cloud *FakeCloudProvider) GetRegionQuotas(ctx context.Context, project, region string) ([]*computev1.Quota, error) {
+	if quotas, ok := cloud.quotas[region]; ok {
+		return quotas, nil
+	}
+	return []*computev1.Quota{
+		{Metric: "DISKS_TOTAL_GB", Limit: DefaultQuotaLimitGb},
+		{Metric: "SSD_TOTAL_GB", Limit: DefaultQuotaLimitGb},
+	}, nil
+}
+
+// UpdateRegionQuota sets the limit and usage of a quota metric in a region.
+func (cloud *FakeCloudProvider) UpdateRegionQuota(region, metric string, limit, usage float64) {
+	for _, q := range cloud.quotas[region] {
+		if q.Metric == metric {
+			q.Limit = limit
+			q.Usage = usage
+			return
+		}
+	}
+	cloud.quotas[region] = append(cloud.quotas[region], &computev1.Quota{
+		Metric: metric,
+		Limit:  limit,
+		Usage:  usage,
+	})
+}
+
 func (cloud *FakeCloudProvider) ListDisks(ctx context.Context, maxEntries int64, pageToken string) ([]*computev1.Disk, string, error) {
 	// Ignore page tokens for now
 	var seen sets.String
@@ -137,9 +208,8 @@ func (cloud *FakeCloudProvider) ListDisks(ctx context.Context, maxEntries int64,
 	}
 
 	for name, cd := range cloud.disks {
-		// Only return v1 disks for simplicity
 		if !seen.Has(name) {
-			d = append(d, cd.disk)
+			d = append(d, listedV1Disk(cd))
 			seen.Insert(name)
 			count++
 		}
@@ -154,21 +224,68 @@ func (cloud *FakeCloudProvider) ListDisks(ctx context.Context, maxEntries int64,
 	return d, newToken, nil
 }
 
+// listedV1Disk returns the v1 disk that listing disks returns for cd, as the
+// v1 API does for disks created with the beta or alpha API.
+func listedV1Disk(cd *CloudDisk) *computev1.Disk {
+	if cd.disk != nil {
+		return cd.disk
+	}
+	return &computev1.Disk{
+		Name:         cd.GetName(),
+		Kind:         cd.GetKind(),
+		Status:       cd.GetStatus(),
+		SelfLink:     cd.GetSelfLink(),
+		SizeGb:       cd.GetSizeGb(),
+		Zone:         cd.GetZone(),
+		Region:       cd.GetRegion(),
+		ReplicaZones: cd.GetReplicaZones(),
+		Users:        cd.GetUsers(),
+	}
+}
+
+func (cloud *FakeCloudProvider) CountDisksByZone(ctx context.Context, project string) (map[string]int, error) {
+	counts := map[string]int{}
+	for _, cd := range cloud.disks {
+		if !inProject(cd.GetSelfLink(), project) {
+			continue
+		}
+		var zone string
+		var replicaZones []string
+		switch {
+		case cd.disk != nil:
+			zone, replicaZones = cd.disk.Zone, cd.disk.ReplicaZones
+		case cd.betaDisk != nil:
+			zone, replicaZones = cd.betaDisk.Zone, cd.betaDisk.ReplicaZones
+		case cd.alphaDisk != nil:
+			zone, replicaZones = cd.alphaDisk.Zone, cd.alphaDisk.ReplicaZones
+		}
+		if zone != "" {
+			counts[lastComponent(zone)]++
+		}
+		for _, replicaZone := range replicaZones {
+			counts[lastComponent(replicaZone)]++
+		}
+	}
+	return counts, nil
+}
+
 func (cloud *FakeCloudProvider) ListSnapshots(ctx context.Context, filter string, maxEntries int64, pageToken string) ([]*computev1.Snapshot, string, error) {
-	var sourceDisk string
+	var sourceDisk *regexp.Regexp
 	snapshots := []*computev1.Snapshot{}
 	if len(filter) > 0 {
 		filterSplits := strings.Fields(filter)
 		if len(filterSplits) != 3 || filterSplits[0] != "sourceDisk" {
 			return nil, "", invalidError()
 		}
-		sourceDisk = filterSplits[2]
+		var err error
+		sourceDisk, err = regexp.Compile(filterSplits[2])
+		if err != nil {
+			return nil, "", invalidError()
+		}
 	}
 	for _, snapshot := range cloud.snapshots {
-		if len(sourceDisk) > 0 {
-			if snapshot.SourceDisk == sourceDisk {
-				continue
-			}
+		if sourceDisk != nil && !sourceDisk.MatchString(snapshot.SourceDisk) {
+			continue
 		}
 		snapshots = append(snapshots, snapshot)
 	}
@@ -216,8 +333,10 @@ func (cloud *FakeCloudProvider) ListSnapshots(ctx context.Context, filter string
 
 // Disk Methods
 func (cloud *FakeCloudProvider) GetDisk(ctx context.Context, project string, volKey *meta.Key, api GCEAPIVersion) (*CloudDisk, error) {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
 	disk, ok := cloud.disks[volKey.Name]
-	if !ok {
+	if !ok || !inProject(disk.GetSelfLink(), project) {
 		return nil, notFoundError()
 	}
 	return disk, nil
@@ -252,7 +371,12 @@ func (cloud *FakeCloudProvider) ValidateExistingDisk(ctx context.Context, resp *
 	return ValidateDiskParameters(resp, params)
 }
 
-func (cloud *FakeCloudProvider) InsertDisk(ctx context.Context, project string, volKey *meta.Key, params common.DiskParameters, capBytes int64, capacityRange *csi.CapacityRange, replicaZones []string, snapshotID string, multiWriter bool) error {
+func (cloud *FakeCloudProvider) InsertDisk(ctx context.Context, project string, volKey *meta.Key, params common.DiskParameters, capBytes int64, capacityRange *csi.CapacityRange, replicaZones []string, snapshotID string, volumeContentSourceVolumeID string, multiWriter bool) error {
+	opKey := diskOperationKey(project, volKey)
+	if cloud.resumeOperation(opKey) {
+		return nil
+	}
+
 	if disk, ok := cloud.disks[volKey.Name]; ok {
 		err := cloud.ValidateExistingDisk(ctx, disk, params,
 			int64(capacityRange.GetRequiredBytes()),
@@ -263,12 +387,51 @@ func (cloud *FakeCloudProvider) InsertDisk(ctx context.Context, project string,
 		}
 	}
 
+	sourceDisk, sourceDiskID := "", ""
+	if volumeContentSourceVolumeID != "" {
+		sourceProject, sourceVolKey, err := common.VolumeIDToKey(volumeContentSourceVolumeID)
+		if err != nil {
+			return err
+		}
+		if _, ok := cloud.disks[sourceVolKey.Name]; !ok {
+			return notFoundError()
+		}
+		// GCE reports the source as a URL, and its ID as a number.
+		sourceDisk = cloud.GetDiskSourceURI(sourceProject, sourceVolKey)
+		sourceDiskID = fakeResourceID(sourceDisk)
+	}
+
+	sourceImage, sourceSnapshotID := params.SourceImage, snapshotID
+	if snapshotID != "" {
+		_, snapshotType, key, err := common.SnapshotIDToProjectKey(snapshotID)
+		if err != nil {
+			return err
+		}
+		if snapshotType == common.DiskImageType {
+			sourceImage, sourceSnapshotID = snapshotID, ""
+		} else if snapshot, ok := cloud.snapshots[key]; ok && !fakeEncryptionKeyMatches(encryptionKeySha256(snapshot.SnapshotEncryptionKey), params.SourceSnapshotEncryptionKey) {
+			return incorrectEncryptionKeyError()
+		}
+	}
+	if sourceImage != "" {
+		imageProject, imageName, err := common.ImageIDToProjectName(sourceImage)
+		if err != nil {
+			return err
+		}
+		if _, ok := cloud.images[fmt.Sprintf(imageIDTemplate, imageProject, imageName)]; !ok {
+			return notFoundError()
+		}
+	}
+
 	computeDisk := &computev1.Disk{
 		Name:             volKey.Name,
 		SizeGb:           common.BytesToGbRoundUp(capBytes),
 		Description:      "Disk created by GCE-PD CSI Driver",
 		Type:             cloud.GetDiskTypeURI(project, volKey, params.DiskType),
-		SourceSnapshotId: snapshotID,
+		SourceDisk:       sourceDisk,
+		SourceDiskId:     sourceDiskID,
+		SourceSnapshotId: sourceSnapshotID,
+		SourceImage:      sourceImage,
 		Status:           cloud.mockDiskStatus,
 		Labels:           params.Labels,
 	}
@@ -276,6 +439,15 @@ func (cloud *FakeCloudProvider) InsertDisk(ctx context.Context, project string,
 		computeDisk.DiskEncryptionKey = &computev1.CustomerEncryptionKey{
 			KmsKeyName: params.DiskEncryptionKMSKey,
 		}
+	} else if params.DiskEncryptionKey != nil {
+		computeDisk.DiskEncryptionKey = fakeCustomerEncryptionKey(params.DiskEncryptionKey)
+	}
+	if len(params.ResourcePolicies) > 0 {
+		policies, err := cloud.getResourcePolicyURIs(project, volKey, params.ResourcePolicies)
+		if err != nil {
+			return err
+		}
+		computeDisk.ResourcePolicies = policies
 	}
 	switch volKey.Type() {
 	case meta.Zonal:
@@ -283,42 +455,136 @@ func (cloud *FakeCloudProvider) InsertDisk(ctx context.Context, project string,
 		computeDisk.SelfLink = fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, volKey.Zone, volKey.Name)
 	case meta.Regional:
 		computeDisk.Region = volKey.Region
+		computeDisk.ReplicaZones = replicaZones
 		computeDisk.SelfLink = fmt.Sprintf("projects/%s/regions/%s/disks/%s", project, volKey.Region, volKey.Name)
 	default:
 		return fmt.Errorf("could not create disk, key was neither zonal nor regional, instead got: %v", volKey.String())
 	}
 
-	cloud.disks[volKey.Name] = CloudDiskFromV1(computeDisk)
-	return nil
+	if cloud.longRunningOperations {
+		// The disk exists while it is being created, as it does in GCE.
+		creatingDisk := *computeDisk
+		creatingDisk.Status = "CREATING"
+		cloud.disks[volKey.Name] = newFakeCloudDisk(&creatingDisk, params, multiWriter)
+	}
+	return cloud.startOperation(opKey, func() {
+		cloud.disks[volKey.Name] = newFakeCloudDisk(computeDisk, params, multiWriter)
+	})
+}
+
+// newFakeCloudDisk wraps a disk built by the fake InsertDisk in the API
+// version that the real InsertDisk would use for params.
+func newFakeCloudDisk(v1Disk *computev1.Disk, params common.DiskParameters, multiWriter bool) *CloudDisk {
+	if GetGCEAPIVersion(params, multiWriter) == GCEAPIVersionAlpha {
+		return CloudDiskFromAlpha(convertV1DiskToFakeAlphaDisk(v1Disk, params, multiWriter))
+	}
+	return CloudDiskFromV1(v1Disk)
+}
+
+// convertV1DiskToFakeAlphaDisk converts a disk built by the fake InsertDisk to
+// the alpha API, keeping the output only fields the fake sets.
+func convertV1DiskToFakeAlphaDisk(v1Disk *computev1.Disk, params common.DiskParameters, multiWriter bool) *computealpha.Disk {
+	alphaDisk := convertV1DiskToAlphaDisk(v1Disk)
+	alphaDisk.Zone = v1Disk.Zone
+	alphaDisk.Region = v1Disk.Region
+	alphaDisk.SelfLink = v1Disk.SelfLink
+	alphaDisk.Status = v1Disk.Status
+	alphaDisk.SourceDiskId = v1Disk.SourceDiskId
+	alphaDisk.SourceSnapshotId = v1Disk.SourceSnapshotId
+	alphaDisk.MultiWriter = multiWriter
+	alphaDisk.ProvisionedIops = params.ProvisionedIOPSOnCreate
+	return alphaDisk
 }
 
 func (cloud *FakeCloudProvider) DeleteDisk(ctx context.Context, project string, volKey *meta.Key) error {
-	if _, ok := cloud.disks[volKey.Name]; !ok {
+	delete(cloud.pendingOperations, diskOperationKey(project, volKey))
+	if disk, ok := cloud.disks[volKey.Name]; !ok || !inProject(disk.GetSelfLink(), project) {
 		return notFoundError()
 	}
 	delete(cloud.disks, volKey.Name)
 	return nil
 }
 
-func (cloud *FakeCloudProvider) AttachDisk(ctx context.Context, project string, volKey *meta.Key, readWrite, diskType, instanceZone, instanceName string) error {
+// AttachDisk attaches the disk to the instance. Like GCE, it fails if a disk
+// that is not multi-writer is attached read-write to another instance, unless
+// a regional disk is force-attached, which detaches it from the other
+// instances.
+func (cloud *FakeCloudProvider) AttachDisk(ctx context.Context, project string, volKey *meta.Key, readWrite, diskType, instanceZone, instanceName string, encryptionKey *common.CustomerEncryptionKey, forceAttach bool) error {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
 	source := cloud.GetDiskSourceURI(project, volKey)
+	disk, diskExists := cloud.disks[volKey.Name]
+	if diskExists && !fakeEncryptionKeyMatches(disk.GetEncryptionKeySha256(), encryptionKey) {
+		return incorrectEncryptionKeyError()
+	}
+	if forceAttach && volKey.Type() != meta.Regional {
+		return invalidError()
+	}
 
+	deviceName, err := common.GetDeviceName(volKey)
+	if err != nil {
+		return err
+	}
 	attachedDiskV1 := &computev1.AttachedDisk{
-		DeviceName: volKey.Name,
+		DeviceName: deviceName,
 		Kind:       diskKind,
 		Mode:       readWrite,
 		Source:     source,
 		Type:       diskType,
 	}
+	opKey := attachOperationKey(project, volKey, instanceZone, instanceName)
+	if cloud.resumeOperation(opKey) {
+		return nil
+	}
 	instance, ok := cloud.instances[instanceName]
 	if !ok {
 		return fmt.Errorf("Failed to get instance %v", instanceName)
 	}
-	instance.Disks = append(instance.Disks, attachedDiskV1)
-	return nil
+	instanceURI := fakeInstanceURI(project, instanceZone, instanceName)
+	if diskExists && readWrite == "READ_WRITE" && !disk.GetMultiWriter() {
+		for _, user := range disk.GetUsers() {
+			if user == instanceURI {
+				continue
+			}
+			if !forceAttach {
+				return resourceInUseError()
+			}
+			cloud.detachFromInstance(lastComponent(user), volKey.Name)
+		}
+	}
+	return cloud.startOperation(opKey, func() {
+		instance.Disks = append(instance.Disks, attachedDiskV1)
+		if diskExists {
+			disk.setUsers(append(disk.GetUsers(), instanceURI))
+		}
+	})
+}
+
+// detachFromInstance removes the disk from the instance as if the instance
+// had been detached from it, without failing for missing instances or disks.
+func (cloud *FakeCloudProvider) detachFromInstance(instanceName, diskName string) {
+	if instance, ok := cloud.instances[instanceName]; ok {
+		for i, attached := range instance.Disks {
+			if lastComponent(attached.Source) == diskName {
+				instance.Disks = append(instance.Disks[:i], instance.Disks[i+1:]...)
+				break
+			}
+		}
+	}
+	if disk, ok := cloud.disks[diskName]; ok {
+		users := []string{}
+		for _, user := range disk.GetUsers() {
+			if lastComponent(user) != instanceName {
+				users = append(users, user)
+			}
+		}
+		disk.setUsers(users)
+	}
 }
 
 func (cloud *FakeCloudProvider) DetachDisk(ctx context.Context, project, deviceName, instanceZone, instanceName string) error {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
 	instance, ok := cloud.instances[instanceName]
 	if !ok {
 		return fmt.Errorf("Failed to get instance %v", instanceName)
@@ -330,8 +596,21 @@ func (cloud *FakeCloudProvider) DetachDisk(ctx context.Context, project, deviceN
 			break
 		}
 	}
+	if found == -1 {
+		return invalidError()
+	}
+	diskName := lastComponent(instance.Disks[found].Source)
 	instance.Disks[found] = instance.Disks[len(instance.Disks)-1]
 	instance.Disks = instance.Disks[:len(instance.Disks)-1]
+	if disk, ok := cloud.disks[diskName]; ok {
+		users := []string{}
+		for _, user := range disk.GetUsers() {
+			if user != fakeInstanceURI(project, instanceZone, instanceName) {
+				users = append(users, user)
+			}
+		}
+		disk.setUsers(users)
+	}
 	return nil
 }
 
@@ -360,30 +639,159 @@ func (cloud *FakeCloudProvider) WaitForAttach(ctx context.Context, project strin
 
 // Regional Disk Methods
 func (cloud *FakeCloudProvider) GetReplicaZoneURI(project, zone string) string {
-	return ""
+	return BasePath + fmt.Sprintf(replicaZoneURITemplateSingleZone, project, zone)
 }
 
 // Instance Methods
 func (cloud *FakeCloudProvider) InsertInstance(instance *computev1.Instance, instanceZone, instanceName string) {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
 	cloud.instances[instanceName] = instance
 	return
 }
 
+// DeleteInstance deletes the instance without detaching its disks, which
+// keep listing it as a user, as they do when an instance is deleted in GCE
+// while disks are attached to it.
+func (cloud *FakeCloudProvider) DeleteInstance(instanceName string) {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+	delete(cloud.instances, instanceName)
+}
+
+// GetInstanceOrError returns a copy of the instance, so that callers can read
+// its disks while they are attached and detached concurrently.
 func (cloud *FakeCloudProvider) GetInstanceOrError(ctx context.Context, instanceZone, instanceName string) (*computev1.Instance, error) {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
 	instance, ok := cloud.instances[instanceName]
 	if !ok {
 		return nil, notFoundError()
 	}
-	return instance, nil
+	instanceCopy := *instance
+	instanceCopy.Disks = append([]*computev1.AttachedDisk{}, instance.Disks...)
+	return &instanceCopy, nil
+}
+
+// Image Methods
+func (cloud *FakeCloudProvider) InsertImage(image *computev1.Image, project, imageName string) {
+	cloud.images[fmt.Sprintf(imageIDTemplate, project, imageName)] = image
+}
+
+func (cloud *FakeCloudProvider) GetImage(ctx context.Context, project, imageName string) (*computev1.Image, error) {
+	image, ok := cloud.images[fmt.Sprintf(imageIDTemplate, project, imageName)]
+	if !ok {
+		return nil, notFoundError()
+	}
+	image.Status = "READY"
+	return image, nil
+}
+
+// ListImages lists the images in the default project. Like the fake
+// ListSnapshots, it only supports filtering by source disk.
+func (cloud *FakeCloudProvider) ListImages(ctx context.Context, filter string, maxEntries int64, pageToken string) ([]*computev1.Image, string, error) {
+	var sourceDisk string
+	if len(filter) > 0 {
+		filterSplits := strings.Fields(filter)
+		if len(filterSplits) != 3 || filterSplits[0] != "sourceDisk" {
+			return nil, "", invalidError()
+		}
+		sourceDisk = strings.TrimSuffix(strings.TrimPrefix(filterSplits[2], ".*"), "$")
+	}
+	imageIDs := []string{}
+	for id, image := range cloud.images {
+		if !strings.HasPrefix(id, fmt.Sprintf(imageIDTemplate, cloud.project, "")) {
+			continue
+		}
+		if len(sourceDisk) > 0 && !strings.HasSuffix(image.SourceDisk, sourceDisk) {
+			continue
+		}
+		imageIDs = append(imageIDs, id)
+	}
+	// Map iteration order is random, pages need a stable order.
+	sort.Strings(imageIDs)
+
+	start := 0
+	if len(pageToken) > 0 {
+		i, err := strconv.ParseUint(pageToken, 10, 32)
+		if err != nil || int(i) > len(imageIDs) {
+			return nil, "", invalidError()
+		}
+		start = int(i)
+	}
+	end := len(imageIDs)
+	if maxEntries > 0 && start+int(maxEntries) < end {
+		end = start + int(maxEntries)
+	}
+	images := []*computev1.Image{}
+	for _, id := range imageIDs[start:end] {
+		images = append(images, cloud.images[id])
+	}
+	var nextToken string
+	if end < len(imageIDs) {
+		nextToken = fmt.Sprintf("%d", end)
+	}
+	return images, nextToken, nil
+}
+
+func (cloud *FakeCloudProvider) CreateImage(ctx context.Context, project string, volKey *meta.Key, imageName string, snapshotParams common.SnapshotParameters) (*computev1.Image, error) {
+	imageID := fmt.Sprintf(imageIDTemplate, project, imageName)
+	if image, ok := cloud.images[imageID]; ok {
+		return image, nil
+	}
+	opKey := imageOperationKey(project, imageName)
+	if cloud.resumeOperation(opKey) {
+		return cloud.images[imageID], nil
+	}
+
+	disk, ok := cloud.disks[volKey.Name]
+	if !ok || !inProject(disk.GetSelfLink(), project) {
+		return nil, notFoundError()
+	}
+	if !fakeEncryptionKeyMatches(disk.GetEncryptionKeySha256(), snapshotParams.DiskEncryptionKey) {
+		return nil, incorrectEncryptionKeyError()
+	}
+	imageToCreate := &computev1.Image{
+		Name:               imageName,
+		DiskSizeGb:         disk.GetSizeGb(),
+		CreationTimestamp:  Timestamp,
+		Status:             "PENDING",
+		SelfLink:           BasePath + fmt.Sprintf(imageURITemplateGlobal, project, imageName),
+		SourceDisk:         cloud.GetDiskSourceURI(project, volKey),
+		Family:             snapshotParams.ImageFamily,
+		Labels:             snapshotParams.Labels,
+		StorageLocations:   snapshotParams.StorageLocations,
+		ImageEncryptionKey: fakeCustomerEncryptionKey(snapshotParams.DiskEncryptionKey),
+	}
+	err := cloud.startOperation(opKey, func() {
+		cloud.images[imageID] = imageToCreate
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imageToCreate, nil
+}
+
+func (cloud *FakeCloudProvider) DeleteImage(ctx context.Context, project, imageName string) error {
+	delete(cloud.images, fmt.Sprintf(imageIDTemplate, project, imageName))
+	return nil
 }
 
 // Snapshot Methods
+func (cloud *FakeCloudProvider) InsertSnapshot(snapshot *computev1.Snapshot, snapshotName string) {
+	cloud.snapshots[snapshotName] = snapshot
+}
+
+// GetSnapshot returns the snapshot with the given name. Snapshots that are
+// not FAILED are ready to use once they have been read.
 func (cloud *FakeCloudProvider) GetSnapshot(ctx context.Context, project, snapshotName string) (*computev1.Snapshot, error) {
 	snapshot, ok := cloud.snapshots[snapshotName]
-	if !ok {
+	if !ok || !inProject(snapshot.SelfLink, project) {
 		return nil, notFoundError()
 	}
-	snapshot.Status = "READY"
+	if snapshot.Status != "FAILED" {
+		snapshot.Status = "READY"
+	}
 	return snapshot, nil
 }
 
@@ -391,14 +799,24 @@ func (cloud *FakeCloudProvider) CreateSnapshot(ctx context.Context, project stri
 	if snapshot, ok := cloud.snapshots[snapshotName]; ok {
 		return snapshot, nil
 	}
+	opKey := snapshotOperationKey(project, snapshotName)
+	if cloud.resumeOperation(opKey) {
+		return cloud.snapshots[snapshotName], nil
+	}
+
+	if disk, ok := cloud.disks[volKey.Name]; ok && !fakeEncryptionKeyMatches(disk.GetEncryptionKeySha256(), snapshotParams.DiskEncryptionKey) {
+		return nil, incorrectEncryptionKeyError()
+	}
 
 	snapshotToCreate := &computev1.Snapshot{
-		Name:              snapshotName,
-		DiskSizeGb:        int64(DiskSizeGb),
-		CreationTimestamp: Timestamp,
-		Status:            "UPLOADING",
-		SelfLink:          cloud.getGlobalSnapshotURI(project, snapshotName),
-		StorageLocations:  snapshotParams.StorageLocations,
+		Name:                  snapshotName,
+		DiskSizeGb:            int64(DiskSizeGb),
+		CreationTimestamp:     Timestamp,
+		Status:                "UPLOADING",
+		SelfLink:              cloud.getGlobalSnapshotURI(project, snapshotName),
+		StorageLocations:      snapshotParams.StorageLocations,
+		Labels:                snapshotParams.Labels,
+		SnapshotEncryptionKey: fakeCustomerEncryptionKey(snapshotParams.DiskEncryptionKey),
 	}
 	switch volKey.Type() {
 	case meta.Zonal:
@@ -409,13 +827,18 @@ func (cloud *FakeCloudProvider) CreateSnapshot(ctx context.Context, project stri
 		return nil, fmt.Errorf("could not create snapshot, disk key was neither zonal nor regional, instead got: %v", volKey.String())
 	}
 
-	cloud.snapshots[snapshotName] = snapshotToCreate
+	err := cloud.startOperation(opKey, func() {
+		cloud.snapshots[snapshotName] = snapshotToCreate
+	})
+	if err != nil {
+		return nil, err
+	}
 	return snapshotToCreate, nil
 }
 
 func (cloud *FakeCloudProvider) ResizeDisk(ctx context.Context, project string, volKey *meta.Key, requestBytes int64) (int64, error) {
 	disk, ok := cloud.disks[volKey.Name]
-	if !ok {
+	if !ok || !inProject(disk.GetSelfLink(), project) {
 		return -1, notFoundError()
 	}
 
@@ -485,6 +908,102 @@ func (cloud *FakeCloudProvider) UpdateDiskStatus(s string) {
 	cloud.mockDiskStatus = s
 }
 
+// SetDiskStatus sets the status of an existing disk, such as FAILED.
+func (cloud *FakeCloudProvider) SetDiskStatus(diskName, status string) {
+	if disk, ok := cloud.disks[diskName]; ok {
+		disk.setStatus(status)
+	}
+}
+
+// SetLongRunningOperations controls whether operations started by the fake
+// outlive the request that started them. Such a request fails as if its
+// context expired, and the operation completes when the request is retried.
+func (cloud *FakeCloudProvider) SetLongRunningOperations(longRunning bool) {
+	cloud.longRunningOperations = longRunning
+}
+
+// IssuedOperations returns the number of operations the fake has started,
+// not counting operations that were resumed.
+func (cloud *FakeCloudProvider) IssuedOperations() int {
+	return cloud.issuedOperations
+}
+
+func (cloud *FakeCloudProvider) getResourcePolicyURIs(project string, volKey *meta.Key, policies []string) ([]string, error) {
+	region, err := getKeyRegion(volKey)
+	if err != nil {
+		return nil, err
+	}
+	uris := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		uris = append(uris, fmt.Sprintf(resourcePolicyURITemplate, project, region, policy))
+	}
+	return uris, nil
+}
+
+func (cloud *FakeCloudProvider) AddResourcePolicies(ctx context.Context, project string, volKey *meta.Key, policies []string) error {
+	disk, ok := cloud.disks[volKey.Name]
+	if !ok || !inProject(disk.GetSelfLink(), project) {
+		return notFoundError()
+	}
+	uris, err := cloud.getResourcePolicyURIs(project, volKey, policies)
+	if err != nil {
+		return err
+	}
+	attached := append([]string{}, disk.GetResourcePolicies()...)
+	for _, uri := range uris {
+		if !hasResourcePolicy(attached, lastComponent(uri)) {
+			attached = append(attached, uri)
+		}
+	}
+	disk.setResourcePolicies(attached)
+	return nil
+}
+
+func (cloud *FakeCloudProvider) RemoveResourcePolicies(ctx context.Context, project string, volKey *meta.Key, policies []string) error {
+	disk, ok := cloud.disks[volKey.Name]
+	if !ok || !inProject(disk.GetSelfLink(), project) {
+		return notFoundError()
+	}
+	removed := sets.NewString(policies...)
+	remaining := []string{}
+	for _, uri := range disk.GetResourcePolicies() {
+		if !removed.Has(lastComponent(uri)) {
+			remaining = append(remaining, uri)
+		}
+	}
+	disk.setResourcePolicies(remaining)
+	return nil
+}
+
+func (cloud *FakeCloudProvider) HasPendingDiskOperation(project string, volKey *meta.Key) bool {
+	_, ok := cloud.pendingOperations[diskOperationKey(project, volKey)]
+	return ok
+}
+
+// startOperation completes an operation immediately, or leaves it pending
+// when long running operations are simulated.
+func (cloud *FakeCloudProvider) startOperation(opKey string, complete func()) error {
+	cloud.issuedOperations++
+	if cloud.longRunningOperations {
+		cloud.pendingOperations[opKey] = complete
+		return fmt.Errorf("timed out waiting for operation %s: %w", opKey, context.DeadlineExceeded)
+	}
+	complete()
+	return nil
+}
+
+// resumeOperation completes the pending operation for opKey and returns true,
+// or returns false if there is none.
+func (cloud *FakeCloudProvider) resumeOperation(opKey string) bool {
+	complete, ok := cloud.pendingOperations[opKey]
+	if !ok {
+		return false
+	}
+	delete(cloud.pendingOperations, opKey)
+	complete()
+	return true
+}
+
 type FakeBlockingCloudProvider struct {
 	*FakeCloudProvider
 	ReadyToExecute chan chan struct{}
@@ -501,6 +1020,21 @@ func (cloud *FakeBlockingCloudProvider) CreateSnapshot(ctx context.Context, proj
 	return cloud.FakeCloudProvider.CreateSnapshot(ctx, project, volKey, snapshotName, snapshotParams)
 }
 
+// inProject returns whether the resource with the given self link is in
+// project. The fake keys resources by name only, so resources without a self
+// link are found in every project.
+func inProject(selfLink, project string) bool {
+	return selfLink == "" || strings.Contains("/"+selfLink, "/projects/"+project+"/")
+}
+
+// fakeResourceID returns a numeric ID for the resource with the given URL, as
+// GCE assigns to every resource.
+func fakeResourceID(url string) string {
+	h := fnv.New64a()
+	h.Write([]byte(url))
+	return strconv.FormatUint(h.Sum64(), 10)
+}
+
 func notFoundError() *googleapi.Error {
 	return &googleapi.Error{
 		Errors: []googleapi.ErrorItem{
@@ -511,6 +1045,72 @@ func notFoundError() *googleapi.Error {
 	}
 }
 
+// incorrectEncryptionKeyError is the error GCE returns when a resource
+// protected by a customer-supplied encryption key is used with another key.
+func incorrectEncryptionKeyError() *googleapi.Error {
+	return &googleapi.Error{
+		Errors: []googleapi.ErrorItem{
+			{
+				Reason: "customerEncryptionKeyIsIncorrect",
+			},
+		},
+	}
+}
+
+// fakeCustomerEncryptionKey returns a customer-supplied encryption key as GCE
+// reports it on the resources it protects, which is only its hash.
+func fakeCustomerEncryptionKey(key *common.CustomerEncryptionKey) *computev1.CustomerEncryptionKey {
+	if key == nil {
+		return nil
+	}
+	return &computev1.CustomerEncryptionKey{Sha256: fakeEncryptionKeySha256(key)}
+}
+
+// fakeEncryptionKeyMatches returns true if key may be used with a resource
+// whose customer-supplied encryption key has the given hash.
+func fakeEncryptionKeyMatches(fetchedSha256 string, key *common.CustomerEncryptionKey) bool {
+	if fetchedSha256 == "" {
+		return true
+	}
+	return fakeEncryptionKeySha256(key) == fetchedSha256
+}
+
+// fakeEncryptionKeySha256 returns the hash of the key. GCE hashes RSA-wrapped
+// keys once it unwraps them, which the fake cannot, so it hashes the wrapped
+// key instead.
+func fakeEncryptionKeySha256(key *common.CustomerEncryptionKey) string {
+	if !key.IsRSAWrapped() {
+		return key.Sha256()
+	}
+	hash := sha256.Sum256([]byte(key.RSAEncryptedKey))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func encryptionKeySha256(key *computev1.CustomerEncryptionKey) string {
+	if key == nil {
+		return ""
+	}
+	return key.Sha256
+}
+
+// resourceInUseError is the error GCE returns when a disk is attached
+// read-write to another instance.
+func resourceInUseError() *googleapi.Error {
+	return &googleapi.Error{
+		Errors: []googleapi.ErrorItem{
+			{
+				Reason: "resourceInUseByAnotherResource",
+			},
+		},
+	}
+}
+
+// fakeInstanceURI returns the URL of an instance, as GCE reports it in the
+// users of a disk.
+func fakeInstanceURI(project, zone, name string) string {
+	return BasePath + fmt.Sprintf("%s/zones/%s/instances/%s", project, zone, name)
+}
+
 func invalidError() *googleapi.Error {
 	return &googleapi.Error{
 		Errors: []googleapi.ErrorItem{
@@ -23,6 +23,9 @@ import (
 
 	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	computealpha "google.golang.org/api/compute/v0.alpha"
 	computebeta "google.golang.org/api/compute/v0.beta"
 	computev1 "google.golang.org/api/compute/v1"
 	"google.golang.org/grpc/codes"
@@ -30,24 +33,53 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog"
 	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/tracing"
 )
 
 const (
 	operationStatusDone            = "DONE"
 	waitForSnapshotCreationTimeOut = 2 * time.Minute
+	regionQuotasCacheTTL           = 1 * time.Minute
+	diskCountsCacheTTL             = 1 * time.Minute
 	diskKind                       = "compute#disk"
 	cryptoKeyVerDelimiter          = "/cryptoKeyVersions"
 )
 
+// operationPollInterval is how often a pending operation is polled. Tests
+// shorten it.
+var operationPollInterval = 3 * time.Second
+
+// resourceCreationPollInterval is how often a snapshot or image is checked
+// while waiting for it to exist. Tests shorten it.
+var resourceCreationPollInterval = time.Second
+
 type GCEAPIVersion string
 
 const (
 	// V1 key type
 	GCEAPIVersionV1 GCEAPIVersion = "v1"
-	// Alpha key type
+	// Beta key type
 	GCEAPIVersionBeta GCEAPIVersion = "beta"
+	// Alpha key type
+	GCEAPIVersionAlpha GCEAPIVersion = "alpha"
 )
 
+// GetGCEAPIVersion returns the API version needed to create a disk with the
+// given parameters, and to read back all of the fields they set.
+func GetGCEAPIVersion(params common.DiskParameters, multiWriter bool) GCEAPIVersion {
+	// Provisioned IOPS is only available in the alpha API, which also
+	// supports multi-writer disks.
+	if params.ProvisionedIOPSOnCreate > 0 {
+		return GCEAPIVersionAlpha
+	}
+	// Only the beta API takes RSA-wrapped customer-supplied keys.
+	if multiWriter || params.DiskEncryptionKey.IsRSAWrapped() || params.SourceSnapshotEncryptionKey.IsRSAWrapped() {
+		return GCEAPIVersionBeta
+	}
+	return GCEAPIVersionV1
+}
+
 type GCECompute interface {
 	// Metadata information
 	GetDefaultProject() string
@@ -56,21 +88,34 @@ type GCECompute interface {
 	GetDisk(ctx context.Context, project string, volumeKey *meta.Key, gceAPIVersion GCEAPIVersion) (*CloudDisk, error)
 	RepairUnderspecifiedVolumeKey(ctx context.Context, project string, volumeKey *meta.Key) (string, *meta.Key, error)
 	ValidateExistingDisk(ctx context.Context, disk *CloudDisk, params common.DiskParameters, reqBytes, limBytes int64, multiWriter bool) error
-	InsertDisk(ctx context.Context, project string, volKey *meta.Key, params common.DiskParameters, capBytes int64, capacityRange *csi.CapacityRange, replicaZones []string, snapshotID string, multiWriter bool) error
+	InsertDisk(ctx context.Context, project string, volKey *meta.Key, params common.DiskParameters, capBytes int64, capacityRange *csi.CapacityRange, replicaZones []string, snapshotID string, volumeContentSourceVolumeID string, multiWriter bool) error
 	DeleteDisk(ctx context.Context, project string, volumeKey *meta.Key) error
-	AttachDisk(ctx context.Context, project string, volKey *meta.Key, readWrite, diskType, instanceZone, instanceName string) error
+	AttachDisk(ctx context.Context, project string, volKey *meta.Key, readWrite, diskType, instanceZone, instanceName string, encryptionKey *common.CustomerEncryptionKey, forceAttach bool) error
 	DetachDisk(ctx context.Context, project, deviceName, instanceZone, instanceName string) error
 	GetDiskSourceURI(project string, volKey *meta.Key) string
 	GetDiskTypeURI(project string, volKey *meta.Key, diskType string) string
 	WaitForAttach(ctx context.Context, project string, volKey *meta.Key, instanceZone, instanceName string) error
 	ResizeDisk(ctx context.Context, project string, volKey *meta.Key, requestBytes int64) (int64, error)
 	ListDisks(ctx context.Context, maxEntries int64, pageToken string) ([]*computev1.Disk, string, error)
+	CountDisksByZone(ctx context.Context, project string) (map[string]int, error)
+	HasPendingDiskOperation(project string, volKey *meta.Key) bool
+	AddResourcePolicies(ctx context.Context, project string, volKey *meta.Key, policies []string) error
+	RemoveResourcePolicies(ctx context.Context, project string, volKey *meta.Key, policies []string) error
 	// Regional Disk Methods
 	GetReplicaZoneURI(project string, zone string) string
 	// Instance Methods
 	GetInstanceOrError(ctx context.Context, instanceZone, instanceName string) (*computev1.Instance, error)
 	// Zone Methods
 	ListZones(ctx context.Context, region string) ([]string, error)
+	GetZoneStatus(ctx context.Context, zone string) (string, error)
+	// Region Methods
+	GetRegionQuotas(ctx context.Context, project, region string) ([]*computev1.Quota, error)
+	// Image Methods
+	GetImage(ctx context.Context, project, imageName string) (*computev1.Image, error)
+	ListImages(ctx context.Context, filter string, maxEntries int64, pageToken string) ([]*computev1.Image, string, error)
+	CreateImage(ctx context.Context, project string, volKey *meta.Key, imageName string, snapshotParams common.SnapshotParameters) (*computev1.Image, error)
+	DeleteImage(ctx context.Context, project, imageName string) error
+	// Snapshot Methods
 	ListSnapshots(ctx context.Context, filter string, maxEntries int64, pageToken string) ([]*computev1.Snapshot, string, error)
 	GetSnapshot(ctx context.Context, project, snapshotName string) (*computev1.Snapshot, error)
 	CreateSnapshot(ctx context.Context, project string, volKey *meta.Key, snapshotName string, snapshotParams common.SnapshotParameters) (*computev1.Snapshot, error)
@@ -97,13 +142,79 @@ func (cloud *CloudProvider) ListDisks(ctx context.Context, maxEntries int64, pag
 	if len(pageToken) != 0 {
 		lCall = lCall.PageToken(pageToken)
 	}
-	diskList, err := lCall.Do()
+	var diskList *computev1.DiskList
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Disks.List", func() (err error) {
+		diskList, err = lCall.Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, "", err
 	}
 	return diskList.Items, diskList.NextPageToken, nil
 }
 
+// CountDisksByZone returns the number of disks in each zone of the project.
+// Regional disks count towards both of their replica zones. Counting lists
+// every disk in the project, so the result is cached for diskCountsCacheTTL.
+// The returned map must not be modified.
+func (cloud *CloudProvider) CountDisksByZone(ctx context.Context, project string) (map[string]int, error) {
+	cloud.diskCountsCacheMutex.Lock()
+	cached, ok := cloud.diskCountsCache[project]
+	cloud.diskCountsCacheMutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < diskCountsCacheTTL {
+		return cached.counts, nil
+	}
+
+	klog.V(5).Infof("Counting disks in each zone of project %s", project)
+	counts, err := cloud.countDisksByZone(ctx, project, func(pageToken string) (*computev1.DiskAggregatedList, error) {
+		lCall := cloud.service.Disks.AggregatedList(project).Fields("items/*/disks(zone,replicaZones)", "nextPageToken")
+		if pageToken != "" {
+			lCall = lCall.PageToken(pageToken)
+		}
+		return lCall.Context(ctx).Do()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cloud.diskCountsCacheMutex.Lock()
+	cloud.diskCountsCache[project] = cachedDiskCounts{counts: counts, fetchedAt: time.Now()}
+	cloud.diskCountsCacheMutex.Unlock()
+	return counts, nil
+}
+
+// countDisksByZone counts the disks in each zone on the pages returned by
+// listPage. Each page is rate limited and retried on its own, so a failed
+// page does not list the pages before it again.
+func (cloud *CloudProvider) countDisksByZone(ctx context.Context, project string, listPage func(pageToken string) (*computev1.DiskAggregatedList, error)) (map[string]int, error) {
+	counts := map[string]int{}
+	pageToken := ""
+	for {
+		var diskList *computev1.DiskAggregatedList
+		err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Disks.AggregatedList", func() (err error) {
+			diskList, err = listPage(pageToken)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list disks in project %s: %v", project, err)
+		}
+		for _, scopedList := range diskList.Items {
+			for _, disk := range scopedList.Disks {
+				if disk.Zone != "" {
+					counts[lastComponent(disk.Zone)]++
+				}
+				for _, replicaZone := range disk.ReplicaZones {
+					counts[lastComponent(replicaZone)]++
+				}
+			}
+		}
+		if diskList.NextPageToken == "" {
+			return counts, nil
+		}
+		pageToken = diskList.NextPageToken
+	}
+}
+
 // RepairUnderspecifiedVolumeKey will query the cloud provider and check each zone for the disk specified
 // by the volume key and return a volume key with a correct zone
 func (cloud *CloudProvider) RepairUnderspecifiedVolumeKey(ctx context.Context, project string, volumeKey *meta.Key) (string, *meta.Key, error) {
@@ -165,7 +276,11 @@ func (cloud *CloudProvider) ListZones(ctx context.Context, region string) ([]str
 		return cloud.zonesCache[region], nil
 	}
 	zones := []string{}
-	zoneList, err := cloud.service.Zones.List(cloud.project).Filter(fmt.Sprintf("region eq .*%s$", region)).Do()
+	var zoneList *computev1.ZoneList
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Zones.List", func() (err error) {
+		zoneList, err = cloud.service.Zones.List(cloud.project).Filter(fmt.Sprintf("region eq .*%s$", region)).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list zones in region %s: %v", region, err)
 	}
@@ -177,10 +292,60 @@ func (cloud *CloudProvider) ListZones(ctx context.Context, region string) ([]str
 
 }
 
+// GetZoneStatus returns the status of the zone, which is UP or DOWN. It is
+// not cached, as the status changes during outages.
+func (cloud *CloudProvider) GetZoneStatus(ctx context.Context, zone string) (string, error) {
+	klog.V(5).Infof("Getting status of zone: %v", zone)
+	var z *computev1.Zone
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Zones.Get", func() (err error) {
+		z, err = cloud.service.Zones.Get(cloud.project, zone).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get zone %s: %v", zone, err)
+	}
+	return z.Status, nil
+}
+
+// GetRegionQuotas returns the Compute Engine quotas of the given region. The
+// result is cached for regionQuotasCacheTTL so that frequent capacity
+// requests do not each result in a call to the API.
+func (cloud *CloudProvider) GetRegionQuotas(ctx context.Context, project, region string) ([]*computev1.Quota, error) {
+	klog.V(5).Infof("Getting quotas for region %s in project %s", region, project)
+	cacheKey := project + "/" + region
+	cloud.quotasCacheMutex.Lock()
+	cached, ok := cloud.quotasCache[cacheKey]
+	cloud.quotasCacheMutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < regionQuotasCacheTTL {
+		return cached.quotas, nil
+	}
+	// The lock is not held while fetching so that requests for other regions
+	// are not held up.
+	var r *computev1.Region
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Regions.Get", func() (err error) {
+		r, err = cloud.service.Regions.Get(project, region).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get region %s: %v", region, err)
+	}
+	cloud.quotasCacheMutex.Lock()
+	cloud.quotasCache[cacheKey] = cachedRegionQuotas{
+		quotas:    r.Quotas,
+		fetchedAt: time.Now(),
+	}
+	cloud.quotasCacheMutex.Unlock()
+	return r.Quotas, nil
+}
+
 func (cloud *CloudProvider) ListSnapshots(ctx context.Context, filter string, maxEntries int64, pageToken string) ([]*computev1.Snapshot, string, error) {
 	klog.V(5).Infof("Listing snapshots with filter: %s, max entries: %v, page token: %s", filter, maxEntries, pageToken)
 	snapshots := []*computev1.Snapshot{}
-	snapshotList, err := cloud.service.Snapshots.List(cloud.project).Filter(filter).MaxResults(maxEntries).PageToken(pageToken).Do()
+	var snapshotList *computev1.SnapshotList
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Snapshots.List", func() (err error) {
+		snapshotList, err = cloud.service.Snapshots.List(cloud.project).Filter(filter).MaxResults(maxEntries).PageToken(pageToken).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return snapshots, "", err
 	}
@@ -191,22 +356,47 @@ func (cloud *CloudProvider) ListSnapshots(ctx context.Context, filter string, ma
 
 }
 
+func (cloud *CloudProvider) ListImages(ctx context.Context, filter string, maxEntries int64, pageToken string) ([]*computev1.Image, string, error) {
+	klog.V(5).Infof("Listing images with filter: %s, max entries: %v, page token: %s", filter, maxEntries, pageToken)
+	images := []*computev1.Image{}
+	var imageList *computev1.ImageList
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Images.List", func() (err error) {
+		imageList, err = cloud.service.Images.List(cloud.project).Filter(filter).MaxResults(maxEntries).PageToken(pageToken).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return images, "", err
+	}
+	for _, image := range imageList.Items {
+		images = append(images, image)
+	}
+	return images, imageList.NextPageToken, nil
+}
+
 func (cloud *CloudProvider) GetDisk(ctx context.Context, project string, key *meta.Key, gceAPIVersion GCEAPIVersion) (*CloudDisk, error) {
 	klog.V(5).Infof("Getting disk %v", key)
 	switch key.Type() {
 	case meta.Zonal:
-		if gceAPIVersion == GCEAPIVersionBeta {
+		switch gceAPIVersion {
+		case GCEAPIVersionAlpha:
+			disk, err := cloud.getZonalAlphaDiskOrError(ctx, project, key.Zone, key.Name)
+			return CloudDiskFromAlpha(disk), err
+		case GCEAPIVersionBeta:
 			disk, err := cloud.getZonalBetaDiskOrError(ctx, project, key.Zone, key.Name)
 			return CloudDiskFromBeta(disk), err
-		} else {
+		default:
 			disk, err := cloud.getZonalDiskOrError(ctx, project, key.Zone, key.Name)
 			return CloudDiskFromV1(disk), err
 		}
 	case meta.Regional:
-		if gceAPIVersion == GCEAPIVersionBeta {
+		switch gceAPIVersion {
+		case GCEAPIVersionAlpha:
 			disk, err := cloud.getRegionalAlphaDiskOrError(ctx, project, key.Region, key.Name)
+			return CloudDiskFromAlpha(disk), err
+		case GCEAPIVersionBeta:
+			disk, err := cloud.getRegionalBetaDiskOrError(ctx, project, key.Region, key.Name)
 			return CloudDiskFromBeta(disk), err
-		} else {
+		default:
 			disk, err := cloud.getRegionalDiskOrError(ctx, project, key.Region, key.Name)
 			return CloudDiskFromV1(disk), err
 		}
@@ -216,7 +406,11 @@ func (cloud *CloudProvider) GetDisk(ctx context.Context, project string, key *me
 }
 
 func (cloud *CloudProvider) getZonalDiskOrError(ctx context.Context, project, volumeZone, volumeName string) (*computev1.Disk, error) {
-	disk, err := cloud.service.Disks.Get(project, volumeZone, volumeName).Context(ctx).Do()
+	var disk *computev1.Disk
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Disks.Get", func() (err error) {
+		disk, err = cloud.service.Disks.Get(project, volumeZone, volumeName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -224,7 +418,11 @@ func (cloud *CloudProvider) getZonalDiskOrError(ctx context.Context, project, vo
 }
 
 func (cloud *CloudProvider) getRegionalDiskOrError(ctx context.Context, project, volumeRegion, volumeName string) (*computev1.Disk, error) {
-	disk, err := cloud.service.RegionDisks.Get(project, volumeRegion, volumeName).Context(ctx).Do()
+	var disk *computev1.Disk
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "RegionDisks.Get", func() (err error) {
+		disk, err = cloud.service.RegionDisks.Get(project, volumeRegion, volumeName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -232,15 +430,47 @@ func (cloud *CloudProvider) getRegionalDiskOrError(ctx context.Context, project,
 }
 
 func (cloud *CloudProvider) getZonalBetaDiskOrError(ctx context.Context, project, volumeZone, volumeName string) (*computebeta.Disk, error) {
-	disk, err := cloud.betaService.Disks.Get(project, volumeZone, volumeName).Context(ctx).Do()
+	var disk *computebeta.Disk
+	err := cloud.call(ctx, readCall, GCEAPIVersionBeta, "Disks.Get", func() (err error) {
+		disk, err = cloud.betaService.Disks.Get(project, volumeZone, volumeName).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+func (cloud *CloudProvider) getRegionalBetaDiskOrError(ctx context.Context, project, volumeRegion, volumeName string) (*computebeta.Disk, error) {
+	var disk *computebeta.Disk
+	err := cloud.call(ctx, readCall, GCEAPIVersionBeta, "RegionDisks.Get", func() (err error) {
+		disk, err = cloud.betaService.RegionDisks.Get(project, volumeRegion, volumeName).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+func (cloud *CloudProvider) getZonalAlphaDiskOrError(ctx context.Context, project, volumeZone, volumeName string) (*computealpha.Disk, error) {
+	var disk *computealpha.Disk
+	err := cloud.call(ctx, readCall, GCEAPIVersionAlpha, "Disks.Get", func() (err error) {
+		disk, err = cloud.alphaService.Disks.Get(project, volumeZone, volumeName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return disk, nil
 }
 
-func (cloud *CloudProvider) getRegionalAlphaDiskOrError(ctx context.Context, project, volumeRegion, volumeName string) (*computebeta.Disk, error) {
-	disk, err := cloud.betaService.RegionDisks.Get(project, volumeRegion, volumeName).Context(ctx).Do()
+func (cloud *CloudProvider) getRegionalAlphaDiskOrError(ctx context.Context, project, volumeRegion, volumeName string) (*computealpha.Disk, error) {
+	var disk *computealpha.Disk
+	err := cloud.call(ctx, readCall, GCEAPIVersionAlpha, "RegionDisks.Get", func() (err error) {
+		disk, err = cloud.alphaService.RegionDisks.Get(project, volumeRegion, volumeName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -294,16 +524,35 @@ func ValidateDiskParameters(disk *CloudDisk, params common.DiskParameters) error
 		return fmt.Errorf("actual disk replication type %v did not match expected param %s", locationType, params.ReplicationType)
 	}
 
-	if !kmsKeyEqual(
+	if !KMSKeyEqual(
 		disk.GetKMSKeyName(), /* fetchedKMSKey */
 		params.DiskEncryptionKMSKey /* storageClassKMSKey */) {
 		return fmt.Errorf("actual disk KMS key name %s did not match expected param %s", disk.GetKMSKeyName(), params.DiskEncryptionKMSKey)
 	}
 
+	if !CustomerEncryptionKeyMatches(disk.GetEncryptionKeySha256(), params.DiskEncryptionKey) {
+		// Only the hashes are reported, as the key itself must never be logged
+		return fmt.Errorf("actual disk customer-supplied encryption key hash %q did not match the key in the secrets, with hash %q", disk.GetEncryptionKeySha256(), params.DiskEncryptionKey.Sha256())
+	}
+
+	if params.SourceImage != "" && !sourceImageEqual(disk.GetSourceImage(), params.SourceImage) {
+		return fmt.Errorf("actual disk source image %s did not match expected param %s", disk.GetSourceImage(), params.SourceImage)
+	}
+
+	if params.ProvisionedIOPSOnCreate > 0 && disk.GetProvisionedIops() != params.ProvisionedIOPSOnCreate {
+		return fmt.Errorf("actual provisioned IOPS %v did not match expected param %v", disk.GetProvisionedIops(), params.ProvisionedIOPSOnCreate)
+	}
+
+	for _, policy := range params.ResourcePolicies {
+		if !hasResourcePolicy(disk.GetResourcePolicies(), policy) {
+			return fmt.Errorf("actual disk resource policies %v did not include expected param %s", disk.GetResourcePolicies(), policy)
+		}
+	}
+
 	return nil
 }
 
-func (cloud *CloudProvider) InsertDisk(ctx context.Context, project string, volKey *meta.Key, params common.DiskParameters, capBytes int64, capacityRange *csi.CapacityRange, replicaZones []string, snapshotID string, multiWriter bool) error {
+func (cloud *CloudProvider) InsertDisk(ctx context.Context, project string, volKey *meta.Key, params common.DiskParameters, capBytes int64, capacityRange *csi.CapacityRange, replicaZones []string, snapshotID string, volumeContentSourceVolumeID string, multiWriter bool) error {
 	klog.V(5).Infof("Inserting disk %v", volKey)
 
 	description, err := encodeDiskTags(params.Tags)
@@ -316,12 +565,12 @@ func (cloud *CloudProvider) InsertDisk(ctx context.Context, project string, volK
 		if description == "" {
 			description = "Disk created by GCE-PD CSI Driver"
 		}
-		return cloud.insertZonalDisk(ctx, project, volKey, params, capBytes, capacityRange, snapshotID, description, multiWriter)
+		return cloud.insertZonalDisk(ctx, project, volKey, params, capBytes, capacityRange, snapshotID, volumeContentSourceVolumeID, description, multiWriter)
 	case meta.Regional:
 		if description == "" {
 			description = "Regional disk created by GCE-PD CSI Driver"
 		}
-		return cloud.insertRegionalDisk(ctx, project, volKey, params, capBytes, capacityRange, replicaZones, snapshotID, description, multiWriter)
+		return cloud.insertRegionalDisk(ctx, project, volKey, params, capBytes, capacityRange, replicaZones, snapshotID, volumeContentSourceVolumeID, description, multiWriter)
 	default:
 		return fmt.Errorf("could not insert disk, key was neither zonal nor regional, instead got: %v", volKey.String())
 	}
@@ -338,11 +587,17 @@ func convertV1CustomerEncryptionKeyToBeta(v1Key *computev1.CustomerEncryptionKey
 }
 
 func convertV1DiskToBetaDisk(v1Disk *computev1.Disk) *computebeta.Disk {
-	var dek *computebeta.CustomerEncryptionKey = nil
+	var dek, ssek, siek *computebeta.CustomerEncryptionKey = nil, nil, nil
 
 	if v1Disk.DiskEncryptionKey != nil {
 		dek = convertV1CustomerEncryptionKeyToBeta(v1Disk.DiskEncryptionKey)
 	}
+	if v1Disk.SourceSnapshotEncryptionKey != nil {
+		ssek = convertV1CustomerEncryptionKeyToBeta(v1Disk.SourceSnapshotEncryptionKey)
+	}
+	if v1Disk.SourceImageEncryptionKey != nil {
+		siek = convertV1CustomerEncryptionKeyToBeta(v1Disk.SourceImageEncryptionKey)
+	}
 
 	// Note: this is an incomplete list. It only includes the fields we use for disk creation.
 	return &computebeta.Disk{
@@ -351,8 +606,56 @@ func convertV1DiskToBetaDisk(v1Disk *computev1.Disk) *computebeta.Disk {
 		Description:       v1Disk.Description,
 		Type:              v1Disk.Type,
 		SourceSnapshot:    v1Disk.SourceSnapshot,
+		SourceDisk:        v1Disk.SourceDisk,
+		SourceImage:       v1Disk.SourceImage,
 		ReplicaZones:      v1Disk.ReplicaZones,
 		DiskEncryptionKey: dek,
+		ResourcePolicies:  v1Disk.ResourcePolicies,
+
+		SourceSnapshotEncryptionKey: ssek,
+		SourceImageEncryptionKey:    siek,
+	}
+}
+
+func convertV1CustomerEncryptionKeyToAlpha(v1Key *computev1.CustomerEncryptionKey) *computealpha.CustomerEncryptionKey {
+	return &computealpha.CustomerEncryptionKey{
+		KmsKeyName:      v1Key.KmsKeyName,
+		RawKey:          v1Key.RawKey,
+		Sha256:          v1Key.Sha256,
+		ForceSendFields: v1Key.ForceSendFields,
+		NullFields:      v1Key.NullFields,
+	}
+}
+
+func convertV1DiskToAlphaDisk(v1Disk *computev1.Disk) *computealpha.Disk {
+	var dek, ssek, siek *computealpha.CustomerEncryptionKey = nil, nil, nil
+
+	if v1Disk.DiskEncryptionKey != nil {
+		dek = convertV1CustomerEncryptionKeyToAlpha(v1Disk.DiskEncryptionKey)
+	}
+	if v1Disk.SourceSnapshotEncryptionKey != nil {
+		ssek = convertV1CustomerEncryptionKeyToAlpha(v1Disk.SourceSnapshotEncryptionKey)
+	}
+	if v1Disk.SourceImageEncryptionKey != nil {
+		siek = convertV1CustomerEncryptionKeyToAlpha(v1Disk.SourceImageEncryptionKey)
+	}
+
+	// Note: this is an incomplete list. It only includes the fields we use for disk creation.
+	return &computealpha.Disk{
+		Name:              v1Disk.Name,
+		SizeGb:            v1Disk.SizeGb,
+		Description:       v1Disk.Description,
+		Type:              v1Disk.Type,
+		SourceSnapshot:    v1Disk.SourceSnapshot,
+		SourceDisk:        v1Disk.SourceDisk,
+		SourceImage:       v1Disk.SourceImage,
+		ReplicaZones:      v1Disk.ReplicaZones,
+		DiskEncryptionKey: dek,
+		Labels:            v1Disk.Labels,
+		ResourcePolicies:  v1Disk.ResourcePolicies,
+
+		SourceSnapshotEncryptionKey: ssek,
+		SourceImageEncryptionKey:    siek,
 	}
 }
 
@@ -365,48 +668,79 @@ func (cloud *CloudProvider) insertRegionalDisk(
 	capacityRange *csi.CapacityRange,
 	replicaZones []string,
 	snapshotID string,
+	volumeContentSourceVolumeID string,
 	description string,
 	multiWriter bool) error {
 	var (
 		err           error
 		opName        string
-		gceAPIVersion = GCEAPIVersionV1
+		gceAPIVersion = GetGCEAPIVersion(params, multiWriter)
 	)
 
-	if multiWriter {
-		gceAPIVersion = GCEAPIVersionBeta
-	}
-
 	diskToCreate := &computev1.Disk{
 		Name:        volKey.Name,
 		SizeGb:      common.BytesToGbRoundUp(capBytes),
 		Description: description,
-		Type:        cloud.GetDiskTypeURI(cloud.project, volKey, params.DiskType),
+		Type:        cloud.GetDiskTypeURI(project, volKey, params.DiskType),
 		Labels:      params.Labels,
 	}
-	if snapshotID != "" {
-		diskToCreate.SourceSnapshot = snapshotID
+	if err := setSnapshotSource(diskToCreate, snapshotID); err != nil {
+		return err
+	}
+	if len(params.ResourcePolicies) > 0 {
+		diskToCreate.ResourcePolicies, err = cloud.getResourcePolicyURIs(project, volKey, params.ResourcePolicies)
+		if err != nil {
+			return err
+		}
+	}
+	if volumeContentSourceVolumeID != "" {
+		diskToCreate.SourceDisk = volumeContentSourceVolumeID
+	}
+	if params.SourceImage != "" {
+		diskToCreate.SourceImage = params.SourceImage
 	}
 	if len(replicaZones) != 0 {
 		diskToCreate.ReplicaZones = replicaZones
 	}
-	if params.DiskEncryptionKMSKey != "" {
-		diskToCreate.DiskEncryptionKey = &computev1.CustomerEncryptionKey{
-			KmsKeyName: params.DiskEncryptionKMSKey,
+	setDiskEncryptionKeys(diskToCreate, params)
+
+	opKey := diskOperationKey(project, volKey)
+	pendingOp, resumed := cloud.opTracker.get(opKey)
+	switch {
+	case resumed:
+		klog.V(4).Infof("Resuming pending operation %s to insert disk %v", pendingOp.name, volKey)
+		opName = pendingOp.name
+	case gceAPIVersion == GCEAPIVersionAlpha:
+		var insertOp *computealpha.Operation
+		alphaDiskToCreate := convertV1DiskToAlphaDisk(diskToCreate)
+		setAlphaCustomerEncryptionKeys(alphaDiskToCreate, params)
+		alphaDiskToCreate.MultiWriter = multiWriter
+		alphaDiskToCreate.ProvisionedIops = params.ProvisionedIOPSOnCreate
+		err = cloud.call(ctx, mutateCall, GCEAPIVersionAlpha, "RegionDisks.Insert", func() (err error) {
+			insertOp, err = cloud.alphaService.RegionDisks.Insert(project, volKey.Region, alphaDiskToCreate).Context(ctx).Do()
+			return err
+		})
+		if insertOp != nil {
+			opName = insertOp.Name
 		}
-	}
-
-	if gceAPIVersion == GCEAPIVersionBeta {
+	case gceAPIVersion == GCEAPIVersionBeta:
 		var insertOp *computebeta.Operation
 		betaDiskToCreate := convertV1DiskToBetaDisk(diskToCreate)
+		setBetaCustomerEncryptionKeys(betaDiskToCreate, params)
 		betaDiskToCreate.MultiWriter = multiWriter
-		insertOp, err = cloud.betaService.RegionDisks.Insert(project, volKey.Region, betaDiskToCreate).Context(ctx).Do()
+		err = cloud.call(ctx, mutateCall, GCEAPIVersionBeta, "RegionDisks.Insert", func() (err error) {
+			insertOp, err = cloud.betaService.RegionDisks.Insert(project, volKey.Region, betaDiskToCreate).Context(ctx).Do()
+			return err
+		})
 		if insertOp != nil {
 			opName = insertOp.Name
 		}
-	} else {
+	default:
 		var insertOp *computev1.Operation
-		insertOp, err = cloud.service.RegionDisks.Insert(project, volKey.Region, diskToCreate).Context(ctx).Do()
+		err = cloud.call(ctx, mutateCall, GCEAPIVersionV1, "RegionDisks.Insert", func() (err error) {
+			insertOp, err = cloud.service.RegionDisks.Insert(project, volKey.Region, diskToCreate).Context(ctx).Do()
+			return err
+		})
 		if insertOp != nil {
 			opName = insertOp.Name
 		}
@@ -430,7 +764,9 @@ func (cloud *CloudProvider) insertRegionalDisk(
 		return status.Error(codes.Internal, fmt.Sprintf("unknown Insert disk error: %v", err))
 	}
 
-	err = cloud.waitForRegionalOp(ctx, project, opName, volKey.Region)
+	op := pendingOperation{name: opName, region: volKey.Region}
+	cloud.opTracker.add(opKey, op)
+	err = cloud.waitForOperation(ctx, project, opKey, op)
 	if err != nil {
 		if IsGCEError(err, "alreadyExists") {
 			disk, err := cloud.GetDisk(ctx, project, volKey, gceAPIVersion)
@@ -447,7 +783,7 @@ func (cloud *CloudProvider) insertRegionalDisk(
 			klog.Warningf("GCE PD %s already exists after wait, reusing", volKey.Name)
 			return nil
 		}
-		return fmt.Errorf("unknown Insert disk operation error: %v", err)
+		return fmt.Errorf("unknown Insert disk operation error: %w", err)
 	}
 	return nil
 }
@@ -460,18 +796,15 @@ func (cloud *CloudProvider) insertZonalDisk(
 	capBytes int64,
 	capacityRange *csi.CapacityRange,
 	snapshotID string,
+	volumeContentSourceVolumeID string,
 	description string,
 	multiWriter bool) error {
 	var (
 		err           error
 		opName        string
-		gceAPIVersion = GCEAPIVersionV1
+		gceAPIVersion = GetGCEAPIVersion(params, multiWriter)
 	)
 
-	if multiWriter {
-		gceAPIVersion = GCEAPIVersionBeta
-	}
-
 	diskToCreate := &computev1.Disk{
 		Name:        volKey.Name,
 		SizeGb:      common.BytesToGbRoundUp(capBytes),
@@ -480,27 +813,60 @@ func (cloud *CloudProvider) insertZonalDisk(
 		Labels:      params.Labels,
 	}
 
-	if snapshotID != "" {
-		diskToCreate.SourceSnapshot = snapshotID
+	if err := setSnapshotSource(diskToCreate, snapshotID); err != nil {
+		return err
 	}
-
-	if params.DiskEncryptionKMSKey != "" {
-		diskToCreate.DiskEncryptionKey = &computev1.CustomerEncryptionKey{
-			KmsKeyName: params.DiskEncryptionKMSKey,
+	if len(params.ResourcePolicies) > 0 {
+		diskToCreate.ResourcePolicies, err = cloud.getResourcePolicyURIs(project, volKey, params.ResourcePolicies)
+		if err != nil {
+			return err
 		}
 	}
-
-	if gceAPIVersion == GCEAPIVersionBeta {
+	if volumeContentSourceVolumeID != "" {
+		diskToCreate.SourceDisk = volumeContentSourceVolumeID
+	}
+	if params.SourceImage != "" {
+		diskToCreate.SourceImage = params.SourceImage
+	}
+	setDiskEncryptionKeys(diskToCreate, params)
+
+	opKey := diskOperationKey(project, volKey)
+	pendingOp, resumed := cloud.opTracker.get(opKey)
+	switch {
+	case resumed:
+		klog.V(4).Infof("Resuming pending operation %s to insert disk %v", pendingOp.name, volKey)
+		opName = pendingOp.name
+	case gceAPIVersion == GCEAPIVersionAlpha:
+		var insertOp *computealpha.Operation
+		alphaDiskToCreate := convertV1DiskToAlphaDisk(diskToCreate)
+		setAlphaCustomerEncryptionKeys(alphaDiskToCreate, params)
+		alphaDiskToCreate.MultiWriter = multiWriter
+		alphaDiskToCreate.ProvisionedIops = params.ProvisionedIOPSOnCreate
+		err = cloud.call(ctx, mutateCall, GCEAPIVersionAlpha, "Disks.Insert", func() (err error) {
+			insertOp, err = cloud.alphaService.Disks.Insert(project, volKey.Zone, alphaDiskToCreate).Context(ctx).Do()
+			return err
+		})
+		if insertOp != nil {
+			opName = insertOp.Name
+		}
+	case gceAPIVersion == GCEAPIVersionBeta:
 		var insertOp *computebeta.Operation
 		betaDiskToCreate := convertV1DiskToBetaDisk(diskToCreate)
+		setBetaCustomerEncryptionKeys(betaDiskToCreate, params)
 		betaDiskToCreate.MultiWriter = multiWriter
-		insertOp, err = cloud.betaService.Disks.Insert(project, volKey.Zone, betaDiskToCreate).Context(ctx).Do()
+		err = cloud.call(ctx, mutateCall, GCEAPIVersionBeta, "Disks.Insert", func() (err error) {
+			insertOp, err = cloud.betaService.Disks.Insert(project, volKey.Zone, betaDiskToCreate).Context(ctx).Do()
+			return err
+		})
 		if insertOp != nil {
 			opName = insertOp.Name
 		}
-	} else {
+	default:
 		var insertOp *computev1.Operation
-		insertOp, err = cloud.service.Disks.Insert(project, volKey.Zone, diskToCreate).Context(ctx).Do()
+		err = cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Disks.Insert", func() (err error) {
+			insertOp, err = cloud.service.Disks.Insert(project, volKey.Zone, diskToCreate).Context(ctx).Do()
+			return err
+		})
 		if insertOp != nil {
 			opName = insertOp.Name
 		}
@@ -525,8 +891,9 @@ func (cloud *CloudProvider) insertZonalDisk(
 		return fmt.Errorf("unknown Insert disk error: %v", err)
 	}
 
-	err = cloud.waitForZonalOp(ctx, project, opName, volKey.Zone)
-
+	op := pendingOperation{name: opName, zone: volKey.Zone}
+	cloud.opTracker.add(opKey, op)
+	err = cloud.waitForOperation(ctx, project, opKey, op)
 	if err != nil {
 		if IsGCEError(err, "alreadyExists") {
 			disk, err := cloud.GetDisk(ctx, project, volKey, gceAPIVersion)
@@ -543,13 +910,24 @@ func (cloud *CloudProvider) insertZonalDisk(
 			klog.Warningf("GCE PD %s already exists after wait, reusing", volKey.Name)
 			return nil
 		}
-		return fmt.Errorf("unknown Insert disk operation error: %v", err)
+		return fmt.Errorf("unknown Insert disk operation error: %w", err)
 	}
 	return nil
 }
 
+// HasPendingDiskOperation returns true if an operation started by InsertDisk
+// for the disk has not been observed to complete. The next InsertDisk call for
+// the disk resumes waiting on it.
+func (cloud *CloudProvider) HasPendingDiskOperation(project string, volKey *meta.Key) bool {
+	_, ok := cloud.opTracker.get(diskOperationKey(project, volKey))
+	return ok
+}
+
 func (cloud *CloudProvider) DeleteDisk(ctx context.Context, project string, volKey *meta.Key) error {
 	klog.V(5).Infof("Deleting disk: %v", volKey)
+	// A disk that is deleted while it is still being inserted must not have
+	// that insert resumed by a later InsertDisk for the same name.
+	cloud.opTracker.remove(diskOperationKey(project, volKey))
 	switch volKey.Type() {
 	case meta.Zonal:
 		return cloud.deleteZonalDisk(ctx, project, volKey.Zone, volKey.Name)
@@ -561,7 +939,11 @@ func (cloud *CloudProvider) DeleteDisk(ctx context.Context, project string, volK
 }
 
 func (cloud *CloudProvider) deleteZonalDisk(ctx context.Context, project, zone, name string) error {
-	op, err := cloud.service.Disks.Delete(project, zone, name).Context(ctx).Do()
+	var op *computev1.Operation
+	err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Disks.Delete", func() (err error) {
+		op, err = cloud.service.Disks.Delete(project, zone, name).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		if IsGCEError(err, "notFound") {
 			// Already deleted
@@ -577,7 +959,11 @@ func (cloud *CloudProvider) deleteZonalDisk(ctx context.Context, project, zone,
 }
 
 func (cloud *CloudProvider) deleteRegionalDisk(ctx context.Context, project, region, name string) error {
-	op, err := cloud.service.RegionDisks.Delete(project, region, name).Context(ctx).Do()
+	var op *computev1.Operation
+	err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "RegionDisks.Delete", func() (err error) {
+		op, err = cloud.service.RegionDisks.Delete(project, region, name).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		if IsGCEError(err, "notFound") {
 			// Already deleted
@@ -592,7 +978,11 @@ func (cloud *CloudProvider) deleteRegionalDisk(ctx context.Context, project, reg
 	return nil
 }
 
-func (cloud *CloudProvider) AttachDisk(ctx context.Context, project string, volKey *meta.Key, readWrite, diskType, instanceZone, instanceName string) error {
+// AttachDisk attaches the disk to the instance. Disks protected by a
+// customer-supplied encryption key can only be attached with the key. If
+// forceAttach is set, a regional disk is attached even if it is attached to
+// another instance, which may be unreachable in a zonal outage.
+func (cloud *CloudProvider) AttachDisk(ctx context.Context, project string, volKey *meta.Key, readWrite, diskType, instanceZone, instanceName string, encryptionKey *common.CustomerEncryptionKey, forceAttach bool) error {
 	klog.V(5).Infof("Attaching disk %v to %s", volKey, instanceName)
 	source := cloud.GetDiskSourceURI(project, volKey)
 
@@ -606,22 +996,80 @@ func (cloud *CloudProvider) AttachDisk(ctx context.Context, project string, volK
 		Mode:       readWrite,
 		Source:     source,
 		Type:       diskType,
+
+		DiskEncryptionKey: convertCustomerEncryptionKey(encryptionKey),
 	}
 
-	op, err := cloud.service.Instances.AttachDisk(project, instanceZone, instanceName, attachedDiskV1).Context(ctx).Do()
-	if err != nil {
-		return fmt.Errorf("failed cloud service attach disk call: %v", err)
+	opKey := attachOperationKey(project, volKey, instanceZone, instanceName)
+	op, resumed := cloud.opTracker.get(opKey)
+	if resumed {
+		klog.V(4).Infof("Resuming pending operation %s to attach disk %v to %s", op.name, volKey, instanceName)
+	} else {
+		var opName string
+		if encryptionKey.IsRSAWrapped() {
+			// Only the beta API takes RSA-wrapped keys.
+			opName, err = cloud.attachDiskBeta(ctx, project, instanceZone, instanceName, attachedDiskV1, encryptionKey, forceAttach)
+		} else {
+			call := cloud.service.Instances.AttachDisk(project, instanceZone, instanceName, attachedDiskV1)
+			if forceAttach {
+				call = call.ForceAttach(true)
+			}
+			var attachOp *computev1.Operation
+			err = cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Instances.AttachDisk", func() (err error) {
+				attachOp, err = call.Context(ctx).Do()
+				return err
+			})
+			if err == nil {
+				opName = attachOp.Name
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed cloud service attach disk call: %w", err)
+		}
+		op = pendingOperation{name: opName, zone: instanceZone}
+		cloud.opTracker.add(opKey, op)
 	}
-	err = cloud.waitForZonalOp(ctx, project, op.Name, instanceZone)
+	err = cloud.waitForOperation(ctx, project, opKey, op)
 	if err != nil {
-		return fmt.Errorf("failed when waiting for zonal op: %v", err)
+		return fmt.Errorf("failed when waiting for zonal op: %w", err)
 	}
 	return nil
 }
 
+// attachDiskBeta attaches the disk with the beta API, which takes RSA-wrapped
+// customer-supplied keys, and returns the name of the operation.
+func (cloud *CloudProvider) attachDiskBeta(ctx context.Context, project, instanceZone, instanceName string, attachedDiskV1 *computev1.AttachedDisk, encryptionKey *common.CustomerEncryptionKey, forceAttach bool) (string, error) {
+	attachedDiskBeta := &computebeta.AttachedDisk{
+		DeviceName: attachedDiskV1.DeviceName,
+		Kind:       attachedDiskV1.Kind,
+		Mode:       attachedDiskV1.Mode,
+		Source:     attachedDiskV1.Source,
+		Type:       attachedDiskV1.Type,
+
+		DiskEncryptionKey: convertCustomerEncryptionKeyToBeta(encryptionKey),
+	}
+	call := cloud.betaService.Instances.AttachDisk(project, instanceZone, instanceName, attachedDiskBeta)
+	if forceAttach {
+		call = call.ForceAttach(true)
+	}
+	var attachOp *computebeta.Operation
+	err := cloud.call(ctx, mutateCall, GCEAPIVersionBeta, "Instances.AttachDisk", func() (err error) {
+		attachOp, err = call.Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return attachOp.Name, nil
+}
+
 func (cloud *CloudProvider) DetachDisk(ctx context.Context, project, deviceName, instanceZone, instanceName string) error {
 	klog.V(5).Infof("Detaching disk %v from %v", deviceName, instanceName)
-	op, err := cloud.service.Instances.DetachDisk(project, instanceZone, instanceName, deviceName).Context(ctx).Do()
+	var op *computev1.Operation
+	err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Instances.DetachDisk", func() (err error) {
+		op, err = cloud.service.Instances.DetachDisk(project, instanceZone, instanceName, deviceName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -678,10 +1126,109 @@ func (cloud *CloudProvider) getRegionalDiskTypeURI(project string, region, diskT
 	return cloud.service.BasePath + fmt.Sprintf(diskTypeURITemplateRegional, project, region, diskType)
 }
 
+// getResourcePolicyURIs returns the URLs of the named resource policies in the
+// project and region of the disk with the given key.
+func (cloud *CloudProvider) getResourcePolicyURIs(project string, volKey *meta.Key, policies []string) ([]string, error) {
+	region, err := getKeyRegion(volKey)
+	if err != nil {
+		return nil, err
+	}
+	uris := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		uris = append(uris, cloud.service.BasePath+fmt.Sprintf(resourcePolicyURITemplate, project, region, policy))
+	}
+	return uris, nil
+}
+
+// getKeyRegion returns the region of a zonal or regional disk key.
+func getKeyRegion(volKey *meta.Key) (string, error) {
+	switch volKey.Type() {
+	case meta.Zonal:
+		return common.GetRegionFromZones([]string{volKey.Zone})
+	case meta.Regional:
+		return volKey.Region, nil
+	default:
+		return "", fmt.Errorf("could not get region, key was neither zonal nor regional, instead got: %v", volKey.String())
+	}
+}
+
+// AddResourcePolicies attaches the named resource policies, which must be in
+// the region of the disk, to the disk with the given key.
+func (cloud *CloudProvider) AddResourcePolicies(ctx context.Context, project string, volKey *meta.Key, policies []string) error {
+	klog.V(5).Infof("Adding resource policies %v to disk %v", policies, volKey)
+	uris, err := cloud.getResourcePolicyURIs(project, volKey, policies)
+	if err != nil {
+		return err
+	}
+	switch volKey.Type() {
+	case meta.Zonal:
+		req := &computev1.DisksAddResourcePoliciesRequest{ResourcePolicies: uris}
+		var op *computev1.Operation
+		err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Disks.AddResourcePolicies", func() (err error) {
+			op, err = cloud.service.Disks.AddResourcePolicies(project, volKey.Zone, volKey.Name, req).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return cloud.waitForZonalOp(ctx, project, op.Name, volKey.Zone)
+	case meta.Regional:
+		req := &computev1.RegionDisksAddResourcePoliciesRequest{ResourcePolicies: uris}
+		var op *computev1.Operation
+		err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "RegionDisks.AddResourcePolicies", func() (err error) {
+			op, err = cloud.service.RegionDisks.AddResourcePolicies(project, volKey.Region, volKey.Name, req).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return cloud.waitForRegionalOp(ctx, project, op.Name, volKey.Region)
+	default:
+		return fmt.Errorf("could not add resource policies, key was neither zonal nor regional, instead got: %v", volKey.String())
+	}
+}
+
+// RemoveResourcePolicies detaches the named resource policies from the disk
+// with the given key.
+func (cloud *CloudProvider) RemoveResourcePolicies(ctx context.Context, project string, volKey *meta.Key, policies []string) error {
+	klog.V(5).Infof("Removing resource policies %v from disk %v", policies, volKey)
+	uris, err := cloud.getResourcePolicyURIs(project, volKey, policies)
+	if err != nil {
+		return err
+	}
+	switch volKey.Type() {
+	case meta.Zonal:
+		req := &computev1.DisksRemoveResourcePoliciesRequest{ResourcePolicies: uris}
+		var op *computev1.Operation
+		err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Disks.RemoveResourcePolicies", func() (err error) {
+			op, err = cloud.service.Disks.RemoveResourcePolicies(project, volKey.Zone, volKey.Name, req).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return cloud.waitForZonalOp(ctx, project, op.Name, volKey.Zone)
+	case meta.Regional:
+		req := &computev1.RegionDisksRemoveResourcePoliciesRequest{ResourcePolicies: uris}
+		var op *computev1.Operation
+		err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "RegionDisks.RemoveResourcePolicies", func() (err error) {
+			op, err = cloud.service.RegionDisks.RemoveResourcePolicies(project, volKey.Region, volKey.Name, req).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return cloud.waitForRegionalOp(ctx, project, op.Name, volKey.Region)
+	default:
+		return fmt.Errorf("could not remove resource policies, key was neither zonal nor regional, instead got: %v", volKey.String())
+	}
+}
+
 func (cloud *CloudProvider) waitForZonalOp(ctx context.Context, project, opName string, zone string) error {
+	ctx, span := startOperationSpan(ctx, opName)
 	// The v1 API can query for v1, alpha, or beta operations.
-	return wait.Poll(3*time.Second, 5*time.Minute, func() (bool, error) {
-		pollOp, err := cloud.service.ZoneOperations.Get(project, zone, opName).Context(ctx).Do()
+	err := wait.Poll(operationPollInterval, 5*time.Minute, func() (bool, error) {
+		pollOp, err := cloud.getOperation(ctx, project, pendingOperation{name: opName, zone: zone})
 		if err != nil {
 			klog.Errorf("WaitForOp(op: %s, zone: %#v) failed to poll the operation", opName, zone)
 			return false, err
@@ -689,12 +1236,15 @@ func (cloud *CloudProvider) waitForZonalOp(ctx context.Context, project, opName
 		done, err := opIsDone(pollOp)
 		return done, err
 	})
+	tracing.EndSpan(span, err)
+	return err
 }
 
 func (cloud *CloudProvider) waitForRegionalOp(ctx context.Context, project, opName string, region string) error {
+	ctx, span := startOperationSpan(ctx, opName)
 	// The v1 API can query for v1, alpha, or beta operations.
-	return wait.Poll(3*time.Second, 5*time.Minute, func() (bool, error) {
-		pollOp, err := cloud.service.RegionOperations.Get(project, region, opName).Context(ctx).Do()
+	err := wait.Poll(operationPollInterval, 5*time.Minute, func() (bool, error) {
+		pollOp, err := cloud.getOperation(ctx, project, pendingOperation{name: opName, region: region})
 		if err != nil {
 			klog.Errorf("WaitForOp(op: %s, region: %#v) failed to poll the operation", opName, region)
 			return false, err
@@ -702,11 +1252,14 @@ func (cloud *CloudProvider) waitForRegionalOp(ctx context.Context, project, opNa
 		done, err := opIsDone(pollOp)
 		return done, err
 	})
+	tracing.EndSpan(span, err)
+	return err
 }
 
 func (cloud *CloudProvider) waitForGlobalOp(ctx context.Context, project, opName string) error {
-	return wait.Poll(3*time.Second, 5*time.Minute, func() (bool, error) {
-		pollOp, err := cloud.service.GlobalOperations.Get(project, opName).Context(ctx).Do()
+	ctx, span := startOperationSpan(ctx, opName)
+	err := wait.Poll(operationPollInterval, 5*time.Minute, func() (bool, error) {
+		pollOp, err := cloud.getOperation(ctx, project, pendingOperation{name: opName})
 		if err != nil {
 			klog.Errorf("waitForGlobalOp(op: %s) failed to poll the operation", opName)
 			return false, err
@@ -714,6 +1267,8 @@ func (cloud *CloudProvider) waitForGlobalOp(ctx context.Context, project, opName
 		done, err := opIsDone(pollOp)
 		return done, err
 	})
+	tracing.EndSpan(span, err)
+	return err
 }
 
 func (cloud *CloudProvider) WaitForAttach(ctx context.Context, project string, volKey *meta.Key, instanceZone, instanceName string) error {
@@ -739,12 +1294,76 @@ func (cloud *CloudProvider) WaitForAttach(ctx context.Context, project string, v
 	})
 }
 
+// waitForOperation waits for a pending operation to complete, and stops
+// tracking it unless waiting was interrupted before it completed.
+func (cloud *CloudProvider) waitForOperation(ctx context.Context, project, opKey string, op pendingOperation) error {
+	ctx, span := startOperationSpan(ctx, op.name)
+	// The v1 API can query for v1, alpha, or beta operations.
+	err := wait.Poll(operationPollInterval, 5*time.Minute, func() (bool, error) {
+		pollOp, err := cloud.getOperation(ctx, project, op)
+		if err != nil {
+			klog.Errorf("waitForOperation(op: %s, zone: %q, region: %q) failed to poll the operation", op.name, op.zone, op.region)
+			return false, err
+		}
+		return opIsDone(pollOp)
+	})
+	if !isOperationInterrupted(err) {
+		cloud.opTracker.remove(opKey)
+	}
+	tracing.EndSpan(span, err)
+	return err
+}
+
+// startOperationSpan starts the span of a wait for a GCE operation, which
+// the polls of the operation are traced as children of.
+func startOperationSpan(ctx context.Context, opName string) (context.Context, trace.Span) {
+	return tracing.StartSpan(ctx, "compute.WaitForOperation", attribute.String(tracing.AttributeOperationName, opName))
+}
+
+// getOperation fetches the current state of a pending operation.
+func (cloud *CloudProvider) getOperation(ctx context.Context, project string, op pendingOperation) (*computev1.Operation, error) {
+	method := "GlobalOperations.Get"
+	switch {
+	case op.zone != "":
+		method = "ZoneOperations.Get"
+	case op.region != "":
+		method = "RegionOperations.Get"
+	}
+	var pollOp *computev1.Operation
+	err := cloud.call(ctx, operationPollCall, GCEAPIVersionV1, method, func() (err error) {
+		pollOp, err = cloud.fetchOperation(ctx, project, op)
+		return err
+	})
+	if pollOp != nil {
+		metrics.RecordGCEOperationPoll(pollOp.OperationType)
+	}
+	return pollOp, err
+}
+
+func (cloud *CloudProvider) fetchOperation(ctx context.Context, project string, op pendingOperation) (*computev1.Operation, error) {
+	if cloud.operationGetter != nil {
+		return cloud.operationGetter(ctx, project, op)
+	}
+	switch {
+	case op.zone != "":
+		return cloud.service.ZoneOperations.Get(project, op.zone, op.name).Context(ctx).Do()
+	case op.region != "":
+		return cloud.service.RegionOperations.Get(project, op.region, op.name).Context(ctx).Do()
+	default:
+		return cloud.service.GlobalOperations.Get(project, op.name).Context(ctx).Do()
+	}
+}
+
 func opIsDone(op *computev1.Operation) (bool, error) {
 	if op == nil || op.Status != operationStatusDone {
 		return false, nil
 	}
 	if op.Error != nil && len(op.Error.Errors) > 0 && op.Error.Errors[0] != nil {
-		return true, fmt.Errorf("operation %v failed (%v): %v", op.Name, op.Error.Errors[0].Code, op.Error.Errors[0].Message)
+		return true, &OperationError{
+			OpName:  op.Name,
+			Code:    op.Error.Errors[0].Code,
+			Message: op.Error.Errors[0].Message,
+		}
 	}
 	return true, nil
 }
@@ -753,7 +1372,11 @@ func (cloud *CloudProvider) GetInstanceOrError(ctx context.Context, instanceZone
 	klog.V(5).Infof("Getting instance %v from zone %v", instanceName, instanceZone)
 	svc := cloud.service
 	project := cloud.project
-	instance, err := svc.Instances.Get(project, instanceZone, instanceName).Do()
+	var instance *computev1.Instance
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Instances.Get", func() (err error) {
+		instance, err = svc.Instances.Get(project, instanceZone, instanceName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -763,16 +1386,59 @@ func (cloud *CloudProvider) GetInstanceOrError(ctx context.Context, instanceZone
 func (cloud *CloudProvider) GetSnapshot(ctx context.Context, project, snapshotName string) (*computev1.Snapshot, error) {
 	klog.V(5).Infof("Getting snapshot %v", snapshotName)
 	svc := cloud.service
-	snapshot, err := svc.Snapshots.Get(project, snapshotName).Context(ctx).Do()
+	var snapshot *computev1.Snapshot
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Snapshots.Get", func() (err error) {
+		snapshot, err = svc.Snapshots.Get(project, snapshotName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	return snapshot, nil
 }
 
+func (cloud *CloudProvider) GetImage(ctx context.Context, project, imageName string) (*computev1.Image, error) {
+	klog.V(5).Infof("Getting image %v", imageName)
+	var image *computev1.Image
+	err := cloud.call(ctx, readCall, GCEAPIVersionV1, "Images.Get", func() (err error) {
+		image, err = cloud.service.Images.Get(project, imageName).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
 func (cloud *CloudProvider) DeleteSnapshot(ctx context.Context, project, snapshotName string) error {
 	klog.V(5).Infof("Deleting snapshot %v", snapshotName)
-	op, err := cloud.service.Snapshots.Delete(project, snapshotName).Context(ctx).Do()
+	var op *computev1.Operation
+	err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Snapshots.Delete", func() (err error) {
+		op, err = cloud.service.Snapshots.Delete(project, snapshotName).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		if IsGCEError(err, "notFound") {
+			// Already deleted
+			return nil
+		}
+		return err
+	}
+	err = cloud.waitForGlobalOp(ctx, project, op.Name)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cloud *CloudProvider) DeleteImage(ctx context.Context, project, imageName string) error {
+	klog.V(5).Infof("Deleting image %v", imageName)
+	cloud.opTracker.remove(imageOperationKey(project, imageName))
+	var op *computev1.Operation
+	err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Images.Delete", func() (err error) {
+		op, err = cloud.service.Images.Delete(project, imageName).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		if IsGCEError(err, "notFound") {
 			// Already deleted
@@ -787,6 +1453,83 @@ func (cloud *CloudProvider) DeleteSnapshot(ctx context.Context, project, snapsho
 	return nil
 }
 
+// CreateImage creates an image of the disk with the given key, and waits until
+// the image exists, which is long before it is ready to use.
+func (cloud *CloudProvider) CreateImage(ctx context.Context, project string, volKey *meta.Key, imageName string, snapshotParams common.SnapshotParameters) (*computev1.Image, error) {
+	klog.V(5).Infof("Creating image %s for volume %v", imageName, volKey)
+	sourceDisk, err := common.KeyToVolumeID(volKey, project)
+	if err != nil {
+		return nil, err
+	}
+	imageToCreate := &computev1.Image{
+		Name:             imageName,
+		SourceDisk:       sourceDisk,
+		Family:           snapshotParams.ImageFamily,
+		Labels:           snapshotParams.Labels,
+		StorageLocations: snapshotParams.StorageLocations,
+
+		// A disk protected by a customer-supplied encryption key can only be
+		// read with the key, which then also protects the image
+		SourceDiskEncryptionKey: convertCustomerEncryptionKey(snapshotParams.DiskEncryptionKey),
+		ImageEncryptionKey:      convertCustomerEncryptionKey(snapshotParams.DiskEncryptionKey),
+	}
+
+	opKey := imageOperationKey(project, imageName)
+	op, resumed := cloud.opTracker.get(opKey)
+	if resumed {
+		klog.V(4).Infof("Resuming pending operation %s to create image %s", op.name, imageName)
+	} else {
+		// The disk may be attached to a running instance, which GCE only
+		// allows images to be created from when forced.
+		var opName string
+		if snapshotParams.DiskEncryptionKey.IsRSAWrapped() {
+			// Only the beta API takes RSA-wrapped keys.
+			betaImageToCreate := &computebeta.Image{
+				Name:             imageToCreate.Name,
+				SourceDisk:       imageToCreate.SourceDisk,
+				Family:           imageToCreate.Family,
+				Labels:           imageToCreate.Labels,
+				StorageLocations: imageToCreate.StorageLocations,
+
+				SourceDiskEncryptionKey: convertCustomerEncryptionKeyToBeta(snapshotParams.DiskEncryptionKey),
+				ImageEncryptionKey:      convertCustomerEncryptionKeyToBeta(snapshotParams.DiskEncryptionKey),
+			}
+			var insertOp *computebeta.Operation
+			err = cloud.call(ctx, mutateCall, GCEAPIVersionBeta, "Images.Insert", func() (err error) {
+				insertOp, err = cloud.betaService.Images.Insert(project, betaImageToCreate).ForceCreate(true).Context(ctx).Do()
+				return err
+			})
+			if err == nil {
+				opName = insertOp.Name
+			}
+		} else {
+			var insertOp *computev1.Operation
+			err = cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Images.Insert", func() (err error) {
+				insertOp, err = cloud.service.Images.Insert(project, imageToCreate).ForceCreate(true).Context(ctx).Do()
+				return err
+			})
+			if err == nil {
+				opName = insertOp.Name
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		op = pendingOperation{name: opName}
+		cloud.opTracker.add(opKey, op)
+	}
+	var image *computev1.Image
+	err = cloud.waitForResourceCreation(ctx, project, imageName, opKey, op, func() error {
+		var err error
+		image, err = cloud.GetImage(ctx, project, imageName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
 func (cloud *CloudProvider) CreateSnapshot(ctx context.Context, project string, volKey *meta.Key, snapshotName string, snapshotParams common.SnapshotParameters) (*computev1.Snapshot, error) {
 	klog.V(5).Infof("Creating snapshot %s for volume %v", snapshotName, volKey)
 	switch volKey.Type() {
@@ -833,7 +1576,11 @@ func (cloud *CloudProvider) resizeZonalDisk(ctx context.Context, project string,
 	resizeReq := &computev1.DisksResizeRequest{
 		SizeGb: requestGb,
 	}
-	op, err := cloud.service.Disks.Resize(project, volKey.Zone, volKey.Name, resizeReq).Context(ctx).Do()
+	var op *computev1.Operation
+	err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Disks.Resize", func() (err error) {
+		op, err = cloud.service.Disks.Resize(project, volKey.Zone, volKey.Name, resizeReq).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return -1, fmt.Errorf("failed to resize zonal volume %v: %v", volKey.String(), err)
 	}
@@ -851,7 +1598,11 @@ func (cloud *CloudProvider) resizeRegionalDisk(ctx context.Context, project stri
 		SizeGb: requestGb,
 	}
 
-	op, err := cloud.service.RegionDisks.Resize(project, volKey.Region, volKey.Name, resizeReq).Context(ctx).Do()
+	var op *computev1.Operation
+	err := cloud.call(ctx, mutateCall, GCEAPIVersionV1, "RegionDisks.Resize", func() (err error) {
+		op, err = cloud.service.RegionDisks.Resize(project, volKey.Region, volKey.Name, resizeReq).Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return -1, fmt.Errorf("failed to resize regional volume %v: %v", volKey.String(), err)
 	}
@@ -868,34 +1619,127 @@ func (cloud *CloudProvider) createZonalDiskSnapshot(ctx context.Context, project
 	snapshotToCreate := &computev1.Snapshot{
 		Name:             snapshotName,
 		StorageLocations: snapshotParams.StorageLocations,
-	}
+		Labels:           snapshotParams.Labels,
 
-	_, err := cloud.service.Disks.CreateSnapshot(project, volKey.Zone, volKey.Name, snapshotToCreate).Context(ctx).Do()
+		SourceDiskEncryptionKey: convertCustomerEncryptionKey(snapshotParams.DiskEncryptionKey),
+		SnapshotEncryptionKey:   convertCustomerEncryptionKey(snapshotParams.DiskEncryptionKey),
+	}
 
-	if err != nil {
-		return nil, err
+	opKey := snapshotOperationKey(project, snapshotName)
+	op, resumed := cloud.opTracker.get(opKey)
+	if resumed {
+		klog.V(4).Infof("Resuming pending operation %s to create snapshot %s", op.name, snapshotName)
+	} else {
+		var opName string
+		var err error
+		if snapshotParams.DiskEncryptionKey.IsRSAWrapped() {
+			// Only the beta API takes RSA-wrapped keys.
+			call := cloud.betaService.Disks.CreateSnapshot(project, volKey.Zone, volKey.Name, convertV1SnapshotToBetaSnapshot(snapshotToCreate, snapshotParams.DiskEncryptionKey))
+			if snapshotParams.GuestFlush {
+				call = call.GuestFlush(true)
+			}
+			var snapshotOp *computebeta.Operation
+			err = cloud.call(ctx, mutateCall, GCEAPIVersionBeta, "Disks.CreateSnapshot", func() (err error) {
+				snapshotOp, err = call.Context(ctx).Do()
+				return err
+			})
+			if err == nil {
+				opName = snapshotOp.Name
+			}
+		} else {
+			call := cloud.service.Disks.CreateSnapshot(project, volKey.Zone, volKey.Name, snapshotToCreate)
+			if snapshotParams.GuestFlush {
+				call = call.GuestFlush(true)
+			}
+			var snapshotOp *computev1.Operation
+			err = cloud.call(ctx, mutateCall, GCEAPIVersionV1, "Disks.CreateSnapshot", func() (err error) {
+				snapshotOp, err = call.Context(ctx).Do()
+				return err
+			})
+			if err == nil {
+				opName = snapshotOp.Name
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		op = pendingOperation{name: opName, zone: volKey.Zone}
+		cloud.opTracker.add(opKey, op)
 	}
 
-	return cloud.waitForSnapshotCreation(ctx, project, snapshotName)
+	return cloud.waitForSnapshotCreation(ctx, project, snapshotName, opKey, op)
 }
 
 func (cloud *CloudProvider) createRegionalDiskSnapshot(ctx context.Context, project string, volKey *meta.Key, snapshotName string, snapshotParams common.SnapshotParameters) (*computev1.Snapshot, error) {
 	snapshotToCreate := &computev1.Snapshot{
 		Name:             snapshotName,
 		StorageLocations: snapshotParams.StorageLocations,
+		Labels:           snapshotParams.Labels,
+
+		SourceDiskEncryptionKey: convertCustomerEncryptionKey(snapshotParams.DiskEncryptionKey),
+		SnapshotEncryptionKey:   convertCustomerEncryptionKey(snapshotParams.DiskEncryptionKey),
 	}
 
-	_, err := cloud.service.RegionDisks.CreateSnapshot(project, volKey.Region, volKey.Name, snapshotToCreate).Context(ctx).Do()
-	if err != nil {
-		return nil, err
+	opKey := snapshotOperationKey(project, snapshotName)
+	op, resumed := cloud.opTracker.get(opKey)
+	if resumed {
+		klog.V(4).Infof("Resuming pending operation %s to create snapshot %s", op.name, snapshotName)
+	} else {
+		var opName string
+		var err error
+		if snapshotParams.DiskEncryptionKey.IsRSAWrapped() {
+			// Only the beta API takes RSA-wrapped keys.
+			betaSnapshotToCreate := convertV1SnapshotToBetaSnapshot(snapshotToCreate, snapshotParams.DiskEncryptionKey)
+			var snapshotOp *computebeta.Operation
+			err = cloud.call(ctx, mutateCall, GCEAPIVersionBeta, "RegionDisks.CreateSnapshot", func() (err error) {
+				snapshotOp, err = cloud.betaService.RegionDisks.CreateSnapshot(project, volKey.Region, volKey.Name, betaSnapshotToCreate).Context(ctx).Do()
+				return err
+			})
+			if err == nil {
+				opName = snapshotOp.Name
+			}
+		} else {
+			var snapshotOp *computev1.Operation
+			err = cloud.call(ctx, mutateCall, GCEAPIVersionV1, "RegionDisks.CreateSnapshot", func() (err error) {
+				snapshotOp, err = cloud.service.RegionDisks.CreateSnapshot(project, volKey.Region, volKey.Name, snapshotToCreate).Context(ctx).Do()
+				return err
+			})
+			if err == nil {
+				opName = snapshotOp.Name
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		op = pendingOperation{name: opName, region: volKey.Region}
+		cloud.opTracker.add(opKey, op)
 	}
 
-	return cloud.waitForSnapshotCreation(ctx, project, snapshotName)
+	return cloud.waitForSnapshotCreation(ctx, project, snapshotName, opKey, op)
+
+}
 
+// waitForSnapshotCreation waits until the snapshot exists, which is long
+// before it is ready to use. Callers check whether it is ready with
+// GetSnapshot.
+func (cloud *CloudProvider) waitForSnapshotCreation(ctx context.Context, project, snapshotName, opKey string, op pendingOperation) (*computev1.Snapshot, error) {
+	var snapshot *computev1.Snapshot
+	err := cloud.waitForResourceCreation(ctx, project, snapshotName, opKey, op, func() error {
+		var err error
+		snapshot, err = cloud.GetSnapshot(ctx, project, snapshotName)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
 }
 
-func (cloud *CloudProvider) waitForSnapshotCreation(ctx context.Context, project, snapshotName string) (*computev1.Snapshot, error) {
-	ticker := time.NewTicker(time.Second)
+// waitForResourceCreation waits until getResource finds the resource with the
+// given name that op creates. The operation stops being tracked once the
+// resource exists or the operation fails.
+func (cloud *CloudProvider) waitForResourceCreation(ctx context.Context, project, name, opKey string, op pendingOperation, getResource func() error) error {
+	ticker := time.NewTicker(resourceCreationPollInterval)
 	defer ticker.Stop()
 	timer := time.NewTimer(waitForSnapshotCreationTimeOut)
 	defer timer.Stop()
@@ -903,34 +1747,189 @@ func (cloud *CloudProvider) waitForSnapshotCreation(ctx context.Context, project
 	for {
 		select {
 		case <-ticker.C:
-			klog.V(6).Infof("Checking GCE Snapshot %s.", snapshotName)
-			snapshot, err := cloud.GetSnapshot(ctx, project, snapshotName)
-			if err != nil {
-				klog.Warningf("Error in getting snapshot %s, %v", snapshotName, err)
-			} else if snapshot != nil {
-				if snapshot.Status != "CREATING" {
-					klog.V(6).Infof("Snapshot %s status is %s", snapshotName, snapshot.Status)
-					return snapshot, nil
-				} else {
-					klog.V(6).Infof("Snapshot %s is still creating ...", snapshotName)
+			klog.V(6).Infof("Checking GCE resource %s.", name)
+			err := getResource()
+			if err == nil {
+				cloud.opTracker.remove(opKey)
+				return nil
+			}
+			klog.Warningf("Error in getting resource %s, %v", name, err)
+			if IsGCENotFoundError(err) {
+				// The resource is never created if its operation failed.
+				pollOp, err := cloud.getOperation(ctx, project, op)
+				if err != nil {
+					klog.Warningf("Error in getting operation %s for resource %s, %v", op.name, name, err)
+				} else if done, err := opIsDone(pollOp); done && err != nil {
+					cloud.opTracker.remove(opKey)
+					return err
 				}
 			}
+		case <-ctx.Done():
+			// The operation is still tracked, so a retry resumes waiting.
+			return ctx.Err()
 		case <-timer.C:
-			return nil, fmt.Errorf("Timeout waiting for snapshot %s to be created.", snapshotName)
+			return fmt.Errorf("timeout waiting for %s to be created", name)
 		}
 	}
 }
 
-// kmsKeyEqual returns true if fetchedKMSKey and storageClassKMSKey refer to the same key.
+// setSnapshotSource sets the source of the disk to the snapshot with the given
+// ID, which may be a snapshot or an image.
+func setSnapshotSource(disk *computev1.Disk, snapshotID string) error {
+	if snapshotID == "" {
+		return nil
+	}
+	_, snapshotType, _, err := common.SnapshotIDToProjectKey(snapshotID)
+	if err != nil {
+		return err
+	}
+	if snapshotType == common.DiskImageType {
+		disk.SourceImage = snapshotID
+	} else {
+		disk.SourceSnapshot = snapshotID
+	}
+	return nil
+}
+
+// KMSKeyEqual returns true if fetchedKMSKey and storageClassKMSKey refer to the same key.
 // fetchedKMSKey - key returned by the server
 //        example: projects/{0}/locations/{1}/keyRings/{2}/cryptoKeys/{3}/cryptoKeyVersions/{4}
 // storageClassKMSKey - key as provided by the client
 //        example: projects/{0}/locations/{1}/keyRings/{2}/cryptoKeys/{3}
 // cryptoKeyVersions should be disregarded if the rest of the key is identical.
-func kmsKeyEqual(fetchedKMSKey, storageClassKMSKey string) bool {
+func KMSKeyEqual(fetchedKMSKey, storageClassKMSKey string) bool {
 	return removeCryptoKeyVersion(fetchedKMSKey) == removeCryptoKeyVersion(storageClassKMSKey)
 }
 
+// setDiskEncryptionKeys sets the KMS or customer-supplied key that protects
+// the disk, and the key of its source snapshot, which is an image for image
+// snapshots. It must be called once the source of the disk is set.
+func setDiskEncryptionKeys(disk *computev1.Disk, params common.DiskParameters) {
+	switch {
+	case params.DiskEncryptionKMSKey != "":
+		disk.DiskEncryptionKey = &computev1.CustomerEncryptionKey{
+			KmsKeyName: params.DiskEncryptionKMSKey,
+		}
+	case params.DiskEncryptionKey != nil:
+		disk.DiskEncryptionKey = convertCustomerEncryptionKey(params.DiskEncryptionKey)
+	}
+	if disk.SourceImage != "" {
+		disk.SourceImageEncryptionKey = convertCustomerEncryptionKey(params.SourceSnapshotEncryptionKey)
+	} else {
+		disk.SourceSnapshotEncryptionKey = convertCustomerEncryptionKey(params.SourceSnapshotEncryptionKey)
+	}
+}
+
+// setBetaCustomerEncryptionKeys sets the customer-supplied keys of a disk to
+// create with the beta API, which unlike the v1 API takes RSA-wrapped keys.
+func setBetaCustomerEncryptionKeys(disk *computebeta.Disk, params common.DiskParameters) {
+	if params.DiskEncryptionKMSKey == "" && params.DiskEncryptionKey != nil {
+		disk.DiskEncryptionKey = convertCustomerEncryptionKeyToBeta(params.DiskEncryptionKey)
+	}
+	if params.SourceSnapshotEncryptionKey == nil {
+		return
+	}
+	if disk.SourceImage != "" {
+		disk.SourceImageEncryptionKey = convertCustomerEncryptionKeyToBeta(params.SourceSnapshotEncryptionKey)
+	} else {
+		disk.SourceSnapshotEncryptionKey = convertCustomerEncryptionKeyToBeta(params.SourceSnapshotEncryptionKey)
+	}
+}
+
+// setAlphaCustomerEncryptionKeys is setBetaCustomerEncryptionKeys for the
+// alpha API.
+func setAlphaCustomerEncryptionKeys(disk *computealpha.Disk, params common.DiskParameters) {
+	if params.DiskEncryptionKMSKey == "" && params.DiskEncryptionKey != nil {
+		disk.DiskEncryptionKey = convertCustomerEncryptionKeyToAlpha(params.DiskEncryptionKey)
+	}
+	if params.SourceSnapshotEncryptionKey == nil {
+		return
+	}
+	if disk.SourceImage != "" {
+		disk.SourceImageEncryptionKey = convertCustomerEncryptionKeyToAlpha(params.SourceSnapshotEncryptionKey)
+	} else {
+		disk.SourceSnapshotEncryptionKey = convertCustomerEncryptionKeyToAlpha(params.SourceSnapshotEncryptionKey)
+	}
+}
+
+// convertV1SnapshotToBetaSnapshot returns the beta form of a snapshot to
+// create, protected by the customer-supplied key of its source disk.
+func convertV1SnapshotToBetaSnapshot(v1Snapshot *computev1.Snapshot, key *common.CustomerEncryptionKey) *computebeta.Snapshot {
+	return &computebeta.Snapshot{
+		Name:             v1Snapshot.Name,
+		StorageLocations: v1Snapshot.StorageLocations,
+		Labels:           v1Snapshot.Labels,
+
+		SourceDiskEncryptionKey: convertCustomerEncryptionKeyToBeta(key),
+		SnapshotEncryptionKey:   convertCustomerEncryptionKeyToBeta(key),
+	}
+}
+
+// convertCustomerEncryptionKey returns the API form of a customer-supplied
+// encryption key, or nil if there is none. The v1 API cannot take RSA-wrapped
+// keys, which are only passed to the beta and alpha APIs.
+func convertCustomerEncryptionKey(key *common.CustomerEncryptionKey) *computev1.CustomerEncryptionKey {
+	if key == nil {
+		return nil
+	}
+	return &computev1.CustomerEncryptionKey{
+		RawKey: key.RawKey,
+	}
+}
+
+func convertCustomerEncryptionKeyToBeta(key *common.CustomerEncryptionKey) *computebeta.CustomerEncryptionKey {
+	if key == nil {
+		return nil
+	}
+	return &computebeta.CustomerEncryptionKey{
+		RawKey:          key.RawKey,
+		RsaEncryptedKey: key.RSAEncryptedKey,
+	}
+}
+
+func convertCustomerEncryptionKeyToAlpha(key *common.CustomerEncryptionKey) *computealpha.CustomerEncryptionKey {
+	if key == nil {
+		return nil
+	}
+	return &computealpha.CustomerEncryptionKey{
+		RawKey:          key.RawKey,
+		RsaEncryptedKey: key.RSAEncryptedKey,
+	}
+}
+
+// CustomerEncryptionKeyMatches returns true if the resource whose
+// customer-supplied encryption key has the given hash is protected by key, or
+// if neither is set. The hash of an RSA-wrapped key is unknown, so it matches
+// any resource protected by a customer-supplied key, and GCE rejects it when
+// it is used if it is the wrong one.
+func CustomerEncryptionKeyMatches(fetchedSha256 string, key *common.CustomerEncryptionKey) bool {
+	if key.IsRSAWrapped() {
+		return fetchedSha256 != ""
+	}
+	return key.Sha256() == fetchedSha256
+}
+
+// sourceImageEqual returns true if the source image reported by GCE, which is
+// a full URL, refers to the image requested in the StorageClass, which is of
+// the form projects/{project}/global/images/{name}.
+func sourceImageEqual(fetchedSourceImage, storageClassSourceImage string) bool {
+	if fetchedSourceImage == storageClassSourceImage {
+		return true
+	}
+	return storageClassSourceImage != "" && strings.HasSuffix(fetchedSourceImage, "/"+storageClassSourceImage)
+}
+
+// hasResourcePolicy returns true if one of the resource policy URLs reported
+// by GCE refers to the policy with the given name.
+func hasResourcePolicy(fetchedPolicies []string, policy string) bool {
+	for _, fetchedPolicy := range fetchedPolicies {
+		if lastComponent(fetchedPolicy) == policy {
+			return true
+		}
+	}
+	return false
+}
+
 func removeCryptoKeyVersion(kmsKey string) string {
 	i := strings.LastIndex(kmsKey, cryptoKeyVerDelimiter)
 	if i > 0 {
@@ -953,3 +1952,9 @@ func encodeDiskTags(tags map[string]string) (string, error) {
 	}
 	return string(enc), nil
 }
+
+// lastComponent returns the last component of a resource URL, such as the
+// zone name of https://www.googleapis.com/compute/v1/projects/{project}/zones/{zone}.
+func lastComponent(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
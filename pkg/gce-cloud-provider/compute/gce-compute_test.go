@@ -15,9 +15,19 @@ limitations under the License.
 package gcecloudprovider
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	computealpha "google.golang.org/api/compute/v0.alpha"
+	computebeta "google.golang.org/api/compute/v0.beta"
 	computev1 "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
 )
 
@@ -101,3 +111,388 @@ func TestValidateDiskParameters(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateDiskParametersProvisionedIops(t *testing.T) {
+	testCases := []struct {
+		name            string
+		disk            *CloudDisk
+		provisionedIops int64
+		expectErr       bool
+	}{
+		{
+			name: "matching provisioned iops",
+			disk: CloudDiskFromAlpha(&computealpha.Disk{
+				Zone:            "us-central1-c",
+				Type:            "pd-extreme",
+				ProvisionedIops: 10000,
+			}),
+			provisionedIops: 10000,
+		},
+		{
+			name: "mismatched provisioned iops",
+			disk: CloudDiskFromAlpha(&computealpha.Disk{
+				Zone:            "us-central1-c",
+				Type:            "pd-extreme",
+				ProvisionedIops: 20000,
+			}),
+			provisionedIops: 10000,
+			expectErr:       true,
+		},
+		{
+			name: "provisioned iops not requested",
+			disk: CloudDiskFromAlpha(&computealpha.Disk{
+				Zone:            "us-central1-c",
+				Type:            "pd-extreme",
+				ProvisionedIops: 20000,
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			storageClassParams := common.DiskParameters{
+				DiskType:                "pd-extreme",
+				ReplicationType:         "none",
+				ProvisionedIOPSOnCreate: tc.provisionedIops,
+			}
+			err := ValidateDiskParameters(tc.disk, storageClassParams)
+			if !tc.expectErr && err != nil {
+				t.Fatalf("ValidateDiskParameters did not expect error, but got %v", err)
+			}
+			if tc.expectErr && err == nil {
+				t.Fatalf("ValidateDiskParameters expected error, but got no error")
+			}
+		})
+	}
+}
+
+func TestValidateDiskParametersSourceImage(t *testing.T) {
+	testCases := []struct {
+		name        string
+		diskImage   string
+		sourceImage string
+		expectErr   bool
+	}{
+		{
+			name:        "matching source image",
+			diskImage:   "https://www.googleapis.com/compute/v1/projects/image-project/global/images/golden-image",
+			sourceImage: "projects/image-project/global/images/golden-image",
+		},
+		{
+			name:        "mismatched source image",
+			diskImage:   "https://www.googleapis.com/compute/v1/projects/image-project/global/images/other-image",
+			sourceImage: "projects/image-project/global/images/golden-image",
+			expectErr:   true,
+		},
+		{
+			name:        "disk without source image",
+			sourceImage: "projects/image-project/global/images/golden-image",
+			expectErr:   true,
+		},
+		{
+			name:      "source image not requested",
+			diskImage: "https://www.googleapis.com/compute/v1/projects/image-project/global/images/golden-image",
+		},
+		{
+			name: "no source image",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			disk := CloudDiskFromV1(&computev1.Disk{
+				Zone:        "us-central1-c",
+				Type:        "pd-standard",
+				SourceImage: tc.diskImage,
+			})
+			storageClassParams := common.DiskParameters{
+				DiskType:        "pd-standard",
+				ReplicationType: "none",
+				SourceImage:     tc.sourceImage,
+			}
+			err := ValidateDiskParameters(disk, storageClassParams)
+			if !tc.expectErr && err != nil {
+				t.Fatalf("ValidateDiskParameters did not expect error, but got %v", err)
+			}
+			if tc.expectErr && err == nil {
+				t.Fatalf("ValidateDiskParameters expected error, but got no error")
+			}
+		})
+	}
+}
+
+func TestValidateDiskParametersResourcePolicies(t *testing.T) {
+	dailyBackup := "https://www.googleapis.com/compute/v1/projects/my-project/regions/us-central1/resourcePolicies/daily-backup"
+	testCases := []struct {
+		name             string
+		diskPolicies     []string
+		resourcePolicies []string
+		expectErr        bool
+	}{
+		{
+			name:             "matching resource policies",
+			diskPolicies:     []string{dailyBackup},
+			resourcePolicies: []string{"daily-backup"},
+		},
+		{
+			name:             "missing resource policy",
+			diskPolicies:     []string{dailyBackup},
+			resourcePolicies: []string{"daily-backup", "weekly-backup"},
+			expectErr:        true,
+		},
+		{
+			name:             "disk without resource policies",
+			resourcePolicies: []string{"daily-backup"},
+			expectErr:        true,
+		},
+		{
+			name:         "resource policies not requested",
+			diskPolicies: []string{dailyBackup},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			disk := CloudDiskFromV1(&computev1.Disk{
+				Zone:             "us-central1-c",
+				Type:             "pd-standard",
+				ResourcePolicies: tc.diskPolicies,
+			})
+			storageClassParams := common.DiskParameters{
+				DiskType:         "pd-standard",
+				ReplicationType:  "none",
+				ResourcePolicies: tc.resourcePolicies,
+			}
+			err := ValidateDiskParameters(disk, storageClassParams)
+			if !tc.expectErr && err != nil {
+				t.Fatalf("ValidateDiskParameters did not expect error, but got %v", err)
+			}
+			if tc.expectErr && err == nil {
+				t.Fatalf("ValidateDiskParameters expected error, but got no error")
+			}
+		})
+	}
+}
+
+func TestFakeResourcePolicies(t *testing.T) {
+	volKey := meta.ZonalKey("test-disk", "us-central1-c")
+	fcp, err := CreateFakeCloudProvider(testProject, "us-central1-c", nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	params := common.DiskParameters{
+		DiskType:         "pd-standard",
+		ReplicationType:  "none",
+		ResourcePolicies: []string{"daily-backup"},
+	}
+	if err := fcp.InsertDisk(context.Background(), testProject, volKey, params, common.GbToBytes(10), nil, nil, "", "", false); err != nil {
+		t.Fatalf("InsertDisk did not expect error, but got %v", err)
+	}
+	checkPolicies := func(expected ...string) {
+		disk, err := fcp.GetDisk(context.Background(), testProject, volKey, GCEAPIVersionV1)
+		if err != nil {
+			t.Fatalf("GetDisk did not expect error, but got %v", err)
+		}
+		got := []string{}
+		for _, policy := range disk.GetResourcePolicies() {
+			got = append(got, lastComponent(policy))
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected resource policies %v, got %v", expected, disk.GetResourcePolicies())
+		}
+	}
+	checkPolicies("daily-backup")
+
+	if err := fcp.AddResourcePolicies(context.Background(), testProject, volKey, []string{"daily-backup", "weekly-backup"}); err != nil {
+		t.Fatalf("AddResourcePolicies did not expect error, but got %v", err)
+	}
+	checkPolicies("daily-backup", "weekly-backup")
+
+	if err := fcp.RemoveResourcePolicies(context.Background(), testProject, volKey, []string{"daily-backup"}); err != nil {
+		t.Fatalf("RemoveResourcePolicies did not expect error, but got %v", err)
+	}
+	checkPolicies("weekly-backup")
+
+	if err := fcp.AddResourcePolicies(context.Background(), testProject, meta.ZonalKey("other-disk", "us-central1-c"), []string{"daily-backup"}); !IsGCENotFoundError(err) {
+		t.Errorf("Expected not found error for a missing disk, got %v", err)
+	}
+}
+
+func TestCodeForError(t *testing.T) {
+	testCases := []struct {
+		name    string
+		err     error
+		expCode codes.Code
+	}{
+		{
+			name:    "context deadline exceeded",
+			err:     fmt.Errorf("failed to poll operation: %w", context.DeadlineExceeded),
+			expCode: codes.DeadlineExceeded,
+		},
+		{
+			name:    "poll timeout",
+			err:     wait.ErrWaitTimeout,
+			expCode: codes.DeadlineExceeded,
+		},
+		{
+			name:    "quota exceeded operation",
+			err:     fmt.Errorf("unknown Insert disk operation error: %w", &OperationError{OpName: "op", Code: "QUOTA_EXCEEDED"}),
+			expCode: codes.ResourceExhausted,
+		},
+		{
+			name:    "zone exhausted operation",
+			err:     &OperationError{OpName: "op", Code: "ZONE_RESOURCE_POOL_EXHAUSTED"},
+			expCode: codes.Unavailable,
+		},
+		{
+			name:    "unknown operation error",
+			err:     &OperationError{OpName: "op", Code: "UNKNOWN"},
+			expCode: codes.Internal,
+		},
+		{
+			name: "rate limit exceeded",
+			err: &googleapi.Error{
+				Code:   403,
+				Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}},
+			},
+			expCode: codes.ResourceExhausted,
+		},
+		{
+			name:    "other error",
+			err:     fmt.Errorf("error"),
+			expCode: codes.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := CodeForError(tc.err); code != tc.expCode {
+				t.Errorf("Expected code %v, got %v", tc.expCode, code)
+			}
+		})
+	}
+}
+
+func TestValidateDiskParametersCustomerEncryptionKey(t *testing.T) {
+	key := &common.CustomerEncryptionKey{RawKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}
+	otherKey := &common.CustomerEncryptionKey{RawKey: "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="}
+	rsaKey := &common.CustomerEncryptionKey{RSAEncryptedKey: "cnNhLXdyYXBwZWQta2V5"}
+	testCases := []struct {
+		name      string
+		diskKey   *computev1.CustomerEncryptionKey
+		key       *common.CustomerEncryptionKey
+		expectErr bool
+	}{
+		{
+			name:    "matching key",
+			diskKey: &computev1.CustomerEncryptionKey{Sha256: key.Sha256()},
+			key:     key,
+		},
+		{
+			name:      "other key",
+			diskKey:   &computev1.CustomerEncryptionKey{Sha256: key.Sha256()},
+			key:       otherKey,
+			expectErr: true,
+		},
+		{
+			name:      "missing key",
+			diskKey:   &computev1.CustomerEncryptionKey{Sha256: key.Sha256()},
+			expectErr: true,
+		},
+		{
+			name:      "disk without key",
+			key:       key,
+			expectErr: true,
+		},
+		{
+			name: "neither has a key",
+		},
+		{
+			name:    "rsa-wrapped key",
+			diskKey: &computev1.CustomerEncryptionKey{Sha256: key.Sha256()},
+			key:     rsaKey,
+		},
+		{
+			name:      "rsa-wrapped key on disk without key",
+			key:       rsaKey,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			disk := CloudDiskFromV1(&computev1.Disk{
+				Zone:              "us-central1-c",
+				Type:              "pd-standard",
+				DiskEncryptionKey: tc.diskKey,
+			})
+			storageClassParams := common.DiskParameters{
+				DiskType:          "pd-standard",
+				ReplicationType:   "none",
+				DiskEncryptionKey: tc.key,
+			}
+			err := ValidateDiskParameters(disk, storageClassParams)
+			if !tc.expectErr && err != nil {
+				t.Fatalf("ValidateDiskParameters did not expect error, but got %v", err)
+			}
+			if tc.expectErr && err == nil {
+				t.Fatalf("ValidateDiskParameters expected error, but got no error")
+			}
+			if err != nil && (strings.Contains(err.Error(), key.RawKey) || strings.Contains(err.Error(), otherKey.RawKey)) {
+				t.Errorf("ValidateDiskParameters error %v contains the key", err)
+			}
+		})
+	}
+}
+
+func TestRSAEncryptedCustomerEncryptionKey(t *testing.T) {
+	rawKey := &common.CustomerEncryptionKey{RawKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}
+	rsaKey := &common.CustomerEncryptionKey{RSAEncryptedKey: "cnNhLXdyYXBwZWQta2V5"}
+	testCases := []struct {
+		name           string
+		params         common.DiskParameters
+		expVersion     GCEAPIVersion
+		expDiskKey     *computebeta.CustomerEncryptionKey
+		expSnapshotKey *computebeta.CustomerEncryptionKey
+	}{
+		{
+			name:       "raw key",
+			params:     common.DiskParameters{DiskEncryptionKey: rawKey},
+			expVersion: GCEAPIVersionV1,
+			expDiskKey: &computebeta.CustomerEncryptionKey{RawKey: rawKey.RawKey},
+		},
+		{
+			name:       "rsa-wrapped key",
+			params:     common.DiskParameters{DiskEncryptionKey: rsaKey},
+			expVersion: GCEAPIVersionBeta,
+			expDiskKey: &computebeta.CustomerEncryptionKey{RsaEncryptedKey: rsaKey.RSAEncryptedKey},
+		},
+		{
+			name:           "rsa-wrapped source snapshot key",
+			params:         common.DiskParameters{SourceSnapshotEncryptionKey: rsaKey},
+			expVersion:     GCEAPIVersionBeta,
+			expSnapshotKey: &computebeta.CustomerEncryptionKey{RsaEncryptedKey: rsaKey.RSAEncryptedKey},
+		},
+		{
+			name:       "rsa-wrapped key with kms key",
+			params:     common.DiskParameters{DiskEncryptionKey: rsaKey, DiskEncryptionKMSKey: "kms-key"},
+			expVersion: GCEAPIVersionBeta,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if version := GetGCEAPIVersion(tc.params, false); version != tc.expVersion {
+				t.Errorf("Expected API version %v, got %v", tc.expVersion, version)
+			}
+			disk := &computebeta.Disk{}
+			setBetaCustomerEncryptionKeys(disk, tc.params)
+			if !reflect.DeepEqual(disk.DiskEncryptionKey, tc.expDiskKey) {
+				t.Errorf("Expected disk encryption key %+v, got %+v", tc.expDiskKey, disk.DiskEncryptionKey)
+			}
+			if !reflect.DeepEqual(disk.SourceSnapshotEncryptionKey, tc.expSnapshotKey) {
+				t.Errorf("Expected source snapshot encryption key %+v, got %+v", tc.expSnapshotKey, disk.SourceSnapshotEncryptionKey)
+			}
+		})
+	}
+}
@@ -16,10 +16,12 @@ package gcecloudprovider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2/google"
@@ -28,9 +30,11 @@ import (
 
 	"cloud.google.com/go/compute/metadata"
 	"golang.org/x/oauth2"
+	computealpha "google.golang.org/api/compute/v0.alpha"
 	computebeta "google.golang.org/api/compute/v0.beta"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog"
 )
@@ -44,6 +48,8 @@ const (
 
 	regionURITemplate = "projects/%s/regions/%s"
 
+	resourcePolicyURITemplate = "%s/regions/%s/resourcePolicies/%s" // {gce.projectID}/regions/{disk.Region}/resourcePolicies/{policy.Name}"
+
 	GCEComputeAPIEndpoint      = "https://www.googleapis.com/compute/v1/"
 	GCEComputeBetaAPIEndpoint  = "https://www.googleapis.com/compute/beta/"
 	GCEComputeAlphaAPIEndpoint = "https://www.googleapis.com/compute/alpha/"
@@ -52,12 +58,43 @@ const (
 )
 
 type CloudProvider struct {
-	service     *compute.Service
-	betaService *computebeta.Service
-	project     string
-	zone        string
+	service      *compute.Service
+	betaService  *computebeta.Service
+	alphaService *computealpha.Service
+	project      string
+	zone         string
 
 	zonesCache map[string][]string
+
+	// quotasCache holds the quotas of each region, keyed by project and
+	// region, as fetched by GetRegionQuotas.
+	quotasCache      map[string]cachedRegionQuotas
+	quotasCacheMutex sync.Mutex
+
+	// diskCountsCache holds the number of disks in each zone, keyed by
+	// project, as counted by CountDisksByZone.
+	diskCountsCache      map[string]cachedDiskCounts
+	diskCountsCacheMutex sync.Mutex
+
+	// opTracker records the operations started by InsertDisk, AttachDisk and
+	// CreateSnapshot until they are observed to complete.
+	opTracker *operationTracker
+	// operationGetter, if set, replaces the API calls that getOperation
+	// makes. It is set in tests.
+	operationGetter func(ctx context.Context, project string, op pendingOperation) (*compute.Operation, error)
+
+	// limiter rate limits and retries the compute API calls.
+	limiter *callLimiter
+}
+
+type cachedRegionQuotas struct {
+	quotas    []*compute.Quota
+	fetchedAt time.Time
+}
+
+type cachedDiskCounts struct {
+	counts    map[string]int
+	fetchedAt time.Time
 }
 
 var _ GCECompute = &CloudProvider{}
@@ -73,7 +110,7 @@ type ConfigGlobal struct {
 	Zone      string `gcfg:"zone"`
 }
 
-func CreateCloudProvider(ctx context.Context, vendorVersion string, configPath string) (*CloudProvider, error) {
+func CreateCloudProvider(ctx context.Context, vendorVersion string, configPath string, rateLimits RateLimitConfig) (*CloudProvider, error) {
 	configFile, err := readConfig(configPath)
 	if err != nil {
 		return nil, err
@@ -98,17 +135,27 @@ func CreateCloudProvider(ctx context.Context, vendorVersion string, configPath s
 		return nil, err
 	}
 
+	alphasvc, err := createAlphaCloudService(ctx, vendorVersion, tokenSource)
+	if err != nil {
+		return nil, err
+	}
+
 	project, zone, err := getProjectAndZone(configFile)
 	if err != nil {
 		return nil, fmt.Errorf("Failed getting Project and Zone: %v", err)
 	}
 
 	return &CloudProvider{
-		service:     svc,
-		betaService: betasvc,
-		project:     project,
-		zone:        zone,
-		zonesCache:  make(map[string]([]string)),
+		service:         svc,
+		betaService:     betasvc,
+		alphaService:    alphasvc,
+		project:         project,
+		zone:            zone,
+		zonesCache:      make(map[string]([]string)),
+		quotasCache:     make(map[string]cachedRegionQuotas),
+		diskCountsCache: make(map[string]cachedDiskCounts),
+		opTracker:       newOperationTracker(),
+		limiter:         newCallLimiter(rateLimits),
 	}, nil
 
 }
@@ -172,6 +219,19 @@ func createBetaCloudService(ctx context.Context, vendorVersion string, tokenSour
 	return service, nil
 }
 
+func createAlphaCloudService(ctx context.Context, vendorVersion string, tokenSource oauth2.TokenSource) (*computealpha.Service, error) {
+	client, err := newOauthClient(ctx, tokenSource)
+	if err != nil {
+		return nil, err
+	}
+	service, err := computealpha.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	service.UserAgent = fmt.Sprintf("GCE CSI Driver/%s (%s %s)", vendorVersion, runtime.GOOS, runtime.GOARCH)
+	return service, nil
+}
+
 func createCloudService(ctx context.Context, vendorVersion string, tokenSource oauth2.TokenSource) (*compute.Service, error) {
 	svc, err := createCloudServiceWithDefaultServiceAccount(ctx, vendorVersion, tokenSource)
 	return svc, err
@@ -264,3 +324,43 @@ func IsGCENotFoundError(err error) bool {
 func IsGCEInvalidError(err error) bool {
 	return IsGCEError(err, "invalid")
 }
+
+// CodeForError returns the gRPC code to report for an error returned by a GCE
+// call or by waiting on a GCE operation.
+func CodeForError(err error) codes.Code {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, wait.ErrWaitTimeout) {
+		// The operation may still be running and is resumed on retry.
+		return codes.DeadlineExceeded
+	}
+
+	var opErr *OperationError
+	if errors.As(err, &opErr) {
+		switch opErr.Code {
+		case "QUOTA_EXCEEDED":
+			return codes.ResourceExhausted
+		case "ZONE_RESOURCE_POOL_EXHAUSTED", "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS":
+			return codes.Unavailable
+		case "RESOURCE_NOT_FOUND":
+			return codes.NotFound
+		case "RESOURCE_ALREADY_EXISTS":
+			return codes.AlreadyExists
+		}
+		return codes.Internal
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		for _, e := range apiErr.Errors {
+			if e.Reason == "quotaExceeded" || e.Reason == "rateLimitExceeded" {
+				return codes.ResourceExhausted
+			}
+			if e.Reason == "customerEncryptionKeyIsIncorrect" || e.Reason == "resourceIsEncryptedWithCustomerEncryptionKey" {
+				return codes.InvalidArgument
+			}
+			if e.Reason == "resourceInUseByAnotherResource" {
+				return codes.FailedPrecondition
+			}
+		}
+	}
+	return codes.Internal
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcecloudprovider
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+)
+
+// OperationError is returned when a GCE operation completes with an error.
+type OperationError struct {
+	OpName  string
+	Code    string
+	Message string
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %v failed (%v): %v", e.OpName, e.Code, e.Message)
+}
+
+// maxPendingOperationAge bounds how long an operation is tracked. GCE
+// operations that are resumed finish well within it, so an older entry is
+// for an operation that nothing is waiting on anymore.
+const maxPendingOperationAge = 30 * time.Minute
+
+// pendingOperation is a GCE operation started by the driver that has not been
+// observed to complete. Exactly one of zone or region is set for zonal and
+// regional operations, neither is set for global operations.
+type pendingOperation struct {
+	name   string
+	zone   string
+	region string
+}
+
+type trackedOperation struct {
+	op      pendingOperation
+	addedAt time.Time
+}
+
+// operationTracker records pending GCE operations keyed by the resource they
+// act on. A request that is retried after its caller timed out waits on the
+// operation started by the earlier attempt instead of issuing a duplicate.
+// Operations are forgotten after maxPendingOperationAge.
+type operationTracker struct {
+	mutex sync.Mutex
+	ops   map[string]trackedOperation
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func newOperationTracker() *operationTracker {
+	return &operationTracker{
+		ops: make(map[string]trackedOperation),
+		now: time.Now,
+	}
+}
+
+func (t *operationTracker) add(key string, op pendingOperation) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := t.now()
+	// Adding is rare enough that expired operations can be evicted here.
+	for k, tracked := range t.ops {
+		if now.Sub(tracked.addedAt) > maxPendingOperationAge {
+			delete(t.ops, k)
+		}
+	}
+	t.ops[key] = trackedOperation{op: op, addedAt: now}
+}
+
+func (t *operationTracker) get(key string) (pendingOperation, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	tracked, ok := t.ops[key]
+	if !ok {
+		return pendingOperation{}, false
+	}
+	if t.now().Sub(tracked.addedAt) > maxPendingOperationAge {
+		delete(t.ops, key)
+		return pendingOperation{}, false
+	}
+	return tracked.op, true
+}
+
+func (t *operationTracker) remove(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.ops, key)
+}
+
+func diskOperationKey(project string, volKey *meta.Key) string {
+	return fmt.Sprintf("disk/%s/%s", project, volKey.String())
+}
+
+func attachOperationKey(project string, volKey *meta.Key, instanceZone, instanceName string) string {
+	return fmt.Sprintf("attach/%s/%s/%s/%s", project, volKey.String(), instanceZone, instanceName)
+}
+
+func snapshotOperationKey(project, snapshotName string) string {
+	return fmt.Sprintf("snapshot/%s/%s", project, snapshotName)
+}
+
+func imageOperationKey(project, imageName string) string {
+	return fmt.Sprintf("image/%s/%s", project, imageName)
+}
+
+// isOperationInterrupted returns true if err means that waiting on an
+// operation stopped before the operation was observed to complete, so that it
+// should be resumed by a later request.
+func isOperationInterrupted(err error) bool {
+	if err == nil {
+		return false
+	}
+	var opErr *OperationError
+	if errors.As(err, &opErr) {
+		return false
+	}
+	// The operation no longer exists, there is nothing left to resume.
+	if IsGCENotFoundError(err) {
+		return false
+	}
+	return true
+}
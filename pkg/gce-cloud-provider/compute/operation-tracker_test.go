@@ -0,0 +1,319 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcecloudprovider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	computev1 "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
+)
+
+const (
+	testProject = "test-project"
+	testZone    = "us-central1-c"
+)
+
+// fastOperationPolling shortens the operation and resource poll intervals
+// and returns a func that restores them.
+func fastOperationPolling() func() {
+	oldInterval, oldResourceInterval := operationPollInterval, resourceCreationPollInterval
+	operationPollInterval = time.Millisecond
+	resourceCreationPollInterval = time.Millisecond
+	return func() {
+		operationPollInterval = oldInterval
+		resourceCreationPollInterval = oldResourceInterval
+	}
+}
+
+// newTestCloudProvider returns a CloudProvider whose operations are fetched
+// with getOperation instead of the API.
+func newTestCloudProvider(t *testing.T, getOperation func(ctx context.Context, project string, op pendingOperation) (*computev1.Operation, error)) *CloudProvider {
+	service, err := computev1.New(http.DefaultClient)
+	if err != nil {
+		t.Fatalf("Failed to create compute service: %v", err)
+	}
+	return &CloudProvider{
+		service:         service,
+		project:         testProject,
+		zone:            testZone,
+		opTracker:       newOperationTracker(),
+		operationGetter: getOperation,
+	}
+}
+
+func TestOperationTracker(t *testing.T) {
+	tracker := newOperationTracker()
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	op := pendingOperation{name: "op-1", zone: testZone}
+	tracker.add("key-1", op)
+	if got, ok := tracker.get("key-1"); !ok || got != op {
+		t.Errorf("Expected to get %v, got %v, %v", op, got, ok)
+	}
+	if _, ok := tracker.get("key-2"); ok {
+		t.Errorf("Expected no operation for an unknown key")
+	}
+
+	tracker.remove("key-1")
+	if _, ok := tracker.get("key-1"); ok {
+		t.Errorf("Expected no operation after remove")
+	}
+
+	// Operations are forgotten once they are too old to be resumed.
+	tracker.add("key-1", op)
+	now = now.Add(maxPendingOperationAge + time.Second)
+	if _, ok := tracker.get("key-1"); ok {
+		t.Errorf("Expected expired operation to be forgotten")
+	}
+	tracker.add("key-2", op)
+	now = now.Add(maxPendingOperationAge + time.Second)
+	tracker.add("key-3", op)
+	if _, ok := tracker.ops["key-2"]; ok {
+		t.Errorf("Expected expired operation to be evicted when another is added")
+	}
+}
+
+func TestIsOperationInterrupted(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{
+			name: "no error",
+		},
+		{
+			name: "operation failed",
+			err:  &OperationError{OpName: "op-1", Code: "QUOTA_EXCEEDED"},
+		},
+		{
+			name: "operation not found",
+			err:  &googleapi.Error{Code: http.StatusNotFound, Errors: []googleapi.ErrorItem{{Reason: "notFound"}}},
+		},
+		{
+			name:   "context cancelled",
+			err:    context.Canceled,
+			expect: true,
+		},
+		{
+			name:   "other API error",
+			err:    &googleapi.Error{Code: http.StatusInternalServerError},
+			expect: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isOperationInterrupted(tc.err); got != tc.expect {
+				t.Errorf("isOperationInterrupted(%v) = %v, expected %v", tc.err, got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestWaitForOperation(t *testing.T) {
+	defer fastOperationPolling()()
+	testCases := []struct {
+		name         string
+		operation    *computev1.Operation
+		getErr       error
+		cancelCtx    bool
+		expErr       bool
+		expTrackedOp bool
+	}{
+		{
+			name:      "operation succeeds",
+			operation: &computev1.Operation{Name: "op-1", Status: operationStatusDone},
+		},
+		{
+			name: "operation fails",
+			operation: &computev1.Operation{
+				Name:   "op-1",
+				Status: operationStatusDone,
+				Error: &computev1.OperationError{
+					Errors: []*computev1.OperationErrorErrors{{Code: "QUOTA_EXCEEDED", Message: "out of quota"}},
+				},
+			},
+			expErr: true,
+		},
+		{
+			name:   "operation not found",
+			getErr: &googleapi.Error{Code: http.StatusNotFound, Errors: []googleapi.ErrorItem{{Reason: "notFound"}}},
+			expErr: true,
+		},
+		{
+			name:         "context cancelled",
+			operation:    &computev1.Operation{Name: "op-1", Status: "RUNNING"},
+			cancelCtx:    true,
+			expErr:       true,
+			expTrackedOp: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cloud := newTestCloudProvider(t, func(ctx context.Context, project string, op pendingOperation) (*computev1.Operation, error) {
+				if tc.cancelCtx {
+					cancel()
+				}
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				return tc.operation, tc.getErr
+			})
+
+			op := pendingOperation{name: "op-1", zone: testZone}
+			cloud.opTracker.add("key-1", op)
+			err := cloud.waitForOperation(ctx, testProject, "key-1", op)
+			if gotErr := err != nil; gotErr != tc.expErr {
+				t.Fatalf("waitForOperation returned %v, expected error: %v", err, tc.expErr)
+			}
+			if tc.cancelCtx && !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context cancelled error, got %v", err)
+			}
+			if _, ok := cloud.opTracker.get("key-1"); ok != tc.expTrackedOp {
+				t.Errorf("Expected operation to be tracked: %v, got %v", tc.expTrackedOp, ok)
+			}
+		})
+	}
+}
+
+func TestWaitForResourceCreation(t *testing.T) {
+	defer fastOperationPolling()()
+	notFound := &googleapi.Error{Code: http.StatusNotFound, Errors: []googleapi.ErrorItem{{Reason: "notFound"}}}
+	testCases := []struct {
+		name         string
+		resourceErrs []error
+		operation    *computev1.Operation
+		cancelCtx    bool
+		expErr       bool
+		expTrackedOp bool
+	}{
+		{
+			name:         "resource exists before the operation is done",
+			resourceErrs: []error{notFound, nil},
+			operation:    &computev1.Operation{Name: "op-1", Status: "RUNNING"},
+		},
+		{
+			name:         "operation fails",
+			resourceErrs: []error{notFound},
+			operation: &computev1.Operation{
+				Name:   "op-1",
+				Status: operationStatusDone,
+				Error: &computev1.OperationError{
+					Errors: []*computev1.OperationErrorErrors{{Code: "QUOTA_EXCEEDED", Message: "out of quota"}},
+				},
+			},
+			expErr: true,
+		},
+		{
+			name:         "context cancelled",
+			resourceErrs: []error{notFound},
+			operation:    &computev1.Operation{Name: "op-1", Status: "RUNNING"},
+			cancelCtx:    true,
+			expErr:       true,
+			expTrackedOp: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cloud := newTestCloudProvider(t, func(ctx context.Context, project string, op pendingOperation) (*computev1.Operation, error) {
+				if tc.cancelCtx {
+					cancel()
+				}
+				return tc.operation, nil
+			})
+
+			op := pendingOperation{name: "op-1"}
+			cloud.opTracker.add("key-1", op)
+			calls := 0
+			err := cloud.waitForResourceCreation(ctx, testProject, "resource", "key-1", op, func() error {
+				err := tc.resourceErrs[calls]
+				if calls < len(tc.resourceErrs)-1 {
+					calls++
+				}
+				return err
+			})
+			if gotErr := err != nil; gotErr != tc.expErr {
+				t.Fatalf("waitForResourceCreation returned %v, expected error: %v", err, tc.expErr)
+			}
+			if tc.cancelCtx && !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context cancelled error, got %v", err)
+			}
+			if _, ok := cloud.opTracker.get("key-1"); ok != tc.expTrackedOp {
+				t.Errorf("Expected operation to be tracked: %v, got %v", tc.expTrackedOp, ok)
+			}
+		})
+	}
+}
+
+func TestResumeTrackedOperation(t *testing.T) {
+	defer fastOperationPolling()()
+	volKey := meta.ZonalKey("test-disk", testZone)
+	testCases := []struct {
+		name    string
+		opKey   string
+		request func(cloud *CloudProvider) error
+	}{
+		{
+			name:  "InsertDisk",
+			opKey: diskOperationKey(testProject, volKey),
+			request: func(cloud *CloudProvider) error {
+				params := common.DiskParameters{DiskType: "pd-standard", ReplicationType: "none"}
+				capacityRange := &csi.CapacityRange{RequiredBytes: common.GbToBytes(10)}
+				return cloud.InsertDisk(context.Background(), testProject, volKey, params, common.GbToBytes(10), capacityRange, nil, "", "", false)
+			},
+		},
+		{
+			name:  "AttachDisk",
+			opKey: attachOperationKey(testProject, volKey, testZone, "test-instance"),
+			request: func(cloud *CloudProvider) error {
+				return cloud.AttachDisk(context.Background(), testProject, volKey, "READ_WRITE", "PERSISTENT", testZone, "test-instance", nil, false)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			polled := []string{}
+			cloud := newTestCloudProvider(t, func(ctx context.Context, project string, op pendingOperation) (*computev1.Operation, error) {
+				polled = append(polled, op.name)
+				return &computev1.Operation{Name: op.name, Status: operationStatusDone}, nil
+			})
+			cloud.opTracker.add(tc.opKey, pendingOperation{name: "earlier-op", zone: testZone})
+
+			if err := tc.request(cloud); err != nil {
+				t.Fatalf("Expected resumed request to succeed, got %v", err)
+			}
+			// Only the earlier operation is waited on, no new one is issued.
+			if len(polled) != 1 || polled[0] != "earlier-op" {
+				t.Errorf("Expected only earlier-op to be polled, got %v", polled)
+			}
+			if _, ok := cloud.opTracker.get(tc.opKey); ok {
+				t.Errorf("Expected completed operation to no longer be tracked")
+			}
+		})
+	}
+}
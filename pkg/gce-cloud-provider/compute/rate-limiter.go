@@ -0,0 +1,203 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcecloudprovider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/googleapi"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/tracing"
+)
+
+// callKind classifies compute API calls, which are rate limited with a
+// separate budget for each kind.
+type callKind int
+
+const (
+	// readCall gets or lists resources.
+	readCall callKind = iota
+	// mutateCall creates, modifies or deletes resources.
+	mutateCall
+	// operationPollCall gets the state of a pending operation.
+	operationPollCall
+)
+
+func (k callKind) String() string {
+	switch k {
+	case readCall:
+		return "read"
+	case mutateCall:
+		return "mutate"
+	case operationPollCall:
+		return "operation poll"
+	default:
+		return fmt.Sprintf("callKind(%d)", int(k))
+	}
+}
+
+// retriableErrorReasons are the googleapi error reasons of calls that were
+// throttled or failed in the backend, and may succeed when retried.
+var retriableErrorReasons = []string{
+	"rateLimitExceeded",
+	"userRateLimitExceeded",
+	"backendError",
+	"internalError",
+}
+
+// RateLimitConfig configures the client-side rate limits of the compute API
+// calls of a CloudProvider, and the retries of calls that were throttled or
+// failed with a server error.
+type RateLimitConfig struct {
+	// The QPS and burst of each kind of call. A QPS of 0 or less disables
+	// rate limiting of that kind of call.
+	ReadQPS            float32
+	ReadBurst          int
+	MutateQPS          float32
+	MutateBurst        int
+	OperationPollQPS   float32
+	OperationPollBurst int
+
+	// MaxRetries is the number of times a failed call is retried.
+	MaxRetries int
+	// InitialBackoff is the delay before the first retry, which doubles for
+	// each further retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRateLimitConfig returns the rate limits used unless they are
+// configured, which keep well within the default per-project compute API
+// quotas.
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		ReadQPS:            20,
+		ReadBurst:          40,
+		MutateQPS:          5,
+		MutateBurst:        20,
+		OperationPollQPS:   10,
+		OperationPollBurst: 20,
+		MaxRetries:         5,
+		InitialBackoff:     time.Second,
+		MaxBackoff:         30 * time.Second,
+	}
+}
+
+// callLimiter rate limits and retries compute API calls. A nil callLimiter
+// makes each call once without limits.
+type callLimiter struct {
+	limiters       map[callKind]flowcontrol.RateLimiter
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func newCallLimiter(config RateLimitConfig) *callLimiter {
+	newLimiter := func(qps float32, burst int) flowcontrol.RateLimiter {
+		if qps <= 0 {
+			return flowcontrol.NewFakeAlwaysRateLimiter()
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		return flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+	}
+	return &callLimiter{
+		limiters: map[callKind]flowcontrol.RateLimiter{
+			readCall:          newLimiter(config.ReadQPS, config.ReadBurst),
+			mutateCall:        newLimiter(config.MutateQPS, config.MutateBurst),
+			operationPollCall: newLimiter(config.OperationPollQPS, config.OperationPollBurst),
+		},
+		maxRetries:     config.MaxRetries,
+		initialBackoff: config.InitialBackoff,
+		maxBackoff:     config.MaxBackoff,
+	}
+}
+
+// do waits for the rate limit of the kind of call, and makes it. If the call
+// fails with a retriable error, it is retried with exponential backoff up to
+// maxRetries times, and the error of the last attempt is returned.
+func (l *callLimiter) do(ctx context.Context, kind callKind, call func() error) error {
+	if l == nil {
+		return call()
+	}
+	backoff := l.initialBackoff
+	for attempt := 0; ; attempt++ {
+		if err := l.limiters[kind].Wait(ctx); err != nil {
+			return fmt.Errorf("rate limited %v call was not made: %w", kind, err)
+		}
+		err := call()
+		if err == nil || attempt >= l.maxRetries || !isRetriableError(err) {
+			return err
+		}
+		delay := wait.Jitter(backoff, 0.1)
+		klog.V(4).Infof("Retrying %v call in %v after attempt %d failed: %v", kind, delay, attempt+1, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+		if backoff > l.maxBackoff {
+			backoff = l.maxBackoff
+		}
+	}
+}
+
+// isRetriableError returns true if the call was throttled, or failed with a
+// server error. Mutations that failed with a server error may have been
+// applied, which the callers already handle for retries by the CO, for
+// example by treating alreadyExists and notFound errors as success.
+func isRetriableError(err error) bool {
+	for _, reason := range retriableErrorReasons {
+		if IsGCEError(err, reason) {
+			return true
+		}
+	}
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code >= 500
+}
+
+// call makes a compute API call of the given kind through the rate limiter
+// of the CloudProvider, and records the latency and error of each attempt
+// by the name of its API method. The call, including its retries, is traced
+// as a span named after the method.
+func (cloud *CloudProvider) call(ctx context.Context, kind callKind, apiVersion GCEAPIVersion, method string, do func() error) error {
+	ctx, span := tracing.StartSpan(ctx, "compute."+method, attribute.String(tracing.AttributeAPIVersion, string(apiVersion)))
+	err := cloud.limiter.do(ctx, kind, func() error {
+		start := time.Now()
+		err := do()
+		metrics.RecordGCERequest(method, string(apiVersion), time.Since(start), err != nil, errorReason(err))
+		return err
+	})
+	tracing.EndSpan(span, err)
+	return err
+}
+
+// errorReason returns the googleapi error reason of an error returned by a
+// compute API call, or "unknown" if it has none.
+func errorReason(err error) string {
+	if apiErr, ok := err.(*googleapi.Error); ok && len(apiErr.Errors) > 0 {
+		return apiErr.Errors[0].Reason
+	}
+	return "unknown"
+}
@@ -0,0 +1,336 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcecloudprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	computev1 "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/tracing"
+)
+
+func gceError(code int, reason string) error {
+	return &googleapi.Error{
+		Code:   code,
+		Errors: []googleapi.ErrorItem{{Reason: reason}},
+	}
+}
+
+// testRateLimitConfig returns a config without rate limits that retries
+// without delay.
+func testRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}
+}
+
+func TestIsRetriableError(t *testing.T) {
+	testCases := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{
+			name:   "rate limit exceeded",
+			err:    gceError(http.StatusForbidden, "rateLimitExceeded"),
+			expect: true,
+		},
+		{
+			name:   "user rate limit exceeded",
+			err:    gceError(http.StatusForbidden, "userRateLimitExceeded"),
+			expect: true,
+		},
+		{
+			name:   "backend error",
+			err:    gceError(http.StatusServiceUnavailable, "backendError"),
+			expect: true,
+		},
+		{
+			name:   "server error without reason",
+			err:    &googleapi.Error{Code: http.StatusBadGateway},
+			expect: true,
+		},
+		{
+			name:   "not found",
+			err:    gceError(http.StatusNotFound, "notFound"),
+			expect: false,
+		},
+		{
+			name:   "quota exceeded",
+			err:    gceError(http.StatusForbidden, "quotaExceeded"),
+			expect: false,
+		},
+		{
+			name:   "not a googleapi error",
+			err:    fmt.Errorf("connection reset"),
+			expect: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isRetriableError(tc.err); got != tc.expect {
+				t.Errorf("isRetriableError(%v) = %v, expected %v", tc.err, got, tc.expect)
+			}
+		})
+	}
+}
+
+func TestCallLimiterRetries(t *testing.T) {
+	testCases := []struct {
+		name        string
+		errs        []error
+		expAttempts int
+		expErr      bool
+	}{
+		{
+			name:        "success",
+			expAttempts: 1,
+		},
+		{
+			name:        "retried after rate limit exceeded",
+			errs:        []error{gceError(http.StatusForbidden, "rateLimitExceeded")},
+			expAttempts: 2,
+		},
+		{
+			name: "retried after server errors",
+			errs: []error{
+				gceError(http.StatusServiceUnavailable, "backendError"),
+				&googleapi.Error{Code: http.StatusInternalServerError},
+			},
+			expAttempts: 3,
+		},
+		{
+			name:        "not retried after not found",
+			errs:        []error{gceError(http.StatusNotFound, "notFound")},
+			expAttempts: 1,
+			expErr:      true,
+		},
+		{
+			name: "gives up after max retries",
+			errs: []error{
+				gceError(http.StatusForbidden, "rateLimitExceeded"),
+				gceError(http.StatusForbidden, "rateLimitExceeded"),
+				gceError(http.StatusForbidden, "rateLimitExceeded"),
+				gceError(http.StatusForbidden, "rateLimitExceeded"),
+			},
+			expAttempts: 4,
+			expErr:      true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := newCallLimiter(testRateLimitConfig())
+			attempts := 0
+			err := limiter.do(context.Background(), readCall, func() error {
+				attempts++
+				if attempts <= len(tc.errs) {
+					return tc.errs[attempts-1]
+				}
+				return nil
+			})
+			if gotErr := err != nil; gotErr != tc.expErr {
+				t.Errorf("Got error %v, expected error %v", err, tc.expErr)
+			}
+			if attempts != tc.expAttempts {
+				t.Errorf("Got %d attempts, expected %d", attempts, tc.expAttempts)
+			}
+		})
+	}
+}
+
+func TestCallLimiterBudgets(t *testing.T) {
+	config := testRateLimitConfig()
+	config.ReadQPS, config.ReadBurst = 0.001, 1
+	config.MutateQPS, config.MutateBurst = 0.001, 1
+	limiter := newCallLimiter(config)
+	noop := func() error { return nil }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := limiter.do(ctx, readCall, noop); err != nil {
+		t.Fatalf("First read call failed: %v", err)
+	}
+	// The read budget is used up, and the next token is not available
+	// before the deadline.
+	called := false
+	err := limiter.do(ctx, readCall, func() error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Errorf("Second read call was made, expected it to be rate limited")
+	}
+	// The mutate and operation poll budgets are separate.
+	if err := limiter.do(ctx, mutateCall, noop); err != nil {
+		t.Errorf("Mutate call failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := limiter.do(ctx, operationPollCall, noop); err != nil {
+			t.Errorf("Unlimited operation poll call failed: %v", err)
+		}
+	}
+}
+
+func TestNilCallLimiter(t *testing.T) {
+	var limiter *callLimiter
+	attempts := 0
+	expErr := gceError(http.StatusForbidden, "rateLimitExceeded")
+	err := limiter.do(context.Background(), mutateCall, func() error {
+		attempts++
+		return expErr
+	})
+	if !errors.Is(err, expErr) || attempts != 1 {
+		t.Errorf("Got error %v after %d attempts, expected %v after 1 attempt", err, attempts, expErr)
+	}
+}
+
+func TestWaitForOperationRetriesPolls(t *testing.T) {
+	defer fastOperationPolling()()
+	polls := 0
+	cloud := newTestCloudProvider(t, func(ctx context.Context, project string, op pendingOperation) (*computev1.Operation, error) {
+		polls++
+		if polls == 1 {
+			return nil, gceError(http.StatusForbidden, "rateLimitExceeded")
+		}
+		return &computev1.Operation{Name: op.name, Status: operationStatusDone}, nil
+	})
+	cloud.limiter = newCallLimiter(testRateLimitConfig())
+
+	op := pendingOperation{name: "op", zone: testZone}
+	cloud.opTracker.add("key", op)
+	if err := cloud.waitForOperation(context.Background(), testProject, "key", op); err != nil {
+		t.Fatalf("waitForOperation failed: %v", err)
+	}
+	if polls != 2 {
+		t.Errorf("Got %d polls, expected 2", polls)
+	}
+}
+
+func TestWaitForOperationTracing(t *testing.T) {
+	defer fastOperationPolling()()
+	exporter := tracetest.NewInMemoryExporter()
+	defer tracing.EnableSync(exporter)()
+
+	polls := 0
+	cloud := newTestCloudProvider(t, func(ctx context.Context, project string, op pendingOperation) (*computev1.Operation, error) {
+		polls++
+		if polls == 1 {
+			return &computev1.Operation{Name: op.name, Status: "RUNNING"}, nil
+		}
+		return &computev1.Operation{Name: op.name, Status: operationStatusDone}, nil
+	})
+
+	ctx, rpcSpan := tracing.StartSpan(context.Background(), "rpc")
+	op := pendingOperation{name: "op", zone: testZone}
+	cloud.opTracker.add("key", op)
+	if err := cloud.waitForOperation(ctx, testProject, "key", op); err != nil {
+		t.Fatalf("waitForOperation failed: %v", err)
+	}
+	tracing.EndSpan(rpcSpan, nil)
+
+	// Each poll is a child of the wait, which is a child of the RPC.
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("Got %d spans, expected 4", len(spans))
+	}
+	polled, wait, rpc := spans[:2], spans[2], spans[3]
+	for _, poll := range polled {
+		if poll.Name != "compute.ZoneOperations.Get" || poll.Parent.SpanID() != wait.SpanContext.SpanID() {
+			t.Errorf("Got span %s with parent %v, expected compute.ZoneOperations.Get with parent %v", poll.Name, poll.Parent.SpanID(), wait.SpanContext.SpanID())
+		}
+		attributes := attribute.NewSet(poll.Attributes...)
+		if v, _ := attributes.Value(tracing.AttributeAPIVersion); v.AsString() != string(GCEAPIVersionV1) {
+			t.Errorf("Got API version attribute %v, expected %v", v.Emit(), GCEAPIVersionV1)
+		}
+	}
+	if wait.Name != "compute.WaitForOperation" || wait.Parent.SpanID() != rpc.SpanContext.SpanID() {
+		t.Errorf("Got span %s with parent %v, expected compute.WaitForOperation with parent %v", wait.Name, wait.Parent.SpanID(), rpc.SpanContext.SpanID())
+	}
+	attributes := attribute.NewSet(wait.Attributes...)
+	if v, _ := attributes.Value(tracing.AttributeOperationName); v.AsString() != "op" {
+		t.Errorf("Got operation name attribute %v, expected op", v.Emit())
+	}
+}
+
+// countingRateLimiter counts the calls that waited for the rate limit.
+type countingRateLimiter struct {
+	flowcontrol.RateLimiter
+	waits int
+}
+
+func (l *countingRateLimiter) Wait(ctx context.Context) error {
+	l.waits++
+	return l.RateLimiter.Wait(ctx)
+}
+
+func TestCountDisksByZoneRateLimitsPages(t *testing.T) {
+	pages := map[string]*computev1.DiskAggregatedList{
+		"": {
+			Items: map[string]computev1.DisksScopedList{
+				"zones/zone-a": {Disks: []*computev1.Disk{{Zone: "zones/zone-a"}}},
+			},
+			NextPageToken: "page-2",
+		},
+		"page-2": {
+			Items: map[string]computev1.DisksScopedList{
+				"regions/region": {Disks: []*computev1.Disk{{ReplicaZones: []string{"zones/zone-a", "zones/zone-b"}}}},
+			},
+		},
+	}
+	var requests []string
+	failedPage := false
+	listPage := func(pageToken string) (*computev1.DiskAggregatedList, error) {
+		requests = append(requests, pageToken)
+		if pageToken == "page-2" && !failedPage {
+			failedPage = true
+			return nil, &googleapi.Error{Code: http.StatusServiceUnavailable}
+		}
+		return pages[pageToken], nil
+	}
+
+	cloud := newTestCloudProvider(t, nil)
+	limiter := &countingRateLimiter{RateLimiter: flowcontrol.NewFakeAlwaysRateLimiter()}
+	cloud.limiter = newCallLimiter(testRateLimitConfig())
+	cloud.limiter.limiters[readCall] = limiter
+
+	counts, err := cloud.countDisksByZone(context.Background(), testProject, listPage)
+	if err != nil {
+		t.Fatalf("countDisksByZone failed: %v", err)
+	}
+	if expected := map[string]int{"zone-a": 2, "zone-b": 1}; !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Got counts %v, expected %v", counts, expected)
+	}
+	// Each page waits for the rate limit, and the failed page is retried
+	// without listing the first page again.
+	if expected := []string{"", "page-2", "page-2"}; !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Got requests for pages %q, expected %q", requests, expected)
+	}
+	if limiter.waits != 3 {
+		t.Errorf("Got %d rate limit waits, expected 3", limiter.waits)
+	}
+}
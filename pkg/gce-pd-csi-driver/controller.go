@@ -16,9 +16,12 @@ package gceGCEDriver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -39,10 +42,29 @@ type GCEControllerServer struct {
 	Driver        *GCEDriver
 	CloudProvider gce.GCECompute
 
+	// zoneSelectors holds the zone selector for each strategy, and
+	// zoneSelectionStrategy is the one used unless a StorageClass overrides
+	// it.
+	zoneSelectors         map[string]zoneSelector
+	zoneSelectionStrategy string
+
+	// allowedProjects are the projects, other than the default project,
+	// that a StorageClass may create disks in.
+	allowedProjects sets.String
+
+	// restrictSnapshotProjects limits the snapshots that volumes are restored
+	// from to the default project, allowedProjects and the project of the
+	// new disk.
+	restrictSnapshotProjects bool
+
 	// A map storing all volumes with ongoing operations so that additional
 	// operations for that same volume (as defined by Volume Key) return an
 	// Aborted error
 	volumeLocks *common.VolumeLocks
+
+	// attachBatcher coalesces the attach and detach calls for the disks of
+	// each instance.
+	attachBatcher *gce.AttachBatcher
 }
 
 var _ csi.ControllerServer = &GCEControllerServer{}
@@ -57,8 +79,21 @@ const (
 
 	replicationTypeNone       = "none"
 	replicationTypeRegionalPD = "regional-pd"
+
+	// imagesPageTokenPrefix marks ListSnapshots page tokens for images.
+	imagesPageTokenPrefix = "images/"
 )
 
+// diskTypeQuotaMetrics maps PD types to the regional Compute Engine quota
+// metric their capacity is counted against.
+// https://cloud.google.com/compute/quotas#disk_quota
+var diskTypeQuotaMetrics = map[string]string{
+	"pd-standard": "DISKS_TOTAL_GB",
+	"pd-balanced": "SSD_TOTAL_GB",
+	"pd-ssd":      "SSD_TOTAL_GB",
+	"pd-extreme":  "SSD_TOTAL_GB",
+}
+
 func isDiskReady(disk *gce.CloudDisk) (bool, error) {
 	status := disk.GetStatus()
 	switch status {
@@ -111,18 +146,60 @@ func (gceCS *GCEControllerServer) CreateVolume(ctx context.Context, req *csi.Cre
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "failed to extract parameters: %v", err)
 	}
+	if params.MkfsOptions != "" {
+		for _, volumeCapability := range volumeCapabilities {
+			if mnt := volumeCapability.GetMount(); mnt != nil {
+				if _, err := common.ParseMkfsOptions(params.MkfsOptions, mnt.GetFsType()); err != nil {
+					return nil, status.Errorf(codes.InvalidArgument, "CreateVolume %s parameter is invalid: %v", common.ParameterKeyMkfsOptions, err)
+				}
+			}
+		}
+	}
+	if params.NodeEncryption == common.NodeEncryptionLUKS {
+		// Block volumes are not staged, so they would not be encrypted.
+		for _, volumeCapability := range volumeCapabilities {
+			if volumeCapability.GetBlock() != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "CreateVolume %s parameter %q is not supported for block volumes", common.ParameterKeyNodeEncryption, params.NodeEncryption)
+			}
+		}
+	}
+	params.DiskEncryptionKey, err = common.ExtractCustomerEncryptionKey(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume %v", err)
+	}
+	if params.DiskEncryptionKey != nil && params.DiskEncryptionKMSKey != "" {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume %s parameter cannot be combined with a customer-supplied encryption key", common.ParameterKeyDiskEncryptionKmsKey)
+	}
+	project, err := gceCS.getProject(params)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume %v", err)
+	}
 	// Determine multiWriter
-	gceAPIVersion := gce.GCEAPIVersionV1
 	multiWriter, _ := getMultiWriterFromCapabilities(volumeCapabilities)
-	if multiWriter {
-		gceAPIVersion = gce.GCEAPIVersionBeta
-	}
+	gceAPIVersion := gce.GetGCEAPIVersion(params, multiWriter)
 	// Determine the zone or zones+region of the disk
+	zoneSelectionStrategy := params.ZoneSelectionStrategy
+	if zoneSelectionStrategy == "" {
+		zoneSelectionStrategy = gceCS.zoneSelectionStrategy
+	}
+	// A clone must share a zone with a zonal source disk, so the source
+	// disk's zone is picked first when the topology allows it.
+	var sourceProject, sourceZone string
+	var sourceVolKey *meta.Key
+	if sourceVolume := req.GetVolumeContentSource().GetVolume(); sourceVolume != nil {
+		sourceProject, sourceVolKey, err = gceCS.getSourceVolumeKey(ctx, sourceVolume.GetVolumeId())
+		if err != nil {
+			return nil, err
+		}
+		if sourceVolKey.Type() == meta.Zonal {
+			sourceZone = sourceVolKey.Zone
+		}
+	}
 	var zones []string
 	var volKey *meta.Key
 	switch params.ReplicationType {
 	case replicationTypeNone:
-		zones, err = pickZones(ctx, gceCS, req.GetAccessibilityRequirements(), 1)
+		zones, err = pickZones(ctx, gceCS, project, req.GetAccessibilityRequirements(), 1, zoneSelectionStrategy, sourceZone)
 		if err != nil {
 			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("CreateVolume failed to pick zones for disk: %v", err))
 		}
@@ -132,7 +209,7 @@ func (gceCS *GCEControllerServer) CreateVolume(ctx context.Context, req *csi.Cre
 		volKey = meta.ZonalKey(name, zones[0])
 
 	case replicationTypeRegionalPD:
-		zones, err = pickZones(ctx, gceCS, req.GetAccessibilityRequirements(), 2)
+		zones, err = pickZones(ctx, gceCS, project, req.GetAccessibilityRequirements(), 2, zoneSelectionStrategy, sourceZone)
 		if err != nil {
 			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("CreateVolume failed to pick zones for disk: %v", err))
 		}
@@ -145,7 +222,7 @@ func (gceCS *GCEControllerServer) CreateVolume(ctx context.Context, req *csi.Cre
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("CreateVolume replication type '%s' is not supported", params.ReplicationType))
 	}
 
-	volumeID, err := common.KeyToVolumeID(volKey, gceCS.CloudProvider.GetDefaultProject())
+	volumeID, err := common.KeyToVolumeID(volKey, project)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Failed to convert volume key to volume ID: %v", err)
 	}
@@ -155,7 +232,7 @@ func (gceCS *GCEControllerServer) CreateVolume(ctx context.Context, req *csi.Cre
 	defer gceCS.volumeLocks.Release(volumeID)
 
 	// Validate if disk already exists
-	existingDisk, err := gceCS.CloudProvider.GetDisk(ctx, gceCS.CloudProvider.GetDefaultProject(), volKey, gceAPIVersion)
+	existingDisk, err := gceCS.CloudProvider.GetDisk(ctx, project, volKey, gceAPIVersion)
 	if err != nil {
 		if !gce.IsGCEError(err, "notFound") {
 			return nil, status.Error(codes.Internal, fmt.Sprintf("CreateVolume unknown get disk error when validating: %v", err))
@@ -170,7 +247,12 @@ func (gceCS *GCEControllerServer) CreateVolume(ctx context.Context, req *csi.Cre
 		if err != nil {
 			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("CreateVolume disk already exists with same name and is incompatible: %v", err))
 		}
-
+	}
+	if err == nil && gceCS.CloudProvider.HasPendingDiskOperation(project, volKey) {
+		// The disk is still being created by an earlier request that timed
+		// out. Creating it below resumes waiting on that operation.
+		klog.V(4).Infof("CreateVolume resuming creation of disk %v", volKey)
+	} else if err == nil {
 		ready, err := isDiskReady(existingDisk)
 		if err != nil {
 			return nil, status.Error(codes.Internal, fmt.Sprintf("CreateVolume disk %v had error checking ready status: %v", volKey, err))
@@ -182,22 +264,35 @@ func (gceCS *GCEControllerServer) CreateVolume(ctx context.Context, req *csi.Cre
 
 		// If there is no validation error, immediately return success
 		klog.V(4).Infof("CreateVolume succeeded for disk %v, it already exists and was compatible", volKey)
-		return generateCreateVolumeResponse(existingDisk, zones), nil
+		return generateCreateVolumeResponse(existingDisk, zones, params), nil
 	}
 
 	snapshotID := ""
+	volumeContentSourceVolumeID := ""
 	content := req.GetVolumeContentSource()
+	if params.SourceImage != "" {
+		if content != nil {
+			return nil, status.Error(codes.InvalidArgument, "CreateVolume source image cannot be combined with a volume content source")
+		}
+		err = gceCS.validateSourceImage(ctx, params.SourceImage, capBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if content != nil {
 		if content.GetSnapshot() != nil {
-			// TODO(#161): Add support for Volume Source (cloning) introduced in CSI v1.0.0
 			snapshotID = content.GetSnapshot().GetSnapshotId()
+			params.SourceSnapshotEncryptionKey, err = gceCS.validateSourceSnapshot(ctx, snapshotID, project, params, capBytes)
+			if err != nil {
+				return nil, err
+			}
+		}
 
-			// Verify that snapshot exists
-			sl, err := gceCS.getSnapshotByID(ctx, snapshotID)
+		if content.GetVolume() != nil {
+			volumeContentSourceVolumeID = content.GetVolume().GetVolumeId()
+			err = gceCS.validateSourceVolume(ctx, volumeContentSourceVolumeID, sourceProject, sourceVolKey, params, volKey, zones, capBytes)
 			if err != nil {
-				return nil, status.Errorf(codes.Internal, "CreateVolume failed to get snapshot %s: %v", snapshotID, err)
-			} else if len(sl.Entries) == 0 {
-				return nil, status.Errorf(codes.NotFound, "CreateVolume source snapshot %s does not exist", snapshotID)
+				return nil, err
 			}
 		}
 	}
@@ -209,17 +304,17 @@ func (gceCS *GCEControllerServer) CreateVolume(ctx context.Context, req *csi.Cre
 		if len(zones) != 1 {
 			return nil, status.Error(codes.Internal, fmt.Sprintf("CreateVolume failed to get a single zone for creating zonal disk, instead got: %v", zones))
 		}
-		disk, err = createSingleZoneDisk(ctx, gceCS.CloudProvider, name, zones, params, capacityRange, capBytes, snapshotID, multiWriter)
+		disk, err = createSingleZoneDisk(ctx, gceCS.CloudProvider, project, name, zones, params, capacityRange, capBytes, snapshotID, volumeContentSourceVolumeID, multiWriter)
 		if err != nil {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("CreateVolume failed to create single zonal disk %#v: %v", name, err))
+			return nil, status.Error(gce.CodeForError(err), fmt.Sprintf("CreateVolume failed to create single zonal disk %#v: %v", name, err))
 		}
 	case replicationTypeRegionalPD:
 		if len(zones) != 2 {
 			return nil, status.Errorf(codes.Internal, fmt.Sprintf("CreateVolume failed to get a 2 zones for creating regional disk, instead got: %v", zones))
 		}
-		disk, err = createRegionalDisk(ctx, gceCS.CloudProvider, name, zones, params, capacityRange, capBytes, snapshotID, multiWriter)
+		disk, err = createRegionalDisk(ctx, gceCS.CloudProvider, project, name, zones, params, capacityRange, capBytes, snapshotID, volumeContentSourceVolumeID, multiWriter)
 		if err != nil {
-			return nil, status.Error(codes.Internal, fmt.Sprintf("CreateVolume failed to create regional disk %#v: %v", name, err))
+			return nil, status.Error(gce.CodeForError(err), fmt.Sprintf("CreateVolume failed to create regional disk %#v: %v", name, err))
 		}
 	default:
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("CreateVolume replication type '%s' is not supported", params.ReplicationType))
@@ -234,8 +329,164 @@ func (gceCS *GCEControllerServer) CreateVolume(ctx context.Context, req *csi.Cre
 	}
 
 	klog.V(4).Infof("CreateVolume succeeded for disk %v", volKey)
-	return generateCreateVolumeResponse(disk, zones), nil
+	return generateCreateVolumeResponse(disk, zones, params), nil
+
+}
+
+// getProject returns the project that disks with the given parameters are
+// created in.
+func (gceCS *GCEControllerServer) getProject(params common.DiskParameters) (string, error) {
+	project := gceCS.CloudProvider.GetDefaultProject()
+	if params.Project == "" || params.Project == project {
+		return project, nil
+	}
+	if !gceCS.allowedProjects.Has(params.Project) {
+		return "", fmt.Errorf("project %q is not allowed, allowed projects are %v", params.Project, gceCS.allowedProjects.List())
+	}
+	return params.Project, nil
+}
+
+// getSourceVolumeKey returns the project and fully specified key of the volume
+// identified by sourceVolumeID.
+func (gceCS *GCEControllerServer) getSourceVolumeKey(ctx context.Context, sourceVolumeID string) (string, *meta.Key, error) {
+	project, sourceVolKey, err := common.VolumeIDToKey(sourceVolumeID)
+	if err != nil {
+		return "", nil, status.Errorf(codes.InvalidArgument, "CreateVolume source volume ID is invalid: %v", err)
+	}
+
+	project, sourceVolKey, err = gceCS.CloudProvider.RepairUnderspecifiedVolumeKey(ctx, project, sourceVolKey)
+	if err != nil {
+		if gce.IsGCENotFoundError(err) {
+			return "", nil, status.Errorf(codes.NotFound, "CreateVolume source volume %s does not exist", sourceVolumeID)
+		}
+		return "", nil, status.Errorf(codes.Internal, "CreateVolume error repairing underspecified source volume key: %v", err)
+	}
+	return project, sourceVolKey, nil
+}
+
+// validateSourceVolume checks that the volume identified by sourceVolumeID,
+// with the given project and key, exists and can be cloned into a new disk
+// with the given key, zones and size.
+func (gceCS *GCEControllerServer) validateSourceVolume(ctx context.Context, sourceVolumeID, project string, sourceVolKey *meta.Key, params common.DiskParameters, volKey *meta.Key, zones []string, capBytes int64) error {
+	sourceDisk, err := gceCS.CloudProvider.GetDisk(ctx, project, sourceVolKey, gce.GCEAPIVersionV1)
+	if err != nil {
+		if gce.IsGCENotFoundError(err) {
+			return status.Errorf(codes.NotFound, "CreateVolume source volume %s does not exist", sourceVolumeID)
+		}
+		return status.Errorf(codes.Internal, "CreateVolume unknown get source disk error: %v", err)
+	}
+
+	switch params.ReplicationType {
+	case replicationTypeNone:
+		// A zonal disk can only be cloned from a zonal disk in the same zone.
+		if sourceVolKey.Type() != meta.Zonal || sourceVolKey.Zone != volKey.Zone {
+			return status.Errorf(codes.InvalidArgument, "CreateVolume source volume %s must be a zonal disk in zone %s", sourceVolumeID, volKey.Zone)
+		}
+	case replicationTypeRegionalPD:
+		// A regional disk can be cloned from a disk in the same region. If the
+		// source is zonal, it must be in one of the replica zones.
+		switch sourceVolKey.Type() {
+		case meta.Zonal:
+			if !sets.NewString(zones...).Has(sourceVolKey.Zone) {
+				return status.Errorf(codes.InvalidArgument, "CreateVolume source volume %s must be in one of the replica zones %v", sourceVolumeID, zones)
+			}
+		case meta.Regional:
+			if sourceVolKey.Region != volKey.Region {
+				return status.Errorf(codes.InvalidArgument, "CreateVolume source volume %s must be in region %s", sourceVolumeID, volKey.Region)
+			}
+		}
+	}
+
+	if sourceSizeBytes := common.GbToBytes(sourceDisk.GetSizeGb()); capBytes < sourceSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "CreateVolume requested capacity %v is less than the size %v of source volume %s", capBytes, sourceSizeBytes, sourceVolumeID)
+	}
+	return nil
+}
+
+// validateSourceSnapshot checks that the snapshot or image snapshot identified
+// by snapshotID exists, is ready to use and can be restored into a new disk in
+// the given project with the given parameters and size. Snapshots can be
+// restored into any region, whatever their storage location. If the snapshot
+// is protected by a customer-supplied encryption key, the key is returned.
+func (gceCS *GCEControllerServer) validateSourceSnapshot(ctx context.Context, snapshotID, project string, params common.DiskParameters, capBytes int64) (*common.CustomerEncryptionKey, error) {
+	snapshotProject, snapshotType, key, err := common.SnapshotIDToProjectKey(snapshotID)
+	if err != nil {
+		// No snapshot can have an ID in the wrong format
+		return nil, status.Errorf(codes.NotFound, "CreateVolume source snapshot %s does not exist: %v", snapshotID, err)
+	}
+	// Unless restricted, any snapshot that the driver's service account can
+	// read may be restored.
+	if gceCS.restrictSnapshotProjects && snapshotProject != project && snapshotProject != gceCS.CloudProvider.GetDefaultProject() && !gceCS.allowedProjects.Has(snapshotProject) {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume source snapshot %s is in project %q, which is not allowed, allowed projects are %v", snapshotID, snapshotProject, gceCS.allowedProjects.List())
+	}
+
+	var (
+		snapshotStatus string
+		sizeGb         int64
+		encryptionKey  *compute.CustomerEncryptionKey
+	)
+	switch snapshotType {
+	case common.DiskSnapshotType:
+		snapshot, err := gceCS.CloudProvider.GetSnapshot(ctx, snapshotProject, key)
+		if err != nil {
+			if gce.IsGCENotFoundError(err) {
+				return nil, status.Errorf(codes.NotFound, "CreateVolume source snapshot %s does not exist", snapshotID)
+			}
+			return nil, status.Errorf(codes.Internal, "CreateVolume unknown get source snapshot error: %v", err)
+		}
+		snapshotStatus, sizeGb, encryptionKey = snapshot.Status, snapshot.DiskSizeGb, snapshot.SnapshotEncryptionKey
+	case common.DiskImageType:
+		// Disks are restored from image snapshots as from source images.
+		image, err := gceCS.CloudProvider.GetImage(ctx, snapshotProject, key)
+		if err != nil {
+			if gce.IsGCENotFoundError(err) {
+				return nil, status.Errorf(codes.NotFound, "CreateVolume source snapshot %s does not exist", snapshotID)
+			}
+			return nil, status.Errorf(codes.Internal, "CreateVolume unknown get source image error: %v", err)
+		}
+		snapshotStatus, sizeGb, encryptionKey = image.Status, image.DiskSizeGb, image.ImageEncryptionKey
+	}
+
+	if ready, _ := isCSISnapshotReady(snapshotStatus); !ready {
+		return nil, status.Errorf(codes.FailedPrecondition, "CreateVolume source snapshot %s is not ready to use, its status is %s", snapshotID, snapshotStatus)
+	}
+	if sizeBytes := common.GbToBytes(sizeGb); capBytes < sizeBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume requested capacity %v is less than the size %v of source snapshot %s", capBytes, sizeBytes, snapshotID)
+	}
+	if encryptionKey != nil && encryptionKey.KmsKeyName != "" && !gce.KMSKeyEqual(encryptionKey.KmsKeyName, params.DiskEncryptionKMSKey) {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolume source snapshot %s is encrypted with KMS key %s, which does not match the %s parameter %q", snapshotID, encryptionKey.KmsKeyName, common.ParameterKeyDiskEncryptionKmsKey, params.DiskEncryptionKMSKey)
+	}
+	// The disk is protected by the same customer-supplied key as its source
+	var sourceKey *common.CustomerEncryptionKey
+	if encryptionKey != nil && encryptionKey.Sha256 != "" {
+		if !gce.CustomerEncryptionKeyMatches(encryptionKey.Sha256, params.DiskEncryptionKey) {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolume source snapshot %s is protected by a customer-supplied encryption key, which the secrets must contain", snapshotID)
+		}
+		sourceKey = params.DiskEncryptionKey
+	}
+	return sourceKey, nil
+}
+
+// validateSourceImage checks that the image a disk is created from exists and
+// fits in the requested capacity.
+func (gceCS *GCEControllerServer) validateSourceImage(ctx context.Context, sourceImage string, capBytes int64) error {
+	project, imageName, err := common.ImageIDToProjectName(sourceImage)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "CreateVolume source image is invalid: %v", err)
+	}
 
+	image, err := gceCS.CloudProvider.GetImage(ctx, project, imageName)
+	if err != nil {
+		if gce.IsGCENotFoundError(err) {
+			return status.Errorf(codes.NotFound, "CreateVolume source image %s does not exist", sourceImage)
+		}
+		return status.Errorf(codes.Internal, "CreateVolume unknown get source image error: %v", err)
+	}
+
+	if imageSizeBytes := common.GbToBytes(image.DiskSizeGb); capBytes < imageSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "CreateVolume requested capacity %v is less than the size %v of source image %s", capBytes, imageSizeBytes, sourceImage)
+	}
+	return nil
 }
 
 func (gceCS *GCEControllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
@@ -322,24 +573,25 @@ func (gceCS *GCEControllerServer) ControllerPublishVolume(ctx context.Context, r
 		PublishContext: nil,
 	}
 
-	_, err = gceCS.CloudProvider.GetDisk(ctx, project, volKey, gce.GCEAPIVersionV1)
+	encryptionKey, err := common.ExtractCustomerEncryptionKey(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerPublishVolume %v", err)
+	}
+
+	disk, err := gceCS.CloudProvider.GetDisk(ctx, project, volKey, gce.GCEAPIVersionV1)
 	if err != nil {
 		if gce.IsGCENotFoundError(err) {
 			return nil, status.Error(codes.NotFound, fmt.Sprintf("Could not find disk %v: %v", volKey.String(), err))
 		}
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown get disk error: %v", err))
 	}
+	if !gce.CustomerEncryptionKeyMatches(disk.GetEncryptionKeySha256(), encryptionKey) {
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerPublishVolume disk %v customer-supplied encryption key does not match the secrets", volKey)
+	}
 	instanceZone, instanceName, err := common.NodeIDToZoneAndName(nodeID)
 	if err != nil {
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("could not split nodeID: %v", err))
 	}
-	instance, err := gceCS.CloudProvider.GetInstanceOrError(ctx, instanceZone, instanceName)
-	if err != nil {
-		if gce.IsGCENotFoundError(err) {
-			return nil, status.Error(codes.NotFound, fmt.Sprintf("Could not find instance %v: %v", nodeID, err))
-		}
-		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown get instance error: %v", err))
-	}
 
 	readWrite := "READ_WRITE"
 	if readOnly {
@@ -351,31 +603,77 @@ func (gceCS *GCEControllerServer) ControllerPublishVolume(ctx context.Context, r
 		return nil, status.Error(codes.Internal, fmt.Sprintf("error getting device name: %v", err))
 	}
 
-	attached, err := diskIsAttachedAndCompatible(deviceName, instance, volumeCapability, readWrite)
+	otherZoneUsers, err := forceAttachUsers(req.GetVolumeContext(), volKey, disk, instanceZone)
 	if err != nil {
-		return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("Disk %v already published to node %v but incompatbile: %v", volKey.Name, nodeID, err))
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerPublishVolume %v", err)
+	}
+	forceAttach := len(otherZoneUsers) > 0
+	if forceAttach {
+		klog.Warningf("ForceAttach: regional disk %v is attached to %v in another zone, force-attaching it to instance %s in zone %s detaches it from them", volKey, otherZoneUsers, instanceName, instanceZone)
+	}
+	err = gceCS.attachBatcher.Attach(ctx, instanceZone, instanceName, gce.AttachRequest{
+		Project:       project,
+		VolKey:        volKey,
+		DeviceName:    deviceName,
+		ReadWrite:     readWrite,
+		DiskType:      attachableDiskTypePersistent,
+		EncryptionKey: encryptionKey,
+		ForceAttach:   forceAttach,
+		IsAttached: func(instance *compute.Instance) (bool, error) {
+			return diskIsAttachedAndCompatible(deviceName, instance, volumeCapability, readWrite)
+		},
+	})
+	if err != nil {
+		var incompatibleErr *gce.IncompatibleAttachmentError
+		switch {
+		case errors.As(err, &incompatibleErr):
+			return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("Disk %v already published to node %v but incompatbile: %v", volKey.Name, nodeID, err))
+		case gce.IsGCENotFoundError(err):
+			return nil, status.Error(codes.NotFound, fmt.Sprintf("Could not find instance %v: %v", nodeID, err))
+		default:
+			return nil, status.Error(gce.CodeForError(err), fmt.Sprintf("unknown Attach error: %v", err))
+		}
 	}
-	if attached {
-		// Volume is attached to node. Success!
-		klog.V(4).Infof("ControllerPublishVolume succeeded for disk %v to instance %v, already attached.", volKey, nodeID)
-		return pubVolResp, nil
+
+	klog.V(4).Infof("ControllerPublishVolume succeeded for disk %v to instance %v", volKey, nodeID)
+	return pubVolResp, nil
+}
+
+// forceAttachUsers returns the instances that a regional disk must be
+// force-attached away from to attach it to an instance in instanceZone. These
+// are its users in other zones if the volume context enables force-attaching,
+// as a user in the zone of the instance is not in a zone that is down.
+func forceAttachUsers(volumeContext map[string]string, volKey *meta.Key, disk *gce.CloudDisk, instanceZone string) ([]string, error) {
+	value, ok := volumeContext[common.VolumeAttributeForceAttach]
+	if !ok || volKey.Type() != meta.Regional {
+		return nil, nil
 	}
-	instanceZone, instanceName, err = common.NodeIDToZoneAndName(nodeID)
+	forceAttach, err := strconv.ParseBool(value)
 	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("could not split nodeID: %v", err))
+		return nil, fmt.Errorf("volume context contains invalid %s %q, must be a boolean", common.VolumeAttributeForceAttach, value)
 	}
-	err = gceCS.CloudProvider.AttachDisk(ctx, project, volKey, readWrite, attachableDiskTypePersistent, instanceZone, instanceName)
-	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("unknown Attach error: %v", err))
+	if !forceAttach {
+		return nil, nil
 	}
-
-	err = gceCS.CloudProvider.WaitForAttach(ctx, project, volKey, instanceZone, instanceName)
-	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("unknown WaitForAttach error: %v", err))
+	var users []string
+	for _, user := range disk.GetUsers() {
+		if zone := instanceZoneFromURI(user); zone != "" && zone != instanceZone {
+			users = append(users, user)
+		}
 	}
+	return users, nil
+}
 
-	klog.V(4).Infof("ControllerPublishVolume succeeded for disk %v to instance %v", volKey, nodeID)
-	return pubVolResp, nil
+// instanceZoneFromURI returns the zone of an instance URL, or "" if it has
+// none.
+func instanceZoneFromURI(uri string) string {
+	parts := strings.Split(uri, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "zones" {
+			return parts[i+1]
+		}
+	}
+	return ""
 }
 
 func (gceCS *GCEControllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
@@ -414,31 +712,25 @@ func (gceCS *GCEControllerServer) ControllerUnpublishVolume(ctx context.Context,
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("could not split nodeID: %v", err))
 	}
-	instance, err := gceCS.CloudProvider.GetInstanceOrError(ctx, instanceZone, instanceName)
-	if err != nil {
-		if gce.IsGCENotFoundError(err) {
-			// Node not existing on GCE means that disk has been detached
-			klog.Warningf("Treating volume %v as unpublished because node %v could not be found", volKey.String(), instanceName)
-			return &csi.ControllerUnpublishVolumeResponse{}, nil
-		}
-		return nil, status.Error(codes.Internal, fmt.Sprintf("error getting instance: %v", err))
-	}
-
 	deviceName, err := common.GetDeviceName(volKey)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("error getting device name: %v", err))
 	}
 
-	attached := diskIsAttached(deviceName, instance)
-
-	if !attached {
-		// Volume is not attached to node. Success!
-		klog.V(4).Infof("ControllerUnpublishVolume succeeded for disk %v from node %v. Already not attached.", volKey, nodeID)
-		return &csi.ControllerUnpublishVolumeResponse{}, nil
-	}
-
-	err = gceCS.CloudProvider.DetachDisk(ctx, project, deviceName, instanceZone, instanceName)
+	err = gceCS.attachBatcher.Detach(ctx, instanceZone, instanceName, gce.AttachRequest{
+		Project:    project,
+		VolKey:     volKey,
+		DeviceName: deviceName,
+		IsAttached: func(instance *compute.Instance) (bool, error) {
+			return diskIsAttached(deviceName, instance), nil
+		},
+	})
 	if err != nil {
+		if gce.IsGCENotFoundError(err) {
+			// Node not existing on GCE means that disk has been detached
+			klog.Warningf("Treating volume %v as unpublished because node %v could not be found", volKey.String(), instanceName)
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
 		return nil, status.Error(codes.Internal, fmt.Sprintf("unknown detach error: %v", err))
 	}
 
@@ -472,7 +764,12 @@ func (gceCS *GCEControllerServer) ValidateVolumeCapabilities(ctx context.Context
 	}
 	defer gceCS.volumeLocks.Release(volumeID)
 
-	disk, err := gceCS.CloudProvider.GetDisk(ctx, project, volKey, gce.GCEAPIVersionV1)
+	params, err := common.ExtractAndDefaultParameters(req.GetParameters(), gceCS.Driver.name, gceCS.Driver.extraVolumeLabels)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to extract parameters: %v", err)
+	}
+
+	disk, err := gceCS.CloudProvider.GetDisk(ctx, project, volKey, gce.GetGCEAPIVersion(params, false /* multiWriter */))
 	if err != nil {
 		if gce.IsGCENotFoundError(err) {
 			return nil, status.Error(codes.NotFound, fmt.Sprintf("Could not find disk %v: %v", volKey.Name, err))
@@ -480,9 +777,9 @@ func (gceCS *GCEControllerServer) ValidateVolumeCapabilities(ctx context.Context
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown get disk error: %v", err))
 	}
 
-	// Check Volume Context is Empty
-	if len(req.GetVolumeContext()) != 0 {
-		return generateFailedValidationMessage("VolumeContext expected to be empty but got %v", req.GetVolumeContext()), nil
+	// Check Volume Context only has known attributes
+	if err := common.ValidateVolumeAttributes(req.GetVolumeContext()); err != nil {
+		return generateFailedValidationMessage("VolumeContext not valid: %v", err), nil
 	}
 
 	// Check volume capabilities supported by PD. These are the same for any PD
@@ -491,10 +788,6 @@ func (gceCS *GCEControllerServer) ValidateVolumeCapabilities(ctx context.Context
 	}
 
 	// Validate the disk parameters match the disk we GET
-	params, err := common.ExtractAndDefaultParameters(req.GetParameters(), gceCS.Driver.name, gceCS.Driver.extraVolumeLabels)
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "failed to extract parameters: %v", err)
-	}
 	if err := gce.ValidateDiskParameters(disk, params); err != nil {
 		return generateFailedValidationMessage("Parameters %v do not match given disk %s: %v", req.GetParameters(), disk.GetName(), err), nil
 	}
@@ -538,20 +831,23 @@ func (gceCS *GCEControllerServer) ListVolumes(ctx context.Context, req *csi.List
 		}
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list disk error: %v", err))
 	}
+	checker := newDiskConditionChecker(gceCS.CloudProvider)
 	entries := []*csi.ListVolumesResponse_Entry{}
 	for _, d := range diskList {
 		users := []string{}
 		for _, u := range d.Users {
 			users = append(users, cleanSelfLink(u))
 		}
-		entries = append(entries, &csi.ListVolumesResponse_Entry{
+		entry := &csi.ListVolumesResponse_Entry{
 			Volume: &csi.Volume{
 				VolumeId: cleanSelfLink(d.SelfLink),
 			},
 			Status: &csi.ListVolumesResponse_VolumeStatus{
 				PublishedNodeIds: users,
+				VolumeCondition:  checker.condition(ctx, d.Name, d.Status, users, d.Region != "", d.ReplicaZones),
 			},
-		})
+		}
+		entries = append(entries, entry)
 	}
 
 	return &csi.ListVolumesResponse{
@@ -560,10 +856,170 @@ func (gceCS *GCEControllerServer) ListVolumes(ctx context.Context, req *csi.List
 	}, nil
 }
 
+func (gceCS *GCEControllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ControllerGetVolume Volume ID must be provided")
+	}
+	project, volKey, err := common.VolumeIDToKey(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "ControllerGetVolume Volume ID is invalid: %v", err)
+	}
+	project, volKey, err = gceCS.CloudProvider.RepairUnderspecifiedVolumeKey(ctx, project, volKey)
+	if err != nil {
+		if gce.IsGCENotFoundError(err) {
+			return nil, status.Errorf(codes.NotFound, "ControllerGetVolume could not find volume with ID %v: %v", volumeID, err)
+		}
+		return nil, status.Errorf(codes.Internal, "ControllerGetVolume error repairing underspecified volume key: %v", err)
+	}
+	disk, err := gceCS.CloudProvider.GetDisk(ctx, project, volKey, gce.GCEAPIVersionV1)
+	if err != nil {
+		if gce.IsGCENotFoundError(err) {
+			return nil, status.Errorf(codes.NotFound, "ControllerGetVolume could not find volume with ID %v: %v", volumeID, err)
+		}
+		return nil, status.Errorf(codes.Internal, "ControllerGetVolume error getting disk: %v", err)
+	}
+
+	users := []string{}
+	for _, u := range disk.GetUsers() {
+		users = append(users, cleanSelfLink(u))
+	}
+	checker := newDiskConditionChecker(gceCS.CloudProvider)
+	return &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: common.GbToBytes(disk.GetSizeGb()),
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: users,
+			VolumeCondition:  checker.condition(ctx, disk.GetName(), disk.GetStatus(), users, disk.GetRegion() != "", disk.GetReplicaZones()),
+		},
+	}, nil
+}
+
+// diskConditionChecker reports the conditions of disks. The instances and
+// zones that it looks up are cached, as the disks of a ListVolumes page
+// share them.
+type diskConditionChecker struct {
+	cloudProvider  gce.GCECompute
+	instanceExists map[string]bool
+	zoneStatuses   map[string]string
+}
+
+func newDiskConditionChecker(cloudProvider gce.GCECompute) *diskConditionChecker {
+	return &diskConditionChecker{
+		cloudProvider:  cloudProvider,
+		instanceExists: map[string]bool{},
+		zoneStatuses:   map[string]string{},
+	}
+}
+
+// condition returns the condition of a disk with the given status and users,
+// which are node IDs. A disk is abnormal when it failed, when an instance it
+// is attached to no longer exists, or when it is regional and is not
+// replicated in two zones that are up. Instances and zones that cannot be
+// looked up are assumed to be fine, as the condition is informational.
+func (c *diskConditionChecker) condition(ctx context.Context, diskName, diskStatus string, users []string, regional bool, replicaZones []string) *csi.VolumeCondition {
+	if diskStatus == "FAILED" {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Disk %s failed", diskName)}
+	}
+	for _, nodeID := range users {
+		if !c.nodeExists(ctx, nodeID) {
+			return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Disk %s is attached to instance %s, which no longer exists", diskName, nodeID)}
+		}
+	}
+	if regional {
+		if len(replicaZones) < 2 {
+			return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Regional disk %s is replicated in %d zones instead of 2", diskName, len(replicaZones))}
+		}
+		for _, replicaZone := range replicaZones {
+			zone := path.Base(replicaZone)
+			if zoneStatus := c.zoneStatus(ctx, zone); zoneStatus != "UP" {
+				return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Replica of regional disk %s in zone %s is degraded, the zone is %s", diskName, zone, zoneStatus)}
+			}
+		}
+	}
+	return &csi.VolumeCondition{Message: "Volume is healthy"}
+}
+
+func (c *diskConditionChecker) nodeExists(ctx context.Context, nodeID string) bool {
+	if exists, ok := c.instanceExists[nodeID]; ok {
+		return exists
+	}
+	exists := true
+	instanceZone, instanceName, err := common.NodeIDToZoneAndName(nodeID)
+	if err == nil {
+		_, err = c.cloudProvider.GetInstanceOrError(ctx, instanceZone, instanceName)
+		exists = !gce.IsGCENotFoundError(err)
+	}
+	if err != nil && exists {
+		klog.Warningf("Failed to check that instance %s exists: %v", nodeID, err)
+	}
+	c.instanceExists[nodeID] = exists
+	return exists
+}
+
+func (c *diskConditionChecker) zoneStatus(ctx context.Context, zone string) string {
+	if zoneStatus, ok := c.zoneStatuses[zone]; ok {
+		return zoneStatus
+	}
+	zoneStatus, err := c.cloudProvider.GetZoneStatus(ctx, zone)
+	if err != nil {
+		klog.Warningf("Failed to get status of zone %s: %v", zone, err)
+		zoneStatus = "UP"
+	}
+	c.zoneStatuses[zone] = zoneStatus
+	return zoneStatus
+}
+
+// GetCapacity reports the remaining regional disk quota for the PD type given
+// in the parameters, in the region of the requested zone.
 func (gceCS *GCEControllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
-	// https://cloud.google.com/compute/quotas
-	// DISKS_TOTAL_GB.
-	return nil, status.Error(codes.Unimplemented, "")
+	// GetCapacity has no PVC or PV to expand label templates from, and does
+	// not use the labels.
+	params, err := common.ExtractAndDefaultParametersWithoutTemplates(req.GetParameters(), gceCS.Driver.name, gceCS.Driver.extraVolumeLabels)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to extract parameters: %v", err)
+	}
+	metric, ok := diskTypeQuotaMetrics[params.DiskType]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "GetCapacity disk type %q has no known quota metric", params.DiskType)
+	}
+
+	zone := gceCS.CloudProvider.GetDefaultZone()
+	if top := req.GetAccessibleTopology(); top != nil {
+		zone, err = getZoneFromSegment(top.GetSegments())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "GetCapacity accessible topology is invalid: %v", err)
+		}
+	}
+	region, err := common.GetRegionFromZones([]string{zone})
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "GetCapacity failed to get region from zone: %v", err)
+	}
+
+	project, err := gceCS.getProject(params)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "GetCapacity %v", err)
+	}
+	quotas, err := gceCS.CloudProvider.GetRegionQuotas(ctx, project, region)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "GetCapacity failed to get quotas for region %s: %v", region, err)
+	}
+	for _, q := range quotas {
+		if q.Metric != metric {
+			continue
+		}
+		availableGb := int64(q.Limit - q.Usage)
+		if availableGb < 0 {
+			availableGb = 0
+		}
+		klog.V(4).Infof("GetCapacity found %v Gb of %s available in region %s", availableGb, metric, region)
+		return &csi.GetCapacityResponse{
+			AvailableCapacity: common.GbToBytes(availableGb),
+		}, nil
+	}
+	return nil, status.Errorf(codes.Internal, "GetCapacity could not find quota %s in region %s", metric, region)
 }
 
 // ControllerGetCapabilities implements the default GRPC callout.
@@ -574,6 +1030,12 @@ func (gceCS *GCEControllerServer) ControllerGetCapabilities(ctx context.Context,
 }
 
 func (gceCS *GCEControllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	return gceCS.createSnapshot(ctx, req, nil)
+}
+
+// createSnapshot creates the snapshot requested by req, with extraLabels in
+// addition to the labels in its parameters.
+func (gceCS *GCEControllerServer) createSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest, extraLabels map[string]string) (*csi.CreateSnapshotResponse, error) {
 	// Validate arguments
 	volumeID := req.GetSourceVolumeId()
 	if len(req.Name) == 0 {
@@ -593,7 +1055,7 @@ func (gceCS *GCEControllerServer) CreateSnapshot(ctx context.Context, req *csi.C
 	defer gceCS.volumeLocks.Release(volumeID)
 
 	// Check if volume exists
-	_, err = gceCS.CloudProvider.GetDisk(ctx, project, volKey, gce.GCEAPIVersionV1)
+	disk, err := gceCS.CloudProvider.GetDisk(ctx, project, volKey, gce.GCEAPIVersionV1)
 	if err != nil {
 		if gce.IsGCENotFoundError(err) {
 			return nil, status.Error(codes.NotFound, fmt.Sprintf("CreateSnapshot could not find disk %v: %v", volKey.String(), err))
@@ -601,32 +1063,96 @@ func (gceCS *GCEControllerServer) CreateSnapshot(ctx context.Context, req *csi.C
 		return nil, status.Error(codes.Internal, fmt.Sprintf("CreateSnapshot unknown get disk error: %v", err))
 	}
 
-	// Check if snapshot already exists
-	var snapshot *compute.Snapshot
-	snapshot, err = gceCS.CloudProvider.GetSnapshot(ctx, project, req.Name)
+	snapshotParams, err := common.ExtractAndDefaultSnapshotParameters(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid snapshot parameters: %v", err))
+	}
+	for k, v := range extraLabels {
+		snapshotParams.Labels[k] = v
+	}
+	// Snapshots of a disk protected by a customer-supplied encryption key
+	// are protected by the same key
+	snapshotParams.DiskEncryptionKey, err = common.ExtractCustomerEncryptionKey(req.GetSecrets())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot %v", err)
+	}
+	if !gce.CustomerEncryptionKeyMatches(disk.GetEncryptionKeySha256(), snapshotParams.DiskEncryptionKey) {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateSnapshot disk %v customer-supplied encryption key does not match the secrets", volKey)
+	}
+	if snapshotParams.GuestFlush && volKey.Type() != meta.Zonal {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid snapshot parameters: guest flush is only supported for zonal disks, got %v", volKey)
+	}
+
+	var snapshot *csi.Snapshot
+	switch snapshotParams.SnapshotType {
+	case common.DiskSnapshotType:
+		snapshot, err = gceCS.createPDSnapshot(ctx, project, volumeID, volKey, req.Name, snapshotParams)
+	case common.DiskImageType:
+		snapshot, err = gceCS.createImage(ctx, project, volumeID, volKey, req.Name, snapshotParams)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid snapshot type: %s", snapshotParams.SnapshotType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	klog.V(4).Infof("CreateSnapshot succeeded for snapshot %s on volume %s", snapshot.SnapshotId, volumeID)
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}, nil
+}
+
+// createPDSnapshot creates a standard snapshot of the disk, or returns the
+// snapshot with the same name if it was already created from the disk.
+func (gceCS *GCEControllerServer) createPDSnapshot(ctx context.Context, project, volumeID string, volKey *meta.Key, snapshotName string, snapshotParams common.SnapshotParameters) (*csi.Snapshot, error) {
+	snapshot, err := gceCS.CloudProvider.GetSnapshot(ctx, project, snapshotName)
 	if err != nil {
 		if !gce.IsGCEError(err, "notFound") {
 			return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown get snapshot error: %v", err))
 		}
 		// If we could not find the snapshot, we create a new one
-		snapshotParams, err := common.ExtractAndDefaultSnapshotParameters(req.GetParameters())
+		snapshot, err = gceCS.CloudProvider.CreateSnapshot(ctx, project, volKey, snapshotName, snapshotParams)
 		if err != nil {
-			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid snapshot parameters: %v", err))
+			if gce.IsGCEError(err, "notFound") {
+				return nil, status.Error(codes.NotFound, fmt.Sprintf("Could not find volume with ID %v: %v", volKey.String(), err))
+			}
+			return nil, status.Error(gce.CodeForError(err), fmt.Sprintf("Unknown create snapshot error: %v", err))
 		}
-		snapshot, err = gceCS.CloudProvider.CreateSnapshot(ctx, project, volKey, req.Name, snapshotParams)
+	}
+
+	err = validateExistingSnapshot(snapshot.Name, snapshot.SourceDisk, volKey)
+	if err != nil {
+		return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("Error in creating snapshot: %v", err))
+	}
+	return generateCSISnapshot(snapshot.CreationTimestamp, snapshot.Status, snapshot.DiskSizeGb, snapshot.SelfLink, volumeID)
+}
+
+// createImage creates an image of the disk, or returns the image with the
+// same name if it was already created from the disk.
+func (gceCS *GCEControllerServer) createImage(ctx context.Context, project, volumeID string, volKey *meta.Key, imageName string, snapshotParams common.SnapshotParameters) (*csi.Snapshot, error) {
+	image, err := gceCS.CloudProvider.GetImage(ctx, project, imageName)
+	if err != nil {
+		if !gce.IsGCEError(err, "notFound") {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown get image error: %v", err))
+		}
+		// If we could not find the image, we create a new one
+		image, err = gceCS.CloudProvider.CreateImage(ctx, project, volKey, imageName, snapshotParams)
 		if err != nil {
 			if gce.IsGCEError(err, "notFound") {
 				return nil, status.Error(codes.NotFound, fmt.Sprintf("Could not find volume with ID %v: %v", volKey.String(), err))
 			}
-			return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown create snapshot error: %v", err))
+			return nil, status.Error(gce.CodeForError(err), fmt.Sprintf("Unknown create image error: %v", err))
 		}
 	}
 
-	err = gceCS.validateExistingSnapshot(snapshot, volKey)
+	err = validateExistingSnapshot(image.Name, image.SourceDisk, volKey)
 	if err != nil {
 		return nil, status.Error(codes.AlreadyExists, fmt.Sprintf("Error in creating snapshot: %v", err))
 	}
-	t, err := time.Parse(time.RFC3339, snapshot.CreationTimestamp)
+	return generateCSISnapshot(image.CreationTimestamp, image.Status, image.DiskSizeGb, image.SelfLink, volumeID)
+}
+
+// generateCSISnapshot returns the CSI snapshot for a newly created snapshot
+// or image with the given fields.
+func generateCSISnapshot(creationTimestamp, snapshotStatus string, diskSizeGb int64, selfLink, volumeID string) (*csi.Snapshot, error) {
+	t, err := time.Parse(time.RFC3339, creationTimestamp)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Failed to covert creation timestamp: %v", err))
 	}
@@ -636,42 +1162,38 @@ func (gceCS *GCEControllerServer) CreateSnapshot(ctx context.Context, req *csi.C
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Failed to covert creation timestamp: %v", err))
 	}
 
-	ready, err := isCSISnapshotReady(snapshot.Status)
+	ready, err := isCSISnapshotReady(snapshotStatus)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("Snapshot had error checking ready status: %v", err))
 	}
 
-	createResp := &csi.CreateSnapshotResponse{
-		Snapshot: &csi.Snapshot{
-			SizeBytes:      common.GbToBytes(snapshot.DiskSizeGb),
-			SnapshotId:     cleanSelfLink(snapshot.SelfLink),
-			SourceVolumeId: volumeID,
-			CreationTime:   tp,
-			ReadyToUse:     ready,
-		},
-	}
-	klog.V(4).Infof("CreateSnapshot succeeded for snapshot %s on volume %s", cleanSelfLink(snapshot.SelfLink), volumeID)
-	return createResp, nil
+	return &csi.Snapshot{
+		SizeBytes:      common.GbToBytes(diskSizeGb),
+		SnapshotId:     cleanSelfLink(selfLink),
+		SourceVolumeId: volumeID,
+		CreationTime:   tp,
+		ReadyToUse:     ready,
+	}, nil
 }
 
-func (gceCS *GCEControllerServer) validateExistingSnapshot(snapshot *compute.Snapshot, volKey *meta.Key) error {
-	if snapshot == nil {
-		return fmt.Errorf("disk does not exist")
-	}
-
-	_, sourceKey, err := common.VolumeIDToKey(cleanSelfLink(snapshot.SourceDisk))
+// validateExistingSnapshot checks that the snapshot or image with the given
+// name and source disk was created from the disk with the given key.
+func validateExistingSnapshot(name, sourceDisk string, volKey *meta.Key) error {
+	_, sourceKey, err := common.VolumeIDToKey(cleanSelfLink(sourceDisk))
 	if err != nil {
-		return fmt.Errorf("fail to get source disk key %s, %v", snapshot.SourceDisk, err)
+		return fmt.Errorf("fail to get source disk key %s, %v", sourceDisk, err)
 	}
 
 	if sourceKey.String() != volKey.String() {
 		return fmt.Errorf("snapshot already exists with same name but with a different disk source %s, expected disk source %s", sourceKey.String(), volKey.String())
 	}
 	// Snapshot exists with matching source disk.
-	klog.V(5).Infof("Compatible snapshot %s exists with source disk %s.", snapshot.Name, snapshot.SourceDisk)
+	klog.V(5).Infof("Compatible snapshot %s exists with source disk %s.", name, sourceDisk)
 	return nil
 }
 
+// isCSISnapshotReady returns whether a snapshot or image with the given status
+// is ready to use.
 func isCSISnapshotReady(status string) (bool, error) {
 	switch status {
 	case "READY":
@@ -693,7 +1215,7 @@ func (gceCS *GCEControllerServer) DeleteSnapshot(ctx context.Context, req *csi.D
 		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot Snapshot ID must be provided")
 	}
 
-	project, key, err := common.SnapshotIDToProjectKey(snapshotID)
+	project, snapshotType, key, err := common.SnapshotIDToProjectKey(snapshotID)
 	if err != nil {
 		// Cannot get snapshot ID from the passing request
 		// This is a success according to the spec
@@ -701,9 +1223,17 @@ func (gceCS *GCEControllerServer) DeleteSnapshot(ctx context.Context, req *csi.D
 		return &csi.DeleteSnapshotResponse{}, nil
 	}
 
-	err = gceCS.CloudProvider.DeleteSnapshot(ctx, project, key)
-	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("unknown Delete snapshot error: %v", err))
+	switch snapshotType {
+	case common.DiskSnapshotType:
+		err = gceCS.CloudProvider.DeleteSnapshot(ctx, project, key)
+		if err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("unknown Delete snapshot error: %v", err))
+		}
+	case common.DiskImageType:
+		err = gceCS.CloudProvider.DeleteImage(ctx, project, key)
+		if err != nil {
+			return nil, status.Error(codes.Internal, fmt.Sprintf("unknown Delete image error: %v", err))
+		}
 	}
 
 	return &csi.DeleteSnapshotResponse{}, nil
@@ -755,29 +1285,53 @@ func (gceCS *GCEControllerServer) ControllerExpandVolume(ctx context.Context, re
 	}, nil
 }
 
+// getSnapshots lists the snapshots and then the images. Page tokens for
+// images are prefixed with imagesPageTokenPrefix.
 func (gceCS *GCEControllerServer) getSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
-	var snapshots []*compute.Snapshot
-	var nextToken string
-	var err error
+	filter := ""
 	if len(req.GetSourceVolumeId()) != 0 {
-		snapshots, nextToken, err = gceCS.CloudProvider.ListSnapshots(ctx, fmt.Sprintf("sourceDisk eq .*%s$", req.SourceVolumeId), int64(req.MaxEntries), req.StartingToken)
-	} else {
-		snapshots, nextToken, err = gceCS.CloudProvider.ListSnapshots(ctx, "", int64(req.MaxEntries), req.StartingToken)
-	}
-	if err != nil {
-		if gce.IsGCEError(err, "invalid") {
-			return nil, status.Error(codes.Aborted, fmt.Sprintf("Invalid error: %v", err))
-		}
-		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list snapshot error: %v", err))
+		filter = fmt.Sprintf("sourceDisk eq .*%s$", req.SourceVolumeId)
 	}
 	entries := []*csi.ListSnapshotsResponse_Entry{}
-
-	for _, snapshot := range snapshots {
-		entry, err := generateSnapshotEntry(snapshot)
+	var nextToken string
+	if imagesPageToken := strings.TrimPrefix(req.StartingToken, imagesPageTokenPrefix); imagesPageToken != req.StartingToken {
+		images, nextImagesPageToken, err := gceCS.CloudProvider.ListImages(ctx, filter, int64(req.MaxEntries), imagesPageToken)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate snapshot entry: %v", err)
+			if gce.IsGCEError(err, "invalid") {
+				return nil, status.Error(codes.Aborted, fmt.Sprintf("Invalid error: %v", err))
+			}
+			return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list image error: %v", err))
+		}
+		for _, image := range images {
+			// Only images of disks are snapshots.
+			if image.SourceDisk == "" {
+				continue
+			}
+			entries = append(entries, generateImageEntry(image))
+		}
+		if nextImagesPageToken != "" {
+			nextToken = imagesPageTokenPrefix + nextImagesPageToken
+		}
+	} else {
+		snapshots, nextSnapshotsPageToken, err := gceCS.CloudProvider.ListSnapshots(ctx, filter, int64(req.MaxEntries), req.StartingToken)
+		if err != nil {
+			if gce.IsGCEError(err, "invalid") {
+				return nil, status.Error(codes.Aborted, fmt.Sprintf("Invalid error: %v", err))
+			}
+			return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list snapshot error: %v", err))
+		}
+		for _, snapshot := range snapshots {
+			entry, err := generateSnapshotEntry(snapshot)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate snapshot entry: %v", err)
+			}
+			entries = append(entries, entry)
+		}
+		nextToken = nextSnapshotsPageToken
+		if nextToken == "" {
+			// The images follow the snapshots.
+			nextToken = imagesPageTokenPrefix
 		}
-		entries = append(entries, entry)
 	}
 	listSnapshotResp := &csi.ListSnapshotsResponse{
 		Entries:   entries,
@@ -787,24 +1341,38 @@ func (gceCS *GCEControllerServer) getSnapshots(ctx context.Context, req *csi.Lis
 }
 
 func (gceCS *GCEControllerServer) getSnapshotByID(ctx context.Context, snapshotID string) (*csi.ListSnapshotsResponse, error) {
-	project, key, err := common.SnapshotIDToProjectKey(snapshotID)
+	project, snapshotType, key, err := common.SnapshotIDToProjectKey(snapshotID)
 	if err != nil {
 		// Cannot get snapshot ID from the passing request
 		klog.Warningf("invalid snapshot id format %s", snapshotID)
 		return &csi.ListSnapshotsResponse{}, nil
 	}
 
-	snapshot, err := gceCS.CloudProvider.GetSnapshot(ctx, project, key)
-	if err != nil {
-		if gce.IsGCEError(err, "notFound") {
-			// return empty list if no snapshot is found
-			return &csi.ListSnapshotsResponse{}, nil
+	var e *csi.ListSnapshotsResponse_Entry
+	switch snapshotType {
+	case common.DiskSnapshotType:
+		snapshot, err := gceCS.CloudProvider.GetSnapshot(ctx, project, key)
+		if err != nil {
+			if gce.IsGCEError(err, "notFound") {
+				// return empty list if no snapshot is found
+				return &csi.ListSnapshotsResponse{}, nil
+			}
+			return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list snapshot error: %v", err))
 		}
-		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list snapshot error: %v", err))
-	}
-	e, err := generateSnapshotEntry(snapshot)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate snapshot entry: %v", err)
+		e, err = generateSnapshotEntry(snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate snapshot entry: %v", err)
+		}
+	case common.DiskImageType:
+		image, err := gceCS.CloudProvider.GetImage(ctx, project, key)
+		if err != nil {
+			if gce.IsGCEError(err, "notFound") {
+				// return empty list if no image is found
+				return &csi.ListSnapshotsResponse{}, nil
+			}
+			return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown list image error: %v", err))
+		}
+		e = generateImageEntry(image)
 	}
 
 	entries := []*csi.ListSnapshotsResponse_Entry{e}
@@ -830,14 +1398,34 @@ func generateSnapshotEntry(snapshot *compute.Snapshot) (*csi.ListSnapshotsRespon
 
 	entry := &csi.ListSnapshotsResponse_Entry{
 		Snapshot: &csi.Snapshot{
-			SizeBytes:      common.GbToBytes(snapshot.DiskSizeGb),
-			SnapshotId:     cleanSelfLink(snapshot.SelfLink),
-			SourceVolumeId: cleanSelfLink(snapshot.SourceDisk),
+			SizeBytes:       common.GbToBytes(snapshot.DiskSizeGb),
+			SnapshotId:      cleanSelfLink(snapshot.SelfLink),
+			SourceVolumeId:  cleanSelfLink(snapshot.SourceDisk),
+			CreationTime:    tp,
+			ReadyToUse:      ready,
+			GroupSnapshotId: groupSnapshotIDOfMember(snapshot),
+		},
+	}
+	return entry, nil
+}
+
+func generateImageEntry(image *compute.Image) *csi.ListSnapshotsResponse_Entry {
+	t, _ := time.Parse(time.RFC3339, image.CreationTimestamp)
+
+	// We ignore the errors intentionally here since we are just listing
+	// snapshots, as generateSnapshotEntry does.
+	tp, _ := ptypes.TimestampProto(t)
+	ready, _ := isCSISnapshotReady(image.Status)
+
+	return &csi.ListSnapshotsResponse_Entry{
+		Snapshot: &csi.Snapshot{
+			SizeBytes:      common.GbToBytes(image.DiskSizeGb),
+			SnapshotId:     cleanSelfLink(image.SelfLink),
+			SourceVolumeId: cleanSelfLink(image.SourceDisk),
 			CreationTime:   tp,
 			ReadyToUse:     ready,
 		},
 	}
-	return entry, nil
 }
 
 func getRequestCapacity(capRange *csi.CapacityRange) (int64, error) {
@@ -897,7 +1485,9 @@ func diskIsAttachedAndCompatible(deviceName string, instance *compute.Instance,
 	return false, nil
 }
 
-func pickZonesFromTopology(top *csi.TopologyRequirement, numZones int) ([]string, error) {
+// pickZonesFromTopology picks numZones zones allowed by top for a disk in the
+// project. sourceZone, if set and allowed, is picked first.
+func pickZonesFromTopology(ctx context.Context, project string, top *csi.TopologyRequirement, numZones int, selector zoneSelector, sourceZone string) ([]string, error) {
 	reqZones, err := getZonesFromTopology(top.GetRequisite())
 	if err != nil {
 		return nil, fmt.Errorf("could not get zones from requisite topology: %v", err)
@@ -907,29 +1497,23 @@ func pickZonesFromTopology(top *csi.TopologyRequirement, numZones int) ([]string
 		return nil, fmt.Errorf("could not get zones from preferred topology: %v", err)
 	}
 
-	if numZones <= len(prefZones) {
-		return prefZones[0:numZones], nil
-	} else {
-		zones := sets.String{}
-		// Add all preferred zones into zones
-		zones.Insert(prefZones...)
-		remainingNumZones := numZones - len(prefZones)
-		// Take all of the remaining zones from requisite zones
-		reqSet := sets.NewString(reqZones...)
-		prefSet := sets.NewString(prefZones...)
-		remainingZones := reqSet.Difference(prefSet)
-
-		if remainingZones.Len() < remainingNumZones {
-			return nil, fmt.Errorf("need %v zones from topology, only got %v unique zones", numZones, reqSet.Union(prefSet).Len())
-		}
-		// Add the remaining number of zones into the set
-		nSlice, err := pickRandAndConsecutive(remainingZones.List(), remainingNumZones)
-		if err != nil {
-			return nil, err
+	reqSet := sets.NewString(reqZones...)
+	prefSet := sets.NewString(prefZones...)
+	if sourceZone != "" && reqSet.Union(prefSet).Has(sourceZone) {
+		zones := []string{sourceZone}
+		for _, z := range prefZones {
+			if z != sourceZone {
+				zones = append(zones, z)
+			}
 		}
-		zones.Insert(nSlice...)
-		return zones.List(), nil
+		prefZones = zones
+		prefSet.Insert(sourceZone)
+	}
+	if reqSet.Union(prefSet).Len() < numZones {
+		return nil, fmt.Errorf("need %v zones from topology, only got %v unique zones", numZones, reqSet.Union(prefSet).Len())
 	}
+	// Take any zones beyond the preferred ones from the requisite zones
+	return selector.selectZones(ctx, project, prefZones, reqSet.Difference(prefSet).List(), numZones)
 }
 
 func getZonesFromTopology(topList []*csi.Topology) ([]string, error) {
@@ -965,16 +1549,24 @@ func getZoneFromSegment(seg map[string]string) (string, error) {
 	return zone, nil
 }
 
-func pickZones(ctx context.Context, gceCS *GCEControllerServer, top *csi.TopologyRequirement, numZones int) ([]string, error) {
+func pickZones(ctx context.Context, gceCS *GCEControllerServer, project string, top *csi.TopologyRequirement, numZones int, zoneSelectionStrategy, sourceZone string) ([]string, error) {
 	var zones []string
 	var err error
+	selector, ok := gceCS.zoneSelectors[zoneSelectionStrategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown zone selection strategy %q", zoneSelectionStrategy)
+	}
 	if top != nil {
-		zones, err = pickZonesFromTopology(top, numZones)
+		zones, err = pickZonesFromTopology(ctx, project, top, numZones, selector, sourceZone)
 		if err != nil {
 			return nil, fmt.Errorf("failed to pick zones from topology: %v", err)
 		}
 	} else {
-		zones, err = getDefaultZonesInRegion(ctx, gceCS, []string{gceCS.CloudProvider.GetDefaultZone()}, numZones)
+		defaultZone := gceCS.CloudProvider.GetDefaultZone()
+		if sourceZone != "" {
+			defaultZone = sourceZone
+		}
+		zones, err = getDefaultZonesInRegion(ctx, gceCS, project, []string{defaultZone}, numZones, zoneSelectionStrategy)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get default %v zones in region: %v", numZones, err)
 		}
@@ -984,7 +1576,7 @@ func pickZones(ctx context.Context, gceCS *GCEControllerServer, top *csi.Topolog
 	return zones, nil
 }
 
-func getDefaultZonesInRegion(ctx context.Context, gceCS *GCEControllerServer, existingZones []string, numZones int) ([]string, error) {
+func getDefaultZonesInRegion(ctx context.Context, gceCS *GCEControllerServer, project string, existingZones []string, numZones int, zoneSelectionStrategy string) ([]string, error) {
 	region, err := common.GetRegionFromZones(existingZones)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get region from zones: %v", err)
@@ -999,15 +1591,24 @@ func getDefaultZonesInRegion(ctx context.Context, gceCS *GCEControllerServer, ex
 	if len(l) < needToGet {
 		return nil, fmt.Errorf("not enough remaining zones in %v to get %v zones out", l, needToGet)
 	}
-	// add l and zones
-	ret := append(existingZones, l[0:needToGet]...)
+	var ret []string
+	if zoneSelectionStrategy == common.ZoneSelectionStrategyRandom {
+		// The default zones are not random: they are the first remaining
+		// zones in the region.
+		ret = append(existingZones, l[0:needToGet]...)
+	} else {
+		ret, err = gceCS.zoneSelectors[zoneSelectionStrategy].selectZones(ctx, project, existingZones, l, numZones)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if len(ret) != numZones {
 		return nil, fmt.Errorf("did some math wrong, need %v zones, but got %v", numZones, ret)
 	}
 	return ret, nil
 }
 
-func generateCreateVolumeResponse(disk *gce.CloudDisk, zones []string) *csi.CreateVolumeResponse {
+func generateCreateVolumeResponse(disk *gce.CloudDisk, zones []string, params common.DiskParameters) *csi.CreateVolumeResponse {
 	tops := []*csi.Topology{}
 	for _, zone := range zones {
 		tops = append(tops, &csi.Topology{
@@ -1023,6 +1624,21 @@ func generateCreateVolumeResponse(disk *gce.CloudDisk, zones []string) *csi.Crea
 			AccessibleTopology: tops,
 		},
 	}
+	if params.ForceAttach || params.MkfsOptions != "" || params.FsckOnStage != "" || params.NodeEncryption != "" {
+		createResp.Volume.VolumeContext = map[string]string{}
+	}
+	if params.ForceAttach {
+		createResp.Volume.VolumeContext[common.VolumeAttributeForceAttach] = "true"
+	}
+	if params.MkfsOptions != "" {
+		createResp.Volume.VolumeContext[common.VolumeAttributeMkfsOptions] = params.MkfsOptions
+	}
+	if params.FsckOnStage != "" {
+		createResp.Volume.VolumeContext[common.VolumeAttributeFsckOnStage] = params.FsckOnStage
+	}
+	if params.NodeEncryption != "" {
+		createResp.Volume.VolumeContext[common.VolumeAttributeNodeEncryption] = params.NodeEncryption
+	}
 	snapshotID := disk.GetSnapshotId()
 	if snapshotID != "" {
 		source := &csi.VolumeContentSource{
@@ -1034,6 +1650,29 @@ func generateCreateVolumeResponse(disk *gce.CloudDisk, zones []string) *csi.Crea
 		}
 		createResp.Volume.ContentSource = source
 	}
+	// A source image that was not requested by the StorageClass is an image
+	// snapshot that the disk was restored from.
+	if sourceImage := cleanSelfLink(disk.GetSourceImage()); sourceImage != "" && params.SourceImage == "" {
+		source := &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{
+					SnapshotId: sourceImage,
+				},
+			},
+		}
+		createResp.Volume.ContentSource = source
+	}
+	diskFromSourceVolume := cleanSelfLink(disk.GetSourceDisk())
+	if diskFromSourceVolume != "" {
+		source := &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: diskFromSourceVolume,
+				},
+			},
+		}
+		createResp.Volume.ContentSource = source
+	}
 	return createResp
 }
 
@@ -1043,8 +1682,7 @@ func cleanSelfLink(selfLink string) string {
 	return strings.TrimPrefix(temp, gce.GCEComputeAlphaAPIEndpoint)
 }
 
-func createRegionalDisk(ctx context.Context, cloudProvider gce.GCECompute, name string, zones []string, params common.DiskParameters, capacityRange *csi.CapacityRange, capBytes int64, snapshotID string, multiWriter bool) (*gce.CloudDisk, error) {
-	project := cloudProvider.GetDefaultProject()
+func createRegionalDisk(ctx context.Context, cloudProvider gce.GCECompute, project, name string, zones []string, params common.DiskParameters, capacityRange *csi.CapacityRange, capBytes int64, snapshotID string, volumeContentSourceVolumeID string, multiWriter bool) (*gce.CloudDisk, error) {
 	region, err := common.GetRegionFromZones(zones)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get region from zones: %v", err)
@@ -1056,16 +1694,12 @@ func createRegionalDisk(ctx context.Context, cloudProvider gce.GCECompute, name
 			fullyQualifiedReplicaZones, cloudProvider.GetReplicaZoneURI(project, replicaZone))
 	}
 
-	err = cloudProvider.InsertDisk(ctx, project, meta.RegionalKey(name, region), params, capBytes, capacityRange, fullyQualifiedReplicaZones, snapshotID, multiWriter)
+	err = cloudProvider.InsertDisk(ctx, project, meta.RegionalKey(name, region), params, capBytes, capacityRange, fullyQualifiedReplicaZones, snapshotID, volumeContentSourceVolumeID, multiWriter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert regional disk: %v", err)
-	}
-
-	gceAPIVersion := gce.GCEAPIVersionV1
-	if multiWriter {
-		gceAPIVersion = gce.GCEAPIVersionBeta
+		return nil, fmt.Errorf("failed to insert regional disk: %w", err)
 	}
 
+	gceAPIVersion := gce.GetGCEAPIVersion(params, multiWriter)
 	disk, err := cloudProvider.GetDisk(ctx, project, meta.RegionalKey(name, region), gceAPIVersion)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get disk after creating regional disk: %v", err)
@@ -1073,21 +1707,17 @@ func createRegionalDisk(ctx context.Context, cloudProvider gce.GCECompute, name
 	return disk, nil
 }
 
-func createSingleZoneDisk(ctx context.Context, cloudProvider gce.GCECompute, name string, zones []string, params common.DiskParameters, capacityRange *csi.CapacityRange, capBytes int64, snapshotID string, multiWriter bool) (*gce.CloudDisk, error) {
-	project := cloudProvider.GetDefaultProject()
+func createSingleZoneDisk(ctx context.Context, cloudProvider gce.GCECompute, project, name string, zones []string, params common.DiskParameters, capacityRange *csi.CapacityRange, capBytes int64, snapshotID string, volumeContentSourceVolumeID string, multiWriter bool) (*gce.CloudDisk, error) {
 	if len(zones) != 1 {
 		return nil, fmt.Errorf("got wrong number of zones for zonal create volume: %v", len(zones))
 	}
 	diskZone := zones[0]
-	err := cloudProvider.InsertDisk(ctx, project, meta.ZonalKey(name, diskZone), params, capBytes, capacityRange, nil, snapshotID, multiWriter)
+	err := cloudProvider.InsertDisk(ctx, project, meta.ZonalKey(name, diskZone), params, capBytes, capacityRange, nil, snapshotID, volumeContentSourceVolumeID, multiWriter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert zonal disk: %v", err)
+		return nil, fmt.Errorf("failed to insert zonal disk: %w", err)
 	}
 
-	gceAPIVersion := gce.GCEAPIVersionV1
-	if multiWriter {
-		gceAPIVersion = gce.GCEAPIVersionBeta
-	}
+	gceAPIVersion := gce.GetGCEAPIVersion(params, multiWriter)
 	disk, err := cloudProvider.GetDisk(ctx, project, meta.ZonalKey(name, diskZone), gceAPIVersion)
 	if err != nil {
 		return nil, err
@@ -15,10 +15,13 @@ limitations under the License.
 package gceGCEDriver
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"math/rand"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -97,6 +100,36 @@ func TestCreateSnapshotArguments(t *testing.T) {
 				ReadyToUse:     false,
 			},
 		},
+		{
+			name: "success guest flush snapshot of zonal disk",
+			req: &csi.CreateSnapshotRequest{
+				Name:           name,
+				SourceVolumeId: testVolumeID,
+				Parameters:     map[string]string{common.ParameterKeyGuestFlush: "true"},
+			},
+			seedDisks: []*gce.CloudDisk{
+				createZonalCloudDisk(name),
+			},
+			expSnapshot: &csi.Snapshot{
+				SnapshotId:     testSnapshotID,
+				SourceVolumeId: testVolumeID,
+				CreationTime:   tp,
+				SizeBytes:      common.GbToBytes(gce.DiskSizeGb),
+				ReadyToUse:     false,
+			},
+		},
+		{
+			name: "fail guest flush snapshot of regional disk",
+			req: &csi.CreateSnapshotRequest{
+				Name:           name,
+				SourceVolumeId: testRegionalID,
+				Parameters:     map[string]string{common.ParameterKeyGuestFlush: "true"},
+			},
+			seedDisks: []*gce.CloudDisk{
+				createZonalCloudDisk(name),
+			},
+			expErrCode: codes.InvalidArgument,
+		},
 		{
 			name: "fail no name",
 			req: &csi.CreateSnapshotRequest{
@@ -190,6 +223,40 @@ func TestCreateSnapshotArguments(t *testing.T) {
 		}
 	}
 }
+func TestCreateSnapshotReadyToUse(t *testing.T) {
+	gceDriver := initGCEDriver(t, []*gce.CloudDisk{createZonalCloudDisk(name)})
+	req := &csi.CreateSnapshotRequest{
+		Name:           name,
+		SourceVolumeId: testVolumeID,
+	}
+
+	// CreateSnapshot returns as soon as the snapshot exists, before it has
+	// been uploaded.
+	resp, err := gceDriver.cs.CreateSnapshot(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CreateSnapshot did not expect error, but got %v", err)
+	}
+	if resp.GetSnapshot().GetReadyToUse() {
+		t.Errorf("Expected new snapshot not to be ready to use")
+	}
+
+	// Later calls report the snapshot as ready once the upload is done.
+	resp, err = gceDriver.cs.CreateSnapshot(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CreateSnapshot did not expect error, but got %v", err)
+	}
+	if !resp.GetSnapshot().GetReadyToUse() {
+		t.Errorf("Expected uploaded snapshot to be ready to use")
+	}
+	listResp, err := gceDriver.cs.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: testSnapshotID})
+	if err != nil {
+		t.Fatalf("ListSnapshots did not expect error, but got %v", err)
+	}
+	if len(listResp.GetEntries()) != 1 || !listResp.GetEntries()[0].GetSnapshot().GetReadyToUse() {
+		t.Errorf("Expected one ready snapshot, got %v", listResp.GetEntries())
+	}
+}
+
 func TestDeleteSnapshot(t *testing.T) {
 	testCases := []struct {
 		name       string
@@ -594,6 +661,147 @@ func TestCreateVolumeArguments(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "success with force attach with repd",
+			req: &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters: map[string]string{
+					common.ParameterKeyReplicationType: replicationTypeRegionalPD,
+					common.ParameterKeyForceAttach:     "true",
+				},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Preferred: []*csi.Topology{
+						{
+							Segments: map[string]string{common.TopologyKeyZone: region + "-c"},
+						},
+						{
+							Segments: map[string]string{common.TopologyKeyZone: region + "-b"},
+						},
+					},
+				},
+			},
+			expVol: &csi.Volume{
+				CapacityBytes: common.GbToBytes(20),
+				VolumeId:      testRegionalID,
+				VolumeContext: map[string]string{common.VolumeAttributeForceAttach: "true"},
+				AccessibleTopology: []*csi.Topology{
+					{
+						Segments: map[string]string{common.TopologyKeyZone: region + "-c"},
+					},
+					{
+						Segments: map[string]string{common.TopologyKeyZone: region + "-b"},
+					},
+				},
+			},
+		},
+		{
+			name: "fail force attach without repd",
+			req: &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{common.ParameterKeyForceAttach: "true"},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "success with mkfs options",
+			req: &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{common.ParameterKeyMkfsOptions: "-i 65536 -m 1"},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Preferred: stdTopology,
+				},
+			},
+			expVol: &csi.Volume{
+				CapacityBytes:      common.GbToBytes(20),
+				VolumeId:           testVolumeID,
+				VolumeContext:      map[string]string{common.VolumeAttributeMkfsOptions: "-i 65536 -m 1"},
+				AccessibleTopology: stdTopology,
+			},
+		},
+		{
+			name: "fail mkfs options of another filesystem type",
+			req: &csi.CreateVolumeRequest{
+				Name:          name,
+				CapacityRange: stdCapRange,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"},
+						},
+						AccessMode: stdVolCap.GetAccessMode(),
+					},
+				},
+				Parameters: map[string]string{common.ParameterKeyMkfsOptions: "-O ^has_journal"},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "success with fsck on stage",
+			req: &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{common.ParameterKeyFsckOnStage: "Repair"},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Preferred: stdTopology,
+				},
+			},
+			expVol: &csi.Volume{
+				CapacityBytes:      common.GbToBytes(20),
+				VolumeId:           testVolumeID,
+				VolumeContext:      map[string]string{common.VolumeAttributeFsckOnStage: common.FsckOnStageRepair},
+				AccessibleTopology: stdTopology,
+			},
+		},
+		{
+			name: "fail invalid fsck on stage policy",
+			req: &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{common.ParameterKeyFsckOnStage: "always"},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "success with node encryption",
+			req: &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{common.ParameterKeyNodeEncryption: "luks"},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Preferred: stdTopology,
+				},
+			},
+			expVol: &csi.Volume{
+				CapacityBytes:      common.GbToBytes(20),
+				VolumeId:           testVolumeID,
+				VolumeContext:      map[string]string{common.VolumeAttributeNodeEncryption: common.NodeEncryptionLUKS},
+				AccessibleTopology: stdTopology,
+			},
+		},
+		{
+			name: "fail node encryption of block volume",
+			req: &csi.CreateVolumeRequest{
+				Name:          name,
+				CapacityRange: stdCapRange,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+						AccessMode: stdVolCap.GetAccessMode(),
+					},
+				},
+				Parameters: map[string]string{common.ParameterKeyNodeEncryption: "luks"},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
 		{
 			name: "fail not enough topology with repd",
 			req: &csi.CreateVolumeRequest{
@@ -714,6 +922,31 @@ func TestCreateVolumeArguments(t *testing.T) {
 				AccessibleTopology: stdTopology,
 			},
 		},
+		{
+			name: "success with provisioned iops parameter",
+			req: &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{"type": "pd-extreme", "provisioned-iops-on-create": "10000"},
+			},
+			expVol: &csi.Volume{
+				CapacityBytes:      common.GbToBytes(20),
+				VolumeId:           testVolumeID,
+				VolumeContext:      nil,
+				AccessibleTopology: stdTopology,
+			},
+		},
+		{
+			name: "fail with malformed provisioned iops parameter",
+			req: &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{"type": "pd-extreme", "provisioned-iops-on-create": "-1"},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
 		{
 			name: "fail with malformed labels parameter",
 			req: &csi.CreateVolumeRequest{
@@ -770,6 +1003,121 @@ func TestCreateVolumeArguments(t *testing.T) {
 	}
 }
 
+func TestCreateVolumeProvisionedIopsIdempotency(t *testing.T) {
+	testCases := []struct {
+		name          string
+		existingIops  string
+		requestedIops string
+		expErrCode    codes.Code
+	}{
+		{
+			name:          "success with same provisioned iops",
+			existingIops:  "10000",
+			requestedIops: "10000",
+		},
+		{
+			name:          "fail with different provisioned iops",
+			existingIops:  "10000",
+			requestedIops: "20000",
+			expErrCode:    codes.AlreadyExists,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gceDriver := initGCEDriver(t, nil)
+			req := &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{common.ParameterKeyType: "pd-extreme", common.ParameterKeyProvisionedIOPSOnCreate: tc.existingIops},
+			}
+			if _, err := gceDriver.cs.CreateVolume(context.Background(), req); err != nil {
+				t.Fatalf("Failed to create initial volume: %v", err)
+			}
+
+			req.Parameters[common.ParameterKeyProvisionedIOPSOnCreate] = tc.requestedIops
+			_, err := gceDriver.cs.CreateVolume(context.Background(), req)
+			if err != nil {
+				serverError, ok := status.FromError(err)
+				if !ok {
+					t.Fatalf("Could not get error status code from err: %v", serverError)
+				}
+				if serverError.Code() != tc.expErrCode {
+					t.Fatalf("Expected error code: %v, got: %v. err : %v", tc.expErrCode, serverError.Code(), err)
+				}
+				return
+			}
+			if tc.expErrCode != codes.OK {
+				t.Fatalf("Expected error: %v, got no error", tc.expErrCode)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeResourcePoliciesIdempotency(t *testing.T) {
+	testCases := []struct {
+		name              string
+		existingPolicies  string
+		requestedPolicies string
+		expErrCode        codes.Code
+	}{
+		{
+			name:              "success with same resource policies",
+			existingPolicies:  "daily-backup,weekly-backup",
+			requestedPolicies: "weekly-backup, daily-backup",
+		},
+		{
+			name:              "fail with missing resource policy",
+			existingPolicies:  "daily-backup",
+			requestedPolicies: "daily-backup,weekly-backup",
+			expErrCode:        codes.AlreadyExists,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gceDriver := initGCEDriver(t, nil)
+			req := &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Preferred: stdTopology,
+				},
+				Parameters: map[string]string{common.ParameterKeyResourcePolicies: tc.existingPolicies},
+			}
+			if _, err := gceDriver.cs.CreateVolume(context.Background(), req); err != nil {
+				t.Fatalf("Failed to create initial volume: %v", err)
+			}
+			disk, err := gceDriver.cs.CloudProvider.GetDisk(context.Background(), project, meta.ZonalKey(name, zone), gce.GCEAPIVersionV1)
+			if err != nil {
+				t.Fatalf("Failed to get disk: %v", err)
+			}
+			expPolicy := fmt.Sprintf("%s/regions/%s/resourcePolicies/daily-backup", project, region)
+			if policies := disk.GetResourcePolicies(); len(policies) == 0 || policies[0] != expPolicy {
+				t.Fatalf("Expected disk resource policies to start with %s, got %v", expPolicy, policies)
+			}
+
+			req.Parameters[common.ParameterKeyResourcePolicies] = tc.requestedPolicies
+			_, err = gceDriver.cs.CreateVolume(context.Background(), req)
+			if err != nil {
+				serverError, ok := status.FromError(err)
+				if !ok {
+					t.Fatalf("Could not get error status code from err: %v", serverError)
+				}
+				if serverError.Code() != tc.expErrCode {
+					t.Fatalf("Expected error code: %v, got: %v. err : %v", tc.expErrCode, serverError.Code(), err)
+				}
+				return
+			}
+			if tc.expErrCode != codes.OK {
+				t.Fatalf("Expected error: %v, got no error", tc.expErrCode)
+			}
+		})
+	}
+}
+
 func TestListVolumeArgs(t *testing.T) {
 	testCases := []struct {
 		name            string
@@ -904,17 +1252,313 @@ func TestCreateVolumeWithVolumeSource(t *testing.T) {
 	}
 }
 
-func TestCreateVolumeRandomRequisiteTopology(t *testing.T) {
-	req := &csi.CreateVolumeRequest{
-		Name:               "test-name",
-		CapacityRange:      stdCapRange,
-		VolumeCapabilities: stdVolCaps,
-		Parameters:         map[string]string{"type": "test-type"},
-		AccessibilityRequirements: &csi.TopologyRequirement{
-			Requisite: []*csi.Topology{
-				{
-					Segments: map[string]string{common.TopologyKeyZone: "topology-zone3"},
-				},
+func TestCreateVolumeWithVolumeSourceFromVolume(t *testing.T) {
+	// Define test cases
+	testCases := []struct {
+		name             string
+		replicationType  string
+		sourceVolumeID   string
+		requestCapacity  int64
+		sourceCapacityGb int64
+		seedSourceDisk   bool
+		expErrCode       codes.Code
+	}{
+		{
+			name:             "success with data source of volume type",
+			replicationType:  replicationTypeNone,
+			sourceVolumeID:   common.CreateZonalVolumeID(project, zone, "source-disk"),
+			requestCapacity:  common.GbToBytes(20),
+			sourceCapacityGb: 20,
+			seedSourceDisk:   true,
+		},
+		{
+			name:             "success with regional disk cloned from zonal disk in a replica zone",
+			replicationType:  replicationTypeRegionalPD,
+			sourceVolumeID:   common.CreateZonalVolumeID(project, zone, "source-disk"),
+			requestCapacity:  common.GbToBytes(20),
+			sourceCapacityGb: 20,
+			seedSourceDisk:   true,
+		},
+		{
+			name:             "fail with data source of volume type that doesn't exist",
+			replicationType:  replicationTypeNone,
+			sourceVolumeID:   common.CreateZonalVolumeID(project, zone, "source-disk"),
+			requestCapacity:  common.GbToBytes(20),
+			sourceCapacityGb: 20,
+			seedSourceDisk:   false,
+			expErrCode:       codes.NotFound,
+		},
+		{
+			name:             "fail with invalid source volume ID",
+			replicationType:  replicationTypeNone,
+			sourceVolumeID:   "/test/wrongname",
+			requestCapacity:  common.GbToBytes(20),
+			sourceCapacityGb: 20,
+			seedSourceDisk:   false,
+			expErrCode:       codes.InvalidArgument,
+		},
+		{
+			name:             "success with source volume in a less preferred zone",
+			replicationType:  replicationTypeNone,
+			sourceVolumeID:   common.CreateZonalVolumeID(project, secondZone, "source-disk"),
+			requestCapacity:  common.GbToBytes(20),
+			sourceCapacityGb: 20,
+			seedSourceDisk:   true,
+		},
+		{
+			name:             "success with regional disk cloned from zonal disk in a less preferred zone",
+			replicationType:  replicationTypeRegionalPD,
+			sourceVolumeID:   common.CreateZonalVolumeID(project, secondZone, "source-disk"),
+			requestCapacity:  common.GbToBytes(20),
+			sourceCapacityGb: 20,
+			seedSourceDisk:   true,
+		},
+		{
+			name:             "fail with source volume in a zone the topology does not allow",
+			replicationType:  replicationTypeNone,
+			sourceVolumeID:   common.CreateZonalVolumeID(project, "country-region-thirdzone", "source-disk"),
+			requestCapacity:  common.GbToBytes(20),
+			sourceCapacityGb: 20,
+			seedSourceDisk:   true,
+			expErrCode:       codes.InvalidArgument,
+		},
+		{
+			name:             "fail with source volume larger than requested capacity",
+			replicationType:  replicationTypeNone,
+			sourceVolumeID:   common.CreateZonalVolumeID(project, zone, "source-disk"),
+			requestCapacity:  common.GbToBytes(20),
+			sourceCapacityGb: 50,
+			seedSourceDisk:   true,
+			expErrCode:       codes.InvalidArgument,
+		},
+	}
+
+	// Run test cases
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		// Setup new driver each time so no interference
+		var seedDisks []*gce.CloudDisk
+		if tc.seedSourceDisk {
+			_, sourceVolKey, err := common.VolumeIDToKey(tc.sourceVolumeID)
+			if err != nil {
+				t.Fatalf("Failed to parse source volume ID %s: %v", tc.sourceVolumeID, err)
+			}
+			seedDisks = append(seedDisks, gce.CloudDiskFromV1(&compute.Disk{
+				Name:   sourceVolKey.Name,
+				Zone:   sourceVolKey.Zone,
+				SizeGb: tc.sourceCapacityGb,
+			}))
+		}
+		gceDriver := initGCEDriver(t, seedDisks)
+
+		// Start Test
+		req := &csi.CreateVolumeRequest{
+			Name: "test-name",
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: tc.requestCapacity,
+			},
+			VolumeCapabilities: stdVolCaps,
+			Parameters: map[string]string{
+				common.ParameterKeyReplicationType: tc.replicationType,
+			},
+			AccessibilityRequirements: &csi.TopologyRequirement{
+				Preferred: []*csi.Topology{
+					{
+						Segments: map[string]string{common.TopologyKeyZone: zone},
+					},
+					{
+						Segments: map[string]string{common.TopologyKeyZone: secondZone},
+					},
+				},
+			},
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{
+						VolumeId: tc.sourceVolumeID,
+					},
+				},
+			},
+		}
+
+		resp, err := gceDriver.cs.CreateVolume(context.Background(), req)
+		//check response
+		if err != nil {
+			serverError, ok := status.FromError(err)
+			if !ok {
+				t.Fatalf("Could not get error status code from err: %v", serverError)
+			}
+			if serverError.Code() != tc.expErrCode {
+				t.Fatalf("Expected error code: %v, got: %v. err : %v", tc.expErrCode, serverError.Code(), err)
+			}
+			continue
+		}
+		if tc.expErrCode != codes.OK {
+			t.Fatalf("Expected error: %v, got no error", tc.expErrCode)
+		}
+
+		// Make sure response has source volume
+		vol := resp.GetVolume()
+		if vol.ContentSource == nil || vol.ContentSource.Type == nil || vol.ContentSource.GetVolume() == nil || vol.ContentSource.GetVolume().VolumeId != tc.sourceVolumeID {
+			t.Fatalf("Expected volume content source to have volume ID %s, got %v", tc.sourceVolumeID, vol.ContentSource)
+		}
+
+		// Make sure the clone shares a zone with the source volume
+		_, sourceVolKey, _ := common.VolumeIDToKey(tc.sourceVolumeID)
+		if got := vol.GetAccessibleTopology()[0].GetSegments()[common.TopologyKeyZone]; got != sourceVolKey.Zone {
+			t.Fatalf("Expected volume to be in source volume zone %s first, got %v", sourceVolKey.Zone, vol.GetAccessibleTopology())
+		}
+	}
+}
+
+func TestCreateVolumeWithSourceImage(t *testing.T) {
+	sourceImage := "projects/image-project/global/images/golden-image"
+	// Define test cases
+	testCases := []struct {
+		name            string
+		sourceImage     string
+		requestCapacity int64
+		imageSizeGb     int64
+		seedImage       bool
+		contentSource   *csi.VolumeContentSource
+		expErrCode      codes.Code
+	}{
+		{
+			name:            "success with source image",
+			sourceImage:     sourceImage,
+			requestCapacity: common.GbToBytes(20),
+			imageSizeGb:     10,
+			seedImage:       true,
+		},
+		{
+			name:            "fail with source image that doesn't exist",
+			sourceImage:     sourceImage,
+			requestCapacity: common.GbToBytes(20),
+			imageSizeGb:     10,
+			seedImage:       false,
+			expErrCode:      codes.NotFound,
+		},
+		{
+			name:            "fail with source image name that only exists in other projects",
+			sourceImage:     "projects/third-project/global/images/golden-image",
+			requestCapacity: common.GbToBytes(20),
+			imageSizeGb:     10,
+			seedImage:       true,
+			expErrCode:      codes.NotFound,
+		},
+		{
+			name:            "fail with malformed source image",
+			sourceImage:     "golden-image",
+			requestCapacity: common.GbToBytes(20),
+			expErrCode:      codes.InvalidArgument,
+		},
+		{
+			name:            "fail with source image larger than requested capacity",
+			sourceImage:     sourceImage,
+			requestCapacity: common.GbToBytes(20),
+			imageSizeGb:     50,
+			seedImage:       true,
+			expErrCode:      codes.InvalidArgument,
+		},
+		{
+			name:            "fail with source image and volume content source",
+			sourceImage:     sourceImage,
+			requestCapacity: common.GbToBytes(20),
+			imageSizeGb:     10,
+			seedImage:       true,
+			contentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{
+						SnapshotId: testSnapshotID,
+					},
+				},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+	}
+
+	// Run test cases
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		// Setup new driver each time so no interference
+		fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+		if err != nil {
+			t.Fatalf("Failed to create fake cloud provider: %v", err)
+		}
+		if tc.seedImage {
+			fcp.InsertImage(&compute.Image{
+				Name:       "golden-image",
+				DiskSizeGb: tc.imageSizeGb,
+			}, "image-project", "golden-image")
+			// An image with the same name in another project must not be used
+			fcp.InsertImage(&compute.Image{
+				Name:       "golden-image",
+				DiskSizeGb: tc.imageSizeGb,
+			}, "other-project", "golden-image")
+		}
+		gceDriver := initGCEDriverWithCloudProvider(t, fcp)
+
+		// Start Test
+		req := &csi.CreateVolumeRequest{
+			Name: "test-name",
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: tc.requestCapacity,
+			},
+			VolumeCapabilities: stdVolCaps,
+			Parameters: map[string]string{
+				common.ParameterKeySourceImage: tc.sourceImage,
+			},
+			VolumeContentSource: tc.contentSource,
+		}
+
+		resp, err := gceDriver.cs.CreateVolume(context.Background(), req)
+		//check response
+		if err != nil {
+			serverError, ok := status.FromError(err)
+			if !ok {
+				t.Fatalf("Could not get error status code from err: %v", serverError)
+			}
+			if serverError.Code() != tc.expErrCode {
+				t.Fatalf("Expected error code: %v, got: %v. err : %v", tc.expErrCode, serverError.Code(), err)
+			}
+			continue
+		}
+		if tc.expErrCode != codes.OK {
+			t.Fatalf("Expected error: %v, got no error", tc.expErrCode)
+		}
+
+		// Validating capabilities without the StorageClass parameters must
+		// still confirm the volume
+		validateResp, err := gceDriver.cs.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+			VolumeId:           resp.GetVolume().GetVolumeId(),
+			VolumeCapabilities: stdVolCaps,
+		})
+		if err != nil {
+			t.Fatalf("ValidateVolumeCapabilities did not expect error, but got %v", err)
+		}
+		if validateResp.GetConfirmed() == nil {
+			t.Fatalf("Expected volume created from an image to be confirmed, got message %q", validateResp.GetMessage())
+		}
+
+		// Retrying with a different image must not reuse the existing disk
+		req.Parameters[common.ParameterKeySourceImage] = "projects/image-project/global/images/other-image"
+		_, err = gceDriver.cs.CreateVolume(context.Background(), req)
+		if serverError, ok := status.FromError(err); !ok || serverError.Code() != codes.AlreadyExists {
+			t.Fatalf("Expected error code: %v on retry with a different image, got: %v", codes.AlreadyExists, err)
+		}
+	}
+}
+
+func TestCreateVolumeRandomRequisiteTopology(t *testing.T) {
+	req := &csi.CreateVolumeRequest{
+		Name:               "test-name",
+		CapacityRange:      stdCapRange,
+		VolumeCapabilities: stdVolCaps,
+		Parameters:         map[string]string{"type": "test-type"},
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Requisite: []*csi.Topology{
+				{
+					Segments: map[string]string{common.TopologyKeyZone: "topology-zone3"},
+				},
 				{
 					Segments: map[string]string{common.TopologyKeyZone: "topology-zone1"},
 				},
@@ -1016,6 +1660,135 @@ func TestDeleteVolume(t *testing.T) {
 	}
 }
 
+func TestGetCapacity(t *testing.T) {
+	testCases := []struct {
+		name        string
+		req         *csi.GetCapacityRequest
+		extraLabels map[string]string
+		quotaMetric string
+		quotaLimit  float64
+		quotaUsage  float64
+		expCapacity int64
+		expErrCode  codes.Code
+	}{
+		{
+			name:        "success default disk type in default zone",
+			req:         &csi.GetCapacityRequest{},
+			quotaMetric: "DISKS_TOTAL_GB",
+			quotaLimit:  1000,
+			quotaUsage:  400,
+			expCapacity: common.GbToBytes(600),
+		},
+		{
+			name: "success ssd disk type in topology zone",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{common.ParameterKeyType: "pd-ssd"},
+				AccessibleTopology: &csi.Topology{
+					Segments: map[string]string{common.TopologyKeyZone: secondZone},
+				},
+			},
+			quotaMetric: "SSD_TOTAL_GB",
+			quotaLimit:  500,
+			quotaUsage:  100,
+			expCapacity: common.GbToBytes(400),
+		},
+		{
+			name: "success balanced disk type counts against ssd quota",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{common.ParameterKeyType: "pd-balanced"},
+			},
+			quotaMetric: "SSD_TOTAL_GB",
+			quotaLimit:  500,
+			quotaUsage:  500,
+			expCapacity: 0,
+		},
+		{
+			name: "success extreme disk type counts against ssd quota",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{common.ParameterKeyType: "pd-extreme", common.ParameterKeyProvisionedIOPSOnCreate: "10000"},
+			},
+			quotaMetric: "SSD_TOTAL_GB",
+			quotaLimit:  500,
+			quotaUsage:  100,
+			expCapacity: common.GbToBytes(400),
+		},
+		{
+			name: "success with label templates",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{common.ParameterKeyLabels: "claim=${pvc.name}"},
+			},
+			extraLabels: map[string]string{"volume": "${pv.name}"},
+			quotaMetric: "DISKS_TOTAL_GB",
+			quotaLimit:  1000,
+			quotaUsage:  400,
+			expCapacity: common.GbToBytes(600),
+		},
+		{
+			name:        "success usage over limit reports no capacity",
+			req:         &csi.GetCapacityRequest{},
+			quotaMetric: "DISKS_TOTAL_GB",
+			quotaLimit:  100,
+			quotaUsage:  200,
+			expCapacity: 0,
+		},
+		{
+			name: "fail unknown disk type",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{common.ParameterKeyType: "test-type"},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "fail unknown topology key",
+			req: &csi.GetCapacityRequest{
+				AccessibleTopology: &csi.Topology{
+					Segments: map[string]string{"unknown-key": zone},
+				},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "fail invalid parameters",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{"bad-key": "bad-value"},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+			if err != nil {
+				t.Fatalf("Failed to create fake cloud provider: %v", err)
+			}
+			if tc.quotaMetric != "" {
+				fcp.UpdateRegionQuota(region, tc.quotaMetric, tc.quotaLimit, tc.quotaUsage)
+			}
+			gceDriver := initGCEDriverWithCloudProvider(t, fcp)
+			gceDriver.extraVolumeLabels = tc.extraLabels
+
+			resp, err := gceDriver.cs.GetCapacity(context.Background(), tc.req)
+			if err != nil {
+				serverError, ok := status.FromError(err)
+				if !ok {
+					t.Fatalf("Could not get error status code from err: %v", serverError)
+				}
+				if serverError.Code() != tc.expErrCode {
+					t.Fatalf("Expected error code: %v, got: %v. err : %v", tc.expErrCode, serverError.Code(), err)
+				}
+				return
+			}
+			if tc.expErrCode != codes.OK {
+				t.Fatalf("Expected error: %v, got no error", tc.expErrCode)
+			}
+			if resp.GetAvailableCapacity() != tc.expCapacity {
+				t.Fatalf("Expected available capacity %v, got %v", tc.expCapacity, resp.GetAvailableCapacity())
+			}
+		})
+	}
+}
+
 func TestGetRequestCapacity(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -1336,11 +2109,12 @@ func TestGetZonesFromTopology(t *testing.T) {
 
 func TestPickZonesFromTopology(t *testing.T) {
 	testCases := []struct {
-		name     string
-		top      *csi.TopologyRequirement
-		numZones int
-		expZones []string
-		expErr   bool
+		name       string
+		top        *csi.TopologyRequirement
+		numZones   int
+		sourceZone string
+		expZones   []string
+		expErr     bool
 	}{
 		{
 			name: "success: preferred",
@@ -1453,10 +2227,52 @@ func TestPickZonesFromTopology(t *testing.T) {
 			numZones: 3,
 			expZones: []string{"topology-zone2", "topology-zone3", "topology-zone1"},
 		},
+		{
+			name: "success: source zone from requisite",
+			top: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{
+						Segments: map[string]string{common.TopologyKeyZone: "topology-zone1"},
+					},
+					{
+						Segments: map[string]string{common.TopologyKeyZone: "topology-zone2"},
+					},
+				},
+				Preferred: []*csi.Topology{
+					{
+						Segments: map[string]string{common.TopologyKeyZone: "topology-zone1"},
+					},
+				},
+			},
+			numZones:   1,
+			sourceZone: "topology-zone2",
+			expZones:   []string{"topology-zone2"},
+		},
+		{
+			name: "success: source zone not allowed",
+			top: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{
+						Segments: map[string]string{common.TopologyKeyZone: "topology-zone1"},
+					},
+					{
+						Segments: map[string]string{common.TopologyKeyZone: "topology-zone2"},
+					},
+				},
+				Preferred: []*csi.Topology{
+					{
+						Segments: map[string]string{common.TopologyKeyZone: "topology-zone1"},
+					},
+				},
+			},
+			numZones:   1,
+			sourceZone: "topology-zone3",
+			expZones:   []string{"topology-zone1"},
+		},
 	}
 	for _, tc := range testCases {
 		t.Logf("test case: %s", tc.name)
-		gotZones, err := pickZonesFromTopology(tc.top, tc.numZones)
+		gotZones, err := pickZonesFromTopology(context.Background(), project, tc.top, tc.numZones, &randomZoneSelector{}, tc.sourceZone)
 		if err != nil && !tc.expErr {
 			t.Errorf("Did not expect error but got: %v", err)
 		}
@@ -1720,3 +2536,972 @@ func TestCreateVolumeDiskReady(t *testing.T) {
 		})
 	}
 }
+
+func TestResumePendingOperation(t *testing.T) {
+	nodeID := common.CreateNodeID(project, zone, node)
+
+	// Define test cases
+	testCases := []struct {
+		name      string
+		seedDisks []*gce.CloudDisk
+		request   func(cs csi.ControllerServer) error
+	}{
+		{
+			name: "CreateVolume",
+			request: func(cs csi.ControllerServer) error {
+				_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+					Name:               name,
+					CapacityRange:      stdCapRange,
+					VolumeCapabilities: stdVolCaps,
+					Parameters:         stdParams,
+				})
+				return err
+			},
+		},
+		{
+			name:      "ControllerPublishVolume",
+			seedDisks: []*gce.CloudDisk{createZonalCloudDisk(name)},
+			request: func(cs csi.ControllerServer) error {
+				_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+					VolumeId:         testVolumeID,
+					NodeId:           nodeID,
+					VolumeCapability: stdVolCap,
+				})
+				return err
+			},
+		},
+		{
+			name:      "CreateSnapshot",
+			seedDisks: []*gce.CloudDisk{createZonalCloudDisk(name)},
+			request: func(cs csi.ControllerServer) error {
+				_, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+					Name:           name,
+					SourceVolumeId: testVolumeID,
+				})
+				return err
+			},
+		},
+	}
+
+	// Run test cases
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fcp, err := gce.CreateFakeCloudProvider(project, zone, tc.seedDisks)
+			if err != nil {
+				t.Fatalf("Failed to create fake cloud provider: %v", err)
+			}
+			fcp.InsertInstance(&compute.Instance{}, zone, node)
+			fcp.SetLongRunningOperations(true)
+			gceDriver := initGCEDriverWithCloudProvider(t, fcp)
+
+			// The first request times out and leaves its operation pending.
+			err = tc.request(gceDriver.cs)
+			if serverError, ok := status.FromError(err); !ok || serverError.Code() != codes.DeadlineExceeded {
+				t.Fatalf("Expected error code: %v, got: %v", codes.DeadlineExceeded, err)
+			}
+
+			// The retry resumes the pending operation rather than starting another.
+			if err := tc.request(gceDriver.cs); err != nil {
+				t.Fatalf("Expected retry to succeed, got: %v", err)
+			}
+			if issued := fcp.IssuedOperations(); issued != 1 {
+				t.Errorf("Expected exactly 1 operation to be issued, got %d", issued)
+			}
+
+			// Further retries find the completed operation's result.
+			if err := tc.request(gceDriver.cs); err != nil {
+				t.Fatalf("Expected second retry to succeed, got: %v", err)
+			}
+			if issued := fcp.IssuedOperations(); issued != 1 {
+				t.Errorf("Expected exactly 1 operation to be issued, got %d", issued)
+			}
+		})
+	}
+}
+
+func TestPendingDiskOperation(t *testing.T) {
+	createReq := &csi.CreateVolumeRequest{
+		Name:               name,
+		CapacityRange:      stdCapRange,
+		VolumeCapabilities: stdVolCaps,
+		Parameters:         stdParams,
+	}
+
+	fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	fcp.SetLongRunningOperations(true)
+	gceDriver := initGCEDriverWithCloudProvider(t, fcp)
+
+	_, err = gceDriver.cs.CreateVolume(context.Background(), createReq)
+	if serverError, ok := status.FromError(err); !ok || serverError.Code() != codes.DeadlineExceeded {
+		t.Fatalf("Expected error code: %v, got: %v", codes.DeadlineExceeded, err)
+	}
+
+	// A request with incompatible parameters must not resume the operation.
+	incompatibleReq := &csi.CreateVolumeRequest{
+		Name:               name,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: common.GbToBytes(100)},
+		VolumeCapabilities: stdVolCaps,
+		Parameters:         stdParams,
+	}
+	_, err = gceDriver.cs.CreateVolume(context.Background(), incompatibleReq)
+	if serverError, ok := status.FromError(err); !ok || serverError.Code() != codes.AlreadyExists {
+		t.Fatalf("Expected error code: %v, got: %v", codes.AlreadyExists, err)
+	}
+
+	// Deleting the disk forgets its pending operation, so creating it again
+	// issues a new one.
+	if _, err := gceDriver.cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: testVolumeID}); err != nil {
+		t.Fatalf("DeleteVolume did not expect error, but got %v", err)
+	}
+	if fcp.HasPendingDiskOperation(project, meta.ZonalKey(name, zone)) {
+		t.Fatalf("Expected DeleteVolume to clear the pending operation")
+	}
+	_, err = gceDriver.cs.CreateVolume(context.Background(), createReq)
+	if serverError, ok := status.FromError(err); !ok || serverError.Code() != codes.DeadlineExceeded {
+		t.Fatalf("Expected error code: %v, got: %v", codes.DeadlineExceeded, err)
+	}
+	if issued := fcp.IssuedOperations(); issued != 2 {
+		t.Errorf("Expected 2 operations to be issued, got %d", issued)
+	}
+}
+
+func TestCreateVolumeFromSnapshotCompatibility(t *testing.T) {
+	const (
+		allowedProject = "allowed-project"
+		kmsKey         = "projects/kms-project/locations/us-central1/keyRings/TestKeyRing/cryptoKeys/test-key"
+	)
+	testCases := []struct {
+		name             string
+		snapshotProject  string
+		snapshot         *compute.Snapshot
+		parameters       map[string]string
+		restrictProjects bool
+		expErrCode       codes.Code
+	}{
+		{
+			name: "success in storage location",
+			snapshot: &compute.Snapshot{
+				StorageLocations: []string{region},
+			},
+		},
+		{
+			name: "success outside storage location",
+			snapshot: &compute.Snapshot{
+				StorageLocations: []string{"other-region1"},
+			},
+		},
+		{
+			name:            "success from other project",
+			snapshotProject: "other-project",
+			snapshot:        &compute.Snapshot{},
+		},
+		{
+			name:             "success from allowed project with restricted projects",
+			snapshotProject:  allowedProject,
+			snapshot:         &compute.Snapshot{},
+			restrictProjects: true,
+		},
+		{
+			name: "success with matching KMS key version",
+			snapshot: &compute.Snapshot{
+				SnapshotEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: kmsKey + "/cryptoKeyVersions/1"},
+			},
+			parameters: map[string]string{common.ParameterKeyDiskEncryptionKmsKey: kmsKey},
+		},
+		{
+			name:             "fail from other project with restricted projects",
+			snapshotProject:  "other-project",
+			snapshot:         &compute.Snapshot{},
+			restrictProjects: true,
+			expErrCode:       codes.InvalidArgument,
+		},
+		{
+			name: "fail with KMS key mismatch",
+			snapshot: &compute.Snapshot{
+				SnapshotEncryptionKey: &compute.CustomerEncryptionKey{KmsKeyName: kmsKey},
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "fail with snapshot larger than capacity",
+			snapshot: &compute.Snapshot{
+				DiskSizeGb: 50,
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "fail not ready",
+			snapshot: &compute.Snapshot{
+				Status: "FAILED",
+			},
+			expErrCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+			if err != nil {
+				t.Fatalf("Failed to create fake cloud provider: %v", err)
+			}
+			gceDriver := GetGCEDriver()
+			controllerServer := NewControllerServer(gceDriver, fcp, common.ZoneSelectionStrategyRandom, []string{allowedProject})
+			controllerServer.SetRestrictSnapshotProjects(tc.restrictProjects)
+			if err := gceDriver.SetupGCEDriver(driver, "test-vendor", nil, nil, controllerServer, nil); err != nil {
+				t.Fatalf("Failed to setup GCE Driver: %v", err)
+			}
+
+			snapshotProject := project
+			if tc.snapshotProject != "" {
+				snapshotProject = tc.snapshotProject
+			}
+			tc.snapshot.Name = "test-snapshot"
+			tc.snapshot.SelfLink = fmt.Sprintf("projects/%s/global/snapshots/test-snapshot", snapshotProject)
+			if tc.snapshot.Status == "" {
+				tc.snapshot.Status = "READY"
+			}
+			fcp.InsertSnapshot(tc.snapshot, tc.snapshot.Name)
+
+			_, err = gceDriver.cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         tc.parameters,
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Requisite: stdTopology,
+				},
+				VolumeContentSource: &csi.VolumeContentSource{
+					Type: &csi.VolumeContentSource_Snapshot{
+						Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: tc.snapshot.SelfLink},
+					},
+				},
+			})
+			if code := status.Code(err); code != tc.expErrCode {
+				t.Fatalf("Expected error code: %v, got: %v", tc.expErrCode, err)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeInOtherProject(t *testing.T) {
+	const otherProject = "other-project"
+	otherVolumeID := fmt.Sprintf("projects/%s/zones/%s/disks/%s", otherProject, zone, name)
+
+	fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	gceDriver := GetGCEDriver()
+	controllerServer := NewControllerServer(gceDriver, fcp, common.ZoneSelectionStrategyRandom, []string{otherProject})
+	if err := gceDriver.SetupGCEDriver(driver, "test-vendor", nil, nil, controllerServer, nil); err != nil {
+		t.Fatalf("Failed to setup GCE Driver: %v", err)
+	}
+
+	createReq := func(project string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:               name,
+			CapacityRange:      stdCapRange,
+			VolumeCapabilities: stdVolCaps,
+			Parameters:         map[string]string{common.ParameterKeyProject: project},
+			AccessibilityRequirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{{Segments: map[string]string{common.TopologyKeyZone: zone}}},
+			},
+		}
+	}
+
+	_, err = gceDriver.cs.CreateVolume(context.Background(), createReq("unlisted-project"))
+	if serverError, ok := status.FromError(err); !ok || serverError.Code() != codes.InvalidArgument {
+		t.Fatalf("Expected error code: %v, got: %v", codes.InvalidArgument, err)
+	}
+
+	// Creating the volume twice must be idempotent.
+	for i := 0; i < 2; i++ {
+		resp, err := gceDriver.cs.CreateVolume(context.Background(), createReq(otherProject))
+		if err != nil {
+			t.Fatalf("CreateVolume did not expect error, but got %v", err)
+		}
+		if volumeID := resp.GetVolume().GetVolumeId(); volumeID != otherVolumeID {
+			t.Fatalf("Expected volume ID %s, got %s", otherVolumeID, volumeID)
+		}
+	}
+	if _, err := fcp.GetDisk(context.Background(), project, meta.ZonalKey(name, zone), gce.GCEAPIVersionV1); !gce.IsGCENotFoundError(err) {
+		t.Errorf("Expected no disk in the default project, got %v", err)
+	}
+
+	snapshotResp, err := gceDriver.cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+		Name:           "test-snapshot",
+		SourceVolumeId: otherVolumeID,
+	})
+	if err != nil {
+		t.Fatalf("CreateSnapshot did not expect error, but got %v", err)
+	}
+	expectedSnapshotID := fmt.Sprintf("projects/%s/global/snapshots/test-snapshot", otherProject)
+	if snapshotID := snapshotResp.GetSnapshot().GetSnapshotId(); snapshotID != expectedSnapshotID {
+		t.Errorf("Expected snapshot ID %s, got %s", expectedSnapshotID, snapshotID)
+	}
+
+	expandResp, err := gceDriver.cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      otherVolumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: common.GbToBytes(20)},
+	})
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume did not expect error, but got %v", err)
+	}
+	if expandResp.GetCapacityBytes() != common.GbToBytes(20) {
+		t.Errorf("Expected capacity %v, got %v", common.GbToBytes(20), expandResp.GetCapacityBytes())
+	}
+
+	// The volume is not found under the default project.
+	defaultVolumeID := fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, name)
+	_, err = gceDriver.cs.ControllerExpandVolume(context.Background(), &csi.ControllerExpandVolumeRequest{
+		VolumeId:      defaultVolumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: common.GbToBytes(30)},
+	})
+	if err == nil {
+		t.Errorf("Expected expanding the volume in the default project to fail")
+	}
+
+	if _, err := gceDriver.cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: otherVolumeID}); err != nil {
+		t.Fatalf("DeleteVolume did not expect error, but got %v", err)
+	}
+	if _, err := fcp.GetDisk(context.Background(), otherProject, meta.ZonalKey(name, zone), gce.GCEAPIVersionV1); !gce.IsGCENotFoundError(err) {
+		t.Errorf("Expected disk to be deleted, got %v", err)
+	}
+}
+
+func TestImageSnapshot(t *testing.T) {
+	const (
+		snapshotName = "test-snapshot"
+		imageName    = "test-image"
+	)
+	imageSnapshotID := fmt.Sprintf("projects/%s/global/images/%s", project, imageName)
+	gceDriver := initGCEDriver(t, []*gce.CloudDisk{createZonalCloudDisk(name)})
+
+	imageReq := &csi.CreateSnapshotRequest{
+		Name:           imageName,
+		SourceVolumeId: testVolumeID,
+		Parameters: map[string]string{
+			common.ParameterKeySnapshotType: common.DiskImageType,
+			common.ParameterKeyImageFamily:  "test-family",
+		},
+	}
+	// Creating the image twice must be idempotent. The image is only ready to
+	// use once the fake has reported it as pending.
+	for i, expReady := range []bool{false, true} {
+		resp, err := gceDriver.cs.CreateSnapshot(context.Background(), imageReq)
+		if err != nil {
+			t.Fatalf("CreateSnapshot did not expect error, but got %v", err)
+		}
+		if snapshotID := resp.GetSnapshot().GetSnapshotId(); snapshotID != imageSnapshotID {
+			t.Fatalf("Expected snapshot ID %s, got %s", imageSnapshotID, snapshotID)
+		}
+		if ready := resp.GetSnapshot().GetReadyToUse(); ready != expReady {
+			t.Errorf("Call #%d: expected image snapshot ready to use %v, got %v", i, expReady, ready)
+		}
+	}
+	image, err := gceDriver.cs.CloudProvider.GetImage(context.Background(), project, imageName)
+	if err != nil {
+		t.Fatalf("Failed to get image: %v", err)
+	}
+	if image.Family != "test-family" {
+		t.Errorf("Expected image family test-family, got %q", image.Family)
+	}
+	if _, err := gceDriver.cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{Name: snapshotName, SourceVolumeId: testVolumeID}); err != nil {
+		t.Fatalf("CreateSnapshot did not expect error, but got %v", err)
+	}
+
+	// Listing by ID finds the image, and listing all pages finds both kinds.
+	listResp, err := gceDriver.cs.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: imageSnapshotID})
+	if err != nil {
+		t.Fatalf("ListSnapshots did not expect error, but got %v", err)
+	}
+	if len(listResp.GetEntries()) != 1 || listResp.GetEntries()[0].GetSnapshot().GetSourceVolumeId() != testVolumeID {
+		t.Errorf("Expected image snapshot of %s, got %v", testVolumeID, listResp.GetEntries())
+	}
+	listed := []string{}
+	listReq := &csi.ListSnapshotsRequest{}
+	for {
+		listResp, err := gceDriver.cs.ListSnapshots(context.Background(), listReq)
+		if err != nil {
+			t.Fatalf("ListSnapshots did not expect error, but got %v", err)
+		}
+		for _, entry := range listResp.GetEntries() {
+			listed = append(listed, entry.GetSnapshot().GetSnapshotId())
+		}
+		if listResp.GetNextToken() == "" {
+			break
+		}
+		listReq.StartingToken = listResp.GetNextToken()
+	}
+	expectedListed := []string{fmt.Sprintf("projects/%s/global/snapshots/%s", project, snapshotName), imageSnapshotID}
+	if !reflect.DeepEqual(listed, expectedListed) {
+		t.Errorf("Expected snapshots %v, got %v", expectedListed, listed)
+	}
+
+	// Volumes restored from the image report it as their source.
+	createResp, err := gceDriver.cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "restored-volume",
+		CapacityRange:      stdCapRange,
+		VolumeCapabilities: stdVolCaps,
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{SnapshotId: imageSnapshotID},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume did not expect error, but got %v", err)
+	}
+	if snapshotID := createResp.GetVolume().GetContentSource().GetSnapshot().GetSnapshotId(); snapshotID != imageSnapshotID {
+		t.Errorf("Expected content source %s, got %s", imageSnapshotID, snapshotID)
+	}
+
+	if _, err := gceDriver.cs.DeleteSnapshot(context.Background(), &csi.DeleteSnapshotRequest{SnapshotId: imageSnapshotID}); err != nil {
+		t.Fatalf("DeleteSnapshot did not expect error, but got %v", err)
+	}
+	if _, err := gceDriver.cs.CloudProvider.GetImage(context.Background(), project, imageName); !gce.IsGCENotFoundError(err) {
+		t.Errorf("Expected image to be deleted, got %v", err)
+	}
+}
+
+func TestCustomerEncryptionKey(t *testing.T) {
+	// Base64 encoded 32 byte keys
+	key := "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	otherKey := "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+	secrets := map[string]string{common.SecretKeyDiskEncryptionRawKey: key}
+	otherSecrets := map[string]string{common.SecretKeyDiskEncryptionRawKey: otherKey}
+	nodeID := common.CreateNodeID(project, zone, node)
+
+	fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	fcp.InsertInstance(&compute.Instance{}, zone, node)
+	gceDriver := initGCEDriverWithCloudProvider(t, fcp)
+	cs := gceDriver.cs
+
+	createVolume := func(name string, secrets map[string]string, params map[string]string, source *csi.VolumeContentSource) error {
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:                name,
+			CapacityRange:       stdCapRange,
+			VolumeCapabilities:  stdVolCaps,
+			Parameters:          params,
+			Secrets:             secrets,
+			VolumeContentSource: source,
+			AccessibilityRequirements: &csi.TopologyRequirement{
+				Preferred: stdTopology,
+			},
+		})
+		return err
+	}
+	createSnapshot := func(secrets map[string]string) error {
+		_, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+			Name:           name,
+			SourceVolumeId: testVolumeID,
+			Secrets:        secrets,
+		})
+		return err
+	}
+	publishVolume := func(secrets map[string]string) error {
+		_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         testVolumeID,
+			NodeId:           nodeID,
+			VolumeCapability: stdVolCap,
+			Secrets:          secrets,
+		})
+		return err
+	}
+	snapshotSource := &csi.VolumeContentSource{
+		Type: &csi.VolumeContentSource_Snapshot{
+			Snapshot: &csi.VolumeContentSource_SnapshotSource{
+				SnapshotId: testSnapshotID,
+			},
+		},
+	}
+
+	steps := []struct {
+		name       string
+		request    func() error
+		expErrCode codes.Code
+	}{
+		{
+			name: "invalid key",
+			request: func() error {
+				return createVolume(name, map[string]string{common.SecretKeyDiskEncryptionRawKey: "c2hvcnQ="}, stdParams, nil)
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "key with KMS key",
+			request: func() error {
+				return createVolume(name, secrets, map[string]string{common.ParameterKeyDiskEncryptionKmsKey: "kms-key"}, nil)
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:    "create volume",
+			request: func() error { return createVolume(name, secrets, stdParams, nil) },
+		},
+		{
+			name:    "create volume again",
+			request: func() error { return createVolume(name, secrets, stdParams, nil) },
+		},
+		{
+			name:       "create volume again with another key",
+			request:    func() error { return createVolume(name, otherSecrets, stdParams, nil) },
+			expErrCode: codes.AlreadyExists,
+		},
+		{
+			name:       "create volume again without key",
+			request:    func() error { return createVolume(name, nil, stdParams, nil) },
+			expErrCode: codes.AlreadyExists,
+		},
+		{
+			name:       "create snapshot without key",
+			request:    func() error { return createSnapshot(nil) },
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:    "create snapshot",
+			request: func() error { return createSnapshot(secrets) },
+		},
+		{
+			name:       "restore snapshot with another key",
+			request:    func() error { return createVolume("restored", otherSecrets, stdParams, snapshotSource) },
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:    "restore snapshot",
+			request: func() error { return createVolume("restored", secrets, stdParams, snapshotSource) },
+		},
+		{
+			name:       "publish volume without key",
+			request:    func() error { return publishVolume(nil) },
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:    "publish volume",
+			request: func() error { return publishVolume(secrets) },
+		},
+	}
+	for _, step := range steps {
+		if err := step.request(); status.Code(err) != step.expErrCode {
+			t.Fatalf("%s: expected error code %v, got %v", step.name, step.expErrCode, err)
+		}
+	}
+
+	disk, err := fcp.GetDisk(context.Background(), project, meta.ZonalKey(name, zone), gce.GCEAPIVersionV1)
+	if err != nil {
+		t.Fatalf("Failed to get disk: %v", err)
+	}
+	expectedSha256 := (&common.CustomerEncryptionKey{RawKey: key}).Sha256()
+	if sha256 := disk.GetEncryptionKeySha256(); sha256 != expectedSha256 {
+		t.Errorf("Expected disk encryption key hash %q, got %q", expectedSha256, sha256)
+	}
+	snapshot, err := fcp.GetSnapshot(context.Background(), project, name)
+	if err != nil {
+		t.Fatalf("Failed to get snapshot: %v", err)
+	}
+	if snapshot.SnapshotEncryptionKey == nil || snapshot.SnapshotEncryptionKey.Sha256 != expectedSha256 {
+		t.Errorf("Expected snapshot encryption key hash %q, got %+v", expectedSha256, snapshot.SnapshotEncryptionKey)
+	}
+}
+
+func TestRSAEncryptedCustomerEncryptionKey(t *testing.T) {
+	// Base64 encoded 256 byte RSA-wrapped key
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0x5a}, 256))
+	secrets := map[string]string{common.SecretKeyDiskEncryptionRSAKey: key}
+	nodeID := common.CreateNodeID(project, zone, node)
+
+	fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	fcp.InsertInstance(&compute.Instance{}, zone, node)
+	gceDriver := initGCEDriverWithCloudProvider(t, fcp)
+	cs := gceDriver.cs
+
+	createVolume := func(name string, secrets map[string]string, source *csi.VolumeContentSource) error {
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:                name,
+			CapacityRange:       stdCapRange,
+			VolumeCapabilities:  stdVolCaps,
+			Parameters:          stdParams,
+			Secrets:             secrets,
+			VolumeContentSource: source,
+			AccessibilityRequirements: &csi.TopologyRequirement{
+				Preferred: stdTopology,
+			},
+		})
+		return err
+	}
+	publishVolume := func(secrets map[string]string) error {
+		_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         testVolumeID,
+			NodeId:           nodeID,
+			VolumeCapability: stdVolCap,
+			Secrets:          secrets,
+		})
+		return err
+	}
+
+	steps := []struct {
+		name       string
+		request    func() error
+		expErrCode codes.Code
+	}{
+		{
+			name: "invalid key",
+			request: func() error {
+				return createVolume(name, map[string]string{common.SecretKeyDiskEncryptionRSAKey: "c2hvcnQ="}, nil)
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name: "key with raw key",
+			request: func() error {
+				return createVolume(name, map[string]string{
+					common.SecretKeyDiskEncryptionRSAKey: key,
+					common.SecretKeyDiskEncryptionRawKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+				}, nil)
+			},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:    "create volume",
+			request: func() error { return createVolume(name, secrets, nil) },
+		},
+		{
+			name:    "create volume again",
+			request: func() error { return createVolume(name, secrets, nil) },
+		},
+		{
+			name:       "create volume again without key",
+			request:    func() error { return createVolume(name, nil, nil) },
+			expErrCode: codes.AlreadyExists,
+		},
+		{
+			name: "create snapshot",
+			request: func() error {
+				_, err := cs.CreateSnapshot(context.Background(), &csi.CreateSnapshotRequest{
+					Name:           name,
+					SourceVolumeId: testVolumeID,
+					Secrets:        secrets,
+				})
+				return err
+			},
+		},
+		{
+			name: "restore snapshot",
+			request: func() error {
+				return createVolume("restored", secrets, &csi.VolumeContentSource{
+					Type: &csi.VolumeContentSource_Snapshot{
+						Snapshot: &csi.VolumeContentSource_SnapshotSource{
+							SnapshotId: testSnapshotID,
+						},
+					},
+				})
+			},
+		},
+		{
+			name:       "publish volume without key",
+			request:    func() error { return publishVolume(nil) },
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:    "publish volume",
+			request: func() error { return publishVolume(secrets) },
+		},
+	}
+	for _, step := range steps {
+		if err := step.request(); status.Code(err) != step.expErrCode {
+			t.Fatalf("%s: expected error code %v, got %v", step.name, step.expErrCode, err)
+		}
+	}
+
+	disk, err := fcp.GetDisk(context.Background(), project, meta.ZonalKey(name, zone), gce.GCEAPIVersionV1)
+	if err != nil {
+		t.Fatalf("Failed to get disk: %v", err)
+	}
+	if disk.GetEncryptionKeySha256() == "" {
+		t.Errorf("Expected disk to be protected by a customer-supplied encryption key")
+	}
+}
+
+func TestControllerPublishVolumeForceAttach(t *testing.T) {
+	zoneB, zoneC := region+"-b", region+"-c"
+	testCases := []struct {
+		name          string
+		forceAttach   string
+		firstNode     string
+		expErrCode    codes.Code
+		expFirstDisks int
+	}{
+		{
+			name:          "force attach from another zone",
+			forceAttach:   "true",
+			firstNode:     "node-b",
+			expFirstDisks: 0,
+		},
+		{
+			name:          "no force attach from another zone",
+			forceAttach:   "false",
+			firstNode:     "node-b",
+			expErrCode:    codes.FailedPrecondition,
+			expFirstDisks: 1,
+		},
+		{
+			name:          "no force attach from the same zone",
+			forceAttach:   "true",
+			firstNode:     "other-node-c",
+			expErrCode:    codes.FailedPrecondition,
+			expFirstDisks: 1,
+		},
+		{
+			name:          "invalid force attach",
+			forceAttach:   "maybe",
+			firstNode:     "node-b",
+			expErrCode:    codes.InvalidArgument,
+			expFirstDisks: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+			if err != nil {
+				t.Fatalf("Failed to create fake cloud provider: %v", err)
+			}
+			instances := map[string]string{"node-b": zoneB, "node-c": zoneC, "other-node-c": zoneC}
+			for instanceName, instanceZone := range instances {
+				fcp.InsertInstance(&compute.Instance{}, instanceZone, instanceName)
+			}
+			cs := initGCEDriverWithCloudProvider(t, fcp).cs
+
+			resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:               name,
+				CapacityRange:      stdCapRange,
+				VolumeCapabilities: stdVolCaps,
+				Parameters:         map[string]string{common.ParameterKeyReplicationType: replicationTypeRegionalPD},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Preferred: []*csi.Topology{
+						{Segments: map[string]string{common.TopologyKeyZone: zoneB}},
+						{Segments: map[string]string{common.TopologyKeyZone: zoneC}},
+					},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create volume: %v", err)
+			}
+			publish := func(instanceName, forceAttach string) error {
+				_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+					VolumeId:         resp.GetVolume().GetVolumeId(),
+					NodeId:           common.CreateNodeID(project, instances[instanceName], instanceName),
+					VolumeCapability: stdVolCap,
+					VolumeContext:    map[string]string{common.VolumeAttributeForceAttach: forceAttach},
+				})
+				return err
+			}
+			if err := publish(tc.firstNode, "false"); err != nil {
+				t.Fatalf("Failed to publish volume to %s: %v", tc.firstNode, err)
+			}
+
+			err = publish("node-c", tc.forceAttach)
+			if status.Code(err) != tc.expErrCode {
+				t.Fatalf("Expected error code %v, got %v", tc.expErrCode, err)
+			}
+			firstInstance, err := fcp.GetInstanceOrError(context.Background(), instances[tc.firstNode], tc.firstNode)
+			if err != nil {
+				t.Fatalf("Failed to get instance: %v", err)
+			}
+			if len(firstInstance.Disks) != tc.expFirstDisks {
+				t.Errorf("Expected %d disks attached to %s, got %v", tc.expFirstDisks, tc.firstNode, firstInstance.Disks)
+			}
+			disk, err := fcp.GetDisk(context.Background(), project, meta.RegionalKey(name, region), gce.GCEAPIVersionV1)
+			if err != nil {
+				t.Fatalf("Failed to get disk: %v", err)
+			}
+			if users := disk.GetUsers(); len(users) != 1 {
+				t.Errorf("Expected the disk to have one user, got %v", users)
+			}
+		})
+	}
+}
+
+func TestDiskConditions(t *testing.T) {
+	instanceURI := func(name string) string {
+		return gce.BasePath + fmt.Sprintf("%s/zones/%s/instances/%s", project, zone, name)
+	}
+	zoneURI := func(zone string) string {
+		return gce.BasePath + fmt.Sprintf("%s/zones/%s", project, zone)
+	}
+	testCases := []struct {
+		name     string
+		disk     *compute.Disk
+		setup    func(fcp *gce.FakeCloudProvider)
+		expected *csi.VolumeCondition
+	}{
+		{
+			name: "healthy zonal disk",
+			disk: &compute.Disk{
+				Zone:  zone,
+				Users: []string{instanceURI(node)},
+			},
+			expected: &csi.VolumeCondition{Message: "Volume is healthy"},
+		},
+		{
+			name: "failed disk",
+			disk: &compute.Disk{Zone: zone},
+			setup: func(fcp *gce.FakeCloudProvider) {
+				fcp.SetDiskStatus("failed-disk", "FAILED")
+			},
+			expected: &csi.VolumeCondition{Abnormal: true, Message: "Disk failed-disk failed"},
+		},
+		{
+			name: "disk attached to deleted instance",
+			disk: &compute.Disk{
+				Zone:  zone,
+				Users: []string{instanceURI(node), instanceURI("deleted-node")},
+			},
+			setup: func(fcp *gce.FakeCloudProvider) {
+				fcp.DeleteInstance("deleted-node")
+			},
+			expected: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Disk disk-attached-to-deleted-instance is attached to instance projects/%s/zones/%s/instances/deleted-node, which no longer exists", project, zone),
+			},
+		},
+		{
+			name: "healthy regional disk",
+			disk: &compute.Disk{
+				Region:       region,
+				ReplicaZones: []string{zoneURI(zone), zoneURI(secondZone)},
+			},
+			expected: &csi.VolumeCondition{Message: "Volume is healthy"},
+		},
+		{
+			name: "regional disk with replica in zone that is down",
+			disk: &compute.Disk{
+				Region:       region,
+				ReplicaZones: []string{zoneURI(zone), zoneURI(secondZone)},
+			},
+			setup: func(fcp *gce.FakeCloudProvider) {
+				fcp.SetZoneStatus(secondZone, "DOWN")
+			},
+			expected: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Replica of regional disk regional-disk-with-replica-in-zone-that-is-down in zone %s is degraded, the zone is DOWN", secondZone),
+			},
+		},
+		{
+			name: "regional disk with one replica",
+			disk: &compute.Disk{
+				Region:       region,
+				ReplicaZones: []string{zoneURI(zone)},
+			},
+			expected: &csi.VolumeCondition{Abnormal: true, Message: "Regional disk regional-disk-with-one-replica is replicated in 1 zones instead of 2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			diskName := strings.ReplaceAll(tc.name, " ", "-")
+			tc.disk.Name = diskName
+			tc.disk.Status = "READY"
+			volumeID := fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, diskName)
+			if tc.disk.Region != "" {
+				volumeID = fmt.Sprintf("projects/%s/regions/%s/disks/%s", project, region, diskName)
+			}
+			tc.disk.SelfLink = gce.GCEComputeAPIEndpoint + volumeID
+			fcp, err := gce.CreateFakeCloudProvider(project, zone, []*gce.CloudDisk{gce.CloudDiskFromV1(tc.disk)})
+			if err != nil {
+				t.Fatalf("Failed to create fake cloud provider: %v", err)
+			}
+			fcp.InsertInstance(&compute.Instance{}, zone, node)
+			fcp.InsertInstance(&compute.Instance{}, zone, "deleted-node")
+			if tc.setup != nil {
+				tc.setup(fcp)
+			}
+			gceDriver := initGCEDriverWithCloudProvider(t, fcp)
+
+			volume, err := gceDriver.cs.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+			if err != nil {
+				t.Fatalf("ControllerGetVolume failed: %v", err)
+			}
+			if volume.GetVolume().GetVolumeId() != volumeID {
+				t.Errorf("Got volume ID %s, expected %s", volume.GetVolume().GetVolumeId(), volumeID)
+			}
+			if got := volume.GetStatus().GetVolumeCondition(); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Got condition %+v from ControllerGetVolume, expected %+v", got, tc.expected)
+			}
+
+			resp, err := gceDriver.cs.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+			if err != nil {
+				t.Fatalf("ListVolumes failed: %v", err)
+			}
+			if got := resp.GetEntries()[0].GetStatus().GetVolumeCondition(); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Got condition %+v from ListVolumes, expected %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	fcp, err := gce.CreateFakeCloudProvider(project, zone, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake cloud provider: %v", err)
+	}
+	gceDriver := initGCEDriverWithCloudProvider(t, fcp)
+	if _, err := gceDriver.cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               name,
+		CapacityRange:      stdCapRange,
+		VolumeCapabilities: stdVolCaps,
+		AccessibilityRequirements: &csi.TopologyRequirement{
+			Preferred: stdTopology,
+		},
+	}); err != nil {
+		t.Fatalf("Failed to create volume: %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		volumeID   string
+		expErrCode codes.Code
+	}{
+		{
+			name:     "success",
+			volumeID: testVolumeID,
+		},
+		{
+			name:       "missing volume ID",
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "invalid volume ID",
+			volumeID:   "invalid",
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "missing volume",
+			volumeID:   fmt.Sprintf("projects/%s/zones/%s/disks/missing", project, zone),
+			expErrCode: codes.NotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := gceDriver.cs.ControllerGetVolume(context.Background(), &csi.ControllerGetVolumeRequest{VolumeId: tc.volumeID})
+			if status.Code(err) != tc.expErrCode {
+				t.Fatalf("Expected error code %v, got %v", tc.expErrCode, err)
+			}
+			if err != nil {
+				return
+			}
+			if resp.GetVolume().GetVolumeId() != testVolumeID || resp.GetVolume().GetCapacityBytes() != stdCapRange.GetRequiredBytes() {
+				t.Errorf("Got volume %v, expected ID %s and capacity %d", resp.GetVolume(), testVolumeID, stdCapRange.GetRequiredBytes())
+			}
+		})
+	}
+}
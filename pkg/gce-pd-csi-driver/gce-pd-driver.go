@@ -20,6 +20,7 @@ import (
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog"
 	"k8s.io/mount-utils"
 	common "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
@@ -37,9 +38,12 @@ type GCEDriver struct {
 	ns  *GCENodeServer
 	cs  *GCEControllerServer
 
-	vcap  []*csi.VolumeCapability_AccessMode
-	cscap []*csi.ControllerServiceCapability
-	nscap []*csi.NodeServiceCapability
+	vcap   []*csi.VolumeCapability_AccessMode
+	cscap  []*csi.ControllerServiceCapability
+	gcscap []*csi.GroupControllerServiceCapability
+	nscap  []*csi.NodeServiceCapability
+
+	rpcLogConfig RPCLogConfig
 }
 
 func GetGCEDriver() *GCEDriver {
@@ -67,12 +71,21 @@ func (gceDriver *GCEDriver) SetupGCEDriver(name, vendorVersion string, extraVolu
 		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
 		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
 		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
 	}
 	gceDriver.AddControllerServiceCapabilities(csc)
+	gcsc := []csi.GroupControllerServiceCapability_RPC_Type{
+		csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
+	}
+	gceDriver.AddGroupControllerServiceCapabilities(gcsc)
 	ns := []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	}
 	gceDriver.AddNodeServiceCapabilities(ns)
 
@@ -106,6 +119,16 @@ func (gceDriver *GCEDriver) AddControllerServiceCapabilities(cl []csi.Controller
 	return nil
 }
 
+func (gceDriver *GCEDriver) AddGroupControllerServiceCapabilities(gl []csi.GroupControllerServiceCapability_RPC_Type) error {
+	var gcsc []*csi.GroupControllerServiceCapability
+	for _, g := range gl {
+		klog.V(4).Infof("Enabling group controller service capability: %v", g.String())
+		gcsc = append(gcsc, NewGroupControllerServiceCapability(g))
+	}
+	gceDriver.gcscap = gcsc
+	return nil
+}
+
 func (gceDriver *GCEDriver) AddNodeServiceCapabilities(nl []csi.NodeServiceCapability_RPC_Type) error {
 	var nsc []*csi.NodeServiceCapability
 	for _, n := range nl {
@@ -147,23 +170,39 @@ func NewNodeServer(gceDriver *GCEDriver, mounter *mount.SafeFormatAndMount, devi
 	}
 }
 
-func NewControllerServer(gceDriver *GCEDriver, cloudProvider gce.GCECompute) *GCEControllerServer {
+func NewControllerServer(gceDriver *GCEDriver, cloudProvider gce.GCECompute, zoneSelectionStrategy string, allowedProjects []string) *GCEControllerServer {
 	return &GCEControllerServer{
-		Driver:        gceDriver,
-		CloudProvider: cloudProvider,
-		volumeLocks:   common.NewVolumeLocks(),
+		Driver:                gceDriver,
+		CloudProvider:         cloudProvider,
+		volumeLocks:           common.NewVolumeLocks(),
+		zoneSelectors:         newZoneSelectors(cloudProvider),
+		zoneSelectionStrategy: zoneSelectionStrategy,
+		allowedProjects:       sets.NewString(allowedProjects...),
+		attachBatcher:         gce.NewAttachBatcher(cloudProvider),
 	}
 }
 
+// SetRestrictSnapshotProjects sets whether volumes may only be restored from
+// snapshots in the default project, the allowed projects and the project of
+// the new disk.
+func (gceCS *GCEControllerServer) SetRestrictSnapshotProjects(restrict bool) {
+	gceCS.restrictSnapshotProjects = restrict
+}
+
+// SetRPCLogConfig configures how the RPCs served by Run are logged.
+func (gceDriver *GCEDriver) SetRPCLogConfig(config RPCLogConfig) {
+	gceDriver.rpcLogConfig = config
+}
+
 func (gceDriver *GCEDriver) Run(endpoint string) {
 	klog.V(4).Infof("Driver: %v", gceDriver.name)
 
 	//Start the nonblocking GRPC
-	s := NewNonBlockingGRPCServer()
+	s := NewNonBlockingGRPCServer(gceDriver.rpcLogConfig)
 	// TODO(#34): Only start specific servers based on a flag.
 	// In the future have this only run specific combinations of servers depending on which version this is.
 	// The schema for that was in util. basically it was just s.start but with some nil servers.
 
-	s.Start(endpoint, gceDriver.ids, gceDriver.cs, gceDriver.ns)
+	s.Start(endpoint, gceDriver.ids, gceDriver.cs, gceDriver.cs, gceDriver.ns)
 	s.Wait()
 }
@@ -0,0 +1,274 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gceGCEDriver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
+	gce "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-cloud-provider/compute"
+)
+
+const (
+	// GCE has no group snapshots, so a group snapshot is a set of PD
+	// snapshots named {groupName}-{index}. Each member is labelled with the
+	// name of its group snapshot and the number of members, which are read
+	// from the first member to find the others.
+	groupSnapshotNameLabelKey    = "csi-group-snapshot-name"
+	groupSnapshotMembersLabelKey = "csi-group-snapshot-members"
+)
+
+var _ csi.GroupControllerServer = &GCEControllerServer{}
+
+// GroupControllerGetCapabilities implements the default GRPC callout.
+func (gceCS *GCEControllerServer) GroupControllerGetCapabilities(ctx context.Context, req *csi.GroupControllerGetCapabilitiesRequest) (*csi.GroupControllerGetCapabilitiesResponse, error) {
+	return &csi.GroupControllerGetCapabilitiesResponse{
+		Capabilities: gceCS.Driver.gcscap,
+	}, nil
+}
+
+// CreateVolumeGroupSnapshot snapshots each of the source volumes, which must
+// be in the same project. The members are created one after another, so
+// applications that need the snapshots to be consistent with each other must
+// quiesce writes first. Retrying with the same name and volumes is
+// idempotent.
+func (gceCS *GCEControllerServer) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	// Validate arguments
+	name := req.GetName()
+	sourceVolumeIDs := req.GetSourceVolumeIds()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Group snapshot name must be provided")
+	}
+	if len(sourceVolumeIDs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolumeGroupSnapshot Source Volume IDs must be provided")
+	}
+	if sets.NewString(sourceVolumeIDs...).Len() != len(sourceVolumeIDs) {
+		return nil, status.Errorf(codes.InvalidArgument, "CreateVolumeGroupSnapshot Source Volume IDs %v contain duplicates", sourceVolumeIDs)
+	}
+	snapshotParams, err := common.ExtractAndDefaultSnapshotParameters(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("Invalid snapshot parameters: %v", err))
+	}
+	if snapshotParams.SnapshotType != common.DiskSnapshotType {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid snapshot parameters: group snapshots only support snapshot type %s, got %s", common.DiskSnapshotType, snapshotParams.SnapshotType)
+	}
+	var project string
+	for _, volumeID := range sourceVolumeIDs {
+		volumeProject, _, err := common.VolumeIDToKey(volumeID)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("CreateVolumeGroupSnapshot Volume ID is invalid: %v", err))
+		}
+		if project == "" {
+			project = volumeProject
+		} else if volumeProject != project {
+			return nil, status.Errorf(codes.InvalidArgument, "CreateVolumeGroupSnapshot Source Volumes %v are not in the same project", sourceVolumeIDs)
+		}
+	}
+
+	// The member names are derived from the sorted volume IDs, so that a
+	// retry finds the snapshots it already created.
+	groupSnapshotID := common.CreateGroupSnapshotID(project, name)
+	labels := map[string]string{
+		groupSnapshotNameLabelKey:    name,
+		groupSnapshotMembersLabelKey: strconv.Itoa(len(sourceVolumeIDs)),
+	}
+	volumeIDs := append([]string{}, sourceVolumeIDs...)
+	sort.Strings(volumeIDs)
+	snapshots := make([]*csi.Snapshot, 0, len(volumeIDs))
+	for i, volumeID := range volumeIDs {
+		resp, err := gceCS.createSnapshot(ctx, &csi.CreateSnapshotRequest{
+			Name:           groupSnapshotMemberName(name, i),
+			SourceVolumeId: volumeID,
+			Secrets:        req.GetSecrets(),
+			Parameters:     req.GetParameters(),
+		}, labels)
+		if err != nil {
+			return nil, status.Error(status.Code(err), fmt.Sprintf("CreateVolumeGroupSnapshot failed to snapshot volume %s: %v", volumeID, status.Convert(err).Message()))
+		}
+		snapshot := resp.GetSnapshot()
+		snapshot.GroupSnapshotId = groupSnapshotID
+		snapshots = append(snapshots, snapshot)
+	}
+	klog.V(4).Infof("CreateVolumeGroupSnapshot succeeded for group snapshot %s of volumes %v", groupSnapshotID, volumeIDs)
+	return &csi.CreateVolumeGroupSnapshotResponse{
+		GroupSnapshot: generateVolumeGroupSnapshot(groupSnapshotID, snapshots),
+	}, nil
+}
+
+// DeleteVolumeGroupSnapshot deletes the member snapshots of a group snapshot.
+// If snapshot IDs are given, they must be the members of the group snapshot.
+func (gceCS *GCEControllerServer) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+	// Validate arguments
+	groupSnapshotID := req.GetGroupSnapshotId()
+	if len(groupSnapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "DeleteVolumeGroupSnapshot Group Snapshot ID must be provided")
+	}
+	project, name, err := common.GroupSnapshotIDToProjectName(groupSnapshotID)
+	if err != nil {
+		// Like DeleteSnapshot, an ID in the wrong format is a success
+		// according to the spec
+		klog.Warningf("Group snapshot id does not have the correct format %s: %v", groupSnapshotID, err)
+		return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+	}
+	memberIDs, err := gceCS.getGroupSnapshotMemberIDs(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	if memberIDs == nil {
+		// The group snapshot was already deleted
+		return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+	}
+	if err := validateGroupSnapshotMembers(groupSnapshotID, memberIDs, req.GetSnapshotIds()); err != nil {
+		return nil, err
+	}
+
+	// The first member is deleted last, so that a retry still finds the
+	// members that are left.
+	for i := len(memberIDs) - 1; i >= 0; i-- {
+		if _, err := gceCS.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: memberIDs[i]}); err != nil {
+			return nil, err
+		}
+	}
+	return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+}
+
+// GetVolumeGroupSnapshot returns the group snapshot with the given ID, which
+// is ready to use once all of its members are.
+func (gceCS *GCEControllerServer) GetVolumeGroupSnapshot(ctx context.Context, req *csi.GetVolumeGroupSnapshotRequest) (*csi.GetVolumeGroupSnapshotResponse, error) {
+	// Validate arguments
+	groupSnapshotID := req.GetGroupSnapshotId()
+	if len(groupSnapshotID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "GetVolumeGroupSnapshot Group Snapshot ID must be provided")
+	}
+	project, name, err := common.GroupSnapshotIDToProjectName(groupSnapshotID)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("GetVolumeGroupSnapshot could not find group snapshot: %v", err))
+	}
+	memberIDs, err := gceCS.getGroupSnapshotMemberIDs(ctx, project, name)
+	if err != nil {
+		return nil, err
+	}
+	if memberIDs == nil {
+		return nil, status.Errorf(codes.NotFound, "GetVolumeGroupSnapshot could not find group snapshot %s", groupSnapshotID)
+	}
+	if err := validateGroupSnapshotMembers(groupSnapshotID, memberIDs, req.GetSnapshotIds()); err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*csi.Snapshot, 0, len(memberIDs))
+	for _, snapshotID := range memberIDs {
+		resp, err := gceCS.getSnapshotByID(ctx, snapshotID)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.GetEntries()) == 0 {
+			return nil, status.Errorf(codes.NotFound, "GetVolumeGroupSnapshot could not find snapshot %s of group snapshot %s", snapshotID, groupSnapshotID)
+		}
+		snapshots = append(snapshots, resp.GetEntries()[0].GetSnapshot())
+	}
+	return &csi.GetVolumeGroupSnapshotResponse{
+		GroupSnapshot: generateVolumeGroupSnapshot(groupSnapshotID, snapshots),
+	}, nil
+}
+
+// getGroupSnapshotMemberIDs returns the IDs of the member snapshots of the
+// group snapshot with the given project and name, or nil if its first member
+// does not exist.
+func (gceCS *GCEControllerServer) getGroupSnapshotMemberIDs(ctx context.Context, project, name string) ([]string, error) {
+	first, err := gceCS.CloudProvider.GetSnapshot(ctx, project, groupSnapshotMemberName(name, 0))
+	if err != nil {
+		if gce.IsGCENotFoundError(err) {
+			return nil, nil
+		}
+		return nil, status.Error(codes.Internal, fmt.Sprintf("Unknown get snapshot error: %v", err))
+	}
+	if first.Labels[groupSnapshotNameLabelKey] != name {
+		// A snapshot with the name of the first member that is not part of
+		// the group snapshot
+		return nil, nil
+	}
+	members, err := strconv.Atoi(first.Labels[groupSnapshotMembersLabelKey])
+	if err != nil || members < 1 {
+		return nil, status.Errorf(codes.Internal, "Snapshot %s has invalid label %s=%q", first.Name, groupSnapshotMembersLabelKey, first.Labels[groupSnapshotMembersLabelKey])
+	}
+	memberIDs := make([]string, 0, members)
+	for i := 0; i < members; i++ {
+		memberIDs = append(memberIDs, fmt.Sprintf("projects/%s/global/%s/%s", project, common.DiskSnapshotType, groupSnapshotMemberName(name, i)))
+	}
+	return memberIDs, nil
+}
+
+// groupSnapshotMemberName returns the name of the snapshot of the i-th
+// source volume of a group snapshot.
+func groupSnapshotMemberName(name string, i int) string {
+	return fmt.Sprintf("%s-%d", name, i)
+}
+
+// groupSnapshotIDOfMember returns the ID of the group snapshot that the
+// snapshot is a member of, or "" if it is not a member of one.
+func groupSnapshotIDOfMember(snapshot *compute.Snapshot) string {
+	name, ok := snapshot.Labels[groupSnapshotNameLabelKey]
+	if !ok {
+		return ""
+	}
+	project, _, _, err := common.SnapshotIDToProjectKey(cleanSelfLink(snapshot.SelfLink))
+	if err != nil {
+		return ""
+	}
+	return common.CreateGroupSnapshotID(project, name)
+}
+
+// validateGroupSnapshotMembers checks that the snapshot IDs given by the CO,
+// if any, are the members of the group snapshot.
+func validateGroupSnapshotMembers(groupSnapshotID string, memberIDs, snapshotIDs []string) error {
+	if len(snapshotIDs) > 0 && !sets.NewString(snapshotIDs...).Equal(sets.NewString(memberIDs...)) {
+		return status.Errorf(codes.InvalidArgument, "Snapshot IDs %v are not the members of group snapshot %s", snapshotIDs, groupSnapshotID)
+	}
+	return nil
+}
+
+// generateVolumeGroupSnapshot returns the group snapshot of the given member
+// snapshots, which was taken when its first member was.
+func generateVolumeGroupSnapshot(groupSnapshotID string, snapshots []*csi.Snapshot) *csi.VolumeGroupSnapshot {
+	groupSnapshot := &csi.VolumeGroupSnapshot{
+		GroupSnapshotId: groupSnapshotID,
+		Snapshots:       snapshots,
+		ReadyToUse:      true,
+	}
+	for _, snapshot := range snapshots {
+		groupSnapshot.ReadyToUse = groupSnapshot.ReadyToUse && snapshot.GetReadyToUse()
+		if t := snapshot.GetCreationTime(); groupSnapshot.CreationTime == nil || timestampBefore(t, groupSnapshot.CreationTime) {
+			groupSnapshot.CreationTime = t
+		}
+	}
+	return groupSnapshot
+}
+
+func timestampBefore(a, b *timestamp.Timestamp) bool {
+	return a.GetSeconds() < b.GetSeconds() || (a.GetSeconds() == b.GetSeconds() && a.GetNanos() < b.GetNanos())
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gceGCEDriver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
+	gce "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-cloud-provider/compute"
+)
+
+func TestVolumeGroupSnapshot(t *testing.T) {
+	const groupName = "test-group"
+	volumeIDs := []string{
+		fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, "disk-b"),
+		fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, "disk-a"),
+	}
+	gceDriver := initGCEDriver(t, []*gce.CloudDisk{createZonalCloudDisk("disk-a"), createZonalCloudDisk("disk-b")})
+	expSnapshotIDs := []string{
+		fmt.Sprintf("projects/%s/global/snapshots/%s-0", project, groupName),
+		fmt.Sprintf("projects/%s/global/snapshots/%s-1", project, groupName),
+	}
+	expGroupSnapshotID := fmt.Sprintf("projects/%s/global/groupSnapshots/%s", project, groupName)
+
+	// Creating the group snapshot twice must be idempotent. The members are
+	// only ready to use once the fake has reported them as uploading.
+	for i, expReady := range []bool{false, true} {
+		resp, err := gceDriver.cs.CreateVolumeGroupSnapshot(context.Background(), &csi.CreateVolumeGroupSnapshotRequest{
+			Name:            groupName,
+			SourceVolumeIds: volumeIDs,
+		})
+		if err != nil {
+			t.Fatalf("CreateVolumeGroupSnapshot did not expect error, but got %v", err)
+		}
+		groupSnapshot := resp.GetGroupSnapshot()
+		if groupSnapshot.GetGroupSnapshotId() != expGroupSnapshotID {
+			t.Fatalf("Expected group snapshot ID %s, got %s", expGroupSnapshotID, groupSnapshot.GetGroupSnapshotId())
+		}
+		if groupSnapshot.GetReadyToUse() != expReady {
+			t.Errorf("Call #%d: expected group snapshot ready to use %v, got %v", i, expReady, groupSnapshot.GetReadyToUse())
+		}
+		if groupSnapshot.GetCreationTime() == nil {
+			t.Errorf("Expected group snapshot creation time to be set")
+		}
+		sourceVolumeIDs := []string{}
+		for _, snapshot := range groupSnapshot.GetSnapshots() {
+			sourceVolumeIDs = append(sourceVolumeIDs, snapshot.GetSourceVolumeId())
+			if snapshot.GetGroupSnapshotId() != expGroupSnapshotID {
+				t.Errorf("Expected snapshot %s to be in group snapshot %s, got %q", snapshot.GetSnapshotId(), expGroupSnapshotID, snapshot.GetGroupSnapshotId())
+			}
+		}
+		if expSourceVolumeIDs := []string{volumeIDs[1], volumeIDs[0]}; !reflect.DeepEqual(sourceVolumeIDs, expSourceVolumeIDs) {
+			t.Errorf("Expected snapshots of %v, got %v", expSourceVolumeIDs, sourceVolumeIDs)
+		}
+	}
+
+	// Listing a member reports its group snapshot
+	listResp, err := gceDriver.cs.ListSnapshots(context.Background(), &csi.ListSnapshotsRequest{SnapshotId: expSnapshotIDs[1]})
+	if err != nil {
+		t.Fatalf("ListSnapshots did not expect error, but got %v", err)
+	}
+	if len(listResp.GetEntries()) != 1 || listResp.GetEntries()[0].GetSnapshot().GetGroupSnapshotId() != expGroupSnapshotID {
+		t.Errorf("Expected snapshot %s in group snapshot %s, got %v", expSnapshotIDs[1], expGroupSnapshotID, listResp.GetEntries())
+	}
+
+	getResp, err := gceDriver.cs.GetVolumeGroupSnapshot(context.Background(), &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: expGroupSnapshotID})
+	if err != nil {
+		t.Fatalf("GetVolumeGroupSnapshot did not expect error, but got %v", err)
+	}
+	snapshotIDs := []string{}
+	for _, snapshot := range getResp.GetGroupSnapshot().GetSnapshots() {
+		snapshotIDs = append(snapshotIDs, snapshot.GetSnapshotId())
+	}
+	if !getResp.GetGroupSnapshot().GetReadyToUse() || !reflect.DeepEqual(snapshotIDs, expSnapshotIDs) {
+		t.Errorf("Expected ready group snapshot with snapshots %v, got %+v", expSnapshotIDs, getResp.GetGroupSnapshot())
+	}
+	_, err = gceDriver.cs.GetVolumeGroupSnapshot(context.Background(), &csi.GetVolumeGroupSnapshotRequest{
+		GroupSnapshotId: expGroupSnapshotID,
+		SnapshotIds:     expSnapshotIDs[:1],
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected error code %v for mismatched snapshot IDs, got %v", codes.InvalidArgument, err)
+	}
+
+	// Deleting the group snapshot twice must be idempotent
+	for i := 0; i < 2; i++ {
+		_, err = gceDriver.cs.DeleteVolumeGroupSnapshot(context.Background(), &csi.DeleteVolumeGroupSnapshotRequest{
+			GroupSnapshotId: expGroupSnapshotID,
+			SnapshotIds:     expSnapshotIDs,
+		})
+		if err != nil {
+			t.Fatalf("Call #%d: DeleteVolumeGroupSnapshot did not expect error, but got %v", i, err)
+		}
+	}
+	for _, snapshotID := range expSnapshotIDs {
+		resp, err := gceDriver.cs.getSnapshotByID(context.Background(), snapshotID)
+		if err != nil {
+			t.Fatalf("getSnapshotByID did not expect error, but got %v", err)
+		}
+		if len(resp.GetEntries()) != 0 {
+			t.Errorf("Expected snapshot %s to be deleted", snapshotID)
+		}
+	}
+	_, err = gceDriver.cs.GetVolumeGroupSnapshot(context.Background(), &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: expGroupSnapshotID})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected error code %v for deleted group snapshot, got %v", codes.NotFound, err)
+	}
+}
+
+func TestCreateVolumeGroupSnapshotArguments(t *testing.T) {
+	testCases := []struct {
+		name       string
+		groupName  string
+		volumeIDs  []string
+		parameters map[string]string
+		expErrCode codes.Code
+	}{
+		{
+			name:       "fail no name",
+			volumeIDs:  []string{testVolumeID},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "fail no source volumes",
+			groupName:  name,
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "fail duplicate source volumes",
+			groupName:  name,
+			volumeIDs:  []string{testVolumeID, testVolumeID},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "fail invalid snapshot parameters",
+			groupName:  name,
+			volumeIDs:  []string{testVolumeID},
+			parameters: map[string]string{"bad-key": ""},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "fail image snapshot type",
+			groupName:  name,
+			volumeIDs:  []string{testVolumeID},
+			parameters: map[string]string{common.ParameterKeySnapshotType: common.DiskImageType},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "fail source volumes in different projects",
+			groupName:  name,
+			volumeIDs:  []string{testVolumeID, common.CreateZonalVolumeID("other-project", zone, name)},
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "fail not found source volume",
+			groupName:  name,
+			volumeIDs:  []string{testVolumeID, common.CreateZonalVolumeID(project, zone, "non-exist-vol-name")},
+			expErrCode: codes.NotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gceDriver := initGCEDriver(t, []*gce.CloudDisk{createZonalCloudDisk(name)})
+			_, err := gceDriver.cs.CreateVolumeGroupSnapshot(context.Background(), &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            tc.groupName,
+				SourceVolumeIds: tc.volumeIDs,
+				Parameters:      tc.parameters,
+			})
+			if code := status.Code(err); code != tc.expErrCode {
+				t.Errorf("Expected error code %v, got %v", tc.expErrCode, err)
+			}
+		})
+	}
+}
+
+func TestDeleteVolumeGroupSnapshotInvalidID(t *testing.T) {
+	gceDriver := initGCEDriver(t, nil)
+	_, err := gceDriver.cs.DeleteVolumeGroupSnapshot(context.Background(), &csi.DeleteVolumeGroupSnapshotRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected error code %v for an empty ID, got %v", codes.InvalidArgument, err)
+	}
+	// An ID in the wrong format is a success according to the spec
+	_, err = gceDriver.cs.DeleteVolumeGroupSnapshot(context.Background(), &csi.DeleteVolumeGroupSnapshotRequest{GroupSnapshotId: testSnapshotID})
+	if err != nil {
+		t.Errorf("Expected no error for an invalid ID, got %v", err)
+	}
+}
+
+func TestGroupControllerGetCapabilities(t *testing.T) {
+	gceDriver := initGCEDriver(t, nil)
+	resp, err := gceDriver.cs.GroupControllerGetCapabilities(context.Background(), &csi.GroupControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("GroupControllerGetCapabilities did not expect error, but got %v", err)
+	}
+	capabilities := resp.GetCapabilities()
+	if len(capabilities) != 1 || capabilities[0].GetRpc().GetType() != csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT {
+		t.Errorf("Expected the CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT capability, got %v", capabilities)
+	}
+}
@@ -72,6 +72,7 @@ func TestGetPluginCapabilities(t *testing.T) {
 			switch capability.GetService().GetType() {
 			case csi.PluginCapability_Service_CONTROLLER_SERVICE:
 			case csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS:
+			case csi.PluginCapability_Service_GROUP_CONTROLLER_SERVICE:
 			default:
 				t.Fatalf("Unknown capability: %v", capability.GetService().GetType())
 			}
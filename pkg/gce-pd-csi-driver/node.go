@@ -24,8 +24,10 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/GoogleCloudPlatform/k8s-cloud-provider/pkg/cloud/meta"
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog"
 	"k8s.io/mount-utils"
 
@@ -297,21 +299,73 @@ func (ns *GCENodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStage
 	}
 
 	// Part 3: Mount device to stagingTargetPath
+	encryption := common.NodeEncryptionNone
+	if e, ok := req.GetVolumeContext()[common.VolumeAttributeNodeEncryption]; ok {
+		if err := common.ValidateNodeEncryption(e); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume volume context contains invalid %s: %v", common.VolumeAttributeNodeEncryption, err)
+		}
+		encryption = e
+	}
+
 	fstype := getDefaultFsType()
 
 	options := []string{}
+	var mkfsArgs []string
 	if mnt := volumeCapability.GetMount(); mnt != nil {
 		if mnt.FsType != "" {
 			fstype = mnt.FsType
 		}
 		options = collectMountOptions(fstype, mnt.MountFlags)
+		if mkfsOptions, ok := req.GetVolumeContext()[common.VolumeAttributeMkfsOptions]; ok {
+			mkfsArgs, err = common.ParseMkfsOptions(mkfsOptions, fstype)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume volume context contains invalid %s: %v", common.VolumeAttributeMkfsOptions, err)
+			}
+		}
 	} else if blk := volumeCapability.GetBlock(); blk != nil {
+		if encryption != common.NodeEncryptionNone {
+			return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume %s %q is not supported for block volumes", common.VolumeAttributeNodeEncryption, encryption)
+		}
 		// Noop for Block NodeStageVolume
 		klog.V(4).Infof("NodeStageVolume succeeded on %v to %s, capability is block so this is a no-op", volumeID, stagingTargetPath)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
-	err = formatAndMount(devicePath, stagingTargetPath, fstype, options, ns.Mounter)
+	readOnly := sets.NewString(options...).Has("ro") ||
+		volumeCapability.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY
+
+	if encryption == common.NodeEncryptionLUKS {
+		passphrase := req.GetSecrets()[common.SecretKeyLUKSPassphrase]
+		if passphrase == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume secret %s must be provided for %s %q", common.SecretKeyLUKSPassphrase, common.VolumeAttributeNodeEncryption, encryption)
+		}
+		deviceName, err := common.GetDeviceName(volumeKey)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "error getting device name: %v", err)
+		}
+		// The filesystem is checked, formatted and mounted on the mapper
+		// device rather than the disk.
+		devicePath, err = openLUKSDevice(devicePath, luksMapperName(deviceName), passphrase, readOnly, ns.Mounter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if policy, ok := req.GetVolumeContext()[common.VolumeAttributeFsckOnStage]; ok && policy != common.FsckOnStageNone {
+		if err := common.ValidateFsckOnStage(policy); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "NodeStageVolume volume context contains invalid %s: %v", common.VolumeAttributeFsckOnStage, err)
+		}
+		// Read-only volumes are only checked, as repairing writes to the
+		// device.
+		if policy == common.FsckOnStageRepair && readOnly {
+			policy = common.FsckOnStageCheck
+		}
+		if err := checkFilesystem(devicePath, policy, readOnly, ns.Mounter); err != nil {
+			return nil, err
+		}
+	}
+
+	err = formatAndMount(devicePath, stagingTargetPath, fstype, mkfsArgs, options, ns.Mounter)
 	if err != nil {
 		return nil, status.Error(codes.Internal,
 			fmt.Sprintf("Failed to format and mount device from (%q) to (%q) with fstype (%q) and options (%q): %v",
@@ -342,6 +396,16 @@ func (ns *GCENodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUns
 		return nil, status.Error(codes.Internal, fmt.Sprintf("NodeUnstageVolume failed: %v\nUnmounting arguments: %s\n", err, stagingTargetPath))
 	}
 
+	// Volumes with invalid IDs cannot have been staged, so no LUKS device
+	// can be open for them.
+	if _, volumeKey, err := common.VolumeIDToKey(volumeID); err == nil {
+		if deviceName, err := common.GetDeviceName(volumeKey); err == nil {
+			if err := closeLUKSDevice(luksMapperName(deviceName), ns.Mounter); err != nil {
+				return nil, status.Errorf(codes.Internal, "NodeUnstageVolume failed: %v", err)
+			}
+		}
+	}
+
 	klog.V(4).Infof("NodeUnstageVolume succeeded on %v from %s", volumeID, stagingTargetPath)
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
@@ -394,21 +458,23 @@ func (ns *GCENodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGe
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", req.VolumePath, err)
 		}
-		return &csi.NodeGetVolumeStatsResponse{
+		resp := &csi.NodeGetVolumeStatsResponse{
 			Usage: []*csi.VolumeUsage{
 				{
 					Unit:  csi.VolumeUsage_BYTES,
 					Total: bcap,
 				},
 			},
-		}, nil
+		}
+		ns.setVolumeCondition(resp, req.VolumeId, "")
+		return resp, nil
 	}
 	available, capacity, used, inodesFree, inodes, inodesUsed, err := ns.VolumeStatter.StatFS(req.VolumePath)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get fs info on path %s: %v", req.VolumePath, err)
 	}
 
-	return &csi.NodeGetVolumeStatsResponse{
+	resp := &csi.NodeGetVolumeStatsResponse{
 		Usage: []*csi.VolumeUsage{
 			{
 				Unit:      csi.VolumeUsage_BYTES,
@@ -423,7 +489,63 @@ func (ns *GCENodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGe
 				Used:      inodesUsed,
 			},
 		},
-	}, nil
+	}
+	ns.setVolumeCondition(resp, req.VolumeId, req.GetStagingTargetPath())
+	return resp, nil
+}
+
+// setVolumeCondition sets the condition of the volume in resp, unless the
+// volume ID is invalid. The filesystem of the volume is only checked if it
+// is staged at stagingTargetPath.
+func (ns *GCENodeServer) setVolumeCondition(resp *csi.NodeGetVolumeStatsResponse, volumeID, stagingTargetPath string) {
+	_, volKey, err := common.VolumeIDToKey(volumeID)
+	if err != nil {
+		klog.Warningf("Not checking the condition of volume %s with invalid ID: %v", volumeID, err)
+		return
+	}
+	condition := ns.volumeCondition(volKey, stagingTargetPath)
+	if condition.Abnormal {
+		klog.Warningf("Volume %s is abnormal: %s", volumeID, condition.Message)
+	}
+	resp.VolumeCondition = condition
+}
+
+// volumeCondition checks the health of the disk of a volume. The volume is
+// abnormal if the disk's device is missing or is another disk, if the
+// filesystem staged at stagingTargetPath has become read-only on a writable
+// disk, which happens after I/O errors, or if the filesystem has recorded
+// errors.
+func (ns *GCENodeServer) volumeCondition(volKey *meta.Key, stagingTargetPath string) *csi.VolumeCondition {
+	deviceName, err := common.GetDeviceName(volKey)
+	if err != nil {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Failed to get device name of disk %s: %v", volKey.Name, err)}
+	}
+	devicePath, err := checkVolumeDevice(ns, deviceName)
+	if err != nil {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Device of disk %s is missing or is another disk: %v", volKey.Name, err)}
+	}
+	if devicePath == "" || stagingTargetPath == "" {
+		return &csi.VolumeCondition{Message: "Volume is healthy"}
+	}
+
+	readOnly, err := ns.VolumeStatter.IsReadOnly(stagingTargetPath)
+	if err != nil {
+		klog.Warningf("Failed to check whether the filesystem at %s is read-only: %v", stagingTargetPath, err)
+	} else if readOnly {
+		deviceReadOnly, err := ns.VolumeStatter.IsDeviceReadOnly(devicePath)
+		if err != nil {
+			klog.Warningf("Failed to check whether device %s is read-only: %v", devicePath, err)
+		} else if !deviceReadOnly {
+			return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Filesystem at %s is read-only on writable disk %s, which happens after I/O errors", stagingTargetPath, volKey.Name)}
+		}
+	}
+	fsErrors, err := ns.VolumeStatter.FilesystemErrors(devicePath)
+	if err != nil {
+		klog.Warningf("Failed to get the filesystem errors of device %s: %v", devicePath, err)
+	} else if fsErrors > 0 {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Filesystem on disk %s has recorded %d errors", volKey.Name, fsErrors)}
+	}
+	return &csi.VolumeCondition{Message: "Volume is healthy"}
 }
 
 func (ns *GCENodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
@@ -447,6 +569,13 @@ func (ns *GCENodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpa
 		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("volume ID is invalid: %v", err))
 	}
 
+	if _, err := os.Lstat(volumePath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "volume path %s does not exist", volumePath)
+		}
+		return nil, status.Errorf(codes.Internal, "unknown error when stat on %s: %v", volumePath, err)
+	}
+
 	devicePath, err := getDevicePath(ns, volumeID, "")
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("error when getting device path for %s: %v", volumeID, err))
@@ -466,15 +595,33 @@ func (ns *GCENodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpa
 		}
 	}
 
+	// The mapper device of a volume encrypted with LUKS is resized to the
+	// disk before the filesystem on it. Requests have no volume context, so
+	// whether the volume is encrypted is found from the open devices.
+	deviceName, err := common.GetDeviceName(volKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error getting device name: %v", err)
+	}
+	fsDevicePath, err := resizeLUKSDevice(luksMapperName(deviceName), ns.Mounter)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error when resizing volume %s: %v", volKey.String(), err)
+	}
+	if fsDevicePath == "" {
+		fsDevicePath = devicePath
+	}
+
 	// TODO(#328): Use requested size in resize if provided
 	resizer := resizefs.NewResizeFs(ns.Mounter)
-	_, err = resizer.Resize(devicePath, volumePath)
+	_, err = resizer.Resize(fsDevicePath, volumePath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("error when resizing volume %s: %v", volKey.String(), err))
 
 	}
 
 	diskSizeBytes, err := getBlockSizeBytes(devicePath, ns.Mounter)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "error when getting size of volume %s: %v", volKey.String(), err)
+	}
 	if diskSizeBytes < reqBytes {
 		// It's possible that the somewhere the volume size was rounded up, getting more size than requested is a success :)
 		return nil, status.Errorf(codes.Internal, "resize requested for %v but after resize volume was size %v", reqBytes, diskSizeBytes)
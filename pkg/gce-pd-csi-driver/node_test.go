@@ -15,15 +15,24 @@ package gceGCEDriver
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/mount-utils"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
 	metadataservice "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-cloud-provider/metadata"
 	mountmanager "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/mount-manager"
 )
@@ -451,6 +460,716 @@ func TestNodeStageVolume(t *testing.T) {
 	}
 }
 
+// execStep is a command that a scripted fake exec expects to be run, and the
+// output and error that the command returns. If stdin is set, the command
+// is expected to be given it as its input.
+type execStep struct {
+	cmd    string
+	output string
+	err    error
+	stdin  string
+}
+
+// newScriptedExec returns a fake exec that answers the commands run with it
+// from script, in order, and records the command line of each of them.
+func newScriptedExec(t *testing.T, script []execStep) (*testingexec.FakeExec, *[][]string) {
+	calls := &[][]string{}
+	fakeExec := &testingexec.FakeExec{}
+	for _, step := range script {
+		step := step
+		fakeExec.CommandScript = append(fakeExec.CommandScript, func(cmd string, args ...string) exec.Cmd {
+			*calls = append(*calls, append([]string{cmd}, args...))
+			if cmd != step.cmd {
+				t.Errorf("Ran %s %v, expected %s", cmd, args, step.cmd)
+			}
+			fakeCmd := &testingexec.FakeCmd{}
+			fakeCmd.CombinedOutputScript = []testingexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					if step.stdin != "" {
+						var stdin []byte
+						if fakeCmd.Stdin != nil {
+							stdin, _ = ioutil.ReadAll(fakeCmd.Stdin)
+						}
+						if string(stdin) != step.stdin {
+							t.Errorf("Ran %s %v with input %q, expected %q", cmd, args, stdin, step.stdin)
+						}
+					}
+					return []byte(step.output), nil, step.err
+				},
+			}
+			return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+		})
+	}
+	return fakeExec, calls
+}
+
+// blkid exits with code 2 for devices that are not formatted.
+var unformattedBlkidError = exec.CodeExitError{Err: errors.New("exit status 2"), Code: 2}
+
+func TestNodeStageVolumeMkfsOptions(t *testing.T) {
+	mountCapability := func(fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		}
+	}
+	testCases := []struct {
+		name        string
+		fsType      string
+		mkfsOptions string
+		script      []execStep
+		expMkfsArgs []string
+		expErrCode  codes.Code
+	}{
+		{
+			name:        "unformatted ext4 device",
+			mkfsOptions: "-i 65536 -m 1",
+			script: []execStep{
+				{cmd: "blkid", err: unformattedBlkidError},
+				{cmd: "mkfs.ext4"},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+			expMkfsArgs: []string{"mkfs.ext4", "-F", "-m0", "-i", "65536", "-m", "1"},
+		},
+		{
+			name:        "unformatted xfs device",
+			fsType:      "xfs",
+			mkfsOptions: "-b size=4096 -m reflink=1",
+			script: []execStep{
+				{cmd: "blkid", err: unformattedBlkidError},
+				{cmd: "mkfs.xfs"},
+				{cmd: "blkid", output: "TYPE=xfs"},
+				{cmd: "fsck"},
+			},
+			expMkfsArgs: []string{"mkfs.xfs", "-b", "size=4096", "-m", "reflink=1"},
+		},
+		{
+			name:        "formatted device is not reformatted",
+			mkfsOptions: "-i 65536",
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+		},
+		{
+			name:        "options of another filesystem type",
+			fsType:      "xfs",
+			mkfsOptions: "-O ^has_journal",
+			expErrCode:  codes.InvalidArgument,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeExec, calls := newScriptedExec(t, tc.script)
+			gceDriver := getTestGCEDriverWithCustomMounter(t, mountmanager.NewFakeSafeMounterWithCustomExec(fakeExec))
+			stagingPath := filepath.Join(t.TempDir(), defaultStagingPath)
+
+			_, err := gceDriver.ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          defaultVolumeID,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  mountCapability(tc.fsType),
+				VolumeContext:     map[string]string{common.VolumeAttributeMkfsOptions: tc.mkfsOptions},
+			})
+			if status.Code(err) != tc.expErrCode {
+				t.Fatalf("Got error %v, expected code %v", err, tc.expErrCode)
+			}
+			if len(*calls) != len(tc.script) {
+				t.Fatalf("Ran commands %v, expected %d commands", *calls, len(tc.script))
+			}
+			var mkfsArgs []string
+			for _, call := range *calls {
+				if strings.HasPrefix(call[0], "mkfs.") {
+					// The last argument is the device.
+					mkfsArgs = call[:len(call)-1]
+				}
+			}
+			if !reflect.DeepEqual(mkfsArgs, tc.expMkfsArgs) {
+				t.Errorf("Ran %v, expected %v", mkfsArgs, tc.expMkfsArgs)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumeFsckOnStage(t *testing.T) {
+	exitError := func(code int) error {
+		return exec.CodeExitError{Err: fmt.Errorf("exit status %d", code), Code: code}
+	}
+	xfsCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{FsType: "xfs"},
+		},
+		AccessMode: stdVolCap.GetAccessMode(),
+	}
+	readOnlyCapability := &csi.VolumeCapability{
+		AccessType: stdVolCap.GetAccessType(),
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		},
+	}
+	testCases := []struct {
+		name          string
+		policy        string
+		capability    *csi.VolumeCapability
+		script        []execStep
+		expCheckCmds  [][]string
+		expReplayLog  bool
+		expErrCode    codes.Code
+		expErrMessage string
+	}{
+		{
+			name:   "none",
+			policy: common.FsckOnStageNone,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+		},
+		{
+			name:   "check clean ext4",
+			policy: common.FsckOnStageCheck,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "e2fsck"},
+				{cmd: "e2fsck"},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+			expCheckCmds: [][]string{{"e2fsck", "-p", "-E", "journal_only"}, {"e2fsck", "-n"}},
+		},
+		{
+			name:   "check ext4 after replaying its journal",
+			policy: common.FsckOnStageCheck,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "e2fsck", output: "recovering journal", err: exitError(1)},
+				{cmd: "e2fsck"},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+			expCheckCmds: [][]string{{"e2fsck", "-p", "-E", "journal_only"}, {"e2fsck", "-n"}},
+		},
+		{
+			name:   "ext4 journal cannot be replayed",
+			policy: common.FsckOnStageCheck,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "e2fsck", output: "journal superblock is corrupt", err: exitError(4)},
+			},
+			expCheckCmds:  [][]string{{"e2fsck", "-p", "-E", "journal_only"}},
+			expErrCode:    codes.FailedPrecondition,
+			expErrMessage: "journal superblock is corrupt",
+		},
+		{
+			name:   "check corrupted ext4",
+			policy: common.FsckOnStageCheck,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "e2fsck"},
+				{cmd: "e2fsck", output: "Inode 12 has illegal blocks", err: exitError(4)},
+			},
+			expCheckCmds:  [][]string{{"e2fsck", "-p", "-E", "journal_only"}, {"e2fsck", "-n"}},
+			expErrCode:    codes.FailedPrecondition,
+			expErrMessage: "Inode 12 has illegal blocks",
+		},
+		{
+			name:   "repair ext4",
+			policy: common.FsckOnStageRepair,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "e2fsck"},
+				{cmd: "e2fsck", err: exitError(1)},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+			expCheckCmds: [][]string{{"e2fsck", "-p", "-E", "journal_only"}, {"e2fsck", "-y"}},
+		},
+		{
+			name:   "repair ext4 fails",
+			policy: common.FsckOnStageRepair,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "e2fsck"},
+				{cmd: "e2fsck", err: exitError(4)},
+			},
+			expCheckCmds: [][]string{{"e2fsck", "-p", "-E", "journal_only"}, {"e2fsck", "-y"}},
+			expErrCode:   codes.FailedPrecondition,
+		},
+		{
+			name:   "e2fsck operational error",
+			policy: common.FsckOnStageCheck,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "e2fsck"},
+				{cmd: "e2fsck", err: exitError(8)},
+			},
+			expCheckCmds: [][]string{{"e2fsck", "-p", "-E", "journal_only"}, {"e2fsck", "-n"}},
+			expErrCode:   codes.Internal,
+		},
+		{
+			name:       "check corrupted xfs",
+			policy:     common.FsckOnStageCheck,
+			capability: xfsCapability,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=xfs"},
+				{cmd: "xfs_repair", err: exitError(1)},
+			},
+			expCheckCmds: [][]string{{"xfs_repair", "-n"}},
+			expReplayLog: true,
+			expErrCode:   codes.FailedPrecondition,
+		},
+		{
+			name:       "repair xfs",
+			policy:     common.FsckOnStageRepair,
+			capability: xfsCapability,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=xfs"},
+				{cmd: "xfs_repair"},
+				{cmd: "blkid", output: "TYPE=xfs"},
+				{cmd: "fsck"},
+			},
+			expCheckCmds: [][]string{{"xfs_repair"}},
+			expReplayLog: true,
+		},
+		{
+			name:       "repair xfs with log still dirty after replaying it",
+			policy:     common.FsckOnStageRepair,
+			capability: xfsCapability,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=xfs"},
+				{cmd: "xfs_repair", err: exitError(2)},
+			},
+			expCheckCmds:  [][]string{{"xfs_repair"}},
+			expReplayLog:  true,
+			expErrCode:    codes.FailedPrecondition,
+			expErrMessage: "log is dirty",
+		},
+		{
+			name:       "read-only volume is only checked",
+			policy:     common.FsckOnStageRepair,
+			capability: readOnlyCapability,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "e2fsck", err: exitError(4)},
+			},
+			expCheckCmds: [][]string{{"e2fsck", "-n"}},
+			expErrCode:   codes.FailedPrecondition,
+		},
+		{
+			name:   "unformatted device is not checked",
+			policy: common.FsckOnStageCheck,
+			script: []execStep{
+				{cmd: "blkid", err: unformattedBlkidError},
+				{cmd: "blkid", err: unformattedBlkidError},
+				{cmd: "mkfs.ext4"},
+			},
+		},
+		{
+			name:       "invalid policy",
+			policy:     "always",
+			expErrCode: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeExec, calls := newScriptedExec(t, tc.script)
+			fakeMounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{}}
+			gceDriver := getTestGCEDriverWithCustomMounter(t, mountmanager.NewCustomFakeSafeMounter(fakeMounter, fakeExec))
+			capability := tc.capability
+			if capability == nil {
+				capability = stdVolCap
+			}
+
+			_, err := gceDriver.ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          defaultVolumeID,
+				StagingTargetPath: filepath.Join(t.TempDir(), defaultStagingPath),
+				VolumeCapability:  capability,
+				VolumeContext:     map[string]string{common.VolumeAttributeFsckOnStage: tc.policy},
+			})
+			if status.Code(err) != tc.expErrCode {
+				t.Fatalf("Got error %v, expected code %v", err, tc.expErrCode)
+			}
+			if !strings.Contains(status.Convert(err).Message(), tc.expErrMessage) {
+				t.Errorf("Got error %v, expected it to contain %q", err, tc.expErrMessage)
+			}
+			if len(*calls) != len(tc.script) {
+				t.Fatalf("Ran commands %v, expected %d commands", *calls, len(tc.script))
+			}
+			var checkCmds [][]string
+			for _, call := range *calls {
+				if call[0] == "e2fsck" || call[0] == "xfs_repair" {
+					// The last argument is the device.
+					checkCmds = append(checkCmds, call[:len(call)-1])
+				}
+			}
+			if !reflect.DeepEqual(checkCmds, tc.expCheckCmds) {
+				t.Errorf("Ran %v, expected %v", checkCmds, tc.expCheckCmds)
+			}
+			// The xfs log is replayed by mounting and unmounting the device
+			// before it is checked.
+			log := fakeMounter.GetLog()
+			replayedLog := len(log) >= 2 && log[0].Action == mount.FakeActionMount && log[0].FSType == "xfs" &&
+				log[1].Action == mount.FakeActionUnmount && log[1].Target == log[0].Target
+			if replayedLog != tc.expReplayLog {
+				t.Errorf("Got mounter actions %v, expected log replayed %v", log, tc.expReplayLog)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumeLUKS(t *testing.T) {
+	const (
+		passphrase = "correct horse battery staple"
+		diskPath   = "/dev/disk/fake-path"
+		mapperPath = "/dev/mapper/luks-testDisk"
+	)
+	inactiveError := exec.CodeExitError{Err: errors.New("exit status 4"), Code: 4}
+	readOnlyCapability := &csi.VolumeCapability{
+		AccessType: stdVolCap.GetAccessType(),
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY,
+		},
+	}
+	blockCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: stdVolCap.GetAccessMode(),
+	}
+	testCases := []struct {
+		name          string
+		encryption    string
+		capability    *csi.VolumeCapability
+		secrets       map[string]string
+		script        []execStep
+		expCalls      [][]string
+		expErrCode    codes.Code
+		expErrMessage string
+	}{
+		{
+			name:       "format and open new device",
+			encryption: common.NodeEncryptionLUKS,
+			script: []execStep{
+				{cmd: "cryptsetup", err: inactiveError},
+				{cmd: "blkid", err: unformattedBlkidError},
+				{cmd: "cryptsetup", stdin: passphrase},
+				{cmd: "cryptsetup", stdin: passphrase},
+				{cmd: "blkid", err: unformattedBlkidError},
+				{cmd: "mkfs.ext4"},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath},
+				{"cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2", "--key-file=-", diskPath},
+				{"cryptsetup", "open", "--type", "luks", "--key-file=-", "--disable-keyring", diskPath, "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", mapperPath},
+				{"mkfs.ext4", "-F", "-m0", mapperPath},
+			},
+		},
+		{
+			name:       "open formatted device",
+			encryption: common.NodeEncryptionLUKS,
+			script: []execStep{
+				{cmd: "cryptsetup", err: inactiveError},
+				{cmd: "blkid", output: "TYPE=crypto_LUKS"},
+				{cmd: "cryptsetup", stdin: passphrase},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath},
+				{"cryptsetup", "open", "--type", "luks", "--key-file=-", "--disable-keyring", diskPath, "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", mapperPath},
+				{"fsck", "-a", mapperPath},
+			},
+		},
+		{
+			name:       "device is already open",
+			encryption: common.NodeEncryptionLUKS,
+			script: []execStep{
+				{cmd: "cryptsetup"},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", mapperPath},
+				{"fsck", "-a", mapperPath},
+			},
+		},
+		{
+			name:       "read-only device is opened read-only",
+			encryption: common.NodeEncryptionLUKS,
+			capability: readOnlyCapability,
+			script: []execStep{
+				{cmd: "cryptsetup", err: inactiveError},
+				{cmd: "blkid", output: "TYPE=crypto_LUKS"},
+				{cmd: "cryptsetup", stdin: passphrase},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath},
+				{"cryptsetup", "open", "--type", "luks", "--key-file=-", "--disable-keyring", "--readonly", diskPath, "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", mapperPath},
+				{"fsck", "-a", mapperPath},
+			},
+		},
+		{
+			name:       "read-only device is not formatted",
+			encryption: common.NodeEncryptionLUKS,
+			capability: readOnlyCapability,
+			script: []execStep{
+				{cmd: "cryptsetup", err: inactiveError},
+				{cmd: "blkid", err: unformattedBlkidError},
+			},
+			expErrCode: codes.FailedPrecondition,
+		},
+		{
+			name:       "wrong passphrase",
+			encryption: common.NodeEncryptionLUKS,
+			script: []execStep{
+				{cmd: "cryptsetup", err: inactiveError},
+				{cmd: "blkid", output: "TYPE=crypto_LUKS"},
+				{cmd: "cryptsetup", output: "No key available with this passphrase.", err: exec.CodeExitError{Err: errors.New("exit status 2"), Code: 2}},
+			},
+			expErrCode:    codes.InvalidArgument,
+			expErrMessage: "does not unlock",
+		},
+		{
+			name:       "device with another format is not reformatted",
+			encryption: common.NodeEncryptionLUKS,
+			script: []execStep{
+				{cmd: "cryptsetup", err: inactiveError},
+				{cmd: "blkid", output: "TYPE=ext4"},
+			},
+			expErrCode:    codes.FailedPrecondition,
+			expErrMessage: "formatted as ext4",
+		},
+		{
+			name:          "missing passphrase",
+			encryption:    common.NodeEncryptionLUKS,
+			secrets:       map[string]string{},
+			expErrCode:    codes.InvalidArgument,
+			expErrMessage: common.SecretKeyLUKSPassphrase,
+		},
+		{
+			name:       "block volume",
+			encryption: common.NodeEncryptionLUKS,
+			capability: blockCapability,
+			expErrCode: codes.InvalidArgument,
+		},
+		{
+			name:       "not encrypted",
+			encryption: common.NodeEncryptionNone,
+			script: []execStep{
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "fsck"},
+			},
+			expCalls: [][]string{
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", diskPath},
+				{"fsck", "-a", diskPath},
+			},
+		},
+		{
+			name:       "invalid encryption",
+			encryption: "dm-crypt",
+			expErrCode: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeExec, calls := newScriptedExec(t, tc.script)
+			gceDriver := getTestGCEDriverWithCustomMounter(t, mountmanager.NewFakeSafeMounterWithCustomExec(fakeExec))
+			capability := tc.capability
+			if capability == nil {
+				capability = stdVolCap
+			}
+			secrets := tc.secrets
+			if secrets == nil {
+				secrets = map[string]string{common.SecretKeyLUKSPassphrase: passphrase}
+			}
+
+			_, err := gceDriver.ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          defaultVolumeID,
+				StagingTargetPath: filepath.Join(t.TempDir(), defaultStagingPath),
+				VolumeCapability:  capability,
+				VolumeContext:     map[string]string{common.VolumeAttributeNodeEncryption: tc.encryption},
+				Secrets:           secrets,
+			})
+			if status.Code(err) != tc.expErrCode {
+				t.Fatalf("Got error %v, expected code %v", err, tc.expErrCode)
+			}
+			if !strings.Contains(status.Convert(err).Message(), tc.expErrMessage) {
+				t.Errorf("Got error %v, expected it to contain %q", err, tc.expErrMessage)
+			}
+			if len(*calls) != len(tc.script) {
+				t.Fatalf("Ran commands %v, expected %d commands", *calls, len(tc.script))
+			}
+			if tc.expCalls != nil && !reflect.DeepEqual(*calls, tc.expCalls) {
+				t.Errorf("Ran commands %v, expected %v", *calls, tc.expCalls)
+			}
+		})
+	}
+}
+
+func TestNodeUnstageVolumeLUKS(t *testing.T) {
+	testCases := []struct {
+		name       string
+		script     []execStep
+		expCalls   [][]string
+		expErrCode codes.Code
+	}{
+		{
+			name: "open device is closed",
+			script: []execStep{
+				{cmd: "cryptsetup"},
+				{cmd: "cryptsetup"},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+				{"cryptsetup", "close", "luks-testDisk"},
+			},
+		},
+		{
+			name: "device that is not open",
+			script: []execStep{
+				{cmd: "cryptsetup", err: exec.CodeExitError{Err: errors.New("exit status 4"), Code: 4}},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+			},
+		},
+		{
+			name: "cryptsetup is not installed",
+			script: []execStep{
+				{cmd: "cryptsetup", err: exec.ErrExecutableNotFound},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+			},
+		},
+		{
+			name: "close fails",
+			script: []execStep{
+				{cmd: "cryptsetup"},
+				{cmd: "cryptsetup", output: "Device luks-testDisk is still in use.", err: exec.CodeExitError{Err: errors.New("exit status 5"), Code: 5}},
+			},
+			expErrCode: codes.Internal,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeExec, calls := newScriptedExec(t, tc.script)
+			gceDriver := getTestGCEDriverWithCustomMounter(t, mountmanager.NewFakeSafeMounterWithCustomExec(fakeExec))
+
+			_, err := gceDriver.ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+				VolumeId:          defaultVolumeID,
+				StagingTargetPath: filepath.Join(t.TempDir(), defaultStagingPath),
+			})
+			if status.Code(err) != tc.expErrCode {
+				t.Fatalf("Got error %v, expected code %v", err, tc.expErrCode)
+			}
+			if len(*calls) != len(tc.script) {
+				t.Fatalf("Ran commands %v, expected %d commands", *calls, len(tc.script))
+			}
+			if tc.expCalls != nil && !reflect.DeepEqual(*calls, tc.expCalls) {
+				t.Errorf("Ran commands %v, expected %v", *calls, tc.expCalls)
+			}
+		})
+	}
+}
+
+func TestNodeExpandVolumeLUKS(t *testing.T) {
+	const resizedBytes = 2000000000
+	testCases := []struct {
+		name       string
+		volumePath string
+		script     []execStep
+		expCalls   [][]string
+		expErrCode codes.Code
+	}{
+		{
+			name: "open device is resized before filesystem",
+			script: []execStep{
+				{cmd: "cryptsetup"},
+				{cmd: "cryptsetup"},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "resize2fs"},
+				{cmd: "blockdev", output: strconv.Itoa(resizedBytes)},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+				{"cryptsetup", "resize", "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", "/dev/mapper/luks-testDisk"},
+				{"resize2fs", "/dev/mapper/luks-testDisk"},
+				{"blockdev", "--getsize64", "/dev/disk/fake-path"},
+			},
+		},
+		{
+			name: "volume that is not encrypted",
+			script: []execStep{
+				{cmd: "cryptsetup", err: exec.CodeExitError{Err: errors.New("exit status 4"), Code: 4}},
+				{cmd: "blkid", output: "TYPE=ext4"},
+				{cmd: "resize2fs"},
+				{cmd: "blockdev", output: strconv.Itoa(resizedBytes)},
+			},
+			expCalls: [][]string{
+				{"cryptsetup", "status", "luks-testDisk"},
+				{"blkid", "-p", "-s", "TYPE", "-s", "PTTYPE", "-o", "export", "/dev/disk/fake-path"},
+				{"resize2fs", "/dev/disk/fake-path"},
+				{"blockdev", "--getsize64", "/dev/disk/fake-path"},
+			},
+		},
+		{
+			name: "resize of device fails",
+			script: []execStep{
+				{cmd: "cryptsetup"},
+				{cmd: "cryptsetup", err: exec.CodeExitError{Err: errors.New("exit status 1"), Code: 1}},
+			},
+			expErrCode: codes.Internal,
+		},
+		{
+			name:       "volume path that does not exist",
+			volumePath: "/mnt/missing",
+			expErrCode: codes.NotFound,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeExec, calls := newScriptedExec(t, tc.script)
+			gceDriver := getTestGCEDriverWithCustomMounter(t, mountmanager.NewFakeSafeMounterWithCustomExec(fakeExec))
+
+			volumePath := tc.volumePath
+			if volumePath == "" {
+				volumePath = t.TempDir()
+			}
+			resp, err := gceDriver.ns.NodeExpandVolume(context.Background(), &csi.NodeExpandVolumeRequest{
+				VolumeId:      defaultVolumeID,
+				VolumePath:    volumePath,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: resizedBytes},
+			})
+			if status.Code(err) != tc.expErrCode {
+				t.Fatalf("Got error %v, expected code %v", err, tc.expErrCode)
+			}
+			if err == nil && resp.GetCapacityBytes() != resizedBytes {
+				t.Errorf("Got capacity %d, expected %d", resp.GetCapacityBytes(), resizedBytes)
+			}
+			if len(*calls) != len(tc.script) {
+				t.Fatalf("Ran commands %v, expected %d commands", *calls, len(tc.script))
+			}
+			if tc.expCalls != nil && !reflect.DeepEqual(*calls, tc.expCalls) {
+				t.Errorf("Ran commands %v, expected %v", *calls, tc.expCalls)
+			}
+		})
+	}
+}
+
 // TODO: This test is too brittle due to the fakeexec package not being
 // expressive enough for our purposes. The main issue being that the actions
 // executed by fakeexec are executed in order of definition instead of by
@@ -775,3 +1494,127 @@ func TestConcurrentNodeOperations(t *testing.T) {
 		t.Errorf("Unexpected error: %v", err)
 	}
 }
+
+func TestNodeGetVolumeStatsCondition(t *testing.T) {
+	const fakeDevicePath = "/dev/disk/fake-path"
+	tempDir, err := ioutil.TempDir("", "ngvsc")
+	if err != nil {
+		t.Fatalf("Failed to set up temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	stagingPath := filepath.Join(tempDir, defaultStagingPath)
+
+	testCases := []struct {
+		name              string
+		volumeID          string
+		stagingTargetPath string
+		devicePathErr     error
+		readOnlyPaths     []string
+		filesystemErrors  int64
+		expCondition      *csi.VolumeCondition
+	}{
+		{
+			name:              "healthy",
+			volumeID:          testVolumeID,
+			stagingTargetPath: stagingPath,
+			expCondition:      &csi.VolumeCondition{Message: "Volume is healthy"},
+		},
+		{
+			name:              "device missing",
+			volumeID:          testVolumeID,
+			stagingTargetPath: stagingPath,
+			devicePathErr:     fmt.Errorf("none of the device paths exists"),
+			expCondition: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Device of disk %s is missing or is another disk: none of the device paths exists", name),
+			},
+		},
+		{
+			name:              "filesystem read-only on writable disk",
+			volumeID:          testVolumeID,
+			stagingTargetPath: stagingPath,
+			readOnlyPaths:     []string{stagingPath},
+			expCondition: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Filesystem at %s is read-only on writable disk %s, which happens after I/O errors", stagingPath, name),
+			},
+		},
+		{
+			name:              "filesystem read-only on read-only disk",
+			volumeID:          testVolumeID,
+			stagingTargetPath: stagingPath,
+			readOnlyPaths:     []string{stagingPath, fakeDevicePath},
+			expCondition:      &csi.VolumeCondition{Message: "Volume is healthy"},
+		},
+		{
+			name:              "filesystem errors",
+			volumeID:          testVolumeID,
+			stagingTargetPath: stagingPath,
+			filesystemErrors:  3,
+			expCondition: &csi.VolumeCondition{
+				Abnormal: true,
+				Message:  fmt.Sprintf("Filesystem on disk %s has recorded 3 errors", name),
+			},
+		},
+		{
+			name:             "filesystem not checked without staging path",
+			volumeID:         testVolumeID,
+			readOnlyPaths:    []string{stagingPath},
+			filesystemErrors: 3,
+			expCondition:     &csi.VolumeCondition{Message: "Volume is healthy"},
+		},
+		{
+			name:              "invalid volume ID",
+			volumeID:          "invalid-id",
+			stagingTargetPath: stagingPath,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mounter := mountmanager.NewFakeSafeMounter()
+			deviceUtils := mountmanager.NewFakeDeviceUtils()
+			if tc.devicePathErr != nil {
+				deviceUtils.SetDevicePathError(name, tc.devicePathErr)
+			}
+			statter := mountmanager.NewFakeStatter(mounter)
+			for _, path := range tc.readOnlyPaths {
+				statter.SetReadOnly(path)
+			}
+			statter.SetFilesystemErrors(fakeDevicePath, tc.filesystemErrors)
+			gceDriver := GetGCEDriver()
+			ns := NewNodeServer(gceDriver, mounter, deviceUtils, metadataservice.NewFakeService(), statter)
+			if err := gceDriver.SetupGCEDriver(driver, "test-vendor", nil, nil, nil, ns); err != nil {
+				t.Fatalf("Failed to setup GCE Driver: %v", err)
+			}
+
+			resp, err := ns.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+				VolumeId:          tc.volumeID,
+				VolumePath:        tempDir,
+				StagingTargetPath: tc.stagingTargetPath,
+			})
+			if err != nil {
+				t.Fatalf("NodeGetVolumeStats failed: %v", err)
+			}
+			if condition := resp.GetVolumeCondition(); !reflect.DeepEqual(condition, tc.expCondition) {
+				t.Errorf("Got condition %+v, expected %+v", condition, tc.expCondition)
+			}
+		})
+	}
+}
+
+func TestNodeGetCapabilitiesVolumeCondition(t *testing.T) {
+	gceDriver := getTestGCEDriver(t)
+	resp, err := gceDriver.ns.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetCapabilities failed: %v", err)
+	}
+	found := false
+	for _, capability := range resp.GetCapabilities() {
+		if capability.GetRpc().GetType() == csi.NodeServiceCapability_RPC_VOLUME_CONDITION {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Got capabilities %v, expected VOLUME_CONDITION", resp.GetCapabilities())
+	}
+}
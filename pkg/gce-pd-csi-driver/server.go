@@ -30,7 +30,7 @@ import (
 // Defines Non blocking GRPC server interfaces
 type NonBlockingGRPCServer interface {
 	// Start services at the endpoint
-	Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer)
+	Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, gcs csi.GroupControllerServer, ns csi.NodeServer)
 	// Waits for the service to stop
 	Wait()
 	// Stops the service gracefully
@@ -39,21 +39,22 @@ type NonBlockingGRPCServer interface {
 	ForceStop()
 }
 
-func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
-	return &nonBlockingGRPCServer{}
+func NewNonBlockingGRPCServer(logConfig RPCLogConfig) NonBlockingGRPCServer {
+	return &nonBlockingGRPCServer{logConfig: logConfig}
 }
 
 // NonBlocking server
 type nonBlockingGRPCServer struct {
-	wg     sync.WaitGroup
-	server *grpc.Server
+	wg        sync.WaitGroup
+	server    *grpc.Server
+	logConfig RPCLogConfig
 }
 
-func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, gcs csi.GroupControllerServer, ns csi.NodeServer) {
 
 	s.wg.Add(1)
 
-	go s.serve(endpoint, ids, cs, ns)
+	go s.serve(endpoint, ids, cs, gcs, ns)
 
 	return
 }
@@ -70,9 +71,9 @@ func (s *nonBlockingGRPCServer) ForceStop() {
 	s.server.Stop()
 }
 
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, gcs csi.GroupControllerServer, ns csi.NodeServer) {
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		grpc.ChainUnaryInterceptor(traceGRPC, s.logConfig.logGRPC),
 	}
 
 	u, err := url.Parse(endpoint)
@@ -118,6 +119,9 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	if cs != nil {
 		csi.RegisterControllerServer(server, cs)
 	}
+	if gcs != nil {
+		csi.RegisterGroupControllerServer(server, gcs)
+	}
 	if ns != nil {
 		csi.RegisterNodeServer(server, ns)
 	}
@@ -19,12 +19,25 @@ package gceGCEDriver
 import (
 	"errors"
 	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"context"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"k8s.io/klog"
+	klogv2 "k8s.io/klog/v2"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/metrics"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/tracing"
 )
 
 const (
@@ -47,6 +60,16 @@ func NewControllerServiceCapability(cap csi.ControllerServiceCapability_RPC_Type
 	}
 }
 
+func NewGroupControllerServiceCapability(cap csi.GroupControllerServiceCapability_RPC_Type) *csi.GroupControllerServiceCapability {
+	return &csi.GroupControllerServiceCapability{
+		Type: &csi.GroupControllerServiceCapability_Rpc{
+			Rpc: &csi.GroupControllerServiceCapability_RPC{
+				Type: cap,
+			},
+		},
+	}
+}
+
 func NewNodeServiceCapability(cap csi.NodeServiceCapability_RPC_Type) *csi.NodeServiceCapability {
 	return &csi.NodeServiceCapability{
 		Type: &csi.NodeServiceCapability_Rpc{
@@ -57,23 +80,198 @@ func NewNodeServiceCapability(cap csi.NodeServiceCapability_RPC_Type) *csi.NodeS
 	}
 }
 
-func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+// defaultRPCLogVerbosity is the klog verbosity at which RPCs are logged,
+// unless it is configured for their method.
+const defaultRPCLogVerbosity klog.Level = 4
+
+// RPCLogConfig configures the logging of CSI RPCs. Failed RPCs are always
+// logged.
+type RPCLogConfig struct {
+	// Structured logs each RPC as a single entry with klog's structured API,
+	// with the method, volume ID, node ID, duration and status code of the
+	// RPC as separate keys.
+	Structured bool
+	// MethodVerbosity is the klog verbosity at which RPCs of each method,
+	// for example NodeGetVolumeStats, are logged. Other methods are logged
+	// at verbosity 4.
+	MethodVerbosity map[string]klog.Level
+}
+
+// ParseMethodVerbosity parses a comma separated list of method and
+// verbosity pairs like 'NodeGetVolumeStats=6,NodeGetCapabilities=5'.
+func ParseMethodVerbosity(str string) (map[string]klog.Level, error) {
+	verbosity := map[string]klog.Level{}
+	if str == "" {
+		return verbosity, nil
+	}
+	for _, pair := range strings.Split(str, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%q is not a method=verbosity pair", pair)
+		}
+		level, err := strconv.ParseInt(kv[1], 10, 32)
+		if err != nil || level < 0 {
+			return nil, fmt.Errorf("verbosity %q of method %s is not a non-negative integer", kv[1], kv[0])
+		}
+		verbosity[kv[0]] = klog.Level(level)
+	}
+	return verbosity, nil
+}
+
+// verbosity returns the klog verbosity at which RPCs of the method with the
+// given full name, like /csi.v1.Node/NodeGetVolumeStats, are logged.
+func (c RPCLogConfig) verbosity(fullMethod string) klog.Level {
+	if level, ok := c.MethodVerbosity[path.Base(fullMethod)]; ok {
+		return level
+	}
+	return defaultRPCLogVerbosity
+}
+
+func (c RPCLogConfig) logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	if info.FullMethod == ProbeCSIFullMethod {
 		return handler(ctx, req)
 	}
-	// Note that secrets are not included in any RPC message. In the past protosanitizer and other log
-	// stripping was shown to cause a significant increase of CPU usage (see
+	verbosity := c.verbosity(info.FullMethod)
+	// Secrets, which hold customer-supplied encryption keys, are stripped
+	// from the few requests that have them. In the past protosanitizer and
+	// other log stripping of every request was shown to cause a significant
+	// increase of CPU usage (see
 	// https://github.com/kubernetes-sigs/gcp-compute-persistent-disk-csi-driver/issues/356#issuecomment-550529004).
-	klog.V(4).Infof("%s called with request: %s", info.FullMethod, req)
+	if !c.Structured {
+		klog.V(verbosity).Infof("%s called with request: %s", info.FullMethod, stripSecrets(req))
+	}
+	start := time.Now()
 	resp, err := handler(ctx, req)
+	duration := time.Since(start)
+	code := status.Code(err).String()
+	metrics.RecordCSIOperation(info.FullMethod, code, duration)
+	if c.Structured {
+		volumeID, nodeID := requestIDs(req)
+		keysAndValues := []interface{}{"method", info.FullMethod, "volumeID", volumeID, "nodeID", nodeID, "duration", duration, "code", code}
+		if err != nil {
+			klogv2.ErrorS(err, "RPC failed", append(keysAndValues, "request", stripSecrets(req))...)
+		} else if klog.V(verbosity) {
+			klogv2.InfoS("RPC succeeded", append(keysAndValues, "request", stripSecrets(req), "response", resp)...)
+		}
+		return resp, err
+	}
 	if err != nil {
 		klog.Errorf("%s returned with error: %v", info.FullMethod, err)
 	} else {
-		klog.V(4).Infof("%s returned with response: %s", info.FullMethod, resp)
+		klog.V(verbosity).Infof("%s returned with response: %s", info.FullMethod, resp)
+	}
+	return resp, err
+}
+
+// traceGRPC traces each RPC as a span, which the GCE API calls made for it
+// are traced as children of.
+func traceGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if info.FullMethod == ProbeCSIFullMethod {
+		return handler(ctx, req)
 	}
+	ctx, span := tracing.StartSpan(ctx, info.FullMethod, requestAttributes(req)...)
+	resp, err := handler(ctx, req)
+	tracing.EndSpan(span, err)
 	return resp, err
 }
 
+// requestIDs returns the IDs of the volume and node that a request is for,
+// which are empty if it has none.
+func requestIDs(req interface{}) (volumeID, nodeID string) {
+	if r, ok := req.(interface{ GetVolumeId() string }); ok {
+		volumeID = r.GetVolumeId()
+	}
+	if r, ok := req.(interface{ GetSourceVolumeId() string }); ok && volumeID == "" {
+		volumeID = r.GetSourceVolumeId()
+	}
+	if r, ok := req.(interface{ GetNodeId() string }); ok {
+		nodeID = r.GetNodeId()
+	}
+	return volumeID, nodeID
+}
+
+// requestAttributes returns the span attributes of the volume and node that
+// a request is for.
+func requestAttributes(req interface{}) []attribute.KeyValue {
+	var attributes []attribute.KeyValue
+	volumeID, nodeID := requestIDs(req)
+	if volumeID != "" {
+		attributes = append(attributes, attribute.String(tracing.AttributeVolumeID, volumeID))
+	}
+	if nodeID != "" {
+		attributes = append(attributes, attribute.String(tracing.AttributeNodeID, nodeID))
+	}
+	return attributes
+}
+
+// strippedSecret replaces the values of secrets in logged requests.
+const strippedSecret = "***stripped***"
+
+// stripSecrets returns req, or a copy of it whose secret values are replaced
+// if it has any. Secrets are the fields marked with the csi_secret option of
+// the CSI spec.
+func stripSecrets(req interface{}) interface{} {
+	msg, ok := req.(proto.Message)
+	if !ok || !stripMessageSecrets(proto.MessageReflect(msg), false) {
+		return req
+	}
+	stripped := proto.Clone(msg)
+	stripMessageSecrets(proto.MessageReflect(stripped), true)
+	return stripped
+}
+
+// stripMessageSecrets returns true if the message, or a message nested in
+// it, has a secret field that is set. If strip is true, the values of the
+// secrets are replaced.
+func stripMessageSecrets(msg protoreflect.Message, strip bool) bool {
+	found := false
+	msg.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		switch {
+		case isSecret(field):
+			found = true
+			if strip {
+				stripSecretField(msg, field)
+			}
+		case field.IsMap():
+			// Maps of messages are not used in the CSI spec.
+		case field.IsList() && field.Message() != nil:
+			list := value.List()
+			for i := 0; i < list.Len(); i++ {
+				found = stripMessageSecrets(list.Get(i).Message(), strip) || found
+			}
+		case field.Message() != nil:
+			found = stripMessageSecrets(value.Message(), strip) || found
+		}
+		// Once a secret is found, the rest of the message is only walked to
+		// strip secrets.
+		return strip || !found
+	})
+	return found
+}
+
+func isSecret(field protoreflect.FieldDescriptor) bool {
+	secret, _ := protov2.GetExtension(field.Options(), csi.E_CsiSecret).(bool)
+	return secret
+}
+
+// stripSecretField replaces the value of a secret string field, or of each
+// entry of a secret map field.
+func stripSecretField(msg protoreflect.Message, field protoreflect.FieldDescriptor) {
+	switch {
+	case field.IsMap():
+		secrets := msg.Mutable(field).Map()
+		secrets.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+			secrets.Set(key, protoreflect.ValueOfString(strippedSecret))
+			return true
+		})
+	case field.Kind() == protoreflect.StringKind && !field.IsList():
+		msg.Set(field, protoreflect.ValueOfString(strippedSecret))
+	default:
+		// Never log secrets that cannot be stripped.
+		msg.Clear(field)
+	}
+}
+
 func validateVolumeCapabilities(vcs []*csi.VolumeCapability) error {
 	isMnt := false
 	isBlk := false
@@ -174,3 +372,10 @@ func collectMountOptions(fsType string, mntFlags []string) []string {
 	}
 	return options
 }
+
+// luksMapperName returns the name of the device mapper device that the disk
+// of a volume encrypted with LUKS is opened as, where deviceName is the
+// name of the disk's device.
+func luksMapperName(deviceName string) string {
+	return "luks-" + deviceName
+}
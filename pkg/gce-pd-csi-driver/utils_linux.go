@@ -17,13 +17,17 @@ package gceGCEDriver
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog"
 	"k8s.io/mount-utils"
+	"k8s.io/utils/exec"
 	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
 )
 
@@ -47,10 +51,279 @@ func getDevicePath(ns *GCENodeServer, volumeID, partition string) (string, error
 	return devicePath, nil
 }
 
-func formatAndMount(source, target, fstype string, options []string, m *mount.SafeFormatAndMount) error {
+// checkVolumeDevice returns the device of the disk of a volume, and an
+// error if the disk's by-id device path is missing, or resolves to a device
+// with another SCSI serial.
+func checkVolumeDevice(ns *GCENodeServer, deviceName string) (string, error) {
+	return ns.DeviceUtils.CheckDevicePath(ns.DeviceUtils.GetDiskByIdPaths(deviceName, ""), deviceName)
+}
+
+// formatAndMount mounts the device at target, formatting it first if it is
+// not formatted yet. mkfsArgs are passed to mkfs in addition to the defaults
+// of mount-utils, and are ignored for devices that are formatted, which are
+// never reformatted.
+func formatAndMount(source, target, fstype string, mkfsArgs, options []string, m *mount.SafeFormatAndMount) error {
+	if len(mkfsArgs) > 0 && !sets.NewString(options...).Has("ro") {
+		existingFormat, err := m.GetDiskFormat(source)
+		if err != nil {
+			return fmt.Errorf("failed to get format of device %s: %v", source, err)
+		}
+		if existingFormat == "" {
+			args := []string{}
+			if fstype == "ext3" || fstype == "ext4" {
+				// The defaults of mount-utils, which the options may override.
+				args = append(args, "-F", "-m0")
+			}
+			args = append(append(args, mkfsArgs...), source)
+			klog.V(4).Infof("Formatting device %s as %s with mkfs arguments %v", source, fstype, args)
+			if output, err := m.Exec.Command("mkfs."+fstype, args...).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to format device %s as %s with arguments %v: %v, output: %s", source, fstype, args, err, string(output))
+			}
+		} else {
+			klog.V(4).Infof("Device %s is already formatted as %s, not applying mkfs arguments %v", source, existingFormat, mkfsArgs)
+		}
+	}
 	return m.FormatAndMount(source, target, fstype, options)
 }
 
+// e2fsck exit codes, which are bits that may be combined.
+const (
+	e2fsckErrorsCorrected       = 1
+	e2fsckErrorsCorrectedReboot = 2
+	e2fsckErrorsUncorrected     = 4
+)
+
+// xfs_repair exit codes.
+const (
+	xfsRepairCorruptionFound = 1
+	xfsRepairDirtyLog        = 2
+)
+
+// checkFilesystem checks the filesystem on the device with e2fsck or
+// xfs_repair before it is mounted, repairing it if the fsck-on-stage policy
+// is repair. The journal of the filesystem is replayed first, as mounting it
+// would, so that a volume that was not unmounted cleanly is not reported as
+// corrupted. The journals of read-only devices cannot be replayed. It returns
+// a FailedPrecondition error when the filesystem is corrupted and is not
+// repaired. Devices that are not formatted yet are not checked.
+func checkFilesystem(devicePath, policy string, readOnly bool, m *mount.SafeFormatAndMount) error {
+	format, err := m.GetDiskFormat(devicePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get format of device %s: %v", devicePath, err)
+	}
+	if format == "" {
+		klog.V(4).Infof("Device %s is not formatted, skipping filesystem check", devicePath)
+		return nil
+	}
+	if !readOnly {
+		if err := replayJournal(devicePath, format, m); err != nil {
+			return err
+		}
+	}
+
+	repair := policy == common.FsckOnStageRepair
+	var cmd string
+	var args []string
+	switch format {
+	case "ext2", "ext3", "ext4":
+		cmd, args = "e2fsck", []string{"-n", devicePath}
+		if repair {
+			args = []string{"-y", devicePath}
+		}
+	case fsTypeXFS:
+		cmd, args = "xfs_repair", []string{"-n", devicePath}
+		if repair {
+			args = []string{devicePath}
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "%s is not supported for filesystem type %s of device %s", common.VolumeAttributeFsckOnStage, format, devicePath)
+	}
+
+	klog.V(4).Infof("Checking filesystem on device %s with %s %v", devicePath, cmd, args)
+	output, err := m.Exec.Command(cmd, args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	exitErr, ok := err.(exec.ExitError)
+	if !ok {
+		return status.Errorf(codes.Internal, "failed to run %s on device %s: %v", cmd, devicePath, err)
+	}
+	code := exitErr.ExitStatus()
+	switch {
+	case cmd == "e2fsck" && code&e2fsckErrorsUncorrected != 0 && !repair:
+		return status.Errorf(codes.FailedPrecondition, "e2fsck found errors in the filesystem on device %s, which are not repaired because %s is %s: %s", devicePath, common.VolumeAttributeFsckOnStage, policy, string(output))
+	case cmd == "e2fsck" && code&e2fsckErrorsUncorrected != 0:
+		return status.Errorf(codes.FailedPrecondition, "e2fsck could not repair the errors in the filesystem on device %s: %s", devicePath, string(output))
+	case cmd == "e2fsck" && code&^(e2fsckErrorsCorrected|e2fsckErrorsCorrectedReboot) == 0:
+		klog.Warningf("e2fsck repaired errors in the filesystem on device %s: %s", devicePath, string(output))
+		return nil
+	case cmd == "xfs_repair" && code == xfsRepairCorruptionFound && !repair:
+		return status.Errorf(codes.FailedPrecondition, "xfs_repair found corruption in the filesystem on device %s, which is not repaired because %s is %s: %s", devicePath, common.VolumeAttributeFsckOnStage, policy, string(output))
+	case cmd == "xfs_repair" && code == xfsRepairDirtyLog:
+		return status.Errorf(codes.FailedPrecondition, "xfs_repair cannot check the filesystem on device %s because its log is dirty, and was not replayed: %s", devicePath, string(output))
+	default:
+		return status.Errorf(codes.Internal, "%s failed on device %s with exit code %d: %s", cmd, devicePath, code, string(output))
+	}
+}
+
+// replayJournal replays the journal of the filesystem on the device, if it
+// has one that needs replaying. ext filesystems are replayed by e2fsck
+// without checking them, and xfs filesystems by mounting and unmounting them
+// in a temporary directory.
+func replayJournal(devicePath, format string, m *mount.SafeFormatAndMount) error {
+	switch format {
+	case "ext3", "ext4":
+		args := []string{"-p", "-E", "journal_only", devicePath}
+		klog.V(4).Infof("Replaying the journal of the filesystem on device %s with e2fsck %v", devicePath, args)
+		output, err := m.Exec.Command("e2fsck", args...).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		exitErr, ok := err.(exec.ExitError)
+		if !ok {
+			return status.Errorf(codes.Internal, "failed to run e2fsck on device %s: %v", devicePath, err)
+		}
+		code := exitErr.ExitStatus()
+		switch {
+		case code&^(e2fsckErrorsCorrected|e2fsckErrorsCorrectedReboot) == 0:
+			klog.V(4).Infof("Replayed the journal of the filesystem on device %s: %s", devicePath, string(output))
+			return nil
+		case code&e2fsckErrorsUncorrected != 0:
+			return status.Errorf(codes.FailedPrecondition, "e2fsck could not replay the journal of the filesystem on device %s: %s", devicePath, string(output))
+		default:
+			return status.Errorf(codes.Internal, "e2fsck failed to replay the journal on device %s with exit code %d: %s", devicePath, code, string(output))
+		}
+	case fsTypeXFS:
+		dir, err := ioutil.TempDir("", "xfs-log-replay")
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to create directory to replay the log of device %s: %v", devicePath, err)
+		}
+		defer os.Remove(dir)
+		klog.V(4).Infof("Replaying the log of the filesystem on device %s by mounting it at %s", devicePath, dir)
+		if err := m.Mount(devicePath, dir, fsTypeXFS, nil); err != nil {
+			return status.Errorf(codes.FailedPrecondition, "failed to replay the log of the filesystem on device %s by mounting it: %v", devicePath, err)
+		}
+		if err := m.Unmount(dir); err != nil {
+			return status.Errorf(codes.Internal, "failed to unmount device %s after replaying its log: %v", devicePath, err)
+		}
+	}
+	return nil
+}
+
+// cryptsetup exit codes.
+const (
+	cryptsetupBadPassphrase = 2
+	cryptsetupNoSuchDevice  = 4
+)
+
+// luksFormat is the format that blkid reports for LUKS devices.
+const luksFormat = "crypto_LUKS"
+
+func luksMapperPath(mapperName string) string {
+	return "/dev/mapper/" + mapperName
+}
+
+// openLUKSDevice opens the LUKS device at devicePath as the device mapper
+// device mapperName with the passphrase, and returns the path of the mapper
+// device. Devices that are not formatted yet are formatted with LUKS first,
+// and devices with another format are never reformatted. Devices that are
+// already open are not opened again.
+func openLUKSDevice(devicePath, mapperName, passphrase string, readOnly bool, m *mount.SafeFormatAndMount) (string, error) {
+	mapperPath := luksMapperPath(mapperName)
+	active, err := isLUKSDeviceActive(mapperName, m)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if active {
+		klog.V(4).Infof("LUKS device %s is already open at %s", devicePath, mapperPath)
+		return mapperPath, nil
+	}
+
+	format, err := m.GetDiskFormat(devicePath)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to get format of device %s: %v", devicePath, err)
+	}
+	switch format {
+	case luksFormat:
+	case "":
+		if readOnly {
+			return "", status.Errorf(codes.FailedPrecondition, "device %s is not formatted with LUKS yet, and cannot be formatted read-only", devicePath)
+		}
+		klog.V(4).Infof("Formatting device %s with LUKS", devicePath)
+		cmd := m.Exec.Command("cryptsetup", "luksFormat", "--batch-mode", "--type", "luks2", "--key-file=-", devicePath)
+		cmd.SetStdin(strings.NewReader(passphrase))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return "", status.Errorf(codes.Internal, "failed to format device %s with LUKS: %v, output: %s", devicePath, err, string(output))
+		}
+	default:
+		return "", status.Errorf(codes.FailedPrecondition, "device %s is formatted as %s, not with LUKS, and is not reformatted", devicePath, format)
+	}
+
+	// The volume key is kept in the device mapper table rather than the
+	// kernel keyring, so that NodeExpandVolume, whose requests have no
+	// secrets, can resize the device without the passphrase.
+	args := []string{"open", "--type", "luks", "--key-file=-", "--disable-keyring"}
+	if readOnly {
+		args = append(args, "--readonly")
+	}
+	args = append(args, devicePath, mapperName)
+	klog.V(4).Infof("Opening LUKS device %s at %s", devicePath, mapperPath)
+	cmd := m.Exec.Command("cryptsetup", args...)
+	cmd.SetStdin(strings.NewReader(passphrase))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if exitErr, ok := err.(exec.ExitError); ok && exitErr.ExitStatus() == cryptsetupBadPassphrase {
+			return "", status.Errorf(codes.InvalidArgument, "secret %s does not unlock LUKS device %s: %s", common.SecretKeyLUKSPassphrase, devicePath, string(output))
+		}
+		return "", status.Errorf(codes.Internal, "failed to open LUKS device %s: %v, output: %s", devicePath, err, string(output))
+	}
+	return mapperPath, nil
+}
+
+// closeLUKSDevice closes the device mapper device mapperName, if it is open.
+func closeLUKSDevice(mapperName string, m *mount.SafeFormatAndMount) error {
+	active, err := isLUKSDeviceActive(mapperName, m)
+	if err != nil || !active {
+		return err
+	}
+	klog.V(4).Infof("Closing LUKS device %s", luksMapperPath(mapperName))
+	if output, err := m.Exec.Command("cryptsetup", "close", mapperName).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to close LUKS device %s: %v, output: %s", mapperName, err, string(output))
+	}
+	return nil
+}
+
+// resizeLUKSDevice resizes the device mapper device mapperName to the size of
+// its disk, if it is open, and returns its path. It returns an empty path if
+// the device is not open.
+func resizeLUKSDevice(mapperName string, m *mount.SafeFormatAndMount) (string, error) {
+	active, err := isLUKSDeviceActive(mapperName, m)
+	if err != nil || !active {
+		return "", err
+	}
+	klog.V(4).Infof("Resizing LUKS device %s", luksMapperPath(mapperName))
+	if output, err := m.Exec.Command("cryptsetup", "resize", mapperName).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to resize LUKS device %s: %v, output: %s", mapperName, err, string(output))
+	}
+	return luksMapperPath(mapperName), nil
+}
+
+// isLUKSDeviceActive returns whether the device mapper device mapperName is
+// open.
+func isLUKSDeviceActive(mapperName string, m *mount.SafeFormatAndMount) (bool, error) {
+	output, err := m.Exec.Command("cryptsetup", "status", mapperName).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	if exitErr, ok := err.(exec.ExitError); ok && exitErr.ExitStatus() == cryptsetupNoSuchDevice {
+		return false, nil
+	}
+	if err == exec.ErrExecutableNotFound {
+		// Nothing can be open on nodes without cryptsetup.
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to get status of LUKS device %s: %v, output: %s", mapperName, err, string(output))
+}
+
 func preparePublishPath(path string, m *mount.SafeFormatAndMount) error {
 	return os.MkdirAll(path, 0750)
 }
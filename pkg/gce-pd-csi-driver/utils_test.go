@@ -18,9 +18,28 @@ limitations under the License.
 package gceGCEDriver
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+	klogv2 "k8s.io/klog/v2"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/logging"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/tracing"
 )
 
 var (
@@ -234,3 +253,266 @@ func TestGetMultiWriterFromCapabilities(t *testing.T) {
 		}
 	}
 }
+
+func TestStripSecrets(t *testing.T) {
+	key := "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	req := &csi.CreateVolumeRequest{
+		Name:    "test-name",
+		Secrets: map[string]string{common.SecretKeyDiskEncryptionRawKey: key},
+	}
+	logged := fmt.Sprintf("%s", stripSecrets(req))
+	if strings.Contains(logged, key) {
+		t.Errorf("stripSecrets(%v) logged %s, which contains the key", req, logged)
+	}
+	if !strings.Contains(logged, "test-name") || !strings.Contains(logged, strippedSecret) {
+		t.Errorf("stripSecrets(%v) logged %s, expected the name and stripped secret", req, logged)
+	}
+	if req.Secrets[common.SecretKeyDiskEncryptionRawKey] != key {
+		t.Errorf("stripSecrets modified the request secrets to %v", req.Secrets)
+	}
+
+	noSecrets := &csi.NodeGetInfoRequest{}
+	if stripped := stripSecrets(noSecrets); stripped != noSecrets {
+		t.Errorf("stripSecrets(%v) = %v, expected the request", noSecrets, stripped)
+	}
+}
+
+func TestTraceGRPC(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	defer tracing.EnableSync(exporter)()
+	testNodeID := common.CreateNodeID(project, zone, node)
+
+	testCases := []struct {
+		name          string
+		req           interface{}
+		method        string
+		handlerErr    error
+		expAttributes []attribute.KeyValue
+	}{
+		{
+			name:   "controller publish",
+			req:    &csi.ControllerPublishVolumeRequest{VolumeId: testVolumeID, NodeId: testNodeID},
+			method: "/csi.v1.Controller/ControllerPublishVolume",
+			expAttributes: []attribute.KeyValue{
+				attribute.String(tracing.AttributeVolumeID, testVolumeID),
+				attribute.String(tracing.AttributeNodeID, testNodeID),
+			},
+		},
+		{
+			name:          "create snapshot",
+			req:           &csi.CreateSnapshotRequest{Name: "snapshot", SourceVolumeId: testVolumeID},
+			method:        "/csi.v1.Controller/CreateSnapshot",
+			expAttributes: []attribute.KeyValue{attribute.String(tracing.AttributeVolumeID, testVolumeID)},
+		},
+		{
+			name:       "failed",
+			req:        &csi.NodeStageVolumeRequest{VolumeId: testVolumeID},
+			method:     "/csi.v1.Node/NodeStageVolume",
+			handlerErr: status.Error(codes.Internal, "failed"),
+			expAttributes: []attribute.KeyValue{
+				attribute.String(tracing.AttributeVolumeID, testVolumeID),
+				attribute.Int64(tracing.AttributeGRPCStatusCode, int64(codes.Internal)),
+			},
+		},
+		{
+			name:   "no attributes",
+			req:    &csi.GetCapacityRequest{},
+			method: "/csi.v1.Controller/GetCapacity",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter.Reset()
+			var handlerSpan trace.Span
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				handlerSpan = trace.SpanFromContext(ctx)
+				return nil, tc.handlerErr
+			}
+			_, err := traceGRPC(context.Background(), tc.req, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+			if err != tc.handlerErr {
+				t.Errorf("Got error %v, expected %v", err, tc.handlerErr)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("Got %d spans, expected 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name != tc.method {
+				t.Errorf("Got span %s, expected %s", span.Name, tc.method)
+			}
+			if handlerSpan == nil || !handlerSpan.SpanContext().Equal(span.SpanContext) {
+				t.Errorf("The handler was not called with the context of the span")
+			}
+			if got, exp := attribute.NewSet(span.Attributes...), attribute.NewSet(tc.expAttributes...); !got.Equals(&exp) {
+				t.Errorf("Got attributes %v, expected %v", got.Encoded(attribute.DefaultEncoder()), exp.Encoded(attribute.DefaultEncoder()))
+			}
+			expStatus := otelcodes.Unset
+			if tc.handlerErr != nil {
+				expStatus = otelcodes.Error
+			}
+			if span.Status.Code != expStatus {
+				t.Errorf("Got status %v, expected %v", span.Status.Code, expStatus)
+			}
+		})
+	}
+}
+
+func TestStripSecretsOfEveryRequest(t *testing.T) {
+	secrets := map[string]string{"key": "secret-value"}
+	reqs := []interface{}{
+		&csi.CreateVolumeRequest{Name: "test-name", Secrets: secrets},
+		&csi.DeleteVolumeRequest{VolumeId: testVolumeID, Secrets: secrets},
+		&csi.ControllerPublishVolumeRequest{VolumeId: testVolumeID, Secrets: secrets},
+		&csi.ControllerExpandVolumeRequest{VolumeId: testVolumeID, Secrets: secrets},
+		&csi.CreateSnapshotRequest{SourceVolumeId: testVolumeID, Secrets: secrets},
+		&csi.NodeStageVolumeRequest{VolumeId: testVolumeID, Secrets: secrets},
+		&csi.NodePublishVolumeRequest{VolumeId: testVolumeID, Secrets: secrets},
+		&csi.ListSnapshotsRequest{SnapshotId: testSnapshotID, Secrets: secrets},
+	}
+	for _, req := range reqs {
+		logged := fmt.Sprintf("%s", stripSecrets(req))
+		if strings.Contains(logged, "secret-value") || !strings.Contains(logged, strippedSecret) {
+			t.Errorf("stripSecrets(%T) logged %s, expected the secret to be stripped", req, logged)
+		}
+	}
+	if secrets["key"] != "secret-value" {
+		t.Errorf("stripSecrets modified the request secrets to %v", secrets)
+	}
+}
+
+func TestParseMethodVerbosity(t *testing.T) {
+	testCases := []struct {
+		str    string
+		expect map[string]klog.Level
+		expErr bool
+	}{
+		{
+			str:    "",
+			expect: map[string]klog.Level{},
+		},
+		{
+			str:    "NodeGetVolumeStats=6,NodeGetCapabilities=0",
+			expect: map[string]klog.Level{"NodeGetVolumeStats": 6, "NodeGetCapabilities": 0},
+		},
+		{
+			str:    "NodeGetVolumeStats",
+			expErr: true,
+		},
+		{
+			str:    "=6",
+			expErr: true,
+		},
+		{
+			str:    "NodeGetVolumeStats=high",
+			expErr: true,
+		},
+		{
+			str:    "NodeGetVolumeStats=-1",
+			expErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		verbosity, err := ParseMethodVerbosity(tc.str)
+		if gotErr := err != nil; gotErr != tc.expErr {
+			t.Errorf("ParseMethodVerbosity(%q) returned error %v, expected error %v", tc.str, err, tc.expErr)
+		}
+		if err == nil && !reflect.DeepEqual(verbosity, tc.expect) {
+			t.Errorf("ParseMethodVerbosity(%q) = %v, expected %v", tc.str, verbosity, tc.expect)
+		}
+	}
+}
+
+func TestStructuredLogGRPC(t *testing.T) {
+	buf := &bytes.Buffer{}
+	klogv2.SetLogger(logging.NewJSONLogger(buf))
+	defer klogv2.SetLogger(nil)
+	// Only RPCs of NodeStageVolume are logged at verbosity 0 when they
+	// succeed.
+	config := RPCLogConfig{
+		Structured:      true,
+		MethodVerbosity: map[string]klog.Level{"NodeStageVolume": 0},
+	}
+	testNodeID := common.CreateNodeID(project, zone, node)
+
+	testCases := []struct {
+		name       string
+		method     string
+		req        interface{}
+		handlerErr error
+		expEntry   map[string]interface{}
+	}{
+		{
+			name:   "succeeded",
+			method: "/csi.v1.Node/NodeStageVolume",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId: testVolumeID,
+				Secrets:  map[string]string{"key": "secret-value"},
+			},
+			expEntry: map[string]interface{}{
+				"msg":      "RPC succeeded",
+				"method":   "/csi.v1.Node/NodeStageVolume",
+				"volumeID": testVolumeID,
+				"nodeID":   "",
+				"code":     "OK",
+			},
+		},
+		{
+			name:   "failed",
+			method: "/csi.v1.Controller/ControllerPublishVolume",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId: testVolumeID,
+				NodeId:   testNodeID,
+				Secrets:  map[string]string{"key": "secret-value"},
+			},
+			handlerErr: status.Error(codes.NotFound, "not found"),
+			expEntry: map[string]interface{}{
+				"msg":      "RPC failed",
+				"err":      "rpc error: code = NotFound desc = not found",
+				"method":   "/csi.v1.Controller/ControllerPublishVolume",
+				"volumeID": testVolumeID,
+				"nodeID":   testNodeID,
+				"code":     "NotFound",
+			},
+		},
+		{
+			name:   "succeeded below verbosity",
+			method: "/csi.v1.Node/NodeGetVolumeStats",
+			req:    &csi.NodeGetVolumeStatsRequest{VolumeId: testVolumeID},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, tc.handlerErr
+			}
+			_, err := config.logGRPC(context.Background(), tc.req, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+			if err != tc.handlerErr {
+				t.Errorf("Got error %v, expected %v", err, tc.handlerErr)
+			}
+
+			if tc.expEntry == nil {
+				if buf.Len() != 0 {
+					t.Errorf("Logged %s, expected nothing", buf.String())
+				}
+				return
+			}
+			if strings.Contains(buf.String(), "secret-value") {
+				t.Errorf("Logged %s, which contains the secret", buf.String())
+			}
+			entry := map[string]interface{}{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("Failed to decode %q: %v", buf.String(), err)
+			}
+			for key, value := range tc.expEntry {
+				if entry[key] != value {
+					t.Errorf("Got %s %v, expected %v", key, entry[key], value)
+				}
+			}
+			if _, ok := entry["duration"].(string); !ok {
+				t.Errorf("Got duration %v, expected a string", entry["duration"])
+			}
+		})
+	}
+}
@@ -19,15 +19,20 @@ import (
 	"fmt"
 	"strings"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/mount-utils"
 	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
 	mounter "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/mount-manager"
 )
 
-func formatAndMount(source, target, fstype string, options []string, m *mount.SafeFormatAndMount) error {
+func formatAndMount(source, target, fstype string, mkfsArgs, options []string, m *mount.SafeFormatAndMount) error {
 	if !strings.EqualFold(fstype, defaultWindowsFsType) {
 		return fmt.Errorf("GCE PD CSI driver can only supports %s file system, it does not support %s", defaultWindowsFsType, fstype)
 	}
+	if len(mkfsArgs) > 0 {
+		return fmt.Errorf("GCE PD CSI driver does not support mkfs arguments on Windows, got %v", mkfsArgs)
+	}
 	proxy, ok := m.Interface.(mounter.CSIProxyMounter)
 	if !ok {
 		return fmt.Errorf("could not cast to csi proxy class")
@@ -35,6 +40,22 @@ func formatAndMount(source, target, fstype string, options []string, m *mount.Sa
 	return proxy.FormatAndMount(source, target, fstype, options)
 }
 
+func checkFilesystem(devicePath, policy string, readOnly bool, m *mount.SafeFormatAndMount) error {
+	return status.Errorf(codes.InvalidArgument, "%s is not supported on Windows", common.VolumeAttributeFsckOnStage)
+}
+
+func openLUKSDevice(devicePath, mapperName, passphrase string, readOnly bool, m *mount.SafeFormatAndMount) (string, error) {
+	return "", status.Errorf(codes.InvalidArgument, "%s is not supported on Windows", common.VolumeAttributeNodeEncryption)
+}
+
+func closeLUKSDevice(mapperName string, m *mount.SafeFormatAndMount) error {
+	return nil
+}
+
+func resizeLUKSDevice(mapperName string, m *mount.SafeFormatAndMount) (string, error) {
+	return "", nil
+}
+
 // Before mounting (which means creating symlink) in Windows, the targetPath should
 // not exist. Currently kubelet creates the path beforehand, this is a workaround to
 // remove the path first.
@@ -93,6 +114,12 @@ func getDevicePath(ns *GCENodeServer, volumeID, partition string) (string, error
 	return proxy.GetDiskNumber(deviceName, partition, volumeKey.Name)
 }
 
+// checkVolumeDevice is not supported on Windows, where devices are not
+// checked.
+func checkVolumeDevice(ns *GCENodeServer, deviceName string) (string, error) {
+	return "", nil
+}
+
 func getBlockSizeBytes(devicePath string, m *mount.SafeFormatAndMount) (int64, error) {
 	proxy, ok := m.Interface.(mounter.CSIProxyMounter)
 	if !ok {
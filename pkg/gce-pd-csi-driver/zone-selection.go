@@ -0,0 +1,126 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gceGCEDriver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
+	gce "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-cloud-provider/compute"
+)
+
+// zoneSelector picks the zones to create a disk in.
+type zoneSelector interface {
+	// selectZones returns numZones zones for a disk in the project, starting
+	// with preferredZones in order, and picking the rest from otherZones.
+	// otherZones must not contain any of preferredZones.
+	selectZones(ctx context.Context, project string, preferredZones, otherZones []string, numZones int) ([]string, error)
+}
+
+func newZoneSelectors(cloudProvider gce.GCECompute) map[string]zoneSelector {
+	return map[string]zoneSelector{
+		common.ZoneSelectionStrategyRandom:     &randomZoneSelector{},
+		common.ZoneSelectionStrategyRoundRobin: &roundRobinZoneSelector{},
+		common.ZoneSelectionStrategyLeastUsed:  &leastUsedZoneSelector{cloudProvider: cloudProvider},
+	}
+}
+
+// randomZoneSelector uses the preferred zones, then picks the rest at random
+// from the other zones.
+type randomZoneSelector struct{}
+
+func (s *randomZoneSelector) selectZones(ctx context.Context, project string, preferredZones, otherZones []string, numZones int) ([]string, error) {
+	if numZones <= len(preferredZones) {
+		return preferredZones[0:numZones], nil
+	}
+	nSlice, err := pickRandAndConsecutive(sets.NewString(otherZones...).List(), numZones-len(preferredZones))
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]string{}, preferredZones...), nSlice...), nil
+}
+
+// roundRobinZoneSelector uses the most preferred zone, then rotates through the
+// rest of the zones across calls. The less preferred zones are treated like
+// the other zones so that they are balanced too.
+type roundRobinZoneSelector struct {
+	mutex sync.Mutex
+	next  int
+}
+
+func (s *roundRobinZoneSelector) selectZones(ctx context.Context, project string, preferredZones, otherZones []string, numZones int) ([]string, error) {
+	zones, candidates := splitMostPreferredZone(preferredZones, otherZones)
+	needToGet := numZones - len(zones)
+	if needToGet <= 0 {
+		return zones[0:numZones], nil
+	}
+	if len(candidates) < needToGet {
+		return nil, fmt.Errorf("not enough zones in %v to get %v zones out", candidates, needToGet)
+	}
+
+	s.mutex.Lock()
+	start := s.next
+	s.next++
+	s.mutex.Unlock()
+
+	for i := 0; i < needToGet; i++ {
+		zones = append(zones, candidates[(start+i)%len(candidates)])
+	}
+	return zones, nil
+}
+
+// leastUsedZoneSelector uses the most preferred zone, then the zones with the
+// fewest disks in them. The less preferred zones are treated like the other
+// zones so that they are balanced too.
+type leastUsedZoneSelector struct {
+	cloudProvider gce.GCECompute
+}
+
+func (s *leastUsedZoneSelector) selectZones(ctx context.Context, project string, preferredZones, otherZones []string, numZones int) ([]string, error) {
+	zones, candidates := splitMostPreferredZone(preferredZones, otherZones)
+	needToGet := numZones - len(zones)
+	if needToGet <= 0 {
+		return zones[0:numZones], nil
+	}
+	if len(candidates) < needToGet {
+		return nil, fmt.Errorf("not enough zones in %v to get %v zones out", candidates, needToGet)
+	}
+
+	diskCounts, err := s.cloudProvider.CountDisksByZone(ctx, project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count disks in zones: %v", err)
+	}
+	// candidates is sorted by name, so a stable sort breaks ties by name.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return diskCounts[candidates[i]] < diskCounts[candidates[j]]
+	})
+	return append(zones, candidates[0:needToGet]...), nil
+}
+
+// splitMostPreferredZone returns the most preferred zone, if there is one, and
+// the rest of the zones sorted by name.
+func splitMostPreferredZone(preferredZones, otherZones []string) ([]string, []string) {
+	if len(preferredZones) == 0 {
+		return []string{}, sets.NewString(otherZones...).List()
+	}
+	mostPreferred := preferredZones[0]
+	rest := sets.NewString(preferredZones[1:]...).Insert(otherZones...).Delete(mostPreferred)
+	return []string{mostPreferred}, rest.List()
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gceGCEDriver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
+	gce "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-cloud-provider/compute"
+)
+
+func createCloudDiskInZones(name string, zones ...string) *gce.CloudDisk {
+	return createCloudDiskInProject(project, name, zones...)
+}
+
+func createCloudDiskInProject(diskProject, name string, zones ...string) *gce.CloudDisk {
+	disk := &compute.Disk{Name: name}
+	if len(zones) == 1 {
+		disk.Zone = fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s", diskProject, zones[0])
+		disk.SelfLink = fmt.Sprintf("%s/disks/%s", disk.Zone, name)
+	} else {
+		for _, z := range zones {
+			disk.ReplicaZones = append(disk.ReplicaZones, fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s", diskProject, z))
+		}
+		disk.SelfLink = fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/regions/%s/disks/%s", diskProject, region, name)
+	}
+	return gce.CloudDiskFromV1(disk)
+}
+
+func TestZoneSelectors(t *testing.T) {
+	testCases := []struct {
+		name           string
+		strategy       string
+		seedDisks      []*gce.CloudDisk
+		preferredZones []string
+		otherZones     []string
+		numZones       int
+		// expZones holds the expected zones for consecutive calls
+		expZones [][]string
+		expErr   bool
+	}{
+		{
+			name:           "random: preferred zones are enough",
+			strategy:       common.ZoneSelectionStrategyRandom,
+			preferredZones: []string{"zone-b", "zone-a"},
+			otherZones:     []string{"zone-c"},
+			numZones:       2,
+			expZones:       [][]string{{"zone-b", "zone-a"}},
+		},
+		{
+			name:           "random: preferred zones stay first",
+			strategy:       common.ZoneSelectionStrategyRandom,
+			preferredZones: []string{"zone-c", "zone-b"},
+			otherZones:     []string{"zone-a"},
+			numZones:       3,
+			expZones:       [][]string{{"zone-c", "zone-b", "zone-a"}},
+		},
+		{
+			name:           "random: not enough zones",
+			strategy:       common.ZoneSelectionStrategyRandom,
+			preferredZones: []string{"zone-a"},
+			otherZones:     []string{},
+			numZones:       2,
+			expErr:         true,
+		},
+		{
+			name:           "round-robin: rotates through the other zones",
+			strategy:       common.ZoneSelectionStrategyRoundRobin,
+			preferredZones: []string{"zone-a"},
+			otherZones:     []string{"zone-d", "zone-b", "zone-c"},
+			numZones:       2,
+			expZones: [][]string{
+				{"zone-a", "zone-b"},
+				{"zone-a", "zone-c"},
+				{"zone-a", "zone-d"},
+				{"zone-a", "zone-b"},
+			},
+		},
+		{
+			name:       "round-robin: no preferred zones",
+			strategy:   common.ZoneSelectionStrategyRoundRobin,
+			otherZones: []string{"zone-a", "zone-b", "zone-c"},
+			numZones:   2,
+			expZones: [][]string{
+				{"zone-a", "zone-b"},
+				{"zone-b", "zone-c"},
+				{"zone-c", "zone-a"},
+			},
+		},
+		{
+			name:           "round-robin: not enough zones",
+			strategy:       common.ZoneSelectionStrategyRoundRobin,
+			preferredZones: []string{"zone-a"},
+			otherZones:     []string{"zone-a"},
+			numZones:       2,
+			expErr:         true,
+		},
+		{
+			name:     "least-used: picks the zones with the fewest disks",
+			strategy: common.ZoneSelectionStrategyLeastUsed,
+			seedDisks: []*gce.CloudDisk{
+				createCloudDiskInZones("disk-1", "zone-b"),
+				createCloudDiskInZones("disk-2", "zone-b"),
+				createCloudDiskInZones("disk-3", "zone-c"),
+				createCloudDiskInZones("disk-4", "zone-a", "zone-b"),
+			},
+			preferredZones: []string{"zone-a"},
+			otherZones:     []string{"zone-b", "zone-c", "zone-d"},
+			numZones:       2,
+			expZones:       [][]string{{"zone-a", "zone-d"}},
+		},
+		{
+			name:     "least-used: regional disks count in both zones",
+			strategy: common.ZoneSelectionStrategyLeastUsed,
+			seedDisks: []*gce.CloudDisk{
+				createCloudDiskInZones("disk-1", "zone-a"),
+				createCloudDiskInZones("disk-2", "zone-a"),
+				createCloudDiskInZones("disk-3", "zone-b", "zone-c"),
+			},
+			otherZones: []string{"zone-a", "zone-b", "zone-c"},
+			numZones:   2,
+			expZones:   [][]string{{"zone-b", "zone-c"}},
+		},
+		{
+			name:     "least-used: ties are broken by zone name",
+			strategy: common.ZoneSelectionStrategyLeastUsed,
+			seedDisks: []*gce.CloudDisk{
+				createCloudDiskInZones("disk-1", "zone-a"),
+			},
+			preferredZones: []string{"zone-d"},
+			otherZones:     []string{"zone-c", "zone-a", "zone-b"},
+			numZones:       2,
+			expZones:       [][]string{{"zone-d", "zone-b"}},
+		},
+		{
+			name:     "least-used: disks in other projects are not counted",
+			strategy: common.ZoneSelectionStrategyLeastUsed,
+			seedDisks: []*gce.CloudDisk{
+				createCloudDiskInProject("other-project", "disk-1", "zone-a"),
+				createCloudDiskInProject("other-project", "disk-2", "zone-a"),
+				createCloudDiskInZones("disk-3", "zone-b"),
+			},
+			otherZones: []string{"zone-a", "zone-b"},
+			numZones:   1,
+			expZones:   [][]string{{"zone-a"}},
+		},
+		{
+			name:           "least-used: preferred zones are enough",
+			strategy:       common.ZoneSelectionStrategyLeastUsed,
+			preferredZones: []string{"zone-a"},
+			otherZones:     []string{"zone-b"},
+			numZones:       1,
+			expZones:       [][]string{{"zone-a"}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		fcp, err := gce.CreateFakeCloudProvider(project, zone, tc.seedDisks)
+		if err != nil {
+			t.Fatalf("Failed to create fake cloud provider: %v", err)
+		}
+		selector := newZoneSelectors(fcp)[tc.strategy]
+		if tc.expErr {
+			if _, err := selector.selectZones(context.Background(), project, tc.preferredZones, tc.otherZones, tc.numZones); err == nil {
+				t.Errorf("Expected error but got none")
+			}
+			continue
+		}
+		for i, expZones := range tc.expZones {
+			gotZones, err := selector.selectZones(context.Background(), project, tc.preferredZones, tc.otherZones, tc.numZones)
+			if err != nil {
+				t.Errorf("Call %d: did not expect error but got: %v", i, err)
+				continue
+			}
+			if !reflect.DeepEqual(gotZones, expZones) {
+				t.Errorf("Call %d: expected zones %v, but got %v", i, expZones, gotZones)
+			}
+		}
+	}
+}
+
+func TestCreateVolumeZoneSelectionStrategy(t *testing.T) {
+	testCases := []struct {
+		name          string
+		parameters    map[string]string
+		driverDefault string
+		seedDisks     []*gce.CloudDisk
+		expZones      []string
+		expErrCode    codes.Code
+	}{
+		{
+			name:          "least-used from the StorageClass",
+			parameters:    map[string]string{common.ParameterKeyZoneSelectionStrategy: "Least-Used"},
+			driverDefault: common.ZoneSelectionStrategyRandom,
+			seedDisks: []*gce.CloudDisk{
+				createCloudDiskInZones("disk-1", "topology-zone1"),
+				createCloudDiskInZones("disk-2", "topology-zone2"),
+			},
+			expZones: []string{"topology-zone3"},
+		},
+		{
+			name:          "least-used from the driver default",
+			driverDefault: common.ZoneSelectionStrategyLeastUsed,
+			seedDisks: []*gce.CloudDisk{
+				createCloudDiskInZones("disk-1", "topology-zone2"),
+				createCloudDiskInZones("disk-2", "topology-zone3"),
+			},
+			expZones: []string{"topology-zone1"},
+		},
+		{
+			name:          "round-robin from the StorageClass",
+			parameters:    map[string]string{common.ParameterKeyZoneSelectionStrategy: common.ZoneSelectionStrategyRoundRobin},
+			driverDefault: common.ZoneSelectionStrategyLeastUsed,
+			expZones:      []string{"topology-zone1"},
+		},
+		{
+			name:          "unknown strategy",
+			parameters:    map[string]string{common.ParameterKeyZoneSelectionStrategy: "most-used"},
+			driverDefault: common.ZoneSelectionStrategyRandom,
+			expErrCode:    codes.InvalidArgument,
+		},
+	}
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		fcp, err := gce.CreateFakeCloudProvider(project, zone, tc.seedDisks)
+		if err != nil {
+			t.Fatalf("Failed to create fake cloud provider: %v", err)
+		}
+		gceDriver := GetGCEDriver()
+		controllerServer := NewControllerServer(gceDriver, fcp, tc.driverDefault, nil)
+		if err := gceDriver.SetupGCEDriver(driver, "test-vendor", nil, nil, controllerServer, nil); err != nil {
+			t.Fatalf("Failed to setup GCE Driver: %v", err)
+		}
+
+		parameters := map[string]string{common.ParameterKeyType: "test-type"}
+		for k, v := range tc.parameters {
+			parameters[k] = v
+		}
+		req := &csi.CreateVolumeRequest{
+			Name:               name,
+			CapacityRange:      stdCapRange,
+			VolumeCapabilities: stdVolCaps,
+			Parameters:         parameters,
+			AccessibilityRequirements: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{Segments: map[string]string{common.TopologyKeyZone: "topology-zone1"}},
+					{Segments: map[string]string{common.TopologyKeyZone: "topology-zone2"}},
+					{Segments: map[string]string{common.TopologyKeyZone: "topology-zone3"}},
+				},
+			},
+		}
+		resp, err := gceDriver.cs.CreateVolume(context.Background(), req)
+		if tc.expErrCode != codes.OK {
+			if status.Code(err) != tc.expErrCode {
+				t.Errorf("Expected error code %v, but got %v", tc.expErrCode, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("CreateVolume did not expect error, but got %v", err)
+			continue
+		}
+		gotZones := []string{}
+		for _, top := range resp.GetVolume().GetAccessibleTopology() {
+			gotZones = append(gotZones, top.GetSegments()[common.TopologyKeyZone])
+		}
+		if !reflect.DeepEqual(gotZones, tc.expZones) {
+			t.Errorf("Expected zones %v, but got %v", tc.expZones, gotZones)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging formats the structured logs of the driver, which are
+// written with klog's structured API.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// NewJSONLogger returns a logger that writes each entry to w as a line of
+// JSON, for use with klog.SetLogger. An entry has the fields ts, the Unix
+// time in seconds, msg, v, the verbosity if it is not 0, err, if it is an
+// error, and the keys and values logged with it.
+func NewJSONLogger(w io.Writer) logr.Logger {
+	return &jsonLogger{out: &lockedWriter{w: w}}
+}
+
+// lockedWriter serializes the writes of the loggers derived from the same
+// logger, so that their lines are not interleaved.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+type jsonLogger struct {
+	out    *lockedWriter
+	name   string
+	v      int
+	values []interface{}
+}
+
+var _ logr.Logger = &jsonLogger{}
+
+func (l *jsonLogger) Enabled() bool {
+	return true
+}
+
+func (l *jsonLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.write(nil, msg, keysAndValues)
+}
+
+func (l *jsonLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.write(err, msg, keysAndValues)
+}
+
+func (l *jsonLogger) V(level int) logr.Logger {
+	derived := *l
+	derived.v += level
+	return &derived
+}
+
+func (l *jsonLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	derived := *l
+	derived.values = append(append([]interface{}{}, l.values...), keysAndValues...)
+	return &derived
+}
+
+func (l *jsonLogger) WithName(name string) logr.Logger {
+	derived := *l
+	if derived.name != "" {
+		name = derived.name + "." + name
+	}
+	derived.name = name
+	return &derived
+}
+
+func (l *jsonLogger) write(err error, msg string, keysAndValues []interface{}) {
+	entry := map[string]interface{}{
+		"ts":  float64(time.Now().UnixNano()) / float64(time.Second),
+		"msg": msg,
+	}
+	if l.name != "" {
+		entry["logger"] = l.name
+	}
+	if l.v != 0 {
+		entry["v"] = l.v
+	}
+	if err != nil {
+		entry["err"] = err.Error()
+	}
+	addValues(entry, l.values)
+	addValues(entry, keysAndValues)
+
+	line, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		line, _ = json.Marshal(map[string]interface{}{
+			"ts":  entry["ts"],
+			"msg": msg,
+			"err": fmt.Sprintf("failed to encode log entry: %v", marshalErr),
+		})
+	}
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(append(line, '\n'))
+}
+
+// addValues adds the key and value pairs to entry. Errors and values that
+// implement fmt.Stringer, other than nil pointers, are logged as strings.
+func addValues(entry map[string]interface{}, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 == len(keysAndValues) {
+			entry[key] = "(MISSING)"
+			break
+		}
+		switch v := keysAndValues[i+1].(type) {
+		case nil:
+			entry[key] = nil
+		case error:
+			entry[key] = v.Error()
+		case fmt.Stringer:
+			if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+				entry[key] = nil
+			} else {
+				entry[key] = v.String()
+			}
+		default:
+			entry[key] = v
+		}
+	}
+}
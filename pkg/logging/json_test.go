@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stringer struct{}
+
+func (*stringer) String() string {
+	return "stringer"
+}
+
+func TestJSONLogger(t *testing.T) {
+	testCases := []struct {
+		name     string
+		log      func(buf *bytes.Buffer)
+		expEntry map[string]interface{}
+	}{
+		{
+			name: "info",
+			log: func(buf *bytes.Buffer) {
+				NewJSONLogger(buf).Info("called", "method", "NodeStageVolume", "count", 2, "duration", time.Second)
+			},
+			expEntry: map[string]interface{}{
+				"msg":      "called",
+				"method":   "NodeStageVolume",
+				"count":    float64(2),
+				"duration": "1s",
+			},
+		},
+		{
+			name: "error",
+			log: func(buf *bytes.Buffer) {
+				NewJSONLogger(buf).Error(fmt.Errorf("failed"), "returned", "code", "Internal")
+			},
+			expEntry: map[string]interface{}{
+				"msg":  "returned",
+				"err":  "failed",
+				"code": "Internal",
+			},
+		},
+		{
+			name: "derived logger",
+			log: func(buf *bytes.Buffer) {
+				NewJSONLogger(buf).WithName("driver").WithName("node").V(4).WithValues("volumeID", "vol").Info("called", "missing")
+			},
+			expEntry: map[string]interface{}{
+				"msg":      "called",
+				"logger":   "driver.node",
+				"v":        float64(4),
+				"volumeID": "vol",
+				"missing":  "(MISSING)",
+			},
+		},
+		{
+			name: "stringers",
+			log: func(buf *bytes.Buffer) {
+				var nilStringer *stringer
+				NewJSONLogger(buf).Info("returned", "set", &stringer{}, "nil", nilStringer)
+			},
+			expEntry: map[string]interface{}{
+				"msg": "returned",
+				"set": "stringer",
+				"nil": nil,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			tc.log(buf)
+			if !strings.HasSuffix(buf.String(), "}\n") || strings.Count(buf.String(), "\n") != 1 {
+				t.Fatalf("Logged %q, expected a single line", buf.String())
+			}
+			entry := map[string]interface{}{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("Failed to decode %q: %v", buf.String(), err)
+			}
+			if _, ok := entry["ts"].(float64); !ok {
+				t.Errorf("Got timestamp %v, expected a number", entry["ts"])
+			}
+			delete(entry, "ts")
+			if !reflect.DeepEqual(entry, tc.expEntry) {
+				t.Errorf("Got entry %v, expected %v", entry, tc.expEntry)
+			}
+		})
+	}
+}
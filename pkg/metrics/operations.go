@@ -0,0 +1,117 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"time"
+
+	"k8s.io/component-base/metrics"
+)
+
+const (
+	subsystemCSI = "csi"
+	subsystemGCE = "gce"
+
+	// labelMethod is the CSI or GCE API method of an operation.
+	labelMethod = "method_name"
+	// labelGRPCCode is the gRPC status code that a CSI method returned.
+	labelGRPCCode = "grpc_status_code"
+	// labelAPIVersion is the compute API version, v1, beta or alpha, of a
+	// GCE API call.
+	labelAPIVersion = "api_version"
+	// labelErrorReason is the googleapi error reason of a failed GCE API
+	// call.
+	labelErrorReason = "error_reason"
+	// labelOperationType is the type, for example insert or attachDisk, of
+	// a polled GCE operation.
+	labelOperationType = "operation_type"
+)
+
+var (
+	// Both the controller and node components record these metrics.
+	csiOperationLatency = metrics.NewHistogramVec(&metrics.HistogramOpts{
+		Subsystem:      subsystemCSI,
+		Name:           "operation_duration_seconds",
+		Help:           "Latency of CSI RPCs by method and gRPC status code.",
+		Buckets:        metrics.ExponentialBuckets(0.01, 2, 16),
+		StabilityLevel: metrics.ALPHA,
+	}, []string{labelMethod, labelGRPCCode})
+
+	csiOperationErrors = metrics.NewCounterVec(&metrics.CounterOpts{
+		Subsystem:      subsystemCSI,
+		Name:           "operation_errors_total",
+		Help:           "Number of CSI RPCs that failed, by method and gRPC status code.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{labelMethod, labelGRPCCode})
+
+	gceRequestLatency = metrics.NewHistogramVec(&metrics.HistogramOpts{
+		Subsystem:      subsystemGCE,
+		Name:           "api_request_duration_seconds",
+		Help:           "Latency of GCE API requests by method and API version. Retried requests are recorded for each attempt.",
+		Buckets:        metrics.ExponentialBuckets(0.01, 2, 14),
+		StabilityLevel: metrics.ALPHA,
+	}, []string{labelMethod, labelAPIVersion})
+
+	gceRequestErrors = metrics.NewCounterVec(&metrics.CounterOpts{
+		Subsystem:      subsystemGCE,
+		Name:           "api_request_errors_total",
+		Help:           "Number of GCE API requests that failed, by method, API version and googleapi error reason.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{labelMethod, labelAPIVersion, labelErrorReason})
+
+	gceOperationPolls = metrics.NewCounterVec(&metrics.CounterOpts{
+		Subsystem:      subsystemGCE,
+		Name:           "operation_polls_total",
+		Help:           "Number of times that pending GCE operations were polled, by operation type.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{labelOperationType})
+)
+
+// RegisterOperationMetrics registers the metrics of CSI RPCs and GCE API
+// calls. Until they are registered, recording them has no effect.
+func (mm *metricsManager) RegisterOperationMetrics() {
+	mm.registry.MustRegister(
+		csiOperationLatency,
+		csiOperationErrors,
+		gceRequestLatency,
+		gceRequestErrors,
+		gceOperationPolls,
+	)
+}
+
+// RecordCSIOperation records the latency of a CSI RPC, and counts it as an
+// error unless code is "OK".
+func RecordCSIOperation(method, code string, duration time.Duration) {
+	csiOperationLatency.WithLabelValues(method, code).Observe(duration.Seconds())
+	if code != "OK" {
+		csiOperationErrors.WithLabelValues(method, code).Inc()
+	}
+}
+
+// RecordGCERequest records the latency of a GCE API request, and counts it
+// as an error with the given googleapi error reason if it failed.
+func RecordGCERequest(method, apiVersion string, duration time.Duration, failed bool, errorReason string) {
+	gceRequestLatency.WithLabelValues(method, apiVersion).Observe(duration.Seconds())
+	if failed {
+		gceRequestErrors.WithLabelValues(method, apiVersion, errorReason).Inc()
+	}
+}
+
+// RecordGCEOperationPoll counts a poll of a pending GCE operation.
+func RecordGCEOperationPoll(operationType string) {
+	gceOperationPolls.WithLabelValues(operationType).Inc()
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sampleCounts returns the value of each counter and the sample count of
+// each histogram in the registry, keyed by metric name and labels.
+func sampleCounts(t *testing.T, mm metricsManager) map[string]float64 {
+	families, err := mm.GetRegistry().Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	counts := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				key += "," + label.GetName() + "=" + label.GetValue()
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				counts[key] = metric.GetCounter().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				counts[key] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return counts
+}
+
+func TestOperationMetrics(t *testing.T) {
+	mm := NewMetricsManager()
+	mm.RegisterOperationMetrics()
+
+	RecordCSIOperation("/csi.v1.Controller/CreateVolume", "OK", time.Second)
+	RecordCSIOperation("/csi.v1.Controller/CreateVolume", "Internal", time.Second)
+	RecordCSIOperation("/csi.v1.Node/NodeStageVolume", "OK", time.Millisecond)
+	RecordGCERequest("Disks.Get", "v1", time.Millisecond, false, "")
+	RecordGCERequest("Disks.Insert", "beta", time.Millisecond, true, "rateLimitExceeded")
+	RecordGCERequest("Disks.Insert", "beta", time.Millisecond, true, "rateLimitExceeded")
+	RecordGCEOperationPoll("insert")
+	RecordGCEOperationPoll("insert")
+	RecordGCEOperationPoll("attachDisk")
+
+	expected := map[string]float64{
+		"csi_operation_duration_seconds,grpc_status_code=OK,method_name=/csi.v1.Controller/CreateVolume":        1,
+		"csi_operation_duration_seconds,grpc_status_code=Internal,method_name=/csi.v1.Controller/CreateVolume":  1,
+		"csi_operation_duration_seconds,grpc_status_code=OK,method_name=/csi.v1.Node/NodeStageVolume":           1,
+		"csi_operation_errors_total,grpc_status_code=Internal,method_name=/csi.v1.Controller/CreateVolume":      1,
+		"gce_api_request_duration_seconds,api_version=v1,method_name=Disks.Get":                                 1,
+		"gce_api_request_duration_seconds,api_version=beta,method_name=Disks.Insert":                            2,
+		"gce_api_request_errors_total,api_version=beta,error_reason=rateLimitExceeded,method_name=Disks.Insert": 2,
+		"gce_operation_polls_total,operation_type=attachDisk":                                                   1,
+		"gce_operation_polls_total,operation_type=insert":                                                       2,
+	}
+	counts := sampleCounts(t, mm)
+	for key, value := range expected {
+		if counts[key] != value {
+			t.Errorf("Got %v for %s, expected %v", counts[key], key, value)
+		}
+	}
+	for key := range counts {
+		if _, ok := expected[key]; !ok {
+			t.Errorf("Unexpected metric %s", key)
+		}
+	}
+
+	// The metrics are served from the HTTP endpoint.
+	mux := http.NewServeMux()
+	mm.registerToServer(mux, "/metrics")
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(recorder.Body.String(), "csi_operation_errors_total") {
+		t.Errorf("Metrics endpoint did not serve csi_operation_errors_total:\n%s", recorder.Body.String())
+	}
+}
@@ -52,6 +52,8 @@ const (
 	// scsi_id output should be in the form of:
 	// 0Google PersistentDisk <disk name>
 	scsiPattern = `^0Google\s+PersistentDisk\s+([\S]+)\s*$`
+	// Location of the scsi_id tool in the driver container
+	scsiIDPath = "/lib/udev_containerized/scsi_id"
 )
 
 var (
@@ -69,6 +71,12 @@ type DeviceUtils interface {
 	// VerifyDevicePath returns the first of the list of device paths that
 	// exists on the machine, or an empty string if none exists
 	VerifyDevicePath(devicePaths []string, deviceName string) (string, error)
+
+	// CheckDevicePath returns the device that the first of the list of
+	// device paths that exists resolves to. It returns an error if none
+	// exists, or if the device has a SCSI serial other than deviceName.
+	// Unlike VerifyDevicePath, it does not try to fix the device paths.
+	CheckDevicePath(devicePaths []string, deviceName string) (string, error)
 }
 
 type deviceUtils struct {
@@ -113,7 +121,7 @@ func existingDevicePath(devicePaths []string) (string, error) {
 // by that device.
 func getScsiSerial(devicePath string) (string, error) {
 	out, err := exec.Command(
-		"/lib/udev_containerized/scsi_id",
+		scsiIDPath,
 		"--page=0x83",
 		"--whitelisted",
 		fmt.Sprintf("--device=%v", devicePath)).CombinedOutput()
@@ -146,7 +154,6 @@ func (m *deviceUtils) VerifyDevicePath(devicePaths []string, deviceName string)
 		pollTimeout  = 3 * time.Second
 	)
 
-	scsiIDPath := "/lib/udev_containerized/scsi_id"
 	exists, err := pathutils.Exists(pathutils.CheckFollowSymlink, scsiIDPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to check scsi_id existence: %v", err)
@@ -213,6 +220,37 @@ func (m *deviceUtils) VerifyDevicePath(devicePaths []string, deviceName string)
 	return devicePath, nil
 }
 
+func (m *deviceUtils) CheckDevicePath(devicePaths []string, deviceName string) (string, error) {
+	devicePath, err := existingDevicePath(devicePaths)
+	if err != nil {
+		return "", fmt.Errorf("failed to check for existing device path: %v", err)
+	}
+	if devicePath == "" {
+		return "", fmt.Errorf("none of the device paths %v exists", devicePaths)
+	}
+	devSDX, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return "", fmt.Errorf("device path %s does not resolve to a device: %v", devicePath, err)
+	}
+	// Only SCSI disks have a serial to check, which cannot be read without
+	// the scsi_id tool.
+	if !strings.Contains(devSDX, diskSDPath) {
+		return devSDX, nil
+	}
+	if exists, err := pathutils.Exists(pathutils.CheckFollowSymlink, scsiIDPath); err != nil || !exists {
+		klog.Warningf("Not checking the SCSI serial of device %s, could not find scsi_id tool at %s: %v", devSDX, scsiIDPath, err)
+		return devSDX, nil
+	}
+	scsiSerial, err := getScsiSerial(devSDX)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get SCSI serial number of device %s: %v", devSDX, err)
+	}
+	if scsiSerial != deviceName {
+		return "", fmt.Errorf("device path %s resolves to device %s, which has SCSI serial %s", devicePath, devSDX, scsiSerial)
+	}
+	return devSDX, nil
+}
+
 func udevadmTriggerForDiskIfExists(deviceName string) error {
 	devToSCSI := map[string]string{}
 	sds, err := filepath.Glob(diskSDPattern)
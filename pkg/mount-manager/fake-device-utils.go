@@ -15,12 +15,13 @@ limitations under the License.
 package mountmanager
 
 type fakeDeviceUtils struct {
+	devicePathErrors map[string]error
 }
 
 var _ DeviceUtils = &fakeDeviceUtils{}
 
 func NewFakeDeviceUtils() *fakeDeviceUtils {
-	return &fakeDeviceUtils{}
+	return &fakeDeviceUtils{devicePathErrors: map[string]error{}}
 }
 
 // Returns list of all /dev/disk/by-id/* paths for given PD.
@@ -34,3 +35,16 @@ func (m *fakeDeviceUtils) VerifyDevicePath(devicePaths []string, diskName string
 	// Return any random device path to use as mount source
 	return "/dev/disk/fake-path", nil
 }
+
+// Returns the fake device path, or the error set for the disk.
+func (m *fakeDeviceUtils) CheckDevicePath(devicePaths []string, diskName string) (string, error) {
+	if err, ok := m.devicePathErrors[diskName]; ok {
+		return "", err
+	}
+	return "/dev/disk/fake-path", nil
+}
+
+// SetDevicePathError makes CheckDevicePath fail for the disk with err.
+func (m *fakeDeviceUtils) SetDevicePathError(diskName string, err error) {
+	m.devicePathErrors[diskName] = err
+}
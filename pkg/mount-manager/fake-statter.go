@@ -0,0 +1,66 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mountmanager
+
+import (
+	"k8s.io/mount-utils"
+)
+
+type fakeStatter struct {
+	readOnlyPaths    map[string]bool
+	filesystemErrors map[string]int64
+}
+
+var _ Statter = &fakeStatter{}
+
+func NewFakeStatter(mounter *mount.SafeFormatAndMount) *fakeStatter {
+	return &fakeStatter{
+		readOnlyPaths:    map[string]bool{},
+		filesystemErrors: map[string]int64{},
+	}
+}
+
+func (*fakeStatter) StatFS(path string) (available, capacity, used, inodesFree, inodes, inodesUsed int64, err error) {
+	// Assume the file exists and give some dummy values back
+	return 1, 1, 1, 1, 1, 1, nil
+}
+
+func (*fakeStatter) IsBlockDevice(fullPath string) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeStatter) IsReadOnly(path string) (bool, error) {
+	return s.readOnlyPaths[path], nil
+}
+
+func (s *fakeStatter) IsDeviceReadOnly(devicePath string) (bool, error) {
+	return s.readOnlyPaths[devicePath], nil
+}
+
+func (s *fakeStatter) FilesystemErrors(devicePath string) (int64, error) {
+	return s.filesystemErrors[devicePath], nil
+}
+
+// SetReadOnly makes IsReadOnly and IsDeviceReadOnly report the filesystem
+// or device at path as read-only.
+func (s *fakeStatter) SetReadOnly(path string) {
+	s.readOnlyPaths[path] = true
+}
+
+// SetFilesystemErrors sets the number of errors that FilesystemErrors
+// reports for the filesystem on the device.
+func (s *fakeStatter) SetFilesystemErrors(devicePath string, count int64) {
+	s.filesystemErrors[devicePath] = count
+}
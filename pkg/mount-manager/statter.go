@@ -17,4 +17,13 @@ package mountmanager
 type Statter interface {
 	StatFS(path string) (int64, int64, int64, int64, int64, int64, error)
 	IsBlockDevice(string) (bool, error)
+	// IsReadOnly returns true if the filesystem mounted at path is
+	// read-only.
+	IsReadOnly(path string) (bool, error)
+	// IsDeviceReadOnly returns true if the block device is read-only, for
+	// example because its disk is attached read-only.
+	IsDeviceReadOnly(devicePath string) (bool, error)
+	// FilesystemErrors returns the number of errors that the filesystem on
+	// the device has recorded, or 0 if the filesystem does not record them.
+	FilesystemErrors(devicePath string) (int64, error)
 }
@@ -16,11 +16,24 @@ package mountmanager
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"golang.org/x/sys/unix"
 	"k8s.io/mount-utils"
 )
 
+const (
+	// blockSysfsPath has a directory for each block device.
+	blockSysfsPath = "/sys/class/block"
+	// ext4SysfsPath has a directory for each mounted ext4 filesystem, named
+	// after its device.
+	ext4SysfsPath = "/sys/fs/ext4"
+)
+
 var _ Statter = &realStatter{}
 
 type realStatter struct {
@@ -61,17 +74,42 @@ func (*realStatter) StatFS(path string) (available, capacity, used, inodesFree,
 	return
 }
 
-type fakeStatter struct{}
-
-func NewFakeStatter(mounter *mount.SafeFormatAndMount) *fakeStatter {
-	return &fakeStatter{}
+// IsReadOnly returns true if the filesystem mounted at path is read-only,
+// for example because it was remounted read-only after an I/O error.
+func (*realStatter) IsReadOnly(path string) (bool, error) {
+	statfs := &unix.Statfs_t{}
+	if err := unix.Statfs(path, statfs); err != nil {
+		return false, fmt.Errorf("failed to get fs info on path %s: %v", path, err)
+	}
+	return statfs.Flags&unix.ST_RDONLY != 0, nil
 }
 
-func (*fakeStatter) StatFS(path string) (available, capacity, used, inodesFree, inodes, inodesUsed int64, err error) {
-	// Assume the file exists and give some dummy values back
-	return 1, 1, 1, 1, 1, 1, nil
+// IsDeviceReadOnly returns true if the read-only flag of the block device is
+// set in sysfs.
+func (*realStatter) IsDeviceReadOnly(devicePath string) (bool, error) {
+	roPath := filepath.Join(blockSysfsPath, filepath.Base(devicePath), "ro")
+	out, err := ioutil.ReadFile(roPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %v", roPath, err)
+	}
+	return strings.TrimSpace(string(out)) == "1", nil
 }
 
-func (*fakeStatter) IsBlockDevice(fullPath string) (bool, error) {
-	return false, nil
+// FilesystemErrors returns the number of errors recorded by the ext4
+// filesystem on the device. Other filesystems do not record errors in
+// sysfs, and have none.
+func (*realStatter) FilesystemErrors(devicePath string) (int64, error) {
+	countPath := filepath.Join(ext4SysfsPath, filepath.Base(devicePath), "errors_count")
+	out, err := ioutil.ReadFile(countPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", countPath, err)
+	}
+	count, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", countPath, err)
+	}
+	return count, nil
 }
@@ -39,6 +39,24 @@ func (r *realStatter) IsBlockDevice(fullPath string) (bool, error) {
 	return false, nil
 }
 
+// IsReadOnly is not supported on Windows, where volumes are reported as
+// writable.
+func (r *realStatter) IsReadOnly(path string) (bool, error) {
+	return false, nil
+}
+
+// IsDeviceReadOnly is not supported on Windows, where devices are reported
+// as writable.
+func (r *realStatter) IsDeviceReadOnly(devicePath string) (bool, error) {
+	return false, nil
+}
+
+// FilesystemErrors is not supported on Windows, where filesystems are
+// reported to have no errors.
+func (r *realStatter) FilesystemErrors(devicePath string) (int64, error) {
+	return 0, nil
+}
+
 // StatFS returns volume usage information
 func (r *realStatter) StatFS(path string) (available, capacity, used, inodesFree, inodes, inodesUsed int64, err error) {
 	switch r.mounter.Interface.(type) {
@@ -103,18 +121,3 @@ func (r *realStatter) StatFSV1Beta(path string) (available, capacity, used, inod
 	available = capacity - used
 	return available, capacity, used, zero, zero, zero, nil
 }
-
-type fakeStatter struct{}
-
-func NewFakeStatter(mounter *mount.SafeFormatAndMount) *fakeStatter {
-	return &fakeStatter{}
-}
-
-func (*fakeStatter) StatFS(path string) (available, capacity, used, inodesFree, inodes, inodesUsed int64, err error) {
-	// Assume the file exists and give some dummy values back
-	return 1, 1, 1, 1, 1, 1, nil
-}
-
-func (*fakeStatter) IsBlockDevice(fullPath string) (bool, error) {
-	return false, nil
-}
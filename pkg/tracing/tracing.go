@@ -0,0 +1,95 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing traces CSI RPCs and the GCE API calls they make.
+//
+// Spans are recorded with the OpenTelemetry SDK. Tracing is disabled until
+// Enable is called with an exporter, such as the OTLP exporter returned by
+// NewOTLPExporter.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+const (
+	// Span attribute keys.
+	AttributeVolumeID       = "csi.volume_id"
+	AttributeNodeID         = "csi.node_id"
+	AttributeOperationName  = "gce.operation_name"
+	AttributeAPIVersion     = "gce.api_version"
+	AttributeGRPCStatusCode = "rpc.grpc.status_code"
+)
+
+// instrumentationName names the tracer that spans are started with.
+const instrumentationName = "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/tracing"
+
+// NewOTLPExporter returns an exporter that sends spans over gRPC to the
+// OTLP collector at endpoint, a host:port. The connection is insecure, as
+// the collector is expected to run next to the driver, e.g. as a sidecar.
+func NewOTLPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	return otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint), otlptracegrpc.WithInsecure())
+}
+
+// Enable records every span and exports it to exporter in batches. The
+// returned function exports the remaining spans and disables tracing.
+func Enable(exporter sdktrace.SpanExporter) func() {
+	return enable(sdktrace.WithBatcher(exporter))
+}
+
+// EnableSync records every span and exports it to exporter as soon as it
+// ends, which lets tests read the spans of an in-memory exporter right away.
+// The returned function disables tracing.
+func EnableSync(exporter sdktrace.SpanExporter) func() {
+	return enable(sdktrace.WithSyncer(exporter))
+}
+
+func enable(opt sdktrace.TracerProviderOption) func() {
+	provider := sdktrace.NewTracerProvider(opt)
+	otel.SetTracerProvider(provider)
+	return func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		if err := provider.Shutdown(context.Background()); err != nil {
+			klog.Errorf("Failed to shut down tracing: %v", err)
+		}
+	}
+}
+
+// StartSpan starts a span that is a child of the span in ctx, if any, and
+// returns a context holding it. The span must be ended with EndSpan.
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndSpan records the error, if any, on the span, along with its gRPC code,
+// and ends it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.Int64(AttributeGRPCStatusCode, int64(status.Code(err))))
+	}
+	span.End()
+}
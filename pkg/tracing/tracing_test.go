@@ -0,0 +1,90 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewOTLPExporter(t *testing.T) {
+	// The exporter connects lazily, so no collector needs to be running.
+	exporter, err := NewOTLPExporter(context.Background(), "localhost:4317")
+	if err != nil {
+		t.Fatalf("NewOTLPExporter failed: %v", err)
+	}
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	// Spans are not recorded until tracing is enabled.
+	_, span := StartSpan(context.Background(), "disabled")
+	if span.IsRecording() {
+		t.Errorf("Span was recorded while tracing was disabled")
+	}
+	EndSpan(span, nil)
+
+	exporter := tracetest.NewInMemoryExporter()
+	disable := EnableSync(exporter)
+	ctx, parent := StartSpan(context.Background(), "parent", attribute.String(AttributeVolumeID, "volume"))
+	_, child := StartSpan(ctx, "child")
+	EndSpan(child, status.Error(codes.NotFound, "not found"))
+	_, other := StartSpan(ctx, "other")
+	EndSpan(other, fmt.Errorf("failed"))
+	EndSpan(parent, nil)
+
+	spans := exporter.GetSpans()
+	disable()
+	if len(spans) != 3 {
+		t.Fatalf("Got %d spans, expected 3", len(spans))
+	}
+	testCases := []struct {
+		span       tracetest.SpanStub
+		expName    string
+		expParent  trace.SpanID
+		expStatus  otelcodes.Code
+		expGRPCode attribute.Value
+	}{
+		{spans[0], "child", spans[2].SpanContext.SpanID(), otelcodes.Error, attribute.Int64Value(int64(codes.NotFound))},
+		{spans[1], "other", spans[2].SpanContext.SpanID(), otelcodes.Error, attribute.Int64Value(int64(codes.Unknown))},
+		{spans[2], "parent", trace.SpanID{}, otelcodes.Unset, attribute.Value{}},
+	}
+	for _, tc := range testCases {
+		if tc.span.Name != tc.expName || tc.span.Parent.SpanID() != tc.expParent || tc.span.Status.Code != tc.expStatus {
+			t.Errorf("Got span %s with parent %v and status %v, expected %s with parent %v and status %v",
+				tc.span.Name, tc.span.Parent.SpanID(), tc.span.Status.Code, tc.expName, tc.expParent, tc.expStatus)
+		}
+		attributes := attribute.NewSet(tc.span.Attributes...)
+		if v, _ := attributes.Value(AttributeGRPCStatusCode); v != tc.expGRPCode {
+			t.Errorf("Got span %s with gRPC code attribute %v, expected %v", tc.span.Name, v.Emit(), tc.expGRPCode.Emit())
+		}
+	}
+	attributes := attribute.NewSet(spans[2].Attributes...)
+	if v, _ := attributes.Value(AttributeVolumeID); v.AsString() != "volume" {
+		t.Errorf("Got volume ID attribute %v, expected volume", v.Emit())
+	}
+}
